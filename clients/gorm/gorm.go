@@ -22,11 +22,13 @@ import (
 	"sync"
 	"time"
 
+	kitlog "github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
 
 	logs "github.com/MicroOps-cn/fuck/log"
 )
@@ -55,6 +57,8 @@ type Client struct {
 	tracerInitial  sync.Once
 	options        DBOptions
 	statsCollector string
+	resolver       *resolver
+	slowQueries    *SlowQueryLog
 }
 
 func (c *Client) Name() string {
@@ -73,6 +77,11 @@ func (c *Client) Close() error {
 		collector.Unregister(c.statsCollector)
 	}
 	logger := logs.GetDefaultLogger()
+	if c.resolver != nil {
+		if err := c.resolver.Close(); err != nil {
+			level.Warn(logger).Log("msg", fmt.Errorf("failed to close replicas: [%s]", c.options.String()), "err", err)
+		}
+	}
 	if sqlDB, err := c.database.DB(); err == nil {
 		if err = sqlDB.Close(); err != nil {
 			level.Warn(logger).Log("msg", fmt.Errorf("failed to close connect: [%s]", c.options.String()), "err", err)
@@ -92,9 +101,35 @@ type Processor interface {
 
 const instrumentationName = "github.com/MicroOps-cn/fuck/clients/gorm"
 
+// Session returns a session of the client. When the client has replicas, the reads of the session are executed by the
+// replicas, unless the context is returned by WithPrimaryContext, or the session is in a transaction.
 func (c *Client) Session(ctx context.Context) *gorm.DB {
-	host, port := c.options.GetPeer()
+	logger := logs.GetContextLogger(ctx)
+	session := &gorm.Session{Logger: c.newLogAdapter(logger)}
+	if conn := ctx.Value(gormConn{}); conn != nil {
+		switch db := conn.(type) {
+		case *gorm.DB:
+			return db.Session(session)
+		default:
+			level.Warn(logger).Log("msg", "Unknown context value type.", "name", fmt.Sprintf("%T", gormConn{}), "value", fmt.Sprintf("%T", conn))
+		}
+	}
+	return c.database.Session(session).WithContext(ctx)
+}
+
+func (c *Client) newLogAdapter(logger kitlog.Logger) gormlogger.Interface {
+	return NewLogAdapter(logger, c.slowThreshold, c.getTracer(), WithClientName(c.name), WithSlowQueryLog(c.slowQueries))
+}
+
+// SetSlowQueryLog records the queries of the sessions slower than the slow threshold of the client in l, l may be
+// shared by the clients, and nil disables the records.
+func (c *Client) SetSlowQueryLog(l *SlowQueryLog) {
+	c.slowQueries = l
+}
+
+func (c *Client) getTracer() trace.Tracer {
 	c.tracerInitial.Do(func() {
+		host, port := c.options.GetPeer()
 		c.tracer = otel.GetTracerProvider().Tracer(instrumentationName, trace.WithInstrumentationAttributes(
 			attribute.String("db.connection_string", c.options.GetConnectionString()),
 			attribute.String("db.name", c.options.GetDBName()),
@@ -104,17 +139,20 @@ func (c *Client) Session(ctx context.Context) *gorm.DB {
 			attribute.Int("net.peer.port", port),
 		))
 	})
-	logger := logs.GetContextLogger(ctx)
-	session := &gorm.Session{Logger: NewLogAdapter(logger, c.slowThreshold, c.tracer)}
-	if conn := ctx.Value(gormConn{}); conn != nil {
-		switch db := conn.(type) {
-		case *gorm.DB:
-			return db.Session(session)
-		default:
-			level.Warn(logger).Log("msg", "Unknown context value type.", "name", fmt.Sprintf("%T", gormConn{}), "value", fmt.Sprintf("%T", conn))
-		}
+	return c.tracer
+}
+
+// useReplicas routes the reads of the client to the replicas.
+func (c *Client) useReplicas(ctx context.Context, policy ReplicaPolicy, healthCheckInterval time.Duration, replicas []*replica) error {
+	r, err := newResolver(logs.GetContextLogger(ctx), policy, healthCheckInterval, replicas)
+	if err != nil {
+		return err
 	}
-	return c.database.Session(session).WithContext(ctx)
+	if err = c.database.Use(r); err != nil {
+		return err
+	}
+	c.resolver = r
+	return nil
 }
 
 type ConnType interface {
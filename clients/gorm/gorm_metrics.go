@@ -1,6 +1,7 @@
 package gorm
 
 import (
+	"database/sql"
 	"fmt"
 	"sync"
 
@@ -45,6 +46,27 @@ var (
 		Name: "gorm_dbstats_max_idletime_closed",
 		Help: "The total number of connections closed due to SetConnMaxIdleTime.",
 	}, []string{"type", "name", "host", "db_name"})
+	queryDurationHistogramVec = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gorm_query_duration_seconds",
+		Help:    "The latency of the queries, partitioned by client name, operation and table.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"name", "operation", "table"})
+	queryRowsAffectedCounterVec = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gorm_query_rows_affected_total",
+		Help: "The total number of rows returned or affected by the queries, partitioned by client name, operation and table.",
+	}, []string{"name", "operation", "table"})
+	queryErrorsCounterVec = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gorm_query_errors_total",
+		Help: "The total number of failed queries, partitioned by client name, operation and table.",
+	}, []string{"name", "operation", "table"})
+	slowQueriesCounterVec = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gorm_slow_queries_total",
+		Help: "The total number of queries slower than the slow threshold, partitioned by client name, operation and table.",
+	}, []string{"name", "operation", "table"})
+	replicaHealthyGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gorm_dbstats_replica_healthy",
+		Help: "Whether the replica passed the last health check, the unhealthy replicas do not serve reads.",
+	}, []string{"type", "name", "host", "db_name"})
 )
 
 type Collector struct {
@@ -65,7 +87,6 @@ func (c *Collector) Collect(metrics chan<- prometheus.Metric) {
 			fmt.Println("failed to collect db metrics: failed to get db instance: ", err)
 			continue
 		}
-		stats := db.Stats()
 		connType := instance.options.GetType()
 		dbName := instance.options.GetDBName()
 		var host string
@@ -75,27 +96,41 @@ func (c *Collector) Collect(metrics chan<- prometheus.Metric) {
 		} else {
 			host = fmt.Sprintf("%s:%d", peer, port)
 		}
-		maxOpenConnectionsGaugeVec.WithLabelValues(connType, instance.name, host, dbName).Set(float64(stats.MaxOpenConnections))
-		openConnectionsGaugeVec.WithLabelValues(connType, instance.name, host, dbName).Set(float64(stats.OpenConnections))
-		inUseGaugeVec.WithLabelValues(connType, instance.name, host, dbName).Set(float64(stats.InUse))
-		idleGaugeVec.WithLabelValues(connType, instance.name, host, dbName).Set(float64(stats.Idle))
-		waitCountGaugeVec.WithLabelValues(connType, instance.name, host, dbName).Set(float64(stats.WaitCount))
-		waitDurationGaugeVec.WithLabelValues(connType, instance.name, host, dbName).Set(float64(stats.WaitDuration))
-		maxIdleClosedGaugeVec.WithLabelValues(connType, instance.name, host, dbName).Set(float64(stats.MaxIdleClosed))
-		maxLifetimeClosedGaugeVec.WithLabelValues(connType, instance.name, host, dbName).Set(float64(stats.MaxLifetimeClosed))
-		maxIdleTimeClosedGaugeVec.WithLabelValues(connType, instance.name, host, dbName).Set(float64(stats.MaxIdleTimeClosed))
+		collectStats(metrics, db.Stats(), connType, instance.name, host, dbName)
+		if instance.resolver != nil {
+			for _, rep := range instance.resolver.replicas {
+				collectStats(metrics, rep.db.Stats(), connType, instance.name, rep.host, dbName)
+				var healthy float64
+				if rep.healthy.Load() {
+					healthy = 1
+				}
+				replicaHealthyGaugeVec.WithLabelValues(connType, instance.name, rep.host, dbName).Set(healthy)
+				metrics <- replicaHealthyGaugeVec.WithLabelValues(connType, instance.name, rep.host, dbName)
+			}
+		}
+	}
+}
 
-		metrics <- maxOpenConnectionsGaugeVec.WithLabelValues(connType, instance.name, host, dbName)
-		metrics <- openConnectionsGaugeVec.WithLabelValues(connType, instance.name, host, dbName)
-		metrics <- inUseGaugeVec.WithLabelValues(connType, instance.name, host, dbName)
-		metrics <- idleGaugeVec.WithLabelValues(connType, instance.name, host, dbName)
-		metrics <- waitCountGaugeVec.WithLabelValues(connType, instance.name, host, dbName)
-		metrics <- waitDurationGaugeVec.WithLabelValues(connType, instance.name, host, dbName)
-		metrics <- maxIdleClosedGaugeVec.WithLabelValues(connType, instance.name, host, dbName)
-		metrics <- maxLifetimeClosedGaugeVec.WithLabelValues(connType, instance.name, host, dbName)
-		metrics <- maxIdleTimeClosedGaugeVec.WithLabelValues(connType, instance.name, host, dbName)
+func collectStats(metrics chan<- prometheus.Metric, stats sql.DBStats, connType, name, host, dbName string) {
+	maxOpenConnectionsGaugeVec.WithLabelValues(connType, name, host, dbName).Set(float64(stats.MaxOpenConnections))
+	openConnectionsGaugeVec.WithLabelValues(connType, name, host, dbName).Set(float64(stats.OpenConnections))
+	inUseGaugeVec.WithLabelValues(connType, name, host, dbName).Set(float64(stats.InUse))
+	idleGaugeVec.WithLabelValues(connType, name, host, dbName).Set(float64(stats.Idle))
+	waitCountGaugeVec.WithLabelValues(connType, name, host, dbName).Set(float64(stats.WaitCount))
+	waitDurationGaugeVec.WithLabelValues(connType, name, host, dbName).Set(float64(stats.WaitDuration))
+	maxIdleClosedGaugeVec.WithLabelValues(connType, name, host, dbName).Set(float64(stats.MaxIdleClosed))
+	maxLifetimeClosedGaugeVec.WithLabelValues(connType, name, host, dbName).Set(float64(stats.MaxLifetimeClosed))
+	maxIdleTimeClosedGaugeVec.WithLabelValues(connType, name, host, dbName).Set(float64(stats.MaxIdleTimeClosed))
 
-	}
+	metrics <- maxOpenConnectionsGaugeVec.WithLabelValues(connType, name, host, dbName)
+	metrics <- openConnectionsGaugeVec.WithLabelValues(connType, name, host, dbName)
+	metrics <- inUseGaugeVec.WithLabelValues(connType, name, host, dbName)
+	metrics <- idleGaugeVec.WithLabelValues(connType, name, host, dbName)
+	metrics <- waitCountGaugeVec.WithLabelValues(connType, name, host, dbName)
+	metrics <- waitDurationGaugeVec.WithLabelValues(connType, name, host, dbName)
+	metrics <- maxIdleClosedGaugeVec.WithLabelValues(connType, name, host, dbName)
+	metrics <- maxLifetimeClosedGaugeVec.WithLabelValues(connType, name, host, dbName)
+	metrics <- maxIdleTimeClosedGaugeVec.WithLabelValues(connType, name, host, dbName)
 }
 
 func (c *Collector) Register(db *Client) string {
@@ -116,5 +151,5 @@ var collector = &Collector{
 }
 
 func init() {
-	prometheus.MustRegister(collector)
+	prometheus.MustRegister(collector, queryDurationHistogramVec, queryRowsAffectedCounterVec, queryErrorsCounterVec, slowQueriesCounterVec)
 }
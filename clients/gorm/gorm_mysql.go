@@ -18,6 +18,7 @@ package gorm
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"reflect"
@@ -91,6 +92,32 @@ type MySQLOptions struct {
 	SlowThreshold         *model.Duration `json:"slow_threshold,omitempty" yaml:"slow_threshold" mapstructure:"slow_threshold"`
 	TLSConfig             *TLSOptions     `json:"tls_config" yaml:"tls_config" mapstructure:"tls_config"`
 	EnableCompression     bool            `json:"enable_compression,omitempty" yaml:"enable_compression" mapstructure:"enable_compression"`
+	// Replicas are the read replicas of the server, the reads are executed by the replicas and the writes and the
+	// transactions by the server.
+	Replicas                   []MySQLReplicaOptions `json:"replicas,omitempty" yaml:"replicas" mapstructure:"replicas"`
+	ReplicaPolicy              ReplicaPolicy         `json:"replica_policy,omitempty" yaml:"replica_policy" mapstructure:"replica_policy"`
+	ReplicaHealthCheckInterval *model.Duration       `json:"replica_health_check_interval,omitempty" yaml:"replica_health_check_interval" mapstructure:"replica_health_check_interval"`
+}
+
+// MySQLReplicaOptions is a read replica of MySQLOptions, the other options of the replica are the same as the server.
+type MySQLReplicaOptions struct {
+	Host string `json:"host,omitempty" yaml:"host" mapstructure:"host"`
+	// Username and Password default to the ones of the server.
+	Username string      `json:"username,omitempty" yaml:"username" mapstructure:"username"`
+	Password safe.String `json:"password,omitempty" yaml:"password" mapstructure:"password"`
+}
+
+// GetReplicaOptions returns the options of the i-th replica.
+func (x *MySQLOptions) GetReplicaOptions(i int) *MySQLOptions {
+	rep := x.Replicas[i]
+	o := *x
+	o.Host = rep.Host
+	if len(rep.Username) != 0 {
+		o.Username = rep.Username
+		o.Password = rep.Password
+	}
+	o.Replicas = nil
+	return &o
 }
 
 func durationEqual(dur1, dur2 *model.Duration) bool {
@@ -115,7 +142,10 @@ func (x *MySQLOptions) Equal(options MySQLOptions) bool {
 		x.Collation != options.Collation ||
 		x.TablePrefix != options.TablePrefix ||
 		!durationEqual(x.SlowThreshold, options.SlowThreshold) ||
-		!x.TLSConfig.Equal(x.TLSConfig))
+		!x.TLSConfig.Equal(x.TLSConfig) ||
+		!reflect.DeepEqual(x.Replicas, options.Replicas) ||
+		x.ReplicaPolicy != options.ReplicaPolicy ||
+		!durationEqual(x.ReplicaHealthCheckInterval, options.ReplicaHealthCheckInterval))
 }
 
 func (x *MySQLOptions) String() string {
@@ -149,42 +179,50 @@ func (x *MySQLOptions) GetType() string {
 	return "mysql"
 }
 
-func openMysqlConn(ctx context.Context, slowThreshold time.Duration, options *MySQLOptions, autoCreateSchema bool) (*gorm.DB, error) {
-	logger := logs.GetContextLogger(ctx)
-	passwd, err := options.Password.UnsafeString()
+func (x *MySQLOptions) getDSNConfig() (*mysql.Config, error) {
+	passwd, err := x.Password.UnsafeString()
 	if err != nil {
 		return nil, err
 	}
-	if options.Charset == "" {
-		options.Charset = "utf8mb4"
-	}
-	if options.Collation == "" {
-		options.Collation = "utf8mb4_general_ci"
+	if x.Charset == "" {
+		x.Charset = "utf8mb4"
 	}
-	if options.MaxOpenConnections == 0 {
-		options.MaxOpenConnections = 100
+	if x.Collation == "" {
+		x.Collation = "utf8mb4_general_ci"
 	}
 
 	var tlsConfigName string
-	if options.TLSConfig != nil {
-		tlsConfigName = options.TLSConfig.name
+	if x.TLSConfig != nil {
+		tlsConfigName = x.TLSConfig.name
 	}
 	cfg := &mysql.Config{
-		User:                 options.Username,
+		User:                 x.Username,
 		Passwd:               passwd,
 		Net:                  "tcp",
-		Addr:                 options.Host,
-		DBName:               options.Schema,
-		Params:               map[string]string{"charset": options.Charset},
-		Collation:            options.Collation,
+		Addr:                 x.Host,
+		DBName:               x.Schema,
+		Params:               map[string]string{"charset": x.Charset},
+		Collation:            x.Collation,
 		AllowNativePasswords: true,
 		CheckConnLiveness:    true,
 		ParseTime:            true,
 		TLSConfig:            tlsConfigName,
 	}
-	if options.EnableCompression {
+	if x.EnableCompression {
 		cfg.Apply(mysql.EnableCompression(true))
 	}
+	return cfg, nil
+}
+
+func openMysqlConn(ctx context.Context, slowThreshold time.Duration, options *MySQLOptions, autoCreateSchema bool) (*gorm.DB, error) {
+	logger := logs.GetContextLogger(ctx)
+	if options.MaxOpenConnections == 0 {
+		options.MaxOpenConnections = 100
+	}
+	cfg, err := options.getDSNConfig()
+	if err != nil {
+		return nil, err
+	}
 	db, err := gorm.Open(
 		mysqldriver.New(mysqldriver.Config{
 			DSNConfig: cfg,
@@ -254,9 +292,25 @@ func NewMySQLClient(ctx context.Context, name string, options MySQLOptions) (clt
 		sqlDB.SetConnMaxLifetime(options.GetStdMaxConnectionLifeTime())
 		sqlDB.SetMaxOpenConns(int(options.MaxOpenConnections))
 	}
+	clt.database = db
+
+	if len(options.Replicas) != 0 {
+		if err = openMysqlReplicas(ctx, clt, &options); err != nil {
+			level.Error(logger).Log("msg", fmt.Errorf("failed to connect to mysql replicas: [%s@%s]", options.Username, options.Host), "err", err)
+			if sqlDB, err := db.DB(); err == nil {
+				sqlDB.Close()
+			}
+			return nil, err
+		}
+	}
 
 	stopCh := signals.SetupSignalHandler(logger)
 	stopCh.PreStop(signals.LevelDB, func() {
+		if clt.resolver != nil {
+			if err := clt.resolver.Close(); err != nil {
+				level.Warn(logger).Log("msg", fmt.Errorf("failed to close mysql replicas: [%s@%s]", options.Username, options.Host), "err", err)
+			}
+		}
 		if sqlDB, err := db.DB(); err == nil {
 			if err = sqlDB.Close(); err != nil {
 				level.Warn(logger).Log("msg", fmt.Errorf("failed to close mysql connect: [%s@%s]", options.Username, options.Host), "err", err)
@@ -270,12 +324,50 @@ func NewMySQLClient(ctx context.Context, name string, options MySQLOptions) (clt
 		"host", options.Host, "username", options.Username,
 		"schema", options.Schema,
 		"charset", options.Charset,
-		"collation", options.Collation)
-	clt.database = db
+		"collation", options.Collation,
+		"replicas", len(options.Replicas))
 	clt.statsCollector = collector.Register(clt)
 	return clt, nil
 }
 
+// openMysqlReplicas routes the reads of clt to the replicas of options. The replicas are not connected until they are
+// used or checked, so that an unavailable replica is ejected instead of failing the client.
+func openMysqlReplicas(ctx context.Context, clt *Client, options *MySQLOptions) error {
+	replicas := make([]*replica, 0, len(options.Replicas))
+	closeReplicas := func() {
+		for _, rep := range replicas {
+			rep.db.Close()
+		}
+	}
+	for i := range options.Replicas {
+		replicaOptions := options.GetReplicaOptions(i)
+		cfg, err := replicaOptions.getDSNConfig()
+		if err != nil {
+			closeReplicas()
+			return err
+		}
+		connector, err := mysql.NewConnector(cfg)
+		if err != nil {
+			closeReplicas()
+			return err
+		}
+		sqlDB := sql.OpenDB(connector)
+		sqlDB.SetMaxIdleConns(int(options.MaxIdleConnections))
+		sqlDB.SetConnMaxLifetime(options.GetStdMaxConnectionLifeTime())
+		sqlDB.SetMaxOpenConns(int(options.MaxOpenConnections))
+		replicas = append(replicas, &replica{host: replicaOptions.Host, db: sqlDB})
+	}
+	var healthCheckInterval time.Duration
+	if options.ReplicaHealthCheckInterval != nil {
+		healthCheckInterval = time.Duration(*options.ReplicaHealthCheckInterval)
+	}
+	if err := clt.useReplicas(ctx, options.ReplicaPolicy, healthCheckInterval, replicas); err != nil {
+		closeReplicas()
+		return err
+	}
+	return nil
+}
+
 func (x *MySQLOptions) GetStdMaxConnectionLifeTime() time.Duration {
 	if x != nil && x.MaxConnectionLifeTime != nil {
 		return time.Duration(*x.MaxConnectionLifeTime)
@@ -0,0 +1,292 @@
+/*
+ Copyright © 2022 MicroOps-cn.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gorm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/prometheus/common/model"
+	postgresdriver "gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+
+	"github.com/MicroOps-cn/fuck/clients/tls"
+	logs "github.com/MicroOps-cn/fuck/log"
+	"github.com/MicroOps-cn/fuck/safe"
+	"github.com/MicroOps-cn/fuck/signals"
+	w "github.com/MicroOps-cn/fuck/wrapper"
+)
+
+// pgInvalidCatalogName is the SQLSTATE of connecting to a database that does not exist.
+const pgInvalidCatalogName = "3D000"
+
+type PostgresOptions struct {
+	Host     string      `json:"host,omitempty" yaml:"host" mapstructure:"host"`
+	Username string      `json:"username,omitempty" yaml:"username" mapstructure:"username"`
+	Password safe.String `json:"password,omitempty" yaml:"password" mapstructure:"password"`
+	Database string      `json:"database,omitempty" yaml:"database" mapstructure:"database"`
+	// Schema is the schema of the tables, it is created if it does not exist and is set as the search_path.
+	// The default is the public schema.
+	Schema                string          `json:"schema,omitempty" yaml:"schema" mapstructure:"schema"`
+	MaxIdleConnections    int32           `json:"max_idle_connections,omitempty" yaml:"max_idle_connections" mapstructure:"max_idle_connections"`
+	MaxOpenConnections    int32           `json:"max_open_connections,omitempty" yaml:"max_open_connections" mapstructure:"max_open_connections"`
+	MaxConnectionLifeTime *model.Duration `json:"max_connection_life_time,omitempty" yaml:"max_connection_life_time" mapstructure:"max_connection_life_time"`
+	TablePrefix           string          `json:"table_prefix,omitempty" yaml:"table_prefix" mapstructure:"table_prefix"`
+	SlowThreshold         *model.Duration `json:"slow_threshold,omitempty" yaml:"slow_threshold" mapstructure:"slow_threshold"`
+	ConnectTimeout        *model.Duration `json:"connect_timeout,omitempty" yaml:"connect_timeout" mapstructure:"connect_timeout"`
+	// TLSConfig enables TLS, the connections are not encrypted if it is nil.
+	TLSConfig *tls.TLSOptions `json:"tls_config,omitempty" yaml:"tls_config" mapstructure:"tls_config"`
+}
+
+func (x *PostgresOptions) Equal(options PostgresOptions) bool {
+	return !(x.Host != options.Host ||
+		x.Username != options.Username ||
+		!x.Password.Equal(options.Password) ||
+		x.Database != options.Database ||
+		x.Schema != options.Schema ||
+		x.MaxIdleConnections != options.MaxIdleConnections ||
+		x.MaxOpenConnections != options.MaxOpenConnections ||
+		!durationEqual(x.MaxConnectionLifeTime, options.MaxConnectionLifeTime) ||
+		x.TablePrefix != options.TablePrefix ||
+		!durationEqual(x.SlowThreshold, options.SlowThreshold) ||
+		!durationEqual(x.ConnectTimeout, options.ConnectTimeout) ||
+		!reflect.DeepEqual(x.TLSConfig, options.TLSConfig))
+}
+
+func (x *PostgresOptions) String() string {
+	return fmt.Sprintf("%s://%s@%s/%s", x.GetType(), x.Username, x.Host, x.Database)
+}
+
+func (x *PostgresOptions) GetPeer() (string, int) {
+	host, port, found := strings.Cut(x.Host, ":")
+	if found && len(port) > 0 {
+		portNum, err := strconv.Atoi(port)
+		if err == nil {
+			return host, portNum
+		}
+	}
+	return x.Host, 5432
+}
+
+func (x *PostgresOptions) GetConnectionString() string {
+	return fmt.Sprintf("postgres://%s", x.Host)
+}
+
+func (x *PostgresOptions) GetDBName() string {
+	return x.Database
+}
+
+func (x *PostgresOptions) GetUsername() string {
+	return x.Username
+}
+
+func (x *PostgresOptions) GetType() string {
+	return "postgres"
+}
+
+func (x *PostgresOptions) GetStdMaxConnectionLifeTime() time.Duration {
+	if x != nil && x.MaxConnectionLifeTime != nil {
+		return time.Duration(*x.MaxConnectionLifeTime)
+	}
+	return time.Second * 30
+}
+
+// GetConnConfig returns the pgx config of the options, the password and the TLS config are set on the config instead
+// of a DSN, so that they need not be escaped.
+func (x *PostgresOptions) GetConnConfig() (*pgx.ConnConfig, error) {
+	host, port := x.GetPeer()
+	cfg, err := pgx.ParseConfig(fmt.Sprintf("host=%s port=%d sslmode=disable", host, port))
+	if err != nil {
+		return nil, err
+	}
+	cfg.User = x.Username
+	if cfg.Password, err = x.Password.UnsafeString(); err != nil {
+		return nil, err
+	}
+	cfg.Database = x.Database
+	if x.ConnectTimeout != nil {
+		cfg.ConnectTimeout = time.Duration(*x.ConnectTimeout)
+	}
+	if len(x.Schema) != 0 {
+		cfg.RuntimeParams["search_path"] = x.Schema
+	}
+	if x.TLSConfig != nil {
+		if cfg.TLSConfig, err = tls.NewTLSConfig(x.TLSConfig); err != nil {
+			return nil, err
+		}
+		if len(cfg.TLSConfig.ServerName) == 0 {
+			cfg.TLSConfig.ServerName = host
+		}
+	}
+	return cfg, nil
+}
+
+func openPostgresConn(ctx context.Context, slowThreshold time.Duration, options *PostgresOptions, autoCreateSchema bool) (*gorm.DB, error) {
+	logger := logs.GetContextLogger(ctx)
+	cfg, err := options.GetConnConfig()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB := stdlib.OpenDB(*cfg)
+	db, err := gorm.Open(
+		postgresdriver.New(postgresdriver.Config{
+			Conn: sqlDB,
+		}), &gorm.Config{
+			NamingStrategy: schema.NamingStrategy{
+				TablePrefix:   options.TablePrefix,
+				SingularTable: options.TablePrefix != "",
+			},
+			Logger:                                   NewLogAdapter(logger, slowThreshold, nil),
+			DisableForeignKeyConstraintWhenMigrating: true,
+		},
+	)
+	if err != nil {
+		sqlDB.Close()
+		var pgErr *pgconn.PgError
+		if autoCreateSchema && errors.As(err, &pgErr) && pgErr.Code == pgInvalidCatalogName {
+			level.Info(logger).Log("msg", fmt.Sprintf("auto create database: %s", options.Database))
+			tmpOpts := *options
+			tmpOpts.Database = "postgres"
+			tmpOpts.Schema = ""
+			db, err = openPostgresConn(ctx, slowThreshold, &tmpOpts, false)
+			if err != nil {
+				return nil, err
+			}
+			err = db.Exec(fmt.Sprintf("CREATE DATABASE %s", pgx.Identifier{options.Database}.Sanitize())).Error
+			if sqlDB, err := db.DB(); err == nil {
+				defer sqlDB.Close()
+			}
+			if err != nil {
+				return nil, err
+			}
+			return openPostgresConn(ctx, slowThreshold, options, autoCreateSchema)
+		}
+		return nil, err
+	}
+	if autoCreateSchema && len(options.Schema) != 0 {
+		if err = db.Exec(fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", pgx.Identifier{options.Schema}.Sanitize())).Error; err != nil {
+			sqlDB.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+func NewPostgresClient(ctx context.Context, name string, options PostgresOptions) (clt *Client, err error) {
+	clt = new(Client)
+	clt.options = &options
+	logger := logs.GetContextLogger(ctx)
+	if options.SlowThreshold != nil {
+		clt.slowThreshold = time.Duration(*options.SlowThreshold)
+	}
+	if options.MaxOpenConnections == 0 {
+		options.MaxOpenConnections = 100
+	}
+	clt.name = name
+	level.Debug(logger).Log("msg", "connect to postgres server",
+		"host", options.Host, "username", options.Username,
+		"database", options.Database, "schema", options.Schema,
+		"tls", options.TLSConfig != nil)
+
+	db, err := openPostgresConn(ctx, clt.slowThreshold, &options, true)
+	if err != nil {
+		level.Error(logger).Log("msg", fmt.Errorf("failed to connect to postgres server: [%s@%s]", options.Username, options.Host), "err", err)
+		return nil, err
+	}
+
+	{
+		sqlDB, err := db.DB()
+		if err != nil {
+			level.Error(logger).Log("msg", fmt.Errorf("failed to connect to postgres server: [%s@%s]", options.Username, options.Host), "err", err)
+			return nil, err
+		}
+		sqlDB.SetMaxIdleConns(int(options.MaxIdleConnections))
+		sqlDB.SetConnMaxLifetime(options.GetStdMaxConnectionLifeTime())
+		sqlDB.SetMaxOpenConns(int(options.MaxOpenConnections))
+	}
+
+	stopCh := signals.SetupSignalHandler(logger)
+	stopCh.PreStop(signals.LevelDB, func() {
+		if sqlDB, err := db.DB(); err == nil {
+			if err = sqlDB.Close(); err != nil {
+				level.Warn(logger).Log("msg", fmt.Errorf("failed to close postgres connect: [%s@%s]", options.Username, options.Host), "err", err)
+			}
+		} else {
+			level.Warn(logger).Log("msg", fmt.Errorf("failed to close postgres connect: [%s@%s]", options.Username, options.Host), "err", err)
+		}
+		level.Debug(logger).Log("msg", "Postgres connect closed")
+	})
+	level.Info(logger).Log("msg", "connected to postgres server",
+		"host", options.Host, "username", options.Username,
+		"database", options.Database, "schema", options.Schema)
+	clt.database = db
+	clt.statsCollector = collector.Register(clt)
+	return clt, nil
+}
+
+func NewPostgresOptions() *PostgresOptions {
+	return &PostgresOptions{
+		MaxIdleConnections:    2,
+		MaxOpenConnections:    100,
+		MaxConnectionLifeTime: (*model.Duration)(w.P(30 * time.Second)),
+		TablePrefix:           "t_",
+		Host:                  "localhost",
+		Database:              "idas",
+		Username:              "idas",
+	}
+}
+
+type PostgresClient struct {
+	*Client
+	options *PostgresOptions
+}
+
+func (c PostgresClient) Options() PostgresOptions {
+	return *c.options
+}
+
+func (c *PostgresClient) SetOptions(o *PostgresOptions) {
+	c.options = o
+}
+
+func (c PostgresClient) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.options)
+}
+
+func (c *PostgresClient) UnmarshalJSON(data []byte) (err error) {
+	if c.options == nil {
+		c.options = NewPostgresOptions()
+	}
+	if err = json.Unmarshal(data, c.options); err != nil {
+		return err
+	}
+	if c.Client, err = NewPostgresClient(context.Background(), "", *c.options); err != nil {
+		return err
+	}
+	return
+}
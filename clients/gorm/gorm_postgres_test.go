@@ -0,0 +1,98 @@
+package gorm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/MicroOps-cn/fuck/clients/tls"
+)
+
+func TestPostgresOptions_GetConnConfig(t *testing.T) {
+	var o PostgresOptions
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"host": "db.example.com:15432",
+		"username": "idas",
+		"password": "p@ss word'\"",
+		"database": "idas",
+		"schema": "app",
+		"connect_timeout": "3s",
+		"tls_config": {"insecure_skip_verify": true}
+	}`), &o))
+	host, port := o.GetPeer()
+	require.Equal(t, "db.example.com", host)
+	require.Equal(t, 15432, port)
+	require.Equal(t, "postgres://idas@db.example.com:15432/idas", o.String())
+
+	cfg, err := o.GetConnConfig()
+	require.NoError(t, err)
+	require.Equal(t, "db.example.com", cfg.Host)
+	require.Equal(t, uint16(15432), cfg.Port)
+	require.Equal(t, "idas", cfg.User)
+	require.Equal(t, `p@ss word'"`, cfg.Password)
+	require.Equal(t, "idas", cfg.Database)
+	require.Equal(t, "app", cfg.RuntimeParams["search_path"])
+	require.Equal(t, 3*time.Second, cfg.ConnectTimeout)
+	require.NotNil(t, cfg.TLSConfig)
+	require.True(t, cfg.TLSConfig.InsecureSkipVerify)
+	require.Equal(t, "db.example.com", cfg.TLSConfig.ServerName)
+
+	o.Host = "localhost"
+	o.TLSConfig = nil
+	cfg, err = o.GetConnConfig()
+	require.NoError(t, err)
+	require.Equal(t, uint16(5432), cfg.Port)
+	require.Nil(t, cfg.TLSConfig)
+
+	o.TLSConfig = &tls.TLSOptions{CertFile: "client.crt"}
+	_, err = o.GetConnConfig()
+	require.Error(t, err)
+}
+
+func TestPostgresOptions_Equal(t *testing.T) {
+	a, b := NewPostgresOptions(), NewPostgresOptions()
+	require.True(t, a.Equal(*b))
+	b.Schema = "app"
+	require.False(t, a.Equal(*b))
+	b.Schema = ""
+	b.TLSConfig = &tls.TLSOptions{ServerName: "db"}
+	require.False(t, a.Equal(*b))
+}
+
+// TestNewPostgresClient runs against the server of POSTGRES_HOST, such as a local container:
+//
+//	docker run -d -p 5432:5432 -e POSTGRES_PASSWORD=postgres postgres
+//	POSTGRES_HOST=localhost:5432 POSTGRES_USERNAME=postgres POSTGRES_PASSWORD=postgres go test ./clients/gorm/
+func TestNewPostgresClient(t *testing.T) {
+	host := os.Getenv("POSTGRES_HOST")
+	if len(host) == 0 {
+		t.Skip("POSTGRES_HOST is not set")
+	}
+	o := NewPostgresOptions()
+	o.Host = host
+	o.Username = os.Getenv("POSTGRES_USERNAME")
+	require.NoError(t, o.Password.SetValue(os.Getenv("POSTGRES_PASSWORD")))
+	o.Database = fmt.Sprintf("fuck_test_%d", time.Now().UnixNano())
+	o.Schema = "app"
+
+	clt, err := NewPostgresClient(context.Background(), "postgres-test", *o)
+	require.NoError(t, err)
+	type Item struct {
+		ID   uint
+		Name string
+	}
+	db := clt.Session(context.Background())
+	require.NoError(t, db.AutoMigrate(&Item{}))
+	require.NoError(t, db.Create(&Item{Name: "a"}).Error)
+	var item Item
+	require.NoError(t, db.First(&item).Error)
+	require.Equal(t, "a", item.Name)
+	var schema string
+	require.NoError(t, db.Raw("SELECT current_schema()").Scan(&schema).Error)
+	require.Equal(t, "app", schema)
+}
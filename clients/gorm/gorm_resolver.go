@@ -0,0 +1,251 @@
+/*
+ Copyright © 2022 MicroOps-cn.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gorm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"gorm.io/gorm"
+)
+
+// ReplicaPolicy is how the resolver chooses the replica of a read.
+type ReplicaPolicy string
+
+const (
+	ReplicaPolicyRandom           ReplicaPolicy = "random"
+	ReplicaPolicyRoundRobin       ReplicaPolicy = "round_robin"
+	ReplicaPolicyLeastConnections ReplicaPolicy = "least_connections"
+)
+
+// DefaultReplicaHealthCheckInterval is the interval of the health checks of the replicas when it is not configured.
+const DefaultReplicaHealthCheckInterval = 10 * time.Second
+
+// replica is a read-only connection pool of a Client.
+type replica struct {
+	host    string
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+// resolver is a gorm plugin that routes the queries to the replicas, and the writes, the transactions, the locking
+// reads and the raw statements other than SELECT to the primary. The reads fall back to the primary when all the
+// replicas are unhealthy.
+type resolver struct {
+	policy   ReplicaPolicy
+	interval time.Duration
+	replicas []*replica
+	primary  gorm.ConnPool
+	next     atomic.Uint64
+	logger   kitlog.Logger
+
+	started   atomic.Bool
+	closeOnce sync.Once
+	stopCh    chan struct{}
+	stopped   chan struct{}
+}
+
+func newResolver(logger kitlog.Logger, policy ReplicaPolicy, interval time.Duration, replicas []*replica) (*resolver, error) {
+	switch policy {
+	case "":
+		policy = ReplicaPolicyRandom
+	case ReplicaPolicyRandom, ReplicaPolicyRoundRobin, ReplicaPolicyLeastConnections:
+	default:
+		return nil, fmt.Errorf("unknown replica policy: %s", policy)
+	}
+	if interval <= 0 {
+		interval = DefaultReplicaHealthCheckInterval
+	}
+	for _, rep := range replicas {
+		// the replicas that fail the first health check are logged as ejected.
+		rep.healthy.Store(true)
+	}
+	return &resolver{
+		policy:   policy,
+		interval: interval,
+		replicas: replicas,
+		logger:   logger,
+		stopCh:   make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}, nil
+}
+
+func (r *resolver) Name() string {
+	return "fuck:resolver"
+}
+
+// Initialize registers the callbacks of the resolver, checks the health of the replicas, and starts the periodic
+// health checks.
+func (r *resolver) Initialize(db *gorm.DB) error {
+	r.primary = db.ConnPool
+	if err := errors.Join(
+		db.Callback().Query().Before("gorm:query").Register("fuck:resolver_read", r.switchReplica),
+		db.Callback().Row().Before("gorm:row").Register("fuck:resolver_read", r.switchReplica),
+		db.Callback().Create().Before("gorm:begin_transaction").Register("fuck:resolver_write", r.switchPrimary),
+		db.Callback().Update().Before("gorm:begin_transaction").Register("fuck:resolver_write", r.switchPrimary),
+		db.Callback().Delete().Before("gorm:begin_transaction").Register("fuck:resolver_write", r.switchPrimary),
+		db.Callback().Raw().Before("gorm:raw").Register("fuck:resolver_write", r.switchPrimary),
+	); err != nil {
+		return err
+	}
+	r.checkHealth()
+	r.started.Store(true)
+	go r.run()
+	return nil
+}
+
+type forcePrimary struct{}
+
+// WithPrimaryContext returns a context whose reads are executed by the primary, such as the reads that must see the
+// writes made just before, regardless of the replication lag.
+func WithPrimaryContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forcePrimary{}, true)
+}
+
+func isPrimaryForced(ctx context.Context) bool {
+	forced, _ := ctx.Value(forcePrimary{}).(bool)
+	return forced
+}
+
+func (r *resolver) isReplica(pool gorm.ConnPool) bool {
+	for _, rep := range r.replicas {
+		if pool == gorm.ConnPool(rep.db) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *resolver) switchPrimary(db *gorm.DB) {
+	if r.isReplica(db.Statement.ConnPool) {
+		db.Statement.ConnPool = r.primary
+	}
+}
+
+func (r *resolver) switchReplica(db *gorm.DB) {
+	stmt := db.Statement
+	if stmt.ConnPool != r.primary && !r.isReplica(stmt.ConnPool) {
+		// the transactions and the connections pinned by gorm.DB.Connection stay on their connection.
+		return
+	}
+	if _, ok := stmt.Clauses["FOR"]; ok || isPrimaryForced(stmt.Context) || !isReadSQL(stmt.SQL.String()) {
+		r.switchPrimary(db)
+		return
+	}
+	if rep := r.pick(); rep != nil {
+		stmt.ConnPool = rep.db
+	} else {
+		r.switchPrimary(db)
+	}
+}
+
+// isReadSQL reports whether the raw SQL of a statement is a read, the statements built by gorm have no SQL when the
+// resolver is called.
+func isReadSQL(sql string) bool {
+	sql = strings.TrimSpace(sql)
+	if len(sql) == 0 {
+		return true
+	}
+	return len(sql) >= 6 && strings.EqualFold(sql[:6], "select") && !strings.Contains(strings.ToUpper(sql), " FOR UPDATE")
+}
+
+// pick returns the replica of a read, or nil if all the replicas are unhealthy.
+func (r *resolver) pick() *replica {
+	healthy := make([]*replica, 0, len(r.replicas))
+	for _, rep := range r.replicas {
+		if rep.healthy.Load() {
+			healthy = append(healthy, rep)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+	switch r.policy {
+	case ReplicaPolicyRoundRobin:
+		return healthy[(r.next.Add(1)-1)%uint64(len(healthy))]
+	case ReplicaPolicyLeastConnections:
+		least := healthy[0]
+		inUse := least.db.Stats().InUse
+		for _, rep := range healthy[1:] {
+			if n := rep.db.Stats().InUse; n < inUse {
+				least, inUse = rep, n
+			}
+		}
+		return least
+	default:
+		return healthy[rand.Intn(len(healthy))]
+	}
+}
+
+func (r *resolver) run() {
+	defer close(r.stopped)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.checkHealth()
+		case <-r.stopCh:
+			return
+		}
+	}
+}
+
+// checkHealth pings the replicas, the failed replicas are ejected until a ping succeeds.
+func (r *resolver) checkHealth() {
+	var wg sync.WaitGroup
+	for _, rep := range r.replicas {
+		wg.Add(1)
+		go func(rep *replica) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), r.interval)
+			defer cancel()
+			err := rep.db.PingContext(ctx)
+			if err != nil && rep.healthy.Swap(false) {
+				level.Warn(r.logger).Log("msg", "replica is unhealthy, eject it", "host", rep.host, "err", err)
+			} else if err == nil && !rep.healthy.Swap(true) {
+				level.Info(r.logger).Log("msg", "replica is healthy", "host", rep.host)
+			}
+		}(rep)
+	}
+	wg.Wait()
+}
+
+// Close stops the health checks and closes the replicas.
+func (r *resolver) Close() (err error) {
+	r.closeOnce.Do(func() {
+		close(r.stopCh)
+		if r.started.Load() {
+			<-r.stopped
+		}
+		for _, rep := range r.replicas {
+			if closeErr := rep.db.Close(); closeErr != nil {
+				err = errors.Join(err, fmt.Errorf("failed to close replica %s: %w", rep.host, closeErr))
+			}
+		}
+	})
+	return err
+}
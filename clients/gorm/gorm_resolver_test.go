@@ -0,0 +1,129 @@
+package gorm
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type resolverItem struct {
+	ID   uint
+	Name string
+}
+
+// newResolverTestClient returns a SQLite client whose replicas are separate databases, so that the database of a read
+// is told by the name of the item it returns.
+func newResolverTestClient(t *testing.T, policy ReplicaPolicy, replicaNames ...string) (*Client, []*replica) {
+	dir := t.TempDir()
+	clt, err := NewGormSQLiteClient(context.Background(), t.Name(), &SQLiteOptions{Path: filepath.Join(dir, "primary.db")})
+	require.NoError(t, err)
+	require.NoError(t, clt.Session(context.Background()).AutoMigrate(&resolverItem{}))
+	require.NoError(t, clt.Session(context.Background()).Create(&resolverItem{Name: "primary"}).Error)
+
+	var replicas []*replica
+	for _, name := range replicaNames {
+		path := filepath.Join(dir, name+".db")
+		db, err := sql.Open("sqlite", path)
+		require.NoError(t, err)
+		_, err = db.Exec("CREATE TABLE t_resolver_item (id integer PRIMARY KEY AUTOINCREMENT, name text)")
+		require.NoError(t, err)
+		_, err = db.Exec("INSERT INTO t_resolver_item (name) VALUES (?)", name)
+		require.NoError(t, err)
+		replicas = append(replicas, &replica{host: path, db: db})
+	}
+	require.NoError(t, clt.useReplicas(context.Background(), policy, time.Hour, replicas))
+	t.Cleanup(func() { require.NoError(t, clt.Close()) })
+	return clt, replicas
+}
+
+func readName(t *testing.T, db *gorm.DB) string {
+	var item resolverItem
+	require.NoError(t, db.First(&item).Error)
+	return item.Name
+}
+
+func TestResolver_Routing(t *testing.T) {
+	clt, _ := newResolverTestClient(t, ReplicaPolicyRoundRobin, "replica1", "replica2")
+	ctx := context.Background()
+
+	require.Equal(t, "replica1", readName(t, clt.Session(ctx)))
+	require.Equal(t, "replica2", readName(t, clt.Session(ctx)))
+	var name string
+	require.NoError(t, clt.Session(ctx).Raw("SELECT name FROM t_resolver_item").Scan(&name).Error)
+	require.Equal(t, "replica1", name)
+
+	require.Equal(t, "primary", readName(t, clt.Session(WithPrimaryContext(ctx))))
+
+	require.NoError(t, clt.Session(ctx).Create(&resolverItem{Name: "created"}).Error)
+	require.NoError(t, clt.Session(ctx).Exec("UPDATE t_resolver_item SET name = ? WHERE name = ?", "updated", "created").Error)
+	var count int64
+	require.NoError(t, clt.Session(WithPrimaryContext(ctx)).Model(&resolverItem{}).Where("name = ?", "updated").Count(&count).Error)
+	require.Equal(t, int64(1), count)
+
+	require.NoError(t, clt.Session(ctx).Transaction(func(tx *gorm.DB) error {
+		require.Equal(t, "primary", readName(t, tx))
+		return nil
+	}))
+
+	require.NoError(t, clt.Session(ctx).Connection(func(conn *gorm.DB) error {
+		require.Equal(t, "primary", readName(t, conn))
+		var name string
+		require.NoError(t, conn.Raw("SELECT name FROM t_resolver_item WHERE id = 1").Scan(&name).Error)
+		require.Equal(t, "primary", name)
+		return nil
+	}))
+
+	// a statement that is read and then written is written to the primary.
+	stmt := clt.Session(ctx).Model(&resolverItem{}).Where("name = ?", "updated")
+	var items []resolverItem
+	require.NoError(t, stmt.Find(&items).Error)
+	require.Empty(t, items)
+	require.NoError(t, stmt.Update("name", "again").Error)
+	require.NoError(t, clt.Session(WithPrimaryContext(ctx)).Model(&resolverItem{}).Where("name = ?", "again").Count(&count).Error)
+	require.Equal(t, int64(1), count)
+}
+
+func TestResolver_HealthCheck(t *testing.T) {
+	clt, replicas := newResolverTestClient(t, ReplicaPolicyRandom, "replica1", "replica2")
+	ctx := context.Background()
+
+	require.Equal(t, 2, testutil.CollectAndCount(collector, "gorm_dbstats_replica_healthy"))
+
+	require.NoError(t, replicas[0].db.Close())
+	clt.resolver.checkHealth()
+	require.False(t, replicas[0].healthy.Load())
+	for i := 0; i < 10; i++ {
+		require.Equal(t, "replica2", readName(t, clt.Session(ctx)))
+	}
+
+	require.NoError(t, replicas[1].db.Close())
+	clt.resolver.checkHealth()
+	require.Equal(t, "primary", readName(t, clt.Session(ctx)))
+}
+
+func TestResolver_LeastConnections(t *testing.T) {
+	clt, replicas := newResolverTestClient(t, ReplicaPolicyLeastConnections, "replica1", "replica2")
+	ctx := context.Background()
+
+	conn, err := replicas[0].db.Conn(ctx)
+	require.NoError(t, err)
+	defer conn.Close()
+	for i := 0; i < 3; i++ {
+		require.Equal(t, "replica2", readName(t, clt.Session(ctx)))
+	}
+}
+
+func TestNewResolver(t *testing.T) {
+	_, err := newResolver(nil, "weighted", 0, nil)
+	require.Error(t, err)
+	r, err := newResolver(nil, "", 0, nil)
+	require.NoError(t, err)
+	require.Equal(t, ReplicaPolicyRandom, r.policy)
+	require.Equal(t, DefaultReplicaHealthCheckInterval, r.interval)
+}
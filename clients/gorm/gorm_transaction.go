@@ -0,0 +1,258 @@
+/*
+ Copyright © 2022 MicroOps-cn.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gorm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	gosqlite "github.com/glebarez/go-sqlite"
+	"github.com/go-kit/log/level"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+
+	logs "github.com/MicroOps-cn/fuck/log"
+)
+
+const (
+	DefaultTxMaxAttempts    = 3
+	DefaultTxInitialBackoff = 10 * time.Millisecond
+	DefaultTxMaxBackoff     = time.Second
+)
+
+type TxOptions struct {
+	// Isolation and ReadOnly are the options of the transaction, the nested transactions use the options of the
+	// outermost transaction.
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+	// MaxAttempts is how many times the transaction is executed when it fails with a deadlock or a serialization
+	// failure, 1 disables the retries. The function of a retried transaction must be safe to call again.
+	MaxAttempts int
+	// InitialBackoff and MaxBackoff bound the random delay before a retry, the upper bound of the delay doubles
+	// after every attempt.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+func (o *TxOptions) withDefaults() TxOptions {
+	var opts TxOptions
+	if o != nil {
+		opts = *o
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultTxMaxAttempts
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = DefaultTxInitialBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultTxMaxBackoff
+	}
+	return opts
+}
+
+func (o TxOptions) backoff(attempt int) time.Duration {
+	d := o.InitialBackoff
+	for i := 1; i < attempt && d < o.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > o.MaxBackoff {
+		d = o.MaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// txState is the state of the transaction of a context.
+type txState struct {
+	client     *Client
+	mux        sync.Mutex
+	savepoints int
+	hooks      []func(ctx context.Context)
+}
+
+type txStateKey struct{}
+
+func getTxState(ctx context.Context) *txState {
+	state, _ := ctx.Value(txStateKey{}).(*txState)
+	return state
+}
+
+// AfterCommit registers hook to be called after the outermost transaction of ctx is committed, the hooks are called
+// in the order they are registered. The hooks of a transaction that is rolled back, including the nested
+// transactions rolled back to their savepoints, are discarded. Without a transaction, hook is called at once.
+func AfterCommit(ctx context.Context, hook func(ctx context.Context)) {
+	state := getTxState(ctx)
+	if state == nil {
+		hook(ctx)
+		return
+	}
+	state.mux.Lock()
+	defer state.mux.Unlock()
+	state.hooks = append(state.hooks, hook)
+}
+
+// IsRetryableTxError reports whether err is a deadlock or a serialization failure, which succeeds when the
+// transaction is executed again.
+func IsRetryableTxError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	var pgErr *pgconn.PgError
+	var sqliteErr *gosqlite.Error
+	switch {
+	case errors.As(err, &mysqlErr):
+		// ER_LOCK_DEADLOCK
+		return mysqlErr.Number == 1213
+	case errors.As(err, &pgErr):
+		// serialization_failure and deadlock_detected
+		return pgErr.Code == "40001" || pgErr.Code == "40P01"
+	case errors.As(err, &sqliteErr):
+		// SQLITE_BUSY and SQLITE_LOCKED
+		return sqliteErr.Code()&0xff == 5 || sqliteErr.Code()&0xff == 6
+	}
+	return false
+}
+
+// Transaction calls fn in a transaction, which is committed if fn returns nil and rolled back otherwise. The sessions
+// of the context passed to fn are in the transaction.
+//
+// When ctx is already in a transaction of the client, the call is nested: fn is called in a savepoint, which is
+// rolled back if fn fails, and the outermost transaction is not affected unless its function returns the error.
+// The outermost transaction is retried with backoff when it fails with a deadlock or a serialization failure.
+func (c *Client) Transaction(ctx context.Context, fn func(ctx context.Context) error, opts *TxOptions) error {
+	if state := getTxState(ctx); state != nil && state.client == c {
+		return c.savepoint(ctx, state, fn)
+	}
+	o := opts.withDefaults()
+	logger := logs.GetContextLogger(ctx)
+	ctx, span := c.getTracer().Start(ctx, "Transaction", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	fail := func(attempt int, err error) error {
+		span.SetAttributes(attribute.Int("db.transaction.attempts", attempt))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		state, err := c.transaction(ctx, fn, &sql.TxOptions{Isolation: o.Isolation, ReadOnly: o.ReadOnly})
+		if err == nil {
+			span.SetAttributes(attribute.Int("db.transaction.attempts", attempt))
+			span.SetStatus(codes.Ok, "")
+			for _, hook := range state.hooks {
+				hook(ctx)
+			}
+			return nil
+		}
+		if attempt >= o.MaxAttempts || !IsRetryableTxError(err) {
+			return fail(attempt, err)
+		}
+		delay := o.backoff(attempt)
+		level.Debug(logger).Log("msg", "retry transaction", "attempt", attempt, "delay", delay, "err", err)
+		span.AddEvent("retry", trace.WithAttributes(attribute.Int("db.transaction.attempt", attempt), attribute.String("error", err.Error())))
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fail(attempt, errors.Join(err, ctx.Err()))
+		case <-timer.C:
+		}
+	}
+}
+
+// transaction executes an attempt of the outermost transaction, and returns its state to call the hooks.
+func (c *Client) transaction(ctx context.Context, fn func(ctx context.Context) error, opts *sql.TxOptions) (state *txState, err error) {
+	var db *gorm.DB
+	if getTxState(ctx) != nil {
+		// ctx is in a transaction of another client, whose connection must not be used.
+		db = c.database.Session(&gorm.Session{Logger: c.newLogAdapter(logs.GetContextLogger(ctx))}).WithContext(ctx)
+	} else {
+		db = c.Session(ctx)
+	}
+	tx := db.Begin(opts)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+	state = &txState{client: c}
+	committed := false
+	defer func() {
+		if !committed {
+			if rollbackErr := tx.Rollback().Error; rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+				err = errors.Join(err, fmt.Errorf("failed to roll back transaction: %w", rollbackErr))
+			}
+		}
+	}()
+	if err = fn(context.WithValue(WithConnContext(ctx, tx), txStateKey{}, state)); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	committed = true
+	return state, nil
+}
+
+// savepoint calls fn in a savepoint of the transaction of ctx, which is rolled back with the hooks registered by fn
+// if fn fails or panics.
+func (c *Client) savepoint(ctx context.Context, state *txState, fn func(ctx context.Context) error) (err error) {
+	ctx, span := c.getTracer().Start(ctx, "Transaction", trace.WithSpanKind(trace.SpanKindClient))
+	state.mux.Lock()
+	state.savepoints++
+	name := fmt.Sprintf("sp%d", state.savepoints)
+	hooks := len(state.hooks)
+	state.mux.Unlock()
+	span.SetAttributes(attribute.Bool("db.transaction.nested", true), attribute.String("db.transaction.savepoint", name))
+
+	tx := c.Session(ctx)
+	if err = tx.SavePoint(name).Error; err != nil {
+		err = fmt.Errorf("failed to create savepoint: %w", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return err
+	}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			state.mux.Lock()
+			state.hooks = state.hooks[:hooks]
+			state.mux.Unlock()
+			if rollbackErr := tx.RollbackTo(name).Error; rollbackErr != nil {
+				err = errors.Join(err, fmt.Errorf("failed to roll back to savepoint: %w", rollbackErr))
+			}
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+		} else {
+			span.SetStatus(codes.Ok, "")
+		}
+		span.End()
+	}()
+	if err = fn(ctx); err != nil {
+		return err
+	}
+	succeeded = true
+	return nil
+}
@@ -0,0 +1,143 @@
+package gorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+type txItem struct {
+	ID   uint
+	Name string
+}
+
+func newTxTestClient(t *testing.T) *Client {
+	clt, err := NewGormSQLiteClient(context.Background(), t.Name(), &SQLiteOptions{Path: filepath.Join(t.TempDir(), "tx.db")})
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, clt.Close()) })
+	require.NoError(t, clt.Session(context.Background()).AutoMigrate(&txItem{}))
+	return clt
+}
+
+func txItemNames(t *testing.T, clt *Client) []string {
+	var names []string
+	require.NoError(t, clt.Session(context.Background()).Model(&txItem{}).Order("id").Pluck("name", &names).Error)
+	return names
+}
+
+func TestClient_Transaction(t *testing.T) {
+	clt := newTxTestClient(t)
+	ctx := context.Background()
+
+	var hooks []string
+	require.NoError(t, clt.Transaction(ctx, func(ctx context.Context) error {
+		require.NoError(t, clt.Session(ctx).Create(&txItem{Name: "a"}).Error)
+		AfterCommit(ctx, func(context.Context) { hooks = append(hooks, "a") })
+		require.Empty(t, hooks)
+		return nil
+	}, nil))
+	require.Equal(t, []string{"a"}, txItemNames(t, clt))
+	require.Equal(t, []string{"a"}, hooks)
+
+	errFailed := errors.New("failed")
+	require.ErrorIs(t, clt.Transaction(ctx, func(ctx context.Context) error {
+		require.NoError(t, clt.Session(ctx).Create(&txItem{Name: "b"}).Error)
+		AfterCommit(ctx, func(context.Context) { hooks = append(hooks, "b") })
+		return errFailed
+	}, nil), errFailed)
+	require.Equal(t, []string{"a"}, txItemNames(t, clt))
+	require.Equal(t, []string{"a"}, hooks)
+
+	require.Panics(t, func() {
+		_ = clt.Transaction(ctx, func(ctx context.Context) error {
+			require.NoError(t, clt.Session(ctx).Create(&txItem{Name: "c"}).Error)
+			panic("boom")
+		}, nil)
+	})
+	require.Equal(t, []string{"a"}, txItemNames(t, clt))
+
+	AfterCommit(ctx, func(context.Context) { hooks = append(hooks, "no transaction") })
+	require.Equal(t, []string{"a", "no transaction"}, hooks)
+}
+
+func TestClient_Transaction_Nested(t *testing.T) {
+	clt := newTxTestClient(t)
+	ctx := context.Background()
+
+	var hooks []string
+	errFailed := errors.New("failed")
+	require.NoError(t, clt.Transaction(ctx, func(ctx context.Context) error {
+		require.NoError(t, clt.Session(ctx).Create(&txItem{Name: "outer"}).Error)
+		AfterCommit(ctx, func(context.Context) { hooks = append(hooks, "outer") })
+
+		require.ErrorIs(t, clt.Transaction(ctx, func(ctx context.Context) error {
+			require.NoError(t, clt.Session(ctx).Create(&txItem{Name: "failed"}).Error)
+			AfterCommit(ctx, func(context.Context) { hooks = append(hooks, "failed") })
+			return errFailed
+		}, nil), errFailed)
+
+		require.NoError(t, clt.Transaction(ctx, func(ctx context.Context) error {
+			AfterCommit(ctx, func(context.Context) { hooks = append(hooks, "inner") })
+			return clt.Transaction(ctx, func(ctx context.Context) error {
+				return clt.Session(ctx).Create(&txItem{Name: "inner"}).Error
+			}, nil)
+		}, nil))
+		require.Empty(t, hooks)
+		return nil
+	}, nil))
+	require.Equal(t, []string{"outer", "inner"}, txItemNames(t, clt))
+	require.Equal(t, []string{"outer", "inner"}, hooks)
+
+	require.Error(t, clt.Transaction(ctx, func(ctx context.Context) error {
+		require.NoError(t, clt.Transaction(ctx, func(ctx context.Context) error {
+			return clt.Session(ctx).Create(&txItem{Name: "rolled back by the outer"}).Error
+		}, nil))
+		return errFailed
+	}, nil))
+	require.Equal(t, []string{"outer", "inner"}, txItemNames(t, clt))
+}
+
+func TestClient_Transaction_Retry(t *testing.T) {
+	clt := newTxTestClient(t)
+	ctx := context.Background()
+
+	for _, retryable := range []error{
+		&mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"},
+		&pgconn.PgError{Code: "40001", Message: "could not serialize access"},
+	} {
+		var attempts int
+		require.NoError(t, clt.Transaction(ctx, func(ctx context.Context) error {
+			attempts++
+			if err := clt.Session(ctx).Create(&txItem{Name: fmt.Sprintf("attempt %d", attempts)}).Error; err != nil {
+				return err
+			}
+			if attempts < 3 {
+				return fmt.Errorf("failed to update: %w", retryable)
+			}
+			return nil
+		}, nil))
+		require.Equal(t, 3, attempts)
+	}
+	require.Equal(t, []string{"attempt 3", "attempt 3"}, txItemNames(t, clt))
+
+	var attempts int
+	err := clt.Transaction(ctx, func(ctx context.Context) error {
+		attempts++
+		return &mysql.MySQLError{Number: 1213}
+	}, &TxOptions{MaxAttempts: 2})
+	require.True(t, IsRetryableTxError(err))
+	require.Equal(t, 2, attempts)
+
+	attempts = 0
+	require.Error(t, clt.Transaction(ctx, func(ctx context.Context) error {
+		attempts++
+		return &mysql.MySQLError{Number: 1062}
+	}, nil))
+	require.Equal(t, 1, attempts)
+}
@@ -38,6 +38,24 @@ type logContext struct {
 	logger        kitlog.Logger
 	slowThreshold time.Duration
 	tracer        trace.Tracer
+	name          string
+	slowQueries   *SlowQueryLog
+}
+
+type LogAdapterOption func(l *logContext)
+
+// WithClientName sets the client name of the query metrics of the adapter.
+func WithClientName(name string) LogAdapterOption {
+	return func(l *logContext) {
+		l.name = name
+	}
+}
+
+// WithSlowQueryLog records the queries slower than the slow threshold of the adapter in slowQueries.
+func WithSlowQueryLog(slowQueries *SlowQueryLog) LogAdapterOption {
+	return func(l *logContext) {
+		l.slowQueries = slowQueries
+	}
 }
 
 func (l *logContext) LogMode(lvl logger.LogLevel) logger.Interface {
@@ -55,7 +73,7 @@ func (l *logContext) LogMode(lvl logger.LogLevel) logger.Interface {
 		filter = l.logger
 	}
 
-	return NewLogAdapter(filter, l.slowThreshold, l.tracer)
+	return NewLogAdapter(filter, l.slowThreshold, l.tracer, WithClientName(l.name), WithSlowQueryLog(l.slowQueries))
 }
 
 func (l logContext) Info(_ context.Context, msg string, data ...interface{}) {
@@ -81,6 +99,7 @@ func (l logContext) Trace(ctx context.Context, begin time.Time, fc func() (strin
 	defer span.End()
 	sql, rows := fc()
 	span.SetAttributes(attribute.String("db.statement", sql), attribute.Int64("db.row_return_count", rows))
+	l.observe(begin, elapsed, sql, rows, err)
 	switch {
 	case err != nil && err != gorm.ErrRecordNotFound:
 		span.SetStatus(codes.Error, err.Error())
@@ -94,8 +113,34 @@ func (l logContext) Trace(ctx context.Context, begin time.Time, fc func() (strin
 	}
 }
 
-func NewLogAdapter(l kitlog.Logger, slowThreshold time.Duration, tracer trace.Tracer) logger.Interface {
-	return &logContext{logger: l, slowThreshold: slowThreshold, tracer: tracer}
+// observe records the query metrics, and the slow query if the adapter has a SlowQueryLog.
+func (l logContext) observe(begin time.Time, elapsed time.Duration, sql string, rows int64, err error) {
+	operation, table := parseQuery(sql)
+	queryDurationHistogramVec.WithLabelValues(l.name, operation, table).Observe(elapsed.Seconds())
+	if rows > 0 {
+		queryRowsAffectedCounterVec.WithLabelValues(l.name, operation, table).Add(float64(rows))
+	}
+	if err != nil && err != gorm.ErrRecordNotFound {
+		queryErrorsCounterVec.WithLabelValues(l.name, operation, table).Inc()
+	}
+	if elapsed > l.slowThreshold && l.slowThreshold != 0 {
+		slowQueriesCounterVec.WithLabelValues(l.name, operation, table).Inc()
+		if l.slowQueries != nil {
+			q := SlowQuery{Time: begin, Client: l.name, Operation: operation, Table: table, SQL: sql, Duration: elapsed, Rows: rows}
+			if err != nil {
+				q.Error = err.Error()
+			}
+			l.slowQueries.Add(q)
+		}
+	}
+}
+
+func NewLogAdapter(l kitlog.Logger, slowThreshold time.Duration, tracer trace.Tracer, opts ...LogAdapterOption) logger.Interface {
+	adapter := &logContext{logger: l, slowThreshold: slowThreshold, tracer: tracer}
+	for _, opt := range opts {
+		opt(adapter)
+	}
+	return adapter
 }
 
 var _ logger.Interface = new(logContext)
@@ -0,0 +1,486 @@
+/*
+ Copyright © 2022 MicroOps-cn.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+// Package migrate applies versioned schema migrations to the databases of the gorm clients.
+//
+// The migrations are SQL files loaded from an fs.FS, such as an embed.FS or a storage.Storage, or Go functions. The
+// SQL files are named <version>_<name>.up.sql and <version>_<name>.down.sql, the down file is optional:
+//
+//	migrations/
+//	  0001_create_user.up.sql
+//	  0001_create_user.down.sql
+//	  0002_add_user_email.up.sql
+//
+// The applied versions are recorded in the schema_migration table, which is named by the naming strategy of the
+// database, so that it honors the TablePrefix of the client.
+package migrate
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/go-kit/log/level"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	logs "github.com/MicroOps-cn/fuck/log"
+)
+
+// DefaultLockTimeout is how long a run waits for the lock of the other runs when Options.LockTimeout is not set.
+const DefaultLockTimeout = 5 * time.Minute
+
+// ErrIrreversible is returned when a migration that has no Down is rolled back.
+var ErrIrreversible = errors.New("migration is irreversible")
+
+// Migration is a versioned change of the schema. Down is nil if the migration cannot be rolled back.
+type Migration struct {
+	Version uint64
+	Name    string
+	Up      func(ctx context.Context, db *gorm.DB) error
+	Down    func(ctx context.Context, db *gorm.DB) error
+}
+
+// SchemaMigration is a record of the applied migrations.
+type SchemaMigration struct {
+	Version   uint64 `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"size:255"`
+	AppliedAt time.Time
+}
+
+// SchemaMigrationLock exists while a run holds the lock of the databases that have no advisory locks.
+type SchemaMigrationLock struct {
+	ID uint8 `gorm:"primaryKey;autoIncrement:false"`
+}
+
+type Direction string
+
+const (
+	DirectionUp   Direction = "up"
+	DirectionDown Direction = "down"
+)
+
+// Step is a migration applied or rolled back by a run, the SQL is only recorded by the dry runs.
+type Step struct {
+	Version   uint64
+	Name      string
+	Direction Direction
+	SQL       []string
+	Duration  time.Duration
+}
+
+type Options struct {
+	// DryRun plans the run and records the SQL of the steps without executing them. The Go migrations are executed
+	// with a gorm dry run session, so that their statements are recorded but their queries return nothing.
+	DryRun bool
+	// LockTimeout is how long a run waits for the other runs.
+	LockTimeout time.Duration
+}
+
+type Migrator struct {
+	db         *gorm.DB
+	o          Options
+	migrations map[uint64]*Migration
+}
+
+// New returns a Migrator of db, such as the session of a gorm client.
+func New(db *gorm.DB, o Options) *Migrator {
+	if o.LockTimeout <= 0 {
+		o.LockTimeout = DefaultLockTimeout
+	}
+	return &Migrator{db: db, o: o, migrations: make(map[uint64]*Migration)}
+}
+
+// Register adds the Go migrations.
+func (m *Migrator) Register(migrations ...Migration) error {
+	for _, migration := range migrations {
+		if migration.Up == nil {
+			return fmt.Errorf("migration %d_%s has no up", migration.Version, migration.Name)
+		}
+		if migration.Version == 0 {
+			return fmt.Errorf("migration %s has no version", migration.Name)
+		}
+		if exists, ok := m.migrations[migration.Version]; ok {
+			return fmt.Errorf("duplicate migration version %d: %s and %s", migration.Version, exists.Name, migration.Name)
+		}
+		migration := migration
+		m.migrations[migration.Version] = &migration
+	}
+	return nil
+}
+
+var sqlFileRegexp = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// LoadFS adds the SQL migrations of the directory dir of fsys, the files not named as migrations are ignored.
+func (m *Migrator) LoadFS(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+	type sqlFiles struct {
+		name     string
+		up, down string
+	}
+	files := make(map[uint64]*sqlFiles)
+	for _, entry := range entries {
+		matches := sqlFileRegexp.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || matches == nil {
+			continue
+		}
+		version, err := strconv.ParseUint(matches[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid version of migration %s: %w", entry.Name(), err)
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		f, ok := files[version]
+		if !ok {
+			f = &sqlFiles{name: matches[2]}
+			files[version] = f
+		} else if f.name != matches[2] {
+			return fmt.Errorf("duplicate migration version %d: %s and %s", version, f.name, matches[2])
+		}
+		if matches[3] == string(DirectionUp) {
+			f.up = string(content)
+		} else {
+			f.down = string(content)
+		}
+	}
+	for version, f := range files {
+		if len(f.up) == 0 {
+			return fmt.Errorf("migration %d_%s has no up file", version, f.name)
+		}
+		migration := Migration{Version: version, Name: f.name, Up: sqlFunc(f.up)}
+		if len(f.down) != 0 {
+			migration.Down = sqlFunc(f.down)
+		}
+		if err = m.Register(migration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqlFunc returns a migration that executes the statements of script one by one, since the drivers of MySQL and
+// ClickHouse do not execute multiple statements at once.
+func sqlFunc(script string) func(ctx context.Context, db *gorm.DB) error {
+	statements := splitStatements(script)
+	return func(ctx context.Context, db *gorm.DB) error {
+		for _, stmt := range statements {
+			if err := db.WithContext(ctx).Exec(stmt).Error; err != nil {
+				return fmt.Errorf("failed to execute %q: %w", stmt, err)
+			}
+		}
+		return nil
+	}
+}
+
+// Migrations returns the registered migrations in the order of their versions.
+func (m *Migrator) Migrations() []Migration {
+	migrations := make([]Migration, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations
+}
+
+// Applied returns the applied migrations in the order of their versions.
+func (m *Migrator) Applied(ctx context.Context) (applied []SchemaMigration, err error) {
+	err = m.db.Connection(func(conn *gorm.DB) error {
+		applied, err = m.applied(ctx, conn)
+		return err
+	})
+	return applied, err
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *gorm.DB) ([]SchemaMigration, error) {
+	var applied []SchemaMigration
+	if !conn.Migrator().HasTable(&SchemaMigration{}) {
+		return nil, nil
+	}
+	if err := conn.WithContext(ctx).Order("version").Find(&applied).Error; err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// Up applies all the pending migrations, including the ones older than the latest applied migration.
+func (m *Migrator) Up(ctx context.Context) ([]Step, error) {
+	return m.run(ctx, func(applied map[uint64]SchemaMigration) ([]plannedStep, error) {
+		return m.planUp(applied, ^uint64(0)), nil
+	})
+}
+
+// To applies the pending migrations up to version and rolls back the applied migrations after version, To(ctx, 0)
+// rolls back all the migrations.
+func (m *Migrator) To(ctx context.Context, version uint64) ([]Step, error) {
+	if _, ok := m.migrations[version]; !ok && version != 0 {
+		return nil, fmt.Errorf("unknown migration version %d", version)
+	}
+	return m.run(ctx, func(applied map[uint64]SchemaMigration) ([]plannedStep, error) {
+		down, err := m.planDown(applied, func(v uint64) bool { return v > version })
+		if err != nil {
+			return nil, err
+		}
+		return append(down, m.planUp(applied, version)...), nil
+	})
+}
+
+// Rollback rolls back the latest n applied migrations.
+func (m *Migrator) Rollback(ctx context.Context, n int) ([]Step, error) {
+	return m.run(ctx, func(applied map[uint64]SchemaMigration) ([]plannedStep, error) {
+		if n <= 0 {
+			return nil, nil
+		}
+		versions := sortedVersions(applied)
+		if n < len(versions) {
+			versions = versions[len(versions)-n:]
+		}
+		rollback := make(map[uint64]bool, len(versions))
+		for _, v := range versions {
+			rollback[v] = true
+		}
+		return m.planDown(applied, func(v uint64) bool { return rollback[v] })
+	})
+}
+
+type plannedStep struct {
+	migration *Migration
+	direction Direction
+}
+
+func sortedVersions(applied map[uint64]SchemaMigration) []uint64 {
+	versions := make([]uint64, 0, len(applied))
+	for v := range applied {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions
+}
+
+func (m *Migrator) planUp(applied map[uint64]SchemaMigration, to uint64) []plannedStep {
+	var steps []plannedStep
+	for _, migration := range m.Migrations() {
+		if _, ok := applied[migration.Version]; !ok && migration.Version <= to {
+			steps = append(steps, plannedStep{migration: m.migrations[migration.Version], direction: DirectionUp})
+		}
+	}
+	return steps
+}
+
+// planDown plans the rollback of the applied migrations selected by match, from the latest to the oldest.
+func (m *Migrator) planDown(applied map[uint64]SchemaMigration, match func(v uint64) bool) ([]plannedStep, error) {
+	var steps []plannedStep
+	versions := sortedVersions(applied)
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := versions[i]
+		if !match(v) {
+			continue
+		}
+		migration, ok := m.migrations[v]
+		if !ok {
+			return nil, fmt.Errorf("migration %d_%s is applied but unknown", v, applied[v].Name)
+		} else if migration.Down == nil {
+			return nil, fmt.Errorf("failed to roll back migration %d_%s: %w", v, migration.Name, ErrIrreversible)
+		}
+		steps = append(steps, plannedStep{migration: migration, direction: DirectionDown})
+	}
+	return steps, nil
+}
+
+// run executes the steps planned by plan on a connection that holds the lock, so that the concurrent runs are
+// executed one by one.
+func (m *Migrator) run(ctx context.Context, plan func(applied map[uint64]SchemaMigration) ([]plannedStep, error)) (steps []Step, err error) {
+	err = m.db.WithContext(ctx).Connection(func(conn *gorm.DB) (err error) {
+		if !m.o.DryRun {
+			unlock, err := m.lock(ctx, conn)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if unlockErr := unlock(); unlockErr != nil {
+					err = errors.Join(err, fmt.Errorf("failed to release the migration lock: %w", unlockErr))
+				}
+			}()
+			if err = conn.Migrator().AutoMigrate(&SchemaMigration{}); err != nil {
+				return fmt.Errorf("failed to create the schema migration table: %w", err)
+			}
+		}
+		records, err := m.applied(ctx, conn)
+		if err != nil {
+			return fmt.Errorf("failed to get the applied migrations: %w", err)
+		}
+		applied := make(map[uint64]SchemaMigration, len(records))
+		for _, record := range records {
+			applied[record.Version] = record
+		}
+		planned, err := plan(applied)
+		if err != nil {
+			return err
+		}
+		for _, p := range planned {
+			step, err := m.execute(ctx, conn, p)
+			if err != nil {
+				return err
+			}
+			steps = append(steps, step)
+		}
+		return nil
+	})
+	return steps, err
+}
+
+// transactional reports whether the DDL statements of the dialect are transactional, the migrations of the other
+// dialects may be partially applied when they fail.
+func transactional(db *gorm.DB) bool {
+	switch db.Dialector.Name() {
+	case "sqlite", "postgres":
+		return true
+	}
+	return false
+}
+
+func (m *Migrator) execute(ctx context.Context, conn *gorm.DB, p plannedStep) (Step, error) {
+	logger := logs.GetContextLogger(ctx)
+	migration := p.migration
+	step := Step{Version: migration.Version, Name: migration.Name, Direction: p.direction}
+	fn := migration.Up
+	if p.direction == DirectionDown {
+		fn = migration.Down
+	}
+	if m.o.DryRun {
+		recorder := &sqlRecorder{}
+		if err := fn(ctx, conn.Session(&gorm.Session{DryRun: true, Logger: recorder})); err != nil {
+			return step, fmt.Errorf("failed to plan migration %d_%s %s: %w", migration.Version, migration.Name, p.direction, err)
+		}
+		step.SQL = recorder.sql
+		level.Info(logger).Log("msg", "dry run of migration", "version", migration.Version, "name", migration.Name, "direction", p.direction, "statements", len(step.SQL))
+		return step, nil
+	}
+
+	begin := time.Now()
+	apply := func(db *gorm.DB) error {
+		if err := fn(ctx, db); err != nil {
+			return err
+		}
+		if p.direction == DirectionUp {
+			return db.WithContext(ctx).Create(&SchemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+		} else if db.Dialector.Name() == "clickhouse" {
+			// the deletes of ClickHouse are asynchronous mutations unless mutations_sync is set.
+			ctx = clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{"mutations_sync": 2}))
+		}
+		return db.WithContext(ctx).Delete(&SchemaMigration{}, "version = ?", migration.Version).Error
+	}
+	var err error
+	if transactional(conn) {
+		err = conn.WithContext(ctx).Transaction(apply)
+	} else {
+		err = apply(conn)
+	}
+	step.Duration = time.Since(begin)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to execute migration", "version", migration.Version, "name", migration.Name, "direction", p.direction, "transactional", transactional(conn), "err", err)
+		return step, fmt.Errorf("failed to execute migration %d_%s %s: %w", migration.Version, migration.Name, p.direction, err)
+	}
+	level.Info(logger).Log("msg", "executed migration", "version", migration.Version, "name", migration.Name, "direction", p.direction, "duration", step.Duration)
+	return step, nil
+}
+
+// lock takes the lock of the migrations on conn. MySQL and PostgreSQL use their advisory locks, which are released
+// when the connection is closed. The others create the lock table, which must be dropped by hand if a run is killed.
+func (m *Migrator) lock(ctx context.Context, conn *gorm.DB) (unlock func() error, err error) {
+	stmt := &gorm.Statement{DB: conn}
+	if err = stmt.Parse(&SchemaMigration{}); err != nil {
+		return nil, err
+	}
+	name := conn.Migrator().CurrentDatabase() + "." + stmt.Table
+	if len(name) > 64 {
+		// the names of the MySQL locks are limited to 64 characters.
+		sum := sha1.Sum([]byte(name))
+		name = hex.EncodeToString(sum[:])
+	}
+	ctx, cancel := context.WithTimeout(ctx, m.o.LockTimeout)
+	defer cancel()
+	db := conn.WithContext(ctx)
+
+	switch conn.Dialector.Name() {
+	case "mysql":
+		var locked *int
+		if err = db.Raw("SELECT GET_LOCK(?, ?)", name, int(m.o.LockTimeout.Seconds())).Row().Scan(&locked); err != nil {
+			return nil, fmt.Errorf("failed to take the migration lock: %w", err)
+		} else if locked == nil || *locked != 1 {
+			return nil, fmt.Errorf("failed to take the migration lock %s in %s", name, m.o.LockTimeout)
+		}
+		return func() error {
+			return conn.Exec("SELECT RELEASE_LOCK(?)", name).Error
+		}, nil
+	case "postgres":
+		if err = db.Exec("SELECT pg_advisory_lock(hashtext(?))", name).Error; err != nil {
+			return nil, fmt.Errorf("failed to take the migration lock: %w", err)
+		}
+		return func() error {
+			return conn.Exec("SELECT pg_advisory_unlock(hashtext(?))", name).Error
+		}, nil
+	}
+
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		// creating a table fails if it exists, which makes it the lock of the databases that have no advisory locks.
+		if err = db.Migrator().CreateTable(&SchemaMigrationLock{}); err == nil {
+			return func() error {
+				return conn.Migrator().DropTable(&SchemaMigrationLock{})
+			}, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to take the migration lock in %s, drop the lock table if no migration is running: %w", m.o.LockTimeout, err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// sqlRecorder is the logger of the dry runs, which records the SQL of the statements.
+type sqlRecorder struct {
+	sql []string
+}
+
+func (r *sqlRecorder) LogMode(logger.LogLevel) logger.Interface {
+	return r
+}
+
+func (r *sqlRecorder) Info(context.Context, string, ...interface{}) {}
+
+func (r *sqlRecorder) Warn(context.Context, string, ...interface{}) {}
+
+func (r *sqlRecorder) Error(context.Context, string, ...interface{}) {}
+
+func (r *sqlRecorder) Trace(_ context.Context, _ time.Time, fc func() (sql string, rowsAffected int64), _ error) {
+	sql, _ := fc()
+	r.sql = append(r.sql, sql)
+}
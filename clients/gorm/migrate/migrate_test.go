@@ -0,0 +1,179 @@
+package migrate
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+func openTestDB(t *testing.T, path string) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(path+"?_pragma=busy_timeout(10000)"), &gorm.Config{
+		NamingStrategy: schema.NamingStrategy{TablePrefix: "t_", SingularTable: true},
+		Logger:         logger.Discard,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+var testFS = fstest.MapFS{
+	"migrations/0001_create_user.up.sql": {Data: []byte(`
+-- the users; of the service
+CREATE TABLE t_user (id integer PRIMARY KEY, name text DEFAULT 'a;b');
+INSERT INTO t_user (id, name) VALUES (1, 'admin');
+`)},
+	"migrations/0001_create_user.down.sql":    {Data: []byte("DROP TABLE t_user;")},
+	"migrations/0002_add_user_email.up.sql":   {Data: []byte("ALTER TABLE t_user ADD COLUMN email text; /* the email of the user */")},
+	"migrations/0002_add_user_email.down.sql": {Data: []byte("ALTER TABLE t_user DROP COLUMN email")},
+	"migrations/0003_create_role.up.sql":      {Data: []byte("CREATE TABLE t_role (id integer PRIMARY KEY)")},
+	"migrations/README.md":                    {Data: []byte("not a migration")},
+}
+
+func appliedVersions(t *testing.T, m *Migrator) []uint64 {
+	applied, err := m.Applied(context.Background())
+	require.NoError(t, err)
+	versions := make([]uint64, 0, len(applied))
+	for _, a := range applied {
+		versions = append(versions, a.Version)
+	}
+	return versions
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t, filepath.Join(t.TempDir(), "test.db"))
+	m := New(db, Options{})
+	require.NoError(t, m.LoadFS(testFS, "migrations"))
+	require.Len(t, m.Migrations(), 3)
+
+	steps, err := m.Up(ctx)
+	require.NoError(t, err)
+	require.Len(t, steps, 3)
+	require.Equal(t, []uint64{1, 2, 3}, appliedVersions(t, m))
+	require.True(t, db.Migrator().HasTable("t_schema_migration"))
+	require.True(t, db.Migrator().HasColumn("t_user", "email"))
+	require.False(t, db.Migrator().HasTable("t_schema_migration_lock"))
+
+	steps, err = m.Up(ctx)
+	require.NoError(t, err)
+	require.Empty(t, steps)
+
+	_, err = m.To(ctx, 1)
+	require.ErrorIs(t, err, ErrIrreversible)
+	require.Equal(t, []uint64{1, 2, 3}, appliedVersions(t, m))
+
+	require.NoError(t, db.Exec("DROP TABLE t_role").Error)
+	require.NoError(t, db.Delete(&SchemaMigration{}, "version = ?", 3).Error)
+
+	dryRun := New(db, Options{DryRun: true})
+	require.NoError(t, dryRun.LoadFS(testFS, "migrations"))
+	steps, err = dryRun.To(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, steps, 1)
+	require.Equal(t, DirectionDown, steps[0].Direction)
+	require.Equal(t, []string{"ALTER TABLE t_user DROP COLUMN email"}, steps[0].SQL)
+	require.Equal(t, []uint64{1, 2}, appliedVersions(t, m))
+
+	steps, err = m.To(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, steps, 1)
+	require.False(t, db.Migrator().HasColumn("t_user", "email"))
+
+	steps, err = m.Rollback(ctx, 5)
+	require.NoError(t, err)
+	require.Len(t, steps, 1)
+	require.Empty(t, appliedVersions(t, m))
+	require.False(t, db.Migrator().HasTable("t_user"))
+
+	steps, err = m.To(ctx, 2)
+	require.NoError(t, err)
+	require.Len(t, steps, 2)
+	var name string
+	require.NoError(t, db.Raw("SELECT name FROM t_user WHERE id = 1").Scan(&name).Error)
+	require.Equal(t, "admin", name)
+}
+
+func TestMigrator_FailedMigrationIsRolledBack(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t, filepath.Join(t.TempDir(), "test.db"))
+	m := New(db, Options{})
+	require.NoError(t, m.LoadFS(fstest.MapFS{
+		"0001_broken.up.sql": {Data: []byte("CREATE TABLE t_a (id integer); CREATE TABLE t_a (id integer);")},
+	}, "."))
+	_, err := m.Up(ctx)
+	require.Error(t, err)
+	require.False(t, db.Migrator().HasTable("t_a"))
+	require.Empty(t, appliedVersions(t, m))
+	require.False(t, db.Migrator().HasTable("t_schema_migration_lock"))
+}
+
+func TestMigrator_Lock(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+	var calls atomic.Int32
+	newMigrator := func() *Migrator {
+		m := New(openTestDB(t, path), Options{LockTimeout: 10 * time.Second})
+		require.NoError(t, m.Register(Migration{Version: 1, Name: "slow", Up: func(ctx context.Context, db *gorm.DB) error {
+			calls.Add(1)
+			time.Sleep(300 * time.Millisecond)
+			return db.Exec("CREATE TABLE t_slow (id integer)").Error
+		}}))
+		return m
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		m := newMigrator()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := m.Up(ctx)
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), calls.Load())
+
+	db := openTestDB(t, path)
+	require.NoError(t, db.Migrator().CreateTable(&SchemaMigrationLock{}))
+	m := New(db, Options{LockTimeout: 300 * time.Millisecond})
+	_, err := m.Up(ctx)
+	require.ErrorContains(t, err, "failed to take the migration lock")
+}
+
+func TestMigrator_Register(t *testing.T) {
+	m := New(nil, Options{})
+	up := func(context.Context, *gorm.DB) error { return nil }
+	require.NoError(t, m.Register(Migration{Version: 1, Name: "a", Up: up}))
+	require.Error(t, m.Register(Migration{Version: 1, Name: "b", Up: up}))
+	require.Error(t, m.Register(Migration{Version: 2, Name: "c"}))
+	require.Error(t, m.Register(Migration{Name: "d", Up: up}))
+	require.Error(t, m.LoadFS(fstest.MapFS{"0002_only_down.down.sql": {Data: []byte("SELECT 1")}}, "."))
+}
+
+func TestSplitStatements(t *testing.T) {
+	require.Equal(t, []string{
+		"CREATE TABLE a (s text DEFAULT 'x;''y')",
+		"INSERT INTO a VALUES (\"q;\")",
+		"CREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql",
+		"SELECT `a;b` FROM a",
+	}, splitStatements(`
+-- comment; with a semicolon
+CREATE TABLE a (s text DEFAULT 'x;''y');
+/* block; comment */ INSERT INTO a VALUES ("q;");;
+CREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql;
+SELECT `+"`a;b`"+` FROM a`))
+}
@@ -0,0 +1,167 @@
+/*
+ Copyright © 2022 MicroOps-cn.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package gorm
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// The operations of the query metrics, the statements other than select, insert, update and delete are "other".
+const (
+	OperationSelect = "select"
+	OperationInsert = "insert"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+	OperationOther  = "other"
+)
+
+// MaxTableLabels is the number of distinct tables of the query metrics, the tables seen after it is reached are
+// labeled as "other", so that the cardinality of the metrics is bounded by it.
+var MaxTableLabels = 256
+
+var (
+	tableLabelsMux sync.Mutex
+	tableLabels    = make(map[string]struct{})
+
+	leadingCommentRegexp = regexp.MustCompile(`^(\s|\(|/\*.*?\*/|--[^\n]*\n)+`)
+	tableRegexps         = map[string]*regexp.Regexp{
+		OperationSelect: regexp.MustCompile("(?is)\\bfrom\\s+([`\"\\[\\]\\w.]+)"),
+		OperationInsert: regexp.MustCompile("(?is)^insert\\s+(?:ignore\\s+)?into\\s+([`\"\\[\\]\\w.]+)"),
+		OperationUpdate: regexp.MustCompile("(?is)^update\\s+([`\"\\[\\]\\w.]+)"),
+		OperationDelete: regexp.MustCompile("(?is)^delete\\s+from\\s+([`\"\\[\\]\\w.]+)"),
+	}
+	shardSuffixRegexp = regexp.MustCompile(`_\d+$`)
+)
+
+// parseQuery returns the operation and the normalized table of a SQL statement. The table is unquoted, lower-cased
+// and stripped of its schema, and the numeric suffixes of the sharded tables, such as t_event_202401, are replaced by
+// "_*".
+func parseQuery(sql string) (operation, table string) {
+	sql = leadingCommentRegexp.ReplaceAllString(sql, "")
+	if i := strings.IndexFunc(sql, unicode.IsSpace); i >= 0 {
+		operation = strings.ToLower(sql[:i])
+	} else {
+		operation = strings.ToLower(sql)
+	}
+	re, ok := tableRegexps[operation]
+	if !ok {
+		return OperationOther, ""
+	}
+	if matches := re.FindStringSubmatch(sql); matches != nil {
+		table = strings.ToLower(strings.Trim(matches[1], "`\"[]"))
+		if i := strings.LastIndexByte(table, '.'); i >= 0 {
+			table = strings.Trim(table[i+1:], "`\"[]")
+		}
+		table = shardSuffixRegexp.ReplaceAllString(table, "_*")
+	}
+	return operation, tableLabel(table)
+}
+
+func tableLabel(table string) string {
+	if len(table) == 0 {
+		return table
+	}
+	tableLabelsMux.Lock()
+	defer tableLabelsMux.Unlock()
+	if _, ok := tableLabels[table]; ok {
+		return table
+	}
+	if len(tableLabels) >= MaxTableLabels {
+		return OperationOther
+	}
+	tableLabels[table] = struct{}{}
+	return table
+}
+
+// SlowQuery is a query slower than the slow threshold of its client.
+type SlowQuery struct {
+	Time      time.Time     `json:"time"`
+	Client    string        `json:"client"`
+	Operation string        `json:"operation"`
+	Table     string        `json:"table"`
+	SQL       string        `json:"sql"`
+	Duration  time.Duration `json:"duration"`
+	Rows      int64         `json:"rows"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// SlowQueryLog keeps the latest slow queries of the clients it is set to by Client.SetSlowQueryLog. The SQL of the
+// queries includes their parameters, so that the endpoint serving it must be restricted to the administrators.
+type SlowQueryLog struct {
+	mux     sync.Mutex
+	entries []SlowQuery
+	next    int
+	full    bool
+}
+
+// NewSlowQueryLog returns a SlowQueryLog of the latest size slow queries.
+func NewSlowQueryLog(size int) *SlowQueryLog {
+	if size <= 0 {
+		size = 1
+	}
+	return &SlowQueryLog{entries: make([]SlowQuery, size)}
+}
+
+func (l *SlowQueryLog) Add(q SlowQuery) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.entries[l.next] = q
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Entries returns the slow queries from the oldest to the latest.
+func (l *SlowQueryLog) Entries() []SlowQuery {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if !l.full {
+		return append([]SlowQuery(nil), l.entries[:l.next]...)
+	}
+	return append(append([]SlowQuery(nil), l.entries[l.next:]...), l.entries[:l.next]...)
+}
+
+// ServeHTTP dumps the slow queries as JSON, from the latest to the oldest. The limit parameter bounds the number of
+// the queries, and the client parameter selects the queries of a client.
+func (l *SlowQueryLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	entries := l.Entries()
+	queries := make([]SlowQuery, 0, len(entries))
+	client, hasClient := r.URL.Query()["client"]
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !hasClient || entries[i].Client == client[0] {
+			queries = append(queries, entries[i])
+		}
+	}
+	if limit := r.URL.Query().Get("limit"); len(limit) != 0 {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid limit: "+limit, http.StatusBadRequest)
+			return
+		}
+		queries = queries[:min(n, len(queries))]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(queries)
+}
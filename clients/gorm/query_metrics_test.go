@@ -0,0 +1,137 @@
+package gorm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQuery(t *testing.T) {
+	for _, tt := range []struct {
+		sql       string
+		operation string
+		table     string
+	}{
+		{"SELECT * FROM `t_user` WHERE `t_user`.`id` = 1", OperationSelect, "t_user"},
+		{"select count(*) from \"public\".\"t_role\"", OperationSelect, "t_role"},
+		{"/* comment */ (SELECT id FROM idas.T_App)", OperationSelect, "t_app"},
+		{"INSERT INTO `t_event_202401` (`name`) VALUES ('a')", OperationInsert, "t_event_*"},
+		{"insert ignore into t_user (name) values ('a')", OperationInsert, "t_user"},
+		{"UPDATE `t_user` SET `name`='b'", OperationUpdate, "t_user"},
+		{"DELETE FROM t_user WHERE id = 1", OperationDelete, "t_user"},
+		{"SELECT\n\tid,\n\tname\nFROM\n\tt_user\nWHERE id = 1", OperationSelect, "t_user"},
+		{"UPDATE\tt_user\nSET name = 'b'", OperationUpdate, "t_user"},
+		{"SELECT 1", OperationSelect, ""},
+		{"CREATE TABLE t_user (id integer)", OperationOther, ""},
+		{"", OperationOther, ""},
+	} {
+		operation, table := parseQuery(tt.sql)
+		require.Equal(t, tt.operation, operation, tt.sql)
+		require.Equal(t, tt.table, table, tt.sql)
+	}
+}
+
+func TestTableLabel_Bounded(t *testing.T) {
+	defer func(n int) { MaxTableLabels = n }(MaxTableLabels)
+	tableLabelsMux.Lock()
+	MaxTableLabels = len(tableLabels) + 2
+	tableLabelsMux.Unlock()
+	require.Equal(t, "t_bounded_a", tableLabel("t_bounded_a"))
+	require.Equal(t, "t_bounded_b", tableLabel("t_bounded_b"))
+	require.Equal(t, OperationOther, tableLabel("t_bounded_c"))
+	require.Equal(t, "t_bounded_a", tableLabel("t_bounded_a"))
+}
+
+func TestSlowQueryLog(t *testing.T) {
+	l := NewSlowQueryLog(3)
+	require.Empty(t, l.Entries())
+	for i := 0; i < 5; i++ {
+		l.Add(SlowQuery{Client: fmt.Sprintf("c%d", i%2), SQL: fmt.Sprintf("SELECT %d", i)})
+	}
+	entries := l.Entries()
+	require.Len(t, entries, 3)
+	require.Equal(t, "SELECT 2", entries[0].SQL)
+	require.Equal(t, "SELECT 4", entries[2].SQL)
+
+	var queries []SlowQuery
+	w := httptest.NewRecorder()
+	l.ServeHTTP(w, httptest.NewRequest("GET", "/?client=c0", nil))
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &queries))
+	require.Len(t, queries, 2)
+	require.Equal(t, "SELECT 4", queries[0].SQL)
+
+	w = httptest.NewRecorder()
+	l.ServeHTTP(w, httptest.NewRequest("GET", "/?limit=1", nil))
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &queries))
+	require.Len(t, queries, 1)
+	require.Equal(t, "SELECT 4", queries[0].SQL)
+
+	w = httptest.NewRecorder()
+	l.ServeHTTP(w, httptest.NewRequest("GET", "/?limit=x", nil))
+	require.Equal(t, 400, w.Code)
+}
+
+// querySampleCount returns the number of the samples of the query duration histogram of the labels.
+func querySampleCount(t *testing.T, name, operation, table string) uint64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != "gorm_query_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["name"] == name && labels["operation"] == operation && labels["table"] == table {
+				return metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return 0
+}
+
+func TestClient_QueryMetrics(t *testing.T) {
+	name := t.Name()
+	clt, err := NewGormSQLiteClient(context.Background(), name, &SQLiteOptions{Path: filepath.Join(t.TempDir(), "metrics.db")})
+	require.NoError(t, err)
+	defer clt.Close()
+	slowQueries := NewSlowQueryLog(10)
+	clt.SetSlowQueryLog(slowQueries)
+	ctx := context.Background()
+
+	type metricsItem struct {
+		ID   uint
+		Name string
+	}
+	require.NoError(t, clt.Session(ctx).AutoMigrate(&metricsItem{}))
+	require.NoError(t, clt.Session(ctx).Create(&[]metricsItem{{Name: "a"}, {Name: "b"}}).Error)
+	var items []metricsItem
+	require.NoError(t, clt.Session(ctx).Find(&items).Error)
+	require.Error(t, clt.Session(ctx).Exec("UPDATE t_metrics_item SET missing = 1").Error)
+
+	require.Equal(t, 2.0, testutil.ToFloat64(queryRowsAffectedCounterVec.WithLabelValues(name, OperationInsert, "t_metrics_item")))
+	require.Equal(t, 2.0, testutil.ToFloat64(queryRowsAffectedCounterVec.WithLabelValues(name, OperationSelect, "t_metrics_item")))
+	require.Equal(t, 1.0, testutil.ToFloat64(queryErrorsCounterVec.WithLabelValues(name, OperationUpdate, "t_metrics_item")))
+	require.Equal(t, uint64(1), querySampleCount(t, name, OperationSelect, "t_metrics_item"))
+	require.Empty(t, slowQueries.Entries())
+
+	clt.slowThreshold = time.Nanosecond
+	require.NoError(t, clt.Session(ctx).Where("name = ?", "a").Find(&items).Error)
+	entries := slowQueries.Entries()
+	require.Len(t, entries, 1)
+	require.Equal(t, name, entries[0].Client)
+	require.Equal(t, OperationSelect, entries[0].Operation)
+	require.Equal(t, "t_metrics_item", entries[0].Table)
+	require.Equal(t, int64(1), entries[0].Rows)
+	require.Equal(t, 1.0, testutil.ToFloat64(slowQueriesCounterVec.WithLabelValues(name, OperationSelect, "t_metrics_item")))
+}
@@ -0,0 +1,428 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/afero"
+
+	"github.com/MicroOps-cn/fuck/clients/storage"
+	"github.com/MicroOps-cn/fuck/log"
+)
+
+type WriteMode string
+
+const (
+	// WriteAround writes objects to the backend only, the cached copy is invalidated.
+	WriteAround WriteMode = "write-around"
+	// WriteThrough writes objects to both the backend and the cache.
+	WriteThrough WriteMode = "write-through"
+)
+
+var (
+	requestsCounterVec = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_cache_requests_total",
+		Help: "The total number of reads served by the storage cache, partitioned by result (hit or miss).",
+	}, []string{"name", "result"})
+	evictionsCounterVec = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_cache_evictions_total",
+		Help: "The total number of objects evicted from the storage cache.",
+	}, []string{"name"})
+	sizeGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "storage_cache_size_bytes",
+		Help: "The total size of the objects in the storage cache.",
+	}, []string{"name"})
+)
+
+func init() {
+	prometheus.MustRegister(requestsCounterVec, evictionsCounterVec, sizeGaugeVec)
+}
+
+type Options struct {
+	// Dir is the local directory of the cached objects.
+	Dir string `json:"dir,omitempty" yaml:"dir,omitempty" mapstructure:"dir"`
+	// MaxSize is the maximum total size of the cached objects in bytes, the least recently used
+	// objects are evicted when it is exceeded. Zero means unlimited.
+	MaxSize   int64     `json:"max_size,omitempty" yaml:"max_size,omitempty" mapstructure:"max_size"`
+	WriteMode WriteMode `json:"write_mode,omitempty" yaml:"write_mode,omitempty" mapstructure:"write_mode"`
+}
+
+type entry struct {
+	key    string
+	file   string
+	object storage.Object
+	elem   *list.Element
+}
+
+// Storage is a read-through cache of a remote storage. The cached objects are validated by the ETag,
+// or the size and LastModified returned by HeadObject of the backend before being served.
+type Storage struct {
+	storage.Storage
+	o       Options
+	fs      afero.Fs
+	mux     sync.Mutex
+	entries map[string]*entry
+	lru     *list.List
+	size    int64
+}
+
+// New creates a cache of backend in the directory o.Dir, objects cached by a previous instance are reused.
+func New(backend storage.Storage, o Options) (*Storage, error) {
+	if len(o.Dir) == 0 {
+		return nil, errors.New("cache dir is empty")
+	}
+	if err := os.MkdirAll(o.Dir, 0o700); err != nil {
+		return nil, err
+	}
+	return newStorage(backend, afero.NewBasePathFs(afero.NewOsFs(), o.Dir), o)
+}
+
+func newStorage(backend storage.Storage, cacheFS afero.Fs, o Options) (*Storage, error) {
+	if len(o.WriteMode) == 0 {
+		o.WriteMode = WriteAround
+	}
+	s := &Storage{
+		Storage: backend,
+		o:       o,
+		fs:      cacheFS,
+		entries: map[string]*entry{},
+		lru:     list.New(),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Storage) Unwrap() storage.Storage {
+	return s.Storage
+}
+
+// load rebuilds the index from the metadata files, the data files without metadata are removed.
+func (s *Storage) load() error {
+	return afero.Walk(s.fs, "/", func(name string, info fs.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		switch {
+		case strings.HasSuffix(name, ".json"):
+			data, err := afero.ReadFile(s.fs, name)
+			if err != nil {
+				return err
+			}
+			var obj storage.Object
+			dataFile := strings.TrimSuffix(name, ".json")
+			if err = json.Unmarshal(data, &obj); err != nil {
+				_ = s.fs.Remove(name)
+				_ = s.fs.Remove(dataFile)
+				return nil
+			}
+			s.add(&entry{key: obj.Key, file: dataFile, object: obj})
+		case strings.HasSuffix(name, ".tmp"):
+			_ = s.fs.Remove(name)
+		default:
+			if _, err := s.fs.Stat(name + ".json"); err != nil {
+				_ = s.fs.Remove(name)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Storage) filename(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	h := hex.EncodeToString(sum[:])
+	return path.Join("/", h[:2], h[2:])
+}
+
+// add inserts e as the most recently used entry and evicts the least recently used entries if
+// the cache is full. The caller must hold s.mux unless the storage is being created.
+func (s *Storage) add(e *entry) {
+	if old, ok := s.entries[e.key]; ok {
+		s.remove(old, false)
+	}
+	e.elem = s.lru.PushFront(e)
+	s.entries[e.key] = e
+	s.size += e.object.Size
+	for s.o.MaxSize > 0 && s.size > s.o.MaxSize && s.lru.Len() > 1 {
+		s.remove(s.lru.Back().Value.(*entry), true)
+		evictionsCounterVec.WithLabelValues(s.Name()).Inc()
+	}
+	sizeGaugeVec.WithLabelValues(s.Name()).Set(float64(s.size))
+}
+
+func (s *Storage) remove(e *entry, removeFiles bool) {
+	s.lru.Remove(e.elem)
+	delete(s.entries, e.key)
+	s.size -= e.object.Size
+	if removeFiles {
+		_ = s.fs.Remove(e.file + ".json")
+		_ = s.fs.Remove(e.file)
+	}
+	sizeGaugeVec.WithLabelValues(s.Name()).Set(float64(s.size))
+}
+
+func (s *Storage) invalidate(keys ...string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, key := range keys {
+		if e, ok := s.entries[key]; ok {
+			s.remove(e, true)
+		}
+	}
+}
+
+// commit moves the downloaded temp file into the cache.
+func (s *Storage) commit(tmpFile string, obj storage.Object) error {
+	file := s.filename(obj.Key)
+	meta, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if old, ok := s.entries[obj.Key]; ok {
+		s.remove(old, true)
+	}
+	if err = s.fs.MkdirAll(path.Dir(file), 0o700); err != nil {
+		return err
+	}
+	if err = s.fs.Rename(tmpFile, file); err != nil {
+		return err
+	}
+	if err = afero.WriteFile(s.fs, file+".json", meta, 0o600); err != nil {
+		_ = s.fs.Remove(file)
+		return err
+	}
+	s.add(&entry{key: obj.Key, file: file, object: obj})
+	return nil
+}
+
+func isValid(cached, current storage.Object) bool {
+	if len(cached.ETag) != 0 && len(current.ETag) != 0 {
+		return cached.ETag == current.ETag
+	}
+	return cached.Size == current.Size && cached.LastModified.Equal(current.LastModified)
+}
+
+// lookup returns the cached file of objectPath if it is still valid, the Condition of ctx is checked against
+// the object returned by HeadObject of the backend.
+func (s *Storage) lookup(ctx context.Context, objectPath string) (afero.File, *storage.Object, error) {
+	head, err := s.Storage.HeadObject(ctx, objectPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	head.Key = objectPath
+	if cond, ok := storage.ConditionFromContext(ctx); ok {
+		if err = cond.CheckRead(head); err != nil {
+			return nil, nil, err
+		}
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	e, ok := s.entries[objectPath]
+	if !ok {
+		return nil, head, nil
+	}
+	if !isValid(e.object, *head) {
+		s.remove(e, true)
+		return nil, head, nil
+	}
+	f, err := s.fs.Open(e.file)
+	if err != nil {
+		level.Warn(log.GetContextLogger(ctx)).Log("msg", "failed to open cached object", "key", objectPath, "err", err)
+		s.remove(e, true)
+		return nil, head, nil
+	}
+	s.lru.MoveToFront(e.elem)
+	return f, head, nil
+}
+
+func (s *Storage) GetObject(ctx context.Context, objectPath string) (*storage.ObjectReader, error) {
+	logger := log.GetContextLogger(ctx)
+	f, head, err := s.lookup(ctx, objectPath)
+	if err != nil {
+		return nil, err
+	}
+	if f != nil {
+		requestsCounterVec.WithLabelValues(s.Name(), "hit").Inc()
+		return &storage.ObjectReader{ReadCloser: f, Object: *head}, nil
+	}
+	requestsCounterVec.WithLabelValues(s.Name(), "miss").Inc()
+	r, err := s.Storage.GetObject(ctx, objectPath)
+	if err != nil {
+		return nil, err
+	}
+	// the content is cached with the object returned with it, the object may have changed since HeadObject.
+	obj := r.Object
+	obj.Key = objectPath
+	if s.o.MaxSize > 0 && obj.Size > s.o.MaxSize {
+		return r, nil
+	}
+	tmp, err := afero.TempFile(s.fs, "/", "object-*.tmp")
+	if err != nil {
+		level.Warn(logger).Log("msg", "failed to create cache file", "key", objectPath, "err", err)
+		return r, nil
+	}
+	r.ReadCloser = &teeReader{
+		ReadCloser: r.ReadCloser,
+		tmp:        tmp,
+		size:       obj.Size,
+		commit: func() error {
+			return s.commit(tmp.Name(), obj)
+		},
+		discard: func() {
+			_ = s.fs.Remove(tmp.Name())
+		},
+	}
+	return r, nil
+}
+
+// GetObjectRange serves the range from the cached object if it is cached, the ranges of uncached objects
+// are read from the backend without being cached.
+func (s *Storage) GetObjectRange(ctx context.Context, objectPath string, offset, length int64) (*storage.ObjectReader, error) {
+	f, head, err := s.lookup(ctx, objectPath)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		requestsCounterVec.WithLabelValues(s.Name(), "miss").Inc()
+		return s.Storage.GetObjectRange(ctx, objectPath, offset, length)
+	}
+	requestsCounterVec.WithLabelValues(s.Name(), "hit").Inc()
+	if length < 0 {
+		length = head.Size - offset
+	}
+	return &storage.ObjectReader{
+		ReadCloser: struct {
+			io.Reader
+			io.Closer
+		}{Reader: io.NewSectionReader(f, offset, length), Closer: f},
+		Object: *head,
+		Offset: offset,
+		ReadRange: func(offset, length int64) (io.ReadCloser, error) {
+			rf, err := s.fs.Open(f.Name())
+			if err != nil {
+				return nil, err
+			}
+			if length < 0 {
+				length = head.Size - offset
+			}
+			return struct {
+				io.Reader
+				io.Closer
+			}{Reader: io.NewSectionReader(rf, offset, length), Closer: rf}, nil
+		},
+	}, nil
+}
+
+func (s *Storage) Open(name string) (fs.File, error) {
+	return s.GetObject(context.Background(), name)
+}
+
+func (s *Storage) PutObject(ctx context.Context, objectPath string, obj io.Reader, headers http.Header, metadata map[string]string) error {
+	s.invalidate(objectPath)
+	if s.o.WriteMode != WriteThrough {
+		return s.Storage.PutObject(ctx, objectPath, obj, headers, metadata)
+	}
+	logger := log.GetContextLogger(ctx)
+	tmp, err := afero.TempFile(s.fs, "/", "object-*.tmp")
+	if err != nil {
+		level.Warn(logger).Log("msg", "failed to create cache file", "key", objectPath, "err", err)
+		return s.Storage.PutObject(ctx, objectPath, obj, headers, metadata)
+	}
+	defer s.fs.Remove(tmp.Name())
+	if err = s.Storage.PutObject(ctx, objectPath, io.TeeReader(obj, tmp), headers, metadata); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		level.Warn(logger).Log("msg", "failed to write cache file", "key", objectPath, "err", err)
+		return nil
+	}
+	head, err := s.Storage.HeadObject(ctx, objectPath)
+	if err != nil {
+		level.Warn(logger).Log("msg", "failed to head object", "key", objectPath, "err", err)
+		return nil
+	}
+	if stat, err := s.fs.Stat(tmp.Name()); err != nil || stat.Size() != head.Size {
+		return nil
+	}
+	if s.o.MaxSize > 0 && head.Size > s.o.MaxSize {
+		return nil
+	}
+	head.Key = objectPath
+	if err = s.commit(tmp.Name(), *head); err != nil {
+		level.Warn(logger).Log("msg", "failed to cache object", "key", objectPath, "err", err)
+	}
+	return nil
+}
+
+func (s *Storage) DeleteObject(ctx context.Context, objectPath string) error {
+	s.invalidate(objectPath)
+	return s.Storage.DeleteObject(ctx, objectPath)
+}
+
+func (s *Storage) DeleteObjects(ctx context.Context, objectPaths []string) error {
+	s.invalidate(objectPaths...)
+	return s.Storage.DeleteObjects(ctx, objectPaths)
+}
+
+func (s *Storage) CopyObject(ctx context.Context, srcPath, dstPath string) error {
+	s.invalidate(dstPath)
+	return s.Storage.CopyObject(ctx, srcPath, dstPath)
+}
+
+// teeReader writes the content read from the backend into the temp file, the temp file is committed
+// to the cache when the whole object has been read, and discarded otherwise.
+type teeReader struct {
+	io.ReadCloser
+	tmp     afero.File
+	size    int64
+	written int64
+	failed  bool
+	eof     bool
+	commit  func() error
+	discard func()
+}
+
+func (t *teeReader) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 && !t.failed {
+		if _, werr := t.tmp.Write(p[:n]); werr != nil {
+			t.failed = true
+		}
+		t.written += int64(n)
+	}
+	if err == io.EOF {
+		t.eof = true
+	}
+	return n, err
+}
+
+func (t *teeReader) Close() error {
+	err := t.ReadCloser.Close()
+	if cerr := t.tmp.Close(); cerr != nil {
+		t.failed = true
+	}
+	if t.eof && !t.failed && t.written == t.size {
+		if cerr := t.commit(); cerr == nil {
+			return err
+		}
+	}
+	t.discard()
+	return err
+}
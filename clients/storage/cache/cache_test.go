@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+
+	"github.com/MicroOps-cn/fuck/clients/storage"
+	storagefs "github.com/MicroOps-cn/fuck/clients/storage/fs"
+)
+
+type countingStorage struct {
+	storage.Storage
+	gets      int
+	afterHead func()
+}
+
+func (c *countingStorage) HeadObject(ctx context.Context, objectPath string) (*storage.Object, error) {
+	obj, err := c.Storage.HeadObject(ctx, objectPath)
+	if c.afterHead != nil {
+		c.afterHead()
+	}
+	return obj, err
+}
+
+func (c *countingStorage) GetObject(ctx context.Context, objectPath string) (*storage.ObjectReader, error) {
+	c.gets++
+	return c.Storage.GetObject(ctx, objectPath)
+}
+
+func readObject(t *testing.T, s storage.Storage, key string) string {
+	r, err := s.GetObject(context.Background(), key)
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestStorage_GetObject(t *testing.T) {
+	base := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(base, "a.tmpl"), []byte("aaaa"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(base, "b.tmpl"), []byte("bbbb"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(base, "c.tmpl"), []byte("cccc"), 0o600))
+	clt, err := storagefs.NewClient(context.Background(), nil, storage.NewMapConfigProvider(map[string]interface{}{
+		"type": "local",
+		"base": base,
+	}))
+	require.NoError(t, err)
+	backend := &countingStorage{Storage: clt}
+	cacheFS := afero.NewMemMapFs()
+	s, err := newStorage(backend, cacheFS, Options{MaxSize: 8})
+	require.NoError(t, err)
+
+	require.Equal(t, "aaaa", readObject(t, s, "a.tmpl"))
+	require.Equal(t, "aaaa", readObject(t, s, "a.tmpl"))
+	require.Equal(t, 1, backend.gets)
+
+	r, err := s.GetObjectRange(context.Background(), "a.tmpl", 1, 2)
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "aa", string(data))
+	require.NoError(t, r.Close())
+
+	// the modified object is fetched again.
+	require.NoError(t, os.WriteFile(filepath.Join(base, "a.tmpl"), []byte("AAAAA"), 0o600))
+	require.NoError(t, os.Chtimes(filepath.Join(base, "a.tmpl"), time.Now(), time.Now().Add(time.Minute)))
+	require.Equal(t, "AAAAA", readObject(t, s, "a.tmpl"))
+	require.Equal(t, 2, backend.gets)
+
+	// b evicts a, which exceeds the max size together with b.
+	require.Equal(t, "bbbb", readObject(t, s, "b.tmpl"))
+	require.Equal(t, "cccc", readObject(t, s, "c.tmpl"))
+	require.Equal(t, 4, backend.gets)
+	require.Equal(t, int64(8), s.size)
+	require.Equal(t, "bbbb", readObject(t, s, "b.tmpl"))
+	require.Equal(t, 4, backend.gets)
+	require.Equal(t, "AAAAA", readObject(t, s, "a.tmpl"))
+	require.Equal(t, 5, backend.gets)
+
+	// the index is rebuilt from the cache dir.
+	s, err = newStorage(backend, cacheFS, Options{MaxSize: 8})
+	require.NoError(t, err)
+	require.Len(t, s.entries, 1)
+	require.Equal(t, "AAAAA", readObject(t, s, "a.tmpl"))
+	require.Equal(t, 5, backend.gets)
+}
+
+func TestStorage_GetObject_Modified(t *testing.T) {
+	base := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(base, "a.tmpl"), []byte("aaaa"), 0o600))
+	clt, err := storagefs.NewClient(context.Background(), nil, storage.NewMapConfigProvider(map[string]interface{}{
+		"type": "local",
+		"base": base,
+	}))
+	require.NoError(t, err)
+	backend := &countingStorage{Storage: clt}
+	s, err := newStorage(backend, afero.NewMemMapFs(), Options{MaxSize: 8})
+	require.NoError(t, err)
+
+	// the object is modified between HeadObject and GetObject, the content is cached with the object it was read with.
+	backend.afterHead = func() {
+		backend.afterHead = nil
+		require.NoError(t, os.WriteFile(filepath.Join(base, "a.tmpl"), []byte("AAAAA"), 0o600))
+		require.NoError(t, os.Chtimes(filepath.Join(base, "a.tmpl"), time.Now(), time.Now().Add(time.Minute)))
+	}
+	require.Equal(t, "AAAAA", readObject(t, s, "a.tmpl"))
+	require.Equal(t, "AAAAA", readObject(t, s, "a.tmpl"))
+	require.Equal(t, 1, backend.gets)
+}
+
+func TestStorage_PutObject(t *testing.T) {
+	for _, mode := range []WriteMode{WriteAround, WriteThrough} {
+		t.Run(string(mode), func(t *testing.T) {
+			ctx := context.Background()
+			clt, err := storagefs.NewClient(ctx, nil, storage.NewMapConfigProvider(map[string]interface{}{
+				"type": "local",
+				"base": t.TempDir(),
+			}))
+			require.NoError(t, err)
+			backend := &countingStorage{Storage: clt}
+			s, err := newStorage(backend, afero.NewMemMapFs(), Options{MaxSize: 8, WriteMode: mode})
+			require.NoError(t, err)
+
+			require.NoError(t, s.PutObject(ctx, "a.tmpl", strings.NewReader("aaaa"), nil, nil))
+			require.Equal(t, "aaaa", readObject(t, s, "a.tmpl"))
+			gets := 1
+			if mode == WriteThrough {
+				gets = 0
+			}
+			require.Equal(t, gets, backend.gets)
+
+			// the cached copy is replaced or invalidated by the next write.
+			require.NoError(t, s.PutObject(ctx, "a.tmpl", strings.NewReader("AAAAA"), nil, nil))
+			require.Equal(t, "AAAAA", readObject(t, s, "a.tmpl"))
+			if mode == WriteAround {
+				gets++
+			}
+			require.Equal(t, gets, backend.gets)
+			require.Equal(t, "AAAAA", readObject(t, s, "a.tmpl"))
+			require.Equal(t, gets, backend.gets)
+		})
+	}
+}
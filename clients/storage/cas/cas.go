@@ -0,0 +1,384 @@
+// Package cas is a content-addressable store on top of storage.Storage. The blobs are stored under their SHA-256
+// digests, so that the same content is only uploaded once, and the manifests map the logical names to the digests.
+//
+// The layout of the store under its prefix is:
+//
+//	blobs/sha256/<first 2 hex digits>/<hex digest>  the content of the blobs
+//	manifests/<name>                                 the digest of a name
+//	refs/<hex digest>/<hex SHA-256 of the name>      a reference from a name to a blob
+//
+// A blob is referenced by as many names as it has references, GC deletes the blobs that have no references.
+package cas
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	stdtime "time"
+
+	"github.com/MicroOps-cn/fuck/clients/storage"
+	"github.com/MicroOps-cn/fuck/safe"
+)
+
+// DefaultGracePeriod is the age under which the blobs and references are not collected when Options.GracePeriod is
+// not set, so that GC does not collect the blobs of the Put running at the same time.
+const DefaultGracePeriod = stdtime.Hour
+
+type Options struct {
+	Prefix      string           `json:"prefix,omitempty" yaml:"prefix,omitempty" mapstructure:"prefix"`
+	GracePeriod stdtime.Duration `json:"grace_period,omitempty" yaml:"grace_period,omitempty" mapstructure:"grace_period"`
+}
+
+type Store struct {
+	s           storage.Storage
+	prefix      string
+	gracePeriod stdtime.Duration
+}
+
+func New(s storage.Storage, o Options) *Store {
+	prefix := strings.Trim(o.Prefix, "/")
+	if len(prefix) != 0 {
+		prefix += "/"
+	}
+	if o.GracePeriod <= 0 {
+		o.GracePeriod = DefaultGracePeriod
+	}
+	return &Store{s: s, prefix: prefix, gracePeriod: o.GracePeriod}
+}
+
+type Manifest struct {
+	Name    string
+	Digest  safe.Hash
+	Size    int64
+	Created stdtime.Time
+}
+
+type manifestFile struct {
+	Digest  string       `json:"digest"`
+	Size    int64        `json:"size"`
+	Created stdtime.Time `json:"created"`
+}
+
+// ParseDigest parses the hex encoded SHA-256 digest.
+func ParseDigest(digest string) (safe.Hash, error) {
+	h, err := hex.DecodeString(digest)
+	if err != nil || len(h) != sha256.Size {
+		return nil, fmt.Errorf("invalid sha256 digest: %s", digest)
+	}
+	return h, nil
+}
+
+func hexDigest(digest safe.Hash) string {
+	return digest.HexString(sha256.Size * 2)
+}
+
+func (c *Store) blobKey(digest safe.Hash) string {
+	d := hexDigest(digest)
+	return c.prefix + "blobs/sha256/" + d[:2] + "/" + d
+}
+
+func (c *Store) manifestKey(name string) string {
+	return c.prefix + "manifests/" + name
+}
+
+func (c *Store) refsPrefix(digest safe.Hash) string {
+	return c.prefix + "refs/" + hexDigest(digest) + "/"
+}
+
+func (c *Store) refKey(digest safe.Hash, name string) string {
+	return c.refsPrefix(digest) + hexDigest(safe.NewHash(sha256.New, []byte(name)))
+}
+
+func cleanName(name string) (string, error) {
+	cleaned := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if len(cleaned) == 0 || cleaned != strings.Trim(name, "/") {
+		return "", fmt.Errorf("invalid name: %q", name)
+	}
+	return cleaned, nil
+}
+
+// buffer writes the content to a temp file to calculate its digest before the upload, the caller removes the file.
+func buffer(r io.Reader) (tmp *os.File, digest safe.Hash, size int64, err error) {
+	if tmp, err = os.CreateTemp("", "cas-*.tmp"); err != nil {
+		return nil, nil, 0, err
+	}
+	h := sha256.New()
+	if size, err = io.Copy(io.MultiWriter(tmp, h), r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, nil, 0, err
+	}
+	return tmp, h.Sum(nil), size, nil
+}
+
+// PutBlob writes the content to its blob and returns its digest, the upload is skipped if the blob already exists.
+func (c *Store) PutBlob(ctx context.Context, r io.Reader, headers http.Header) (digest safe.Hash, size int64, uploaded bool, err error) {
+	tmp, digest, size, err := buffer(r)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if uploaded, err = c.putBlob(ctx, digest, tmp, headers); err != nil {
+		return nil, 0, false, err
+	}
+	return digest, size, uploaded, nil
+}
+
+func (c *Store) putBlob(ctx context.Context, digest safe.Hash, content io.ReadSeeker, headers http.Header) (bool, error) {
+	if _, err := c.s.HeadObject(ctx, c.blobKey(digest)); err == nil {
+		return false, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	if err := c.s.PutObject(ctx, c.blobKey(digest), content, headers, map[string]string{"sha256": hexDigest(digest)}); err != nil {
+		return false, fmt.Errorf("failed to upload blob %s: %w", hexDigest(digest), err)
+	}
+	return true, nil
+}
+
+// Put writes the content as the blob of name, and points name to it. The reference of name is written before the blob,
+// so that GC does not collect a deduplicated blob before the manifest is written.
+func (c *Store) Put(ctx context.Context, name string, r io.Reader, headers http.Header) (*Manifest, error) {
+	name, err := cleanName(name)
+	if err != nil {
+		return nil, err
+	}
+	tmp, digest, size, err := buffer(r)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	m := &Manifest{Name: name, Digest: digest, Size: size, Created: stdtime.Now()}
+	if err = c.s.PutObject(ctx, c.refKey(m.Digest, name), strings.NewReader(name), nil, nil); err != nil {
+		return nil, fmt.Errorf("failed to write reference of %s: %w", name, err)
+	}
+	if _, err = c.putBlob(ctx, m.Digest, tmp, headers); err != nil {
+		return nil, err
+	}
+	old, err := c.Stat(ctx, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err = c.writeManifest(ctx, m); err != nil {
+		return nil, err
+	}
+	if old != nil && !bytes.Equal(old.Digest, m.Digest) {
+		if err = c.s.DeleteObject(ctx, c.refKey(old.Digest, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("failed to delete reference of %s: %w", name, err)
+		}
+	}
+	return m, nil
+}
+
+func (c *Store) writeManifest(ctx context.Context, m *Manifest) error {
+	data, err := json.Marshal(manifestFile{Digest: hexDigest(m.Digest), Size: m.Size, Created: m.Created})
+	if err != nil {
+		return err
+	}
+	headers := http.Header{"Content-Type": {"application/json"}}
+	if err = c.s.PutObject(ctx, c.manifestKey(m.Name), bytes.NewReader(data), headers, nil); err != nil {
+		return fmt.Errorf("failed to write manifest of %s: %w", m.Name, err)
+	}
+	return nil
+}
+
+// Stat returns the manifest of name.
+func (c *Store) Stat(ctx context.Context, name string) (*Manifest, error) {
+	name, err := cleanName(name)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.s.GetObject(ctx, c.manifestKey(name))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var mf manifestFile
+	if err = json.NewDecoder(r).Decode(&mf); err != nil {
+		return nil, fmt.Errorf("invalid manifest of %s: %w", name, err)
+	}
+	digest, err := ParseDigest(mf.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest of %s: %w", name, err)
+	}
+	return &Manifest{Name: name, Digest: digest, Size: mf.Size, Created: mf.Created}, nil
+}
+
+// Get returns the content of name, the content is verified against the digest when it is read to the end, see GetBlob.
+func (c *Store) Get(ctx context.Context, name string) (*storage.ObjectReader, error) {
+	m, err := c.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetBlob(ctx, m.Digest)
+}
+
+// GetBlob returns the content of the blob, the content is verified against the digest when it is read to the end.
+// The reader does not support Seek and ReadAt, since the content is only verified when it is read sequentially from
+// the start.
+func (c *Store) GetBlob(ctx context.Context, digest safe.Hash) (*storage.ObjectReader, error) {
+	r, err := c.s.GetObject(ctx, c.blobKey(digest))
+	if err != nil {
+		return nil, err
+	}
+	r.ReadCloser = &verifyingReader{ReadCloser: r.ReadCloser, hash: sha256.New(), digest: digest}
+	r.ReadRange = nil
+	return r, nil
+}
+
+// Delete deletes the manifest of name and its reference to the blob, the blob is deleted by GC if no name refers to it.
+func (c *Store) Delete(ctx context.Context, name string) error {
+	m, err := c.Stat(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err = c.s.DeleteObject(ctx, c.manifestKey(m.Name)); err != nil {
+		return err
+	}
+	if err = c.s.DeleteObject(ctx, c.refKey(m.Digest, m.Name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Refs returns the number of the names that refer to the blob.
+func (c *Store) Refs(ctx context.Context, digest safe.Hash) (int, error) {
+	var n int
+	err := c.s.ListObject(ctx, c.refsPrefix(digest), true, func(obj storage.Object) {
+		if !obj.IsDir() {
+			n++
+		}
+	})
+	return n, err
+}
+
+type GCOptions struct {
+	// DryRun reports the collected blobs and references without deleting them.
+	DryRun bool
+}
+
+type GCResult struct {
+	Blobs int
+	// Refs is the number of the stale references, whose names point to other blobs or are deleted.
+	Refs  int
+	Bytes int64
+}
+
+// GC deletes the stale references and the blobs without references. The blobs and references modified in the grace
+// period are kept, since they may belong to a running Put.
+func (c *Store) GC(ctx context.Context, o GCOptions) (*GCResult, error) {
+	result := &GCResult{}
+	deadline := stdtime.Now().Add(-c.gracePeriod)
+	refs := map[string]int{}
+	// the references older than the grace period are validated against the manifests.
+	var old []string
+	err := c.s.ListObject(ctx, c.prefix+"refs/", true, func(obj storage.Object) {
+		if obj.IsDir() {
+			return
+		}
+		key := strings.TrimPrefix(obj.Key, "/")
+		if obj.LastModified.Before(deadline) {
+			old = append(old, key)
+		} else {
+			refs[path.Base(path.Dir(key))]++
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	var stale []string
+	for _, key := range old {
+		digest := path.Base(path.Dir(key))
+		valid, err := c.validRef(ctx, key, digest)
+		if err != nil {
+			return nil, err
+		}
+		if valid {
+			refs[digest]++
+		} else {
+			stale = append(stale, key)
+		}
+	}
+	var blobs []string
+	err = c.s.ListObject(ctx, c.prefix+"blobs/sha256/", true, func(obj storage.Object) {
+		if obj.IsDir() || refs[path.Base(obj.Key)] > 0 || !obj.LastModified.Before(deadline) {
+			return
+		}
+		blobs = append(blobs, strings.TrimPrefix(obj.Key, "/"))
+		if obj.Size > 0 {
+			result.Bytes += obj.Size
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Refs, result.Blobs = len(stale), len(blobs)
+	if o.DryRun {
+		return result, nil
+	}
+	for _, keys := range [][]string{stale, blobs} {
+		if len(keys) == 0 {
+			continue
+		}
+		if err = c.s.DeleteObjects(ctx, keys); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// validRef reports whether the name of the reference still points to the digest.
+func (c *Store) validRef(ctx context.Context, key, digest string) (bool, error) {
+	r, err := c.s.GetObject(ctx, key)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	name, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return false, err
+	}
+	m, err := c.Stat(ctx, string(name))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return hexDigest(m.Digest) == digest, nil
+}
+
+// verifyingReader returns an error at the end of the content if its digest does not match.
+type verifyingReader struct {
+	io.ReadCloser
+	hash   hash.Hash
+	digest safe.Hash
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(r.hash.Sum(nil), r.digest) {
+		return n, fmt.Errorf("blob %s is corrupted: digest mismatch", hexDigest(r.digest))
+	}
+	return n, err
+}
@@ -0,0 +1,104 @@
+package cas
+
+import (
+	"context"
+	"crypto/sha256"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"testing"
+	stdtime "time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/MicroOps-cn/fuck/clients/storage"
+	fsstorage "github.com/MicroOps-cn/fuck/clients/storage/fs"
+	"github.com/MicroOps-cn/fuck/safe"
+)
+
+type countingStorage struct {
+	storage.Storage
+	puts int
+}
+
+func (s *countingStorage) PutObject(ctx context.Context, objectPath string, obj io.Reader, headers http.Header, metadata map[string]string) error {
+	if strings.Contains(objectPath, "blobs/") {
+		s.puts++
+	}
+	return s.Storage.PutObject(ctx, objectPath, obj, headers, metadata)
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	backend, err := fsstorage.NewClient(ctx, nil, storage.NewMapConfigProvider(map[string]interface{}{"type": "in-memory"}))
+	require.NoError(t, err)
+	s := &countingStorage{Storage: backend}
+	c := New(s, Options{Prefix: "cas", GracePeriod: stdtime.Nanosecond})
+
+	m, err := c.Put(ctx, "builds/1/app.tar", strings.NewReader("artifact"), nil)
+	require.NoError(t, err)
+	require.Equal(t, safe.NewHash(sha256.New, []byte("artifact")), m.Digest)
+	_, err = c.Put(ctx, "builds/2/app.tar", strings.NewReader("artifact"), nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, s.puts)
+	refs, err := c.Refs(ctx, m.Digest)
+	require.NoError(t, err)
+	require.Equal(t, 2, refs)
+
+	r, err := c.Get(ctx, "builds/2/app.tar")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, "artifact", string(data))
+
+	// the content read from an offset cannot be verified.
+	r, err = c.Get(ctx, "builds/2/app.tar")
+	require.NoError(t, err)
+	_, err = r.Seek(1, io.SeekStart)
+	require.NoError(t, err)
+	_, err = r.Read(make([]byte, 1))
+	require.Error(t, err)
+	_, err = r.ReadAt(make([]byte, 1), 1)
+	require.Error(t, err)
+	require.NoError(t, r.Close())
+
+	// overwriting a name moves its reference to the new blob.
+	_, err = c.Put(ctx, "builds/2/app.tar", strings.NewReader("patched"), nil)
+	require.NoError(t, err)
+	refs, err = c.Refs(ctx, m.Digest)
+	require.NoError(t, err)
+	require.Equal(t, 1, refs)
+
+	require.NoError(t, c.Delete(ctx, "builds/1/app.tar"))
+	_, err = c.Stat(ctx, "builds/1/app.tar")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	stdtime.Sleep(stdtime.Millisecond)
+	result, err := c.GC(ctx, GCOptions{DryRun: true})
+	require.NoError(t, err)
+	require.Equal(t, GCResult{Blobs: 1, Bytes: 8}, *result)
+	_, err = c.GC(ctx, GCOptions{})
+	require.NoError(t, err)
+	_, err = c.GetBlob(ctx, m.Digest)
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	r, err = c.Get(ctx, "builds/2/app.tar")
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	// a stale reference left by an interrupted Put is collected.
+	digest, _, uploaded, err := c.PutBlob(ctx, strings.NewReader("orphan"), nil)
+	require.NoError(t, err)
+	require.True(t, uploaded)
+	require.NoError(t, s.PutObject(ctx, c.refKey(digest, "lost"), strings.NewReader("lost"), nil, nil))
+	stdtime.Sleep(stdtime.Millisecond)
+	result, err = c.GC(ctx, GCOptions{})
+	require.NoError(t, err)
+	require.Equal(t, GCResult{Blobs: 1, Refs: 1, Bytes: 6}, *result)
+}
+
+func TestVerifyingReader(t *testing.T) {
+	r := &verifyingReader{ReadCloser: io.NopCloser(strings.NewReader("tampered")), hash: sha256.New(), digest: safe.NewHash(sha256.New, []byte("original"))}
+	_, err := io.ReadAll(r)
+	require.ErrorContains(t, err, "digest mismatch")
+}
@@ -0,0 +1,344 @@
+package compression
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/MicroOps-cn/fuck/clients/storage"
+)
+
+type Encoding string
+
+const (
+	Identity Encoding = "identity"
+	Gzip     Encoding = "gzip"
+	Zstd     Encoding = "zstd"
+)
+
+// The metadata keys of the objects compressed by Storage. MetadataContentEncoding is recorded in addition to the
+// Content-Encoding header, and marks the objects to decompress on read.
+const (
+	MetadataContentEncoding  = "content-encoding"
+	MetadataUncompressedSize = "uncompressed-size"
+)
+
+type Rule struct {
+	// Pattern is a key prefix or a glob pattern, see storage.MatchKey.
+	Pattern  string   `json:"pattern" yaml:"pattern" mapstructure:"pattern"`
+	Encoding Encoding `json:"encoding" yaml:"encoding" mapstructure:"encoding"`
+	// Level is the compression level of the encoding, zero means the default level of the encoding.
+	Level int `json:"level,omitempty" yaml:"level,omitempty" mapstructure:"level"`
+}
+
+type Options struct {
+	// Rules are matched against the key in order, the objects that match no rule are not compressed.
+	// A rule of the identity encoding excludes the objects it matches from the following rules.
+	Rules []Rule `json:"rules" yaml:"rules" mapstructure:"rules"`
+}
+
+// Storage compresses the objects on PutObject according to the rules, and decompresses the objects it compressed on
+// GetObject. The objects put with their own Content-Encoding are stored and returned as is. HeadObject and GetObject
+// return the uncompressed size recorded on PutObject, while ListObject and ListObjects return the stored sizes.
+type Storage struct {
+	storage.Storage
+	rules []Rule
+}
+
+func New(backend storage.Storage, o Options) (*Storage, error) {
+	for _, rule := range o.Rules {
+		switch rule.Encoding {
+		case Identity:
+		case Gzip:
+			if rule.Level != 0 && (rule.Level < gzip.HuffmanOnly || rule.Level > gzip.BestCompression) {
+				return nil, fmt.Errorf("invalid gzip level %d of pattern %s", rule.Level, rule.Pattern)
+			}
+		case Zstd:
+		default:
+			return nil, fmt.Errorf("unsupported encoding %s of pattern %s", rule.Encoding, rule.Pattern)
+		}
+	}
+	return &Storage{Storage: backend, rules: o.Rules}, nil
+}
+
+func (s *Storage) Unwrap() storage.Storage {
+	return s.Storage
+}
+
+func (s *Storage) match(key string) *Rule {
+	for i, rule := range s.rules {
+		if storage.MatchKey(rule.Pattern, key) {
+			if rule.Encoding == Identity {
+				return nil
+			}
+			return &s.rules[i]
+		}
+	}
+	return nil
+}
+
+func newWriter(w io.Writer, rule *Rule) (io.WriteCloser, error) {
+	switch rule.Encoding {
+	case Gzip:
+		level := rule.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case Zstd:
+		var opts []zstd.EOption
+		if rule.Level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(rule.Level)))
+		}
+		return zstd.NewWriter(w, opts...)
+	default:
+		return nil, fmt.Errorf("unsupported encoding %s", rule.Encoding)
+	}
+}
+
+func newReader(r io.Reader, encoding Encoding) (io.ReadCloser, error) {
+	switch encoding {
+	case Gzip:
+		return gzip.NewReader(r)
+	case Zstd:
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported encoding %s", encoding)
+	}
+}
+
+// PutObject compresses obj with the encoding of the first rule matching objectPath. The objects that already
+// have a Content-Encoding header are stored as is. The content is buffered in a temp file to get its size unless
+// obj implements io.Seeker.
+func (s *Storage) PutObject(ctx context.Context, objectPath string, obj io.Reader, headers http.Header, metadata map[string]string) error {
+	if encoding := headers.Get("Content-Encoding"); len(encoding) != 0 && Encoding(encoding) != Identity {
+		return s.Storage.PutObject(ctx, objectPath, obj, headers, metadata)
+	}
+	rule := s.match(objectPath)
+	if rule == nil {
+		return s.Storage.PutObject(ctx, objectPath, obj, headers, metadata)
+	}
+	obj, size, cleanup, err := contentSize(obj)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if headers == nil {
+		headers = http.Header{}
+	} else {
+		headers = headers.Clone()
+	}
+	headers.Set("Content-Encoding", string(rule.Encoding))
+	headers.Del("Content-Length")
+	headers.Del("Content-MD5")
+	meta := make(map[string]string, len(metadata)+2)
+	for k, v := range metadata {
+		meta[k] = v
+	}
+	meta[MetadataContentEncoding] = string(rule.Encoding)
+	meta[MetadataUncompressedSize] = strconv.FormatInt(size, 10)
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w, err := newWriter(pw, rule)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err = io.Copy(w, obj); err != nil {
+			w.Close()
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(w.Close())
+	}()
+	err = s.Storage.PutObject(ctx, objectPath, pr, headers, meta)
+	// stops the compression if the backend returns before reading all of it.
+	pr.CloseWithError(io.ErrClosedPipe)
+	<-done
+	return err
+}
+
+// contentSize returns the content of r and its size, r is buffered in a temp file if it does not implement io.Seeker.
+// The returned cleanup removes the temp file.
+func contentSize(r io.Reader) (content io.Reader, size int64, cleanup func(), err error) {
+	if seeker, ok := r.(io.Seeker); ok {
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		end, err := seeker.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		if _, err = seeker.Seek(start, io.SeekStart); err != nil {
+			return nil, 0, nil, err
+		}
+		return r, end - start, func() {}, nil
+	}
+	tmp, err := os.CreateTemp("", "storage-compression-*")
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	cleanup = func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	if size, err = io.Copy(tmp, r); err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, 0, nil, err
+	}
+	return tmp, size, cleanup, nil
+}
+
+// encodingOf returns the encoding of obj if it is compressed by Storage.
+func encodingOf(obj *storage.Object) (Encoding, bool) {
+	encoding := Encoding(obj.Metadata[MetadataContentEncoding])
+	return encoding, encoding == Gzip || encoding == Zstd
+}
+
+// decompressedObject removes the encoding of the compressed object from obj, and replaces its size by the
+// uncompressed size, which is -1 if it is not recorded.
+func decompressedObject(obj storage.Object) storage.Object {
+	obj.Size = -1
+	if size, err := strconv.ParseInt(obj.Metadata[MetadataUncompressedSize], 10, 64); err == nil && size >= 0 {
+		obj.Size = size
+	}
+	if obj.Headers != nil {
+		obj.Headers = obj.Headers.Clone()
+		obj.Headers.Del("Content-Encoding")
+		obj.Headers.Del("Content-Length")
+		if obj.Size >= 0 {
+			obj.Headers.Set("Content-Length", strconv.FormatInt(obj.Size, 10))
+		}
+	}
+	metadata := make(map[string]string, len(obj.Metadata))
+	for k, v := range obj.Metadata {
+		if k != MetadataContentEncoding && k != MetadataUncompressedSize {
+			metadata[k] = v
+		}
+	}
+	obj.Metadata = metadata
+	return obj
+}
+
+func (s *Storage) HeadObject(ctx context.Context, objectPath string) (*storage.Object, error) {
+	obj, err := s.Storage.HeadObject(ctx, objectPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := encodingOf(obj); ok {
+		decompressed := decompressedObject(*obj)
+		return &decompressed, nil
+	}
+	return obj, nil
+}
+
+func (s *Storage) GetObject(ctx context.Context, objectPath string) (*storage.ObjectReader, error) {
+	r, err := s.Storage.GetObject(ctx, objectPath)
+	if err != nil {
+		return nil, err
+	}
+	encoding, ok := encodingOf(&r.Object)
+	if !ok {
+		return r, nil
+	}
+	rc, err := newReadCloser(r, encoding)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return &storage.ObjectReader{
+		ReadCloser: rc,
+		Object:     decompressedObject(r.Object),
+		ReadRange: func(offset, length int64) (io.ReadCloser, error) {
+			return s.readRange(ctx, objectPath, encoding, offset, length)
+		},
+	}, nil
+}
+
+// GetObjectRange decompresses the compressed objects from the beginning and discards the content before offset.
+func (s *Storage) GetObjectRange(ctx context.Context, objectPath string, offset, length int64) (*storage.ObjectReader, error) {
+	head, err := s.Storage.HeadObject(ctx, objectPath)
+	if err != nil {
+		return nil, err
+	}
+	encoding, ok := encodingOf(head)
+	if !ok {
+		return s.Storage.GetObjectRange(ctx, objectPath, offset, length)
+	}
+	rc, err := s.readRange(ctx, objectPath, encoding, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return &storage.ObjectReader{
+		ReadCloser: rc,
+		Object:     decompressedObject(*head),
+		Offset:     offset,
+		ReadRange: func(offset, length int64) (io.ReadCloser, error) {
+			return s.readRange(ctx, objectPath, encoding, offset, length)
+		},
+	}, nil
+}
+
+func (s *Storage) readRange(ctx context.Context, objectPath string, encoding Encoding, offset, length int64) (io.ReadCloser, error) {
+	r, err := s.Storage.GetObject(ctx, objectPath)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := newReadCloser(r, encoding)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	if _, err = io.CopyN(io.Discard, rc, offset); err != nil && err != io.EOF {
+		rc.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return rc, nil
+	}
+	return &readCloser{Reader: io.LimitReader(rc, length), closers: []io.Closer{rc}}, nil
+}
+
+func newReadCloser(r io.ReadCloser, encoding Encoding) (io.ReadCloser, error) {
+	d, err := newReader(r, encoding)
+	if err != nil {
+		return nil, err
+	}
+	return &readCloser{Reader: d, closers: []io.Closer{d, r}}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *readCloser) Close() (err error) {
+	for _, c := range r.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (s *Storage) Open(name string) (fs.File, error) {
+	return s.GetObject(context.Background(), name)
+}
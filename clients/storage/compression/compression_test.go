@@ -0,0 +1,114 @@
+package compression
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/MicroOps-cn/fuck/clients/storage"
+	fsstorage "github.com/MicroOps-cn/fuck/clients/storage/fs"
+)
+
+func newBackend(t *testing.T) storage.Storage {
+	backend, err := fsstorage.NewClient(context.Background(), nil, storage.NewMapConfigProvider(map[string]interface{}{"type": "in-memory"}))
+	require.NoError(t, err)
+	return backend
+}
+
+// rawObject returns the stored content of the object and the object in the backend.
+func rawObject(t *testing.T, backend storage.Storage, objectPath string) ([]byte, storage.Object) {
+	r, err := backend.GetObject(context.Background(), objectPath)
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return data, r.Object
+}
+
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+	backend := newBackend(t)
+	s, err := New(backend, Options{Rules: []Rule{
+		{Pattern: "logs/raw/", Encoding: Identity},
+		{Pattern: "logs/", Encoding: Gzip},
+		{Pattern: "*.json", Encoding: Zstd, Level: 3},
+	}})
+	require.NoError(t, err)
+	content := strings.Repeat("GET /index.html 200\n", 100)
+
+	for key, encoding := range map[string]string{
+		"logs/access.log":     "gzip",
+		"data.json":           "zstd",
+		"logs/raw/access.log": "",
+		"data/data.json":      "",
+	} {
+		// the content of a reader that cannot seek is buffered to get its size.
+		require.NoError(t, s.PutObject(ctx, key, io.MultiReader(strings.NewReader(content)), http.Header{"Content-Type": {"text/plain"}}, nil))
+		raw, stored := rawObject(t, backend, key)
+		require.Equal(t, encoding, stored.Headers.Get("Content-Encoding"), key)
+		require.Equal(t, encoding, stored.Metadata[MetadataContentEncoding], key)
+		if len(encoding) != 0 {
+			require.Less(t, len(raw), len(content))
+			require.Equal(t, strconv.Itoa(len(content)), stored.Metadata[MetadataUncompressedSize])
+		}
+
+		head, err := s.HeadObject(ctx, key)
+		require.NoError(t, err)
+		require.Equal(t, int64(len(content)), head.Size)
+		require.Equal(t, strconv.Itoa(len(content)), head.Headers.Get("Content-Length"))
+
+		r, err := s.GetObject(ctx, key)
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		require.Equal(t, content, string(data))
+		require.Equal(t, int64(len(content)), r.Size)
+		require.Empty(t, r.Headers.Get("Content-Encoding"))
+		require.Equal(t, "text/plain", r.Headers.Get("Content-Type"))
+		require.NotContains(t, r.Metadata, MetadataContentEncoding)
+		require.NotContains(t, r.Metadata, MetadataUncompressedSize)
+
+		r, err = s.GetObjectRange(ctx, key, 20, 15)
+		require.NoError(t, err)
+		data, err = io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, content[20:35], string(data))
+		_, err = r.Seek(5, io.SeekStart)
+		require.NoError(t, err)
+		data = make([]byte, 10)
+		_, err = io.ReadFull(r, data)
+		require.NoError(t, err)
+		require.Equal(t, content[5:15], string(data))
+		_, err = r.Seek(-10, io.SeekEnd)
+		require.NoError(t, err)
+		data, err = io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, content[len(content)-10:], string(data))
+		require.NoError(t, r.Close())
+	}
+
+	// the content encoded by the caller is stored and returned as is.
+	var buf bytes.Buffer
+	w, err := newWriter(&buf, &Rule{Encoding: Gzip})
+	require.NoError(t, err)
+	_, err = w.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, s.PutObject(ctx, "logs/error.log", bytes.NewReader(buf.Bytes()), http.Header{"Content-Encoding": {"gzip"}}, nil))
+	raw, _ := rawObject(t, backend, "logs/error.log")
+	require.Equal(t, buf.Bytes(), raw)
+	r, err := s.GetObject(ctx, "logs/error.log")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, buf.Bytes(), data)
+	require.Equal(t, "gzip", r.Headers.Get("Content-Encoding"))
+	require.Equal(t, int64(buf.Len()), r.Size)
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	stdtime "time"
+
+	"github.com/MicroOps-cn/fuck/errors"
+)
+
+// ErrConditionNotSupported is returned by the writes whose Condition the backend cannot evaluate atomically with the
+// write, a check before the write would let concurrent writers pass it.
+var ErrConditionNotSupported = errors.New("storage: condition is not supported by the backend")
+
+// Condition is the precondition of GetObject, GetObjectRange and PutObject, which is passed by WithCondition.
+// The backends map it to the conditional headers of the object storage, a failed condition is reported as
+// *errors.PreconditionFailedError. The conditions of writes are evaluated atomically with the write, the backends
+// that cannot do so for a condition, such as OSS for If-Match and S3 for If-Unmodified-Since, reject the write with
+// ErrConditionNotSupported.
+type Condition struct {
+	// IfMatch is the ETag that the object must have, "*" matches any existing object.
+	IfMatch string
+	// IfNoneMatch is the ETag that the object must not have, "*" matches any existing object,
+	// so that PutObject creates the object only if it does not exist.
+	IfNoneMatch string
+	// IfModifiedSince only applies to reads.
+	IfModifiedSince   stdtime.Time
+	IfUnmodifiedSince stdtime.Time
+}
+
+func (c Condition) IsZero() bool {
+	return len(c.IfMatch) == 0 && len(c.IfNoneMatch) == 0 && c.IfModifiedSince.IsZero() && c.IfUnmodifiedSince.IsZero()
+}
+
+type conditionContextKey struct{}
+
+// WithCondition returns a context that makes the reads and writes with it conditional.
+func WithCondition(ctx context.Context, c Condition) context.Context {
+	return context.WithValue(ctx, conditionContextKey{}, c)
+}
+
+// WithoutCondition returns a context without condition, it is used for the requests that are not
+// the conditional request itself, such as reading the source of a copy.
+func WithoutCondition(ctx context.Context) context.Context {
+	if _, ok := ConditionFromContext(ctx); !ok {
+		return ctx
+	}
+	return WithCondition(ctx, Condition{})
+}
+
+func ConditionFromContext(ctx context.Context) (Condition, bool) {
+	c, ok := ctx.Value(conditionContextKey{}).(Condition)
+	return c, ok && !c.IsZero()
+}
+
+// QuoteETag returns the ETag in the quoted form of the HTTP headers, "*" is returned as is.
+func QuoteETag(etag string) string {
+	if etag == "*" || strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, `W/"`) {
+		return etag
+	}
+	return `"` + etag + `"`
+}
+
+func etagMatch(condition, etag string) bool {
+	if condition == "*" {
+		return true
+	}
+	return strings.Trim(strings.TrimPrefix(condition, "W/"), `"`) == strings.Trim(strings.TrimPrefix(etag, "W/"), `"`)
+}
+
+// CheckRead evaluates the condition of a read of obj as RFC 7232 does.
+func (c Condition) CheckRead(obj *Object) error {
+	modTime := obj.LastModified.Truncate(stdtime.Second)
+	if len(c.IfMatch) != 0 && !etagMatch(c.IfMatch, obj.ETag) {
+		return errors.NewPreconditionFailedError(obj.Key, "If-Match")
+	}
+	if len(c.IfMatch) == 0 && !c.IfUnmodifiedSince.IsZero() && modTime.After(c.IfUnmodifiedSince) {
+		return errors.NewPreconditionFailedError(obj.Key, "If-Unmodified-Since")
+	}
+	if len(c.IfNoneMatch) != 0 && etagMatch(c.IfNoneMatch, obj.ETag) {
+		return errors.NewNotModifiedError(obj.Key, "If-None-Match")
+	}
+	if len(c.IfNoneMatch) == 0 && !c.IfModifiedSince.IsZero() && !modTime.After(c.IfModifiedSince) {
+		return errors.NewNotModifiedError(obj.Key, "If-Modified-Since")
+	}
+	return nil
+}
+
+// CheckWrite evaluates the condition of a write of key, obj is the current object or nil if it does not exist.
+func (c Condition) CheckWrite(key string, obj *Object) error {
+	if len(c.IfMatch) != 0 && (obj == nil || !etagMatch(c.IfMatch, obj.ETag)) {
+		return errors.NewPreconditionFailedError(key, "If-Match")
+	}
+	if len(c.IfNoneMatch) != 0 && obj != nil && etagMatch(c.IfNoneMatch, obj.ETag) {
+		return errors.NewPreconditionFailedError(key, "If-None-Match")
+	}
+	if !c.IfUnmodifiedSince.IsZero() && obj != nil && obj.LastModified.Truncate(stdtime.Second).After(c.IfUnmodifiedSince) {
+		return errors.NewPreconditionFailedError(key, "If-Unmodified-Since")
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"testing"
+	stdtime "time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/MicroOps-cn/fuck/errors"
+)
+
+func TestCondition_CheckRead(t *testing.T) {
+	modTime := stdtime.Date(2024, 1, 2, 3, 4, 5, 600, stdtime.UTC)
+	obj := &Object{Key: "a.txt", ETag: "abc", LastModified: modTime}
+	for _, tc := range []struct {
+		name        string
+		cond        Condition
+		failed      bool
+		notModified bool
+	}{
+		{name: "if-match", cond: Condition{IfMatch: `"abc"`}},
+		{name: "if-match any", cond: Condition{IfMatch: "*"}},
+		{name: "if-match mismatch", cond: Condition{IfMatch: "def"}, failed: true},
+		{name: "if-none-match", cond: Condition{IfNoneMatch: "abc"}, failed: true, notModified: true},
+		{name: "if-none-match mismatch", cond: Condition{IfNoneMatch: `W/"def"`}},
+		{name: "if-modified-since", cond: Condition{IfModifiedSince: modTime.Truncate(stdtime.Second)}, failed: true, notModified: true},
+		{name: "if-modified-since before", cond: Condition{IfModifiedSince: modTime.Add(-stdtime.Second)}},
+		{name: "if-unmodified-since", cond: Condition{IfUnmodifiedSince: modTime.Add(-stdtime.Second)}, failed: true},
+		{name: "if-match overrides if-unmodified-since", cond: Condition{IfMatch: "abc", IfUnmodifiedSince: modTime.Add(-stdtime.Hour)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.cond.CheckRead(obj)
+			if !tc.failed {
+				require.NoError(t, err)
+				return
+			}
+			require.True(t, errors.IsPreconditionFailed(err))
+			require.Equal(t, tc.notModified, err.(*errors.PreconditionFailedError).NotModified)
+		})
+	}
+}
+
+func TestCondition_CheckWrite(t *testing.T) {
+	obj := &Object{Key: "a.txt", ETag: "abc", LastModified: stdtime.Now()}
+	require.NoError(t, Condition{IfNoneMatch: "*"}.CheckWrite("b.txt", nil))
+	require.True(t, errors.IsPreconditionFailed(Condition{IfNoneMatch: "*"}.CheckWrite("a.txt", obj)))
+	require.True(t, errors.IsPreconditionFailed(Condition{IfMatch: "abc"}.CheckWrite("b.txt", nil)))
+	require.NoError(t, Condition{IfMatch: "abc"}.CheckWrite("a.txt", obj))
+	require.True(t, errors.IsPreconditionFailed(Condition{IfUnmodifiedSince: obj.LastModified.Add(-stdtime.Hour)}.CheckWrite("a.txt", obj)))
+}
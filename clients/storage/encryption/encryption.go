@@ -0,0 +1,483 @@
+package encryption
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/MicroOps-cn/fuck/clients/storage"
+	"github.com/MicroOps-cn/fuck/safe"
+)
+
+// AlgorithmAES256GCMStream encrypts the object in chunks with AES-256-GCM under a random data key.
+// The nonce of each chunk is the 7 bytes nonce prefix of the object, the big endian chunk index
+// and a byte marking the last chunk, so that reordered or truncated chunks fail to decrypt.
+const AlgorithmAES256GCMStream = "AES256-GCM-STREAM"
+
+// The metadata keys of encrypted objects.
+const (
+	MetadataAlgorithm = "encryption-algorithm"
+	MetadataKeyID     = "encryption-key-id"
+	MetadataKey       = "encryption-key"
+	MetadataNonce     = "encryption-nonce"
+	MetadataChunkSize = "encryption-chunk-size"
+)
+
+const (
+	DefaultChunkSize = 64 << 10
+	// MaxChunkSize bounds the chunk size, including the one read from the metadata of an object, which is not
+	// authenticated, so that a tampered object cannot make the reader allocate an arbitrary large buffer.
+	MaxChunkSize    = 4 << 20
+	dataKeySize     = 32
+	noncePrefixSize = 7
+)
+
+var ErrNoKey = errors.New("encryption: no master key, set " + safe.SecretEnvName + " or Options.Keys")
+
+type Options struct {
+	// Keys are the master keys by key id. The keys that have been rotated out should be kept to
+	// decrypt the objects encrypted with them. The key of GLOBAL_ENCRYPT_KEY is used when it is empty.
+	Keys map[string]safe.String `json:"keys,omitempty" yaml:"keys,omitempty" mapstructure:"keys"`
+	// KeyID is the id in Keys of the master key that encrypts new objects, it can be omitted if there is only one key.
+	KeyID     string `json:"key_id,omitempty" yaml:"key_id,omitempty" mapstructure:"key_id"`
+	ChunkSize int    `json:"chunk_size,omitempty" yaml:"chunk_size,omitempty" mapstructure:"chunk_size"`
+}
+
+// Storage encrypts the objects before they are put into the backend and decrypts them when they are read.
+// Every object is encrypted with its own data key, the data key is wrapped by the master key and stored in
+// the object metadata with the key id, so the master key can be rotated without re-encrypting the objects.
+//
+// Objects without encryption metadata are returned as is. The sizes returned by HeadObject and GetObject are
+// the sizes of the plaintext, while ListObject and ListObjects return the sizes stored in the backend.
+type Storage struct {
+	storage.Storage
+	keys      map[string]cipher.AEAD
+	keyID     string
+	chunkSize int
+}
+
+// The labels that derive the key id and the key encryption key from a master key, so that the key id, which is
+// stored in plaintext in the metadata, reveals nothing about the key encryption key.
+const (
+	keyIDLabel = "storage-encryption key-id"
+	kekLabel   = "storage-encryption kek"
+)
+
+// KeyID returns the default id of the master key, which is the prefix of its HMAC-SHA256 of a fixed label.
+func KeyID(key string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(keyIDLabel))
+	return safe.Hash(mac.Sum(nil)).HexString(16)
+}
+
+// deriveKEK derives the AES-256 key that wraps the data keys from the master key with HKDF-SHA256.
+func deriveKEK(key string) ([]byte, error) {
+	kek := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, []byte(key), nil, []byte(kekLabel)), kek); err != nil {
+		return nil, err
+	}
+	return kek, nil
+}
+
+func New(backend storage.Storage, o Options) (*Storage, error) {
+	keys := map[string]string{}
+	for id, key := range o.Keys {
+		plain, err := key.UnsafeString()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt key %s: %w", id, err)
+		}
+		keys[id] = plain
+	}
+	if len(keys) == 0 {
+		if key := os.Getenv(safe.SecretEnvName); len(key) != 0 {
+			keys[KeyID(key)] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, ErrNoKey
+	}
+	if len(o.KeyID) == 0 {
+		if len(keys) != 1 {
+			return nil, errors.New("encryption: key id is required when there are multiple keys")
+		}
+		for id := range keys {
+			o.KeyID = id
+		}
+	}
+	if _, ok := keys[o.KeyID]; !ok {
+		return nil, fmt.Errorf("encryption: unknown key id %s", o.KeyID)
+	}
+	if o.ChunkSize <= 0 {
+		o.ChunkSize = DefaultChunkSize
+	} else if o.ChunkSize > MaxChunkSize {
+		return nil, fmt.Errorf("encryption: chunk size %d exceeds %d", o.ChunkSize, MaxChunkSize)
+	}
+	s := &Storage{Storage: backend, keys: map[string]cipher.AEAD{}, keyID: o.KeyID, chunkSize: o.ChunkSize}
+	for id, key := range keys {
+		kek, err := deriveKEK(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := newAEAD(kek)
+		if err != nil {
+			return nil, err
+		}
+		s.keys[id] = aead
+	}
+	return s, nil
+}
+
+func (s *Storage) Unwrap() storage.Storage {
+	return s.Storage
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s *Storage) wrapKey(dataKey []byte) (string, error) {
+	kek := s.keys[s.keyID]
+	nonce := make([]byte, kek.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := kek.Seal(nonce, nonce, dataKey, []byte(AlgorithmAES256GCMStream))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *Storage) unwrapKey(keyID, wrapped string) ([]byte, error) {
+	kek, ok := s.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("encryption: unknown key id %s", keyID)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: invalid data key: %w", err)
+	}
+	if len(sealed) < kek.NonceSize() {
+		return nil, errors.New("encryption: invalid data key")
+	}
+	dataKey, err := kek.Open(nil, sealed[:kek.NonceSize()], sealed[kek.NonceSize():], []byte(AlgorithmAES256GCMStream))
+	if err != nil {
+		return nil, fmt.Errorf("encryption: failed to unwrap data key with key %s: %w", keyID, err)
+	}
+	return dataKey, nil
+}
+
+// params are the encryption parameters of an object read from its metadata.
+type params struct {
+	aead        cipher.AEAD
+	noncePrefix []byte
+	chunkSize   int64
+}
+
+// cipherChunkSize is the size of an encrypted chunk, including the tag.
+func (p *params) cipherChunkSize() int64 {
+	return p.chunkSize + int64(p.aead.Overhead())
+}
+
+// chunks returns the number of chunks of an object of cipherSize bytes.
+func (p *params) chunks(cipherSize int64) int64 {
+	return (cipherSize + p.cipherChunkSize() - 1) / p.cipherChunkSize()
+}
+
+func (p *params) plainSize(cipherSize int64) int64 {
+	return cipherSize - p.chunks(cipherSize)*int64(p.aead.Overhead())
+}
+
+func (s *Storage) params(obj *storage.Object) (*params, error) {
+	algorithm, ok := obj.Metadata[MetadataAlgorithm]
+	if !ok {
+		return nil, nil
+	}
+	if algorithm != AlgorithmAES256GCMStream {
+		return nil, fmt.Errorf("encryption: unsupported algorithm %s", algorithm)
+	}
+	dataKey, err := s.unwrapKey(obj.Metadata[MetadataKeyID], obj.Metadata[MetadataKey])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	noncePrefix, err := base64.StdEncoding.DecodeString(obj.Metadata[MetadataNonce])
+	if err != nil || len(noncePrefix) != noncePrefixSize {
+		return nil, errors.New("encryption: invalid nonce")
+	}
+	chunkSize, err := strconv.ParseInt(obj.Metadata[MetadataChunkSize], 10, 64)
+	if err != nil || chunkSize <= 0 || chunkSize > MaxChunkSize {
+		return nil, errors.New("encryption: invalid chunk size")
+	}
+	if obj.Size < int64(aead.Overhead()) {
+		return nil, errors.New("encryption: object is truncated")
+	}
+	return &params{aead: aead, noncePrefix: noncePrefix, chunkSize: chunkSize}, nil
+}
+
+func nonce(prefix []byte, index int64, last bool) []byte {
+	n := make([]byte, noncePrefixSize+5)
+	copy(n, prefix)
+	binary.BigEndian.PutUint32(n[noncePrefixSize:], uint32(index))
+	if last {
+		n[len(n)-1] = 1
+	}
+	return n
+}
+
+// plainObject replaces the sizes of obj with the size of the plaintext and removes the encryption metadata,
+// so that the object can be copied to other storages as is.
+func plainObject(obj storage.Object, p *params) storage.Object {
+	size := p.plainSize(obj.Size)
+	obj.Size = size
+	metadata := make(map[string]string, len(obj.Metadata))
+	for k, v := range obj.Metadata {
+		switch k {
+		case MetadataAlgorithm, MetadataKeyID, MetadataKey, MetadataNonce, MetadataChunkSize:
+		default:
+			metadata[k] = v
+		}
+	}
+	obj.Metadata = metadata
+	if obj.Headers != nil {
+		obj.Headers = obj.Headers.Clone()
+		obj.Headers.Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	return obj
+}
+
+func (s *Storage) HeadObject(ctx context.Context, objectPath string) (*storage.Object, error) {
+	obj, err := s.Storage.HeadObject(ctx, objectPath)
+	if err != nil {
+		return nil, err
+	}
+	p, err := s.params(obj)
+	if err != nil || p == nil {
+		return obj, err
+	}
+	plain := plainObject(*obj, p)
+	return &plain, nil
+}
+
+func (s *Storage) GetObject(ctx context.Context, objectPath string) (*storage.ObjectReader, error) {
+	r, err := s.Storage.GetObject(ctx, objectPath)
+	if err != nil {
+		return nil, err
+	}
+	p, err := s.params(&r.Object)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	if p == nil {
+		return r, nil
+	}
+	cipherSize := r.Size
+	return &storage.ObjectReader{
+		ReadCloser: p.decrypter(r, 0, -1, cipherSize),
+		Object:     plainObject(r.Object, p),
+		ReadRange: func(offset, length int64) (io.ReadCloser, error) {
+			return s.readRange(ctx, objectPath, p, cipherSize, offset, length)
+		},
+	}, nil
+}
+
+func (s *Storage) GetObjectRange(ctx context.Context, objectPath string, offset, length int64) (*storage.ObjectReader, error) {
+	head, err := s.Storage.HeadObject(ctx, objectPath)
+	if err != nil {
+		return nil, err
+	}
+	p, err := s.params(head)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return s.Storage.GetObjectRange(ctx, objectPath, offset, length)
+	}
+	rc, err := s.readRange(ctx, objectPath, p, head.Size, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return &storage.ObjectReader{
+		ReadCloser: rc,
+		Object:     plainObject(*head, p),
+		Offset:     offset,
+		ReadRange: func(offset, length int64) (io.ReadCloser, error) {
+			return s.readRange(ctx, objectPath, p, head.Size, offset, length)
+		},
+	}, nil
+}
+
+// readRange reads the chunks that contain the plaintext range from the backend and decrypts them.
+func (s *Storage) readRange(ctx context.Context, objectPath string, p *params, cipherSize, offset, length int64) (io.ReadCloser, error) {
+	plainSize := p.plainSize(cipherSize)
+	if offset >= plainSize || length == 0 {
+		return http.NoBody, nil
+	}
+	if length < 0 || offset+length > plainSize {
+		length = plainSize - offset
+	}
+	first := offset / p.chunkSize
+	last := (offset + length - 1) / p.chunkSize
+	r, err := s.Storage.GetObjectRange(ctx, objectPath, first*p.cipherChunkSize(), (last-first+1)*p.cipherChunkSize())
+	if err != nil {
+		return nil, err
+	}
+	d := p.decrypter(r, first, length, cipherSize)
+	d.skip = offset - first*p.chunkSize
+	return d, nil
+}
+
+func (p *params) decrypter(r io.ReadCloser, index, length, cipherSize int64) *decrypter {
+	return &decrypter{
+		ReadCloser: r,
+		params:     p,
+		index:      index,
+		last:       p.chunks(cipherSize) - 1,
+		remaining:  length,
+		buf:        make([]byte, p.cipherChunkSize()),
+	}
+}
+
+type decrypter struct {
+	io.ReadCloser
+	*params
+	index     int64
+	last      int64
+	skip      int64
+	remaining int64
+	buf       []byte
+	plain     []byte
+}
+
+func (d *decrypter) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.remaining == 0 || d.index > d.last {
+			return 0, io.EOF
+		}
+		// only the last chunk can be shorter than the chunk size.
+		n, err := io.ReadFull(d.ReadCloser, d.buf)
+		if err != nil && (d.index != d.last || !errors.Is(err, io.ErrUnexpectedEOF)) {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return 0, errors.New("encryption: object is truncated")
+			}
+			return 0, err
+		}
+		plain, err := d.aead.Open(d.buf[:0], nonce(d.noncePrefix, d.index, d.index == d.last), d.buf[:n], nil)
+		if err != nil {
+			return 0, fmt.Errorf("encryption: failed to decrypt chunk %d: %w", d.index, err)
+		}
+		d.index++
+		if d.skip > 0 {
+			plain = plain[min(d.skip, int64(len(plain))):]
+			d.skip = 0
+		}
+		if d.remaining >= 0 && int64(len(plain)) > d.remaining {
+			plain = plain[:d.remaining]
+		}
+		d.plain = plain
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	if d.remaining > 0 {
+		d.remaining -= int64(n)
+	}
+	return n, nil
+}
+
+func (s *Storage) Open(name string) (fs.File, error) {
+	return s.GetObject(context.Background(), name)
+}
+
+// PutObject encrypts obj with a new data key. Content-Length and Content-MD5 of headers are removed,
+// because they describe the plaintext.
+func (s *Storage) PutObject(ctx context.Context, objectPath string, obj io.Reader, headers http.Header, metadata map[string]string) error {
+	dataKey := make([]byte, dataKeySize)
+	noncePrefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+	if _, err := rand.Read(noncePrefix); err != nil {
+		return err
+	}
+	wrapped, err := s.wrapKey(dataKey)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+	if headers != nil {
+		headers = headers.Clone()
+		headers.Del("Content-Length")
+		headers.Del("Content-MD5")
+	}
+	meta := make(map[string]string, len(metadata)+5)
+	for k, v := range metadata {
+		meta[k] = v
+	}
+	meta[MetadataAlgorithm] = AlgorithmAES256GCMStream
+	meta[MetadataKeyID] = s.keyID
+	meta[MetadataKey] = wrapped
+	meta[MetadataNonce] = base64.StdEncoding.EncodeToString(noncePrefix)
+	meta[MetadataChunkSize] = strconv.Itoa(s.chunkSize)
+	e := &encrypter{
+		r:      bufio.NewReaderSize(obj, s.chunkSize),
+		params: &params{aead: aead, noncePrefix: noncePrefix, chunkSize: int64(s.chunkSize)},
+		buf:    make([]byte, s.chunkSize, s.chunkSize+aead.Overhead()),
+	}
+	return s.Storage.PutObject(ctx, objectPath, e, headers, meta)
+}
+
+type encrypter struct {
+	*params
+	r      *bufio.Reader
+	index  int64
+	buf    []byte
+	sealed []byte
+	done   bool
+}
+
+func (e *encrypter) Read(p []byte) (int, error) {
+	for len(e.sealed) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(e.r, e.buf[:e.chunkSize])
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, err
+		}
+		if err == nil {
+			// the chunk is the last one if nothing follows it.
+			if _, err = e.r.Peek(1); err != nil && !errors.Is(err, io.EOF) {
+				return 0, err
+			}
+		}
+		e.done = err != nil
+		e.sealed = e.aead.Seal(e.buf[:0], nonce(e.noncePrefix, e.index, e.done), e.buf[:n], nil)
+		e.index++
+	}
+	n := copy(p, e.sealed)
+	e.sealed = e.sealed[n:]
+	return n, nil
+}
@@ -0,0 +1,150 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/MicroOps-cn/fuck/clients/storage"
+	fsstorage "github.com/MicroOps-cn/fuck/clients/storage/fs"
+	"github.com/MicroOps-cn/fuck/safe"
+)
+
+func newBackend(t *testing.T) storage.Storage {
+	backend, err := fsstorage.NewClient(context.Background(), nil, storage.NewMapConfigProvider(map[string]interface{}{"type": "in-memory"}))
+	require.NoError(t, err)
+	return backend
+}
+
+// rawObject returns the content and the metadata of the object in the backend.
+func rawObject(t *testing.T, backend storage.Storage, objectPath string) ([]byte, map[string]string) {
+	r, err := backend.GetObject(context.Background(), objectPath)
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return data, r.Metadata
+}
+
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+	backend := newBackend(t)
+	s, err := New(backend, Options{
+		Keys:      map[string]safe.String{"k1": *safe.NewEncryptedString("0123456789abcdef", "")},
+		ChunkSize: 16,
+	})
+	require.NoError(t, err)
+
+	for _, size := range []int{0, 1, 16, 17, 47, 48, 100} {
+		data := []byte(strings.Repeat("0123456789", 10)[:size])
+		require.NoError(t, s.PutObject(ctx, "a.txt", bytes.NewReader(data), nil, map[string]string{"owner": "test"}))
+		raw, meta := rawObject(t, backend, "a.txt")
+		require.NotContains(t, string(raw), "0123456789")
+		require.Equal(t, "k1", meta[MetadataKeyID])
+
+		head, err := s.HeadObject(ctx, "a.txt")
+		require.NoError(t, err)
+		require.Equal(t, int64(size), head.Size)
+		require.Equal(t, map[string]string{"owner": "test"}, head.Metadata)
+
+		r, err := s.GetObject(ctx, "a.txt")
+		require.NoError(t, err)
+		got, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, data, got)
+		require.Equal(t, int64(size), r.Size)
+
+		for _, rng := range [][2]int64{{0, 1}, {3, 20}, {15, 2}, {16, -1}, {40, 100}} {
+			r, err = s.GetObjectRange(ctx, "a.txt", rng[0], rng[1])
+			require.NoError(t, err)
+			got, err = io.ReadAll(r)
+			require.NoError(t, err)
+			expected := data[min(int(rng[0]), size):]
+			if rng[1] >= 0 && int(rng[1]) < len(expected) {
+				expected = expected[:rng[1]]
+			}
+			require.Equal(t, expected, got, "size=%d range=%v", size, rng)
+		}
+	}
+
+	r, err := s.GetObject(ctx, "a.txt")
+	require.NoError(t, err)
+	_, err = r.Seek(55, io.SeekStart)
+	require.NoError(t, err)
+	buf := make([]byte, 10)
+	_, err = io.ReadFull(r, buf)
+	require.NoError(t, err)
+	require.Equal(t, "5678901234", string(buf))
+
+	// the objects encrypted with the rotated key can still be read.
+	s, err = New(backend, Options{
+		Keys: map[string]safe.String{
+			"k1": *safe.NewEncryptedString("0123456789abcdef", ""),
+			"k2": *safe.NewEncryptedString("fedcba9876543210", ""),
+		},
+		KeyID: "k2",
+	})
+	require.NoError(t, err)
+	r, err = s.GetObject(ctx, "a.txt")
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Len(t, got, 100)
+	require.NoError(t, s.PutObject(ctx, "b.txt", strings.NewReader("hello"), nil, nil))
+	_, meta := rawObject(t, backend, "b.txt")
+	require.Equal(t, "k2", meta[MetadataKeyID])
+
+	// the plain objects are returned as is.
+	require.NoError(t, backend.PutObject(ctx, "c.txt", strings.NewReader("plain"), nil, nil))
+	r, err = s.GetObject(ctx, "c.txt")
+	require.NoError(t, err)
+	got, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "plain", string(got))
+
+	// the tampered or truncated objects fail to decrypt.
+	raw, meta := rawObject(t, backend, "a.txt")
+	raw[20] ^= 1
+	require.NoError(t, backend.PutObject(ctx, "a.txt", bytes.NewReader(raw), nil, meta))
+	r, err = s.GetObject(ctx, "a.txt")
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	require.Error(t, err)
+	raw, meta = rawObject(t, backend, "b.txt")
+	require.NoError(t, backend.PutObject(ctx, "b.txt", bytes.NewReader(raw[:18]), nil, meta))
+	r, err = s.GetObject(ctx, "b.txt")
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	require.Error(t, err)
+
+	// the chunk size in the metadata is bounded.
+	raw, meta = rawObject(t, backend, "b.txt")
+	meta[MetadataChunkSize] = strconv.Itoa(1 << 40)
+	require.NoError(t, backend.PutObject(ctx, "b.txt", bytes.NewReader(raw), nil, meta))
+	_, err = s.GetObject(ctx, "b.txt")
+	require.ErrorContains(t, err, "invalid chunk size")
+	_, err = New(backend, Options{Keys: map[string]safe.String{"k1": *safe.NewEncryptedString("0123456789abcdef", "")}, ChunkSize: MaxChunkSize + 1})
+	require.Error(t, err)
+}
+
+func TestKeyID(t *testing.T) {
+	const key = "master key"
+	id := KeyID(key)
+	require.Len(t, id, 16)
+	require.Equal(t, id, KeyID(key))
+	require.NotEqual(t, id, KeyID("another key"))
+
+	kek, err := deriveKEK(key)
+	require.NoError(t, err)
+	require.Len(t, kek, 32)
+	for _, derived := range [][]byte{kek, safe.NewHash(sha256.New, []byte(key))} {
+		require.False(t, strings.HasPrefix(hex.EncodeToString(derived), id))
+	}
+}
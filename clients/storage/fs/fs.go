@@ -4,11 +4,14 @@ import (
 	"archive/zip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -18,26 +21,45 @@ import (
 
 	"github.com/MicroOps-cn/fuck/clients/storage"
 	"github.com/MicroOps-cn/fuck/log"
+	"github.com/MicroOps-cn/fuck/safe"
 )
 
 type Options struct {
-	Base string `json:"base,omitempty" yaml:"base,omitempty"`
-	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+	Base          string      `json:"base,omitempty" yaml:"base,omitempty"`
+	Type          string      `json:"type,omitempty" yaml:"type,omitempty"`
+	Mode          string      `json:"mode,omitempty" yaml:"mode,omitempty"`
+	PresignURL    string      `json:"presign_url,omitempty" yaml:"presign_url,omitempty"`
+	PresignSecret safe.String `json:"presign_secret,omitempty" yaml:"presign_secret,omitempty"`
 }
 
 type Client struct {
-	fs     fs.FS
-	fsType string
-	o      Options
+	fs fs.FS
+	// afs is the writable file system of fs, it is nil if fs is read-only.
+	afs    afero.Fs
+	closer io.Closer
+	locks  *objectLocks
+	// etags caches the ETags of the read-only fs, it is nil if fs is writable.
+	etags *etagCache
+	// localDir is the directory of the local storage in the OS file system, it is empty for the other storages.
+	localDir      string
+	fsType        string
+	o             Options
+	presignURL    *url.URL
+	presignSecret []byte
 }
 
+// ReadDir lists the directory as storage.ReadDir does, except that an existing directory without any object under it
+// is empty rather than not found.
 func (c Client) ReadDir(name string) ([]fs.DirEntry, error) {
-	if strings.HasPrefix(name, "file://") {
-		name = strings.TrimPrefix(name, "file://")
-	} else if strings.HasPrefix(name, "local://") {
-		name = strings.TrimPrefix(name, "local://")
+	entries, err := storage.ReadDir(context.Background(), c, resolveObjectPath(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		if dir, cleanErr := cleanObjectPath(name); cleanErr == nil {
+			if info, statErr := fs.Stat(c.fs, dir); statErr == nil && info.IsDir() {
+				return []fs.DirEntry{}, nil
+			}
+		}
 	}
-	return fs.ReadDir(c.fs, name)
+	return entries, err
 }
 
 func (c Client) Open(name string) (fs.File, error) {
@@ -51,96 +73,248 @@ func (c Client) MarshalJSON() ([]byte, error) {
 func (c Client) Name() string {
 	return fmt.Sprintf("%s://%s", c.Type(), c.o.Base)
 }
-func (c Client) GetObject(_ context.Context, objectPath string) (*storage.ObjectReader, error) {
-	if strings.HasPrefix(objectPath, "file://") {
-		objectPath = strings.TrimPrefix(objectPath, "file://")
-	} else if strings.HasPrefix(objectPath, "local://") {
-		objectPath = strings.TrimPrefix(objectPath, "local://")
-	}
-	f, err := c.fs.Open(objectPath)
+func (c Client) GetObject(ctx context.Context, objectPath string) (*storage.ObjectReader, error) {
+	return c.GetObjectRange(ctx, objectPath, 0, -1)
+}
+
+func (c Client) GetObjectRange(ctx context.Context, objectPath string, offset, length int64) (*storage.ObjectReader, error) {
+	objectPath = resolveObjectPath(objectPath)
+	f, err := c.openRange(objectPath, offset, length)
 	if err != nil {
 		return nil, err
 	}
 	stat, err := f.Stat()
 	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	obj, err := c.newObject(objectPath, stat, true)
+	if err != nil {
+		f.Close()
 		return nil, err
 	}
+	if cond, ok := storage.ConditionFromContext(ctx); ok {
+		if err = cond.CheckRead(obj); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
 	return &storage.ObjectReader{
 		ReadCloser: f,
-		Object: storage.Object{
-			LastModified: stat.ModTime(),
-			Size:         stat.Size(),
-			StorageClass: c.Type(),
-			Metadata:     map[string]string{},
-			Mode:         stat.Mode(),
-			Headers: map[string][]string{
-				"Content-Length": {strconv.FormatInt(stat.Size(), 10)},
-				"Last-Modified":  {time.FormatDateTime(stat.ModTime())},
-			},
+		Offset:     offset,
+		ReadRange: func(offset, length int64) (io.ReadCloser, error) {
+			return c.openRange(objectPath, offset, length)
 		},
+		Object: *obj,
 	}, nil
 }
 
+// newObject returns the object of the file, with the headers and metadata in its sidecar file. If cacheETag is true,
+// the ETag calculated from the content is saved to the sidecar, see Client.etag.
+func (c Client) newObject(name string, stat fs.FileInfo, cacheETag bool) (*storage.Object, error) {
+	meta, err := c.readMetadata(name)
+	if err != nil {
+		return nil, err
+	}
+	etag, err := c.etag(name, stat, meta, cacheETag)
+	if err != nil {
+		return nil, err
+	}
+	headers := http.Header{}
+	for key, values := range meta.Headers {
+		headers[key] = values
+	}
+	headers.Set("Content-Length", strconv.FormatInt(stat.Size(), 10))
+	headers.Set("Last-Modified", time.FormatDateTime(stat.ModTime()))
+	if len(etag) != 0 {
+		headers.Set("ETag", `"`+etag+`"`)
+	}
+	metadata := meta.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	return &storage.Object{
+		Key:          name,
+		LastModified: stat.ModTime(),
+		Size:         stat.Size(),
+		ETag:         etag,
+		StorageClass: c.Type(),
+		Metadata:     metadata,
+		Mode:         stat.Mode(),
+		Headers:      headers,
+	}, nil
+}
+
+// rangeFile is a file limited to a range of its content, it hides the Seek and ReadAt of the file,
+// so that they are served by storage.ObjectReader over the whole file.
+type rangeFile struct {
+	io.Reader
+	fs.File
+}
+
+func (f rangeFile) Read(p []byte) (int, error) {
+	return f.Reader.Read(p)
+}
+
+func (c Client) openRange(name string, offset, length int64) (fs.File, error) {
+	f, err := c.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		if seeker, ok := f.(io.Seeker); ok {
+			_, err = seeker.Seek(offset, io.SeekStart)
+		} else {
+			_, err = io.CopyN(io.Discard, f, offset)
+		}
+		if err != nil && err != io.EOF {
+			f.Close()
+			return nil, err
+		}
+	}
+	if offset == 0 && length < 0 {
+		return f, nil
+	}
+	var r io.Reader = f
+	if length >= 0 {
+		r = io.LimitReader(f, length)
+	}
+	return rangeFile{Reader: r, File: f}, nil
+}
+
 type OpenFileFS interface {
 	OpenFile(name string, flag int, perm os.FileMode) (*os.File, error)
 }
 
+// PutObject writes the object to a temp file and renames it to objectPath, the parent directories are created
+// if they do not exist. The headers and metadata are saved in a sidecar file. The writes of the same object are
+// serialized by a lock, so the Condition of ctx is checked atomically with the write.
 func (c Client) PutObject(ctx context.Context, objectPath string, obj io.Reader, headers http.Header, metadata map[string]string) error {
-	if strings.HasPrefix(objectPath, "file://") {
-		objectPath = strings.TrimPrefix(objectPath, "file://")
-	} else if strings.HasPrefix(objectPath, "local://") {
-		objectPath = strings.TrimPrefix(objectPath, "local://")
+	if c.afs != nil {
+		name, err := cleanObjectPath(objectPath)
+		if err != nil {
+			return err
+		}
+		unlock, err := c.lockObject(name)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		if cond, ok := storage.ConditionFromContext(ctx); ok {
+			// the ETag is not cached here, which would take the lock of the object again.
+			current, err := c.headObject(name, false)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			if err = cond.CheckWrite(name, current); err != nil {
+				return err
+			}
+		}
+		return c.putObject(name, obj, headers, metadata)
 	}
+	objectPath = resolveObjectPath(objectPath)
 	if ofs, ok := c.fs.(OpenFileFS); ok {
 		w, err := ofs.OpenFile(objectPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 		if err != nil {
 			return err
 		}
+		defer w.Close()
 		_, err = io.Copy(w, obj)
 		return err
 	}
 	return fmt.Errorf("not support put object")
 }
 
-func (c Client) HeadObject(ctx context.Context, objectPath string) (obj *storage.Object, err error) {
-	if strings.HasPrefix(objectPath, "file://") {
-		objectPath = strings.TrimPrefix(objectPath, "file://")
-	} else if strings.HasPrefix(objectPath, "local://") {
-		objectPath = strings.TrimPrefix(objectPath, "local://")
+type RemoveFS interface {
+	Remove(name string) error
+}
+
+// DeleteObject removes the object and its sidecar file under the lock of the object, as PutObject writes them.
+func (c Client) DeleteObject(_ context.Context, objectPath string) error {
+	name, err := cleanObjectPath(objectPath)
+	if err != nil {
+		return err
+	}
+	var rfs RemoveFS = c.afs
+	if c.afs == nil {
+		var ok bool
+		if rfs, ok = c.fs.(RemoveFS); !ok {
+			return fmt.Errorf("not support delete object")
+		}
+	} else {
+		unlock, err := c.lockObject(name)
+		if err != nil {
+			return err
+		}
+		defer unlock()
 	}
-	stat, err := os.Stat(objectPath)
+	if err = rfs.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err = rfs.Remove(metadataPath(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (c Client) DeleteObjects(ctx context.Context, objectPaths []string) error {
+	var errs []error
+	for _, objectPath := range objectPaths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := c.DeleteObject(ctx, objectPath); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete object %s: %w", objectPath, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c Client) CopyObject(ctx context.Context, srcPath, dstPath string) error {
+	if resolveObjectPath(srcPath) == resolveObjectPath(dstPath) {
+		_, err := c.HeadObject(ctx, srcPath)
+		return err
+	}
+	src, err := c.GetObject(ctx, srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return c.PutObject(ctx, dstPath, src, src.Headers, src.Metadata)
+}
+
+func (c Client) HeadObject(_ context.Context, objectPath string) (obj *storage.Object, err error) {
+	return c.headObject(resolveObjectPath(objectPath), true)
+}
+
+func (c Client) headObject(name string, cacheETag bool) (*storage.Object, error) {
+	stat, err := fs.Stat(c.fs, name)
 	if err != nil {
 		return nil, err
 	}
-	return &storage.Object{
-		Key:          objectPath,
-		LastModified: stat.ModTime(),
-		Size:         stat.Size(),
-		StorageClass: c.Type(),
-		Metadata:     map[string]string{},
-		Mode:         stat.Mode(),
-		Headers: map[string][]string{
-			"Content-Length": {strconv.FormatInt(stat.Size(), 64)},
-			"Last-Modified":  {time.FormatDateTime(stat.ModTime())},
-		},
-	}, nil
+	return c.newObject(name, stat, cacheETag)
 }
 
 func (c Client) ListObject(ctx context.Context, objectPrefix string, recursion bool, callback func(key storage.Object)) error {
 	logger := log.GetContextLogger(ctx)
-	if strings.HasPrefix(objectPrefix, "file://") {
-		objectPrefix = strings.TrimPrefix(objectPrefix, "file://")
-	} else if strings.HasPrefix(objectPrefix, "local://") {
-		objectPrefix = strings.TrimPrefix(objectPrefix, "local://")
+	objectPrefix = strings.Trim(resolveObjectPath(objectPrefix), "/")
+	if len(objectPrefix) == 0 {
+		objectPrefix = "."
 	}
 	return fs.WalkDir(c.fs, objectPrefix, func(path string, info fs.DirEntry, err error) error {
 		if err != nil {
+			if path == objectPrefix && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
 			return err
 		}
 		if info.IsDir() {
-			if path == "." {
+			if path == objectPrefix {
 				return nil
 			}
+			if path == metadataDir {
+				return fs.SkipDir
+			}
 			if !recursion {
 				return fs.SkipDir
 			}
@@ -160,6 +334,7 @@ func (c Client) ListObject(ctx context.Context, objectPrefix string, recursion b
 			} else {
 				o.LastModified = fileInfo.ModTime()
 				o.Size = fileInfo.Size()
+				o.ETag = c.cachedETag(path, fileInfo)
 			}
 			callback(o)
 		}
@@ -167,6 +342,222 @@ func (c Client) ListObject(ctx context.Context, objectPrefix string, recursion b
 	})
 }
 
+// ListObjects emulates the listing of object storage: the keys are the slash-separated paths of the files, and with
+// a delimiter, the keys that contain it after the prefix are grouped into common prefixes. The directories without
+// files, such as those left by DeleteObject, are not listed. The continuation token is the last key of the previous
+// page, the directories before it are skipped instead of walked.
+func (c Client) ListObjects(ctx context.Context, o storage.ListOptions, callback func(page *storage.ListPage) error) error {
+	l := &lister{
+		c:          c,
+		ctx:        ctx,
+		prefix:     resolveObjectPath(o.Prefix),
+		delimiter:  o.Delimiter,
+		startAfter: o.StartAfter,
+		pageSize:   o.PageSize(),
+		callback:   callback,
+	}
+	if len(o.ContinuationToken) != 0 {
+		l.startAfter = o.ContinuationToken
+	}
+	root := "."
+	if i := strings.LastIndex(l.prefix, "/"); i > 0 {
+		root = l.prefix[:i]
+	}
+	info, err := fs.Stat(c.fs, root)
+	if err == nil && info.IsDir() {
+		err = l.walk(root)
+	} else if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+	if err == nil {
+		err = l.flush("")
+	}
+	if errors.Is(err, fs.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+// lister walks the files in the lexicographical order of their keys, and calls the callback of ListObjects page by
+// page.
+type lister struct {
+	c          Client
+	ctx        context.Context
+	prefix     string
+	delimiter  string
+	startAfter string
+	pageSize   int
+	callback   func(page *storage.ListPage) error
+
+	page storage.ListPage
+	size int
+	// last is the key or the common prefix last added to the pages.
+	last string
+}
+
+// entryKey returns the key of the entry of dir, the key of a directory ends with "/", so that it is ordered after
+// the files whose names extend the name of the directory, such as "a-b" < "a/".
+func entryKey(dir string, d fs.DirEntry) string {
+	key := d.Name()
+	if dir != "." {
+		key = dir + "/" + key
+	}
+	if d.IsDir() {
+		key += "/"
+	}
+	return key
+}
+
+func (l *lister) walk(dir string) error {
+	entries, err := fs.ReadDir(l.c.fs, dir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entryKey(dir, entries[i]) < entryKey(dir, entries[j])
+	})
+	for _, d := range entries {
+		if err = l.ctx.Err(); err != nil {
+			return err
+		}
+		key := entryKey(dir, d)
+		if d.IsDir() {
+			err = l.walkDir(key)
+		} else {
+			err = l.addFile(key, d)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *lister) walkDir(key string) error {
+	name := strings.TrimSuffix(key, "/")
+	switch {
+	case name == metadataDir:
+		return nil
+	case strings.HasPrefix(key, l.prefix):
+	case strings.HasPrefix(l.prefix, key):
+		return l.walk(name)
+	default:
+		return nil
+	}
+	if key <= l.startAfter && !strings.HasPrefix(l.startAfter, key) {
+		// all the keys in the directory are before startAfter.
+		return nil
+	}
+	if commonPrefix, ok := l.commonPrefix(key); ok {
+		if commonPrefix <= l.startAfter || commonPrefix == l.last {
+			return nil
+		}
+		if found, err := l.hasFile(name); err != nil || !found {
+			return err
+		}
+		return l.add(nil, commonPrefix)
+	}
+	return l.walk(name)
+}
+
+func (l *lister) addFile(key string, d fs.DirEntry) error {
+	if !strings.HasPrefix(key, l.prefix) {
+		return nil
+	}
+	if commonPrefix, ok := l.commonPrefix(key); ok {
+		if commonPrefix <= l.startAfter || commonPrefix == l.last {
+			return nil
+		}
+		return l.add(nil, commonPrefix)
+	}
+	if key <= l.startAfter {
+		return nil
+	}
+	obj := &storage.Object{Key: key, StorageClass: l.c.Type()}
+	if info, err := d.Info(); err == nil {
+		obj.LastModified = info.ModTime()
+		obj.Size = info.Size()
+		obj.Mode = info.Mode()
+		obj.ETag = l.c.cachedETag(key, info)
+	}
+	return l.add(obj, "")
+}
+
+// commonPrefix returns the common prefix of key if it contains the delimiter after the prefix.
+func (l *lister) commonPrefix(key string) (string, bool) {
+	if len(l.delimiter) == 0 {
+		return "", false
+	}
+	idx := strings.Index(key[len(l.prefix):], l.delimiter)
+	if idx < 0 {
+		return "", false
+	}
+	return key[:len(l.prefix)+idx+len(l.delimiter)], true
+}
+
+// hasFile reports whether there is a file in dir or its subdirectories.
+func (l *lister) hasFile(dir string) (bool, error) {
+	entries, err := fs.ReadDir(l.c.fs, dir)
+	if err != nil {
+		return false, err
+	}
+	for _, d := range entries {
+		if !d.IsDir() {
+			return true, nil
+		}
+	}
+	for _, d := range entries {
+		if found, err := l.hasFile(dir + "/" + d.Name()); err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+// add adds the object or the common prefix to the page, the page is passed to the callback once it is full and
+// another entry follows it.
+func (l *lister) add(obj *storage.Object, commonPrefix string) error {
+	if l.size == l.pageSize {
+		if err := l.flush(l.last); err != nil {
+			return err
+		}
+	}
+	if obj != nil {
+		l.page.Objects = append(l.page.Objects, *obj)
+		l.last = obj.Key
+	} else {
+		l.page.CommonPrefixes = append(l.page.CommonPrefixes, commonPrefix)
+		l.last = commonPrefix
+	}
+	l.size++
+	return nil
+}
+
+func (l *lister) flush(nextContinuationToken string) error {
+	page := l.page
+	page.NextContinuationToken = nextContinuationToken
+	l.page, l.size = storage.ListPage{}, 0
+	return l.callback(&page)
+}
+
+func resolveObjectPath(objectPath string) string {
+	if strings.HasPrefix(objectPath, "file://") {
+		return strings.TrimPrefix(objectPath, "file://")
+	} else if strings.HasPrefix(objectPath, "local://") {
+		return strings.TrimPrefix(objectPath, "local://")
+	}
+	return objectPath
+}
+
+// Close closes the zip archive of the zip storage, in the write mode the archive is written on Close.
+// It does nothing for the other storages.
+func (c Client) Close() error {
+	if c.closer == nil {
+		return nil
+	}
+	return c.closer.Close()
+}
+
 func (c Client) Type() string {
 	return c.fsType
 }
@@ -174,16 +565,46 @@ func (c Client) Type() string {
 func NewClient(_ context.Context, fs fs.FS, v storage.ConfigProvider) (*Client, error) {
 	var storageType string
 	var base string
+	var mode string
+	var presignURL *url.URL
+	var presignSecret safe.String
+	var err error
 	if v != nil {
 		storageType = v.GetString("type")
 		base = v.GetString("base")
+		mode = v.GetString("mode")
+		if rawURL := v.GetString("presign_url"); len(rawURL) != 0 {
+			if presignURL, err = url.Parse(rawURL); err != nil {
+				return nil, fmt.Errorf("failed to parse presign_url: %s", err)
+			}
+		}
+		if err = presignSecret.SetValue(v.GetString("presign_secret")); err != nil {
+			return nil, fmt.Errorf("failed to parse presign_secret: %s", err)
+		}
 	}
-	var err error
+	secret, err := presignSecret.UnsafeString()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt presign_secret: %s", err)
+	}
+	var closer io.Closer
 	if fs == nil {
 		switch storageType {
 		case "zip":
-			if fs, err = zip.OpenReader(base); err != nil {
-				return nil, err
+			switch mode {
+			case ZipModeWrite:
+				zw, err := newZipWriter(base)
+				if err != nil {
+					return nil, err
+				}
+				fs, closer = afero.NewIOFS(zw.fs), zw
+			case "", ZipModeRead:
+				zr, err := zip.OpenReader(base)
+				if err != nil {
+					return nil, err
+				}
+				fs, closer = zr, zr
+			default:
+				return nil, fmt.Errorf("unknown zip mode: %s", mode)
 			}
 		case "in-memory", "inmemory":
 			fs = afero.NewIOFS(afero.NewMemMapFs())
@@ -199,10 +620,33 @@ func NewClient(_ context.Context, fs fs.FS, v storage.ConfigProvider) (*Client,
 			storageType = "unknown"
 		}
 	}
+	var afs afero.Fs
+	var localDir string
+	if iofs, ok := fs.(afero.IOFS); ok {
+		afs = iofs.Fs
+		if (storageType == "local" || storageType == "file") && len(base) != 0 {
+			localDir = base
+		}
+	}
+	o := Options{Base: base, Type: storageType, Mode: mode, PresignSecret: presignSecret}
+	if presignURL != nil {
+		o.PresignURL = presignURL.String()
+	}
+	var etags *etagCache
+	if afs == nil {
+		etags = &etagCache{}
+	}
 	return &Client{
-		fs:     fs,
-		fsType: storageType,
-		o:      Options{Base: base, Type: storageType},
+		fs:            fs,
+		afs:           afs,
+		closer:        closer,
+		locks:         &objectLocks{},
+		etags:         etags,
+		localDir:      localDir,
+		fsType:        storageType,
+		o:             o,
+		presignURL:    presignURL,
+		presignSecret: []byte(secret),
 	}, nil
 }
 
@@ -1,16 +1,25 @@
 package fs
 
 import (
+	"archive/zip"
 	"context"
+	"io"
 	"io/fs"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"sort"
+	"strings"
 	"testing"
+	"testing/fstest"
+	stdtime "time"
 
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/require"
 
 	"github.com/MicroOps-cn/fuck/clients/storage"
+	"github.com/MicroOps-cn/fuck/errors"
 )
 
 func touchFile(fs afero.Fs, path string) error {
@@ -112,3 +121,396 @@ func TestClient_ListObject(t *testing.T) {
 		})
 	}
 }
+
+func TestClient_DeleteObject(t *testing.T) {
+	temppath, err := os.MkdirTemp("", "test-fs")
+	require.NoError(t, err)
+	defer os.RemoveAll(temppath)
+	tempFS := afero.NewBasePathFs(afero.NewOsFs(), temppath)
+	require.NoError(t, tempFS.MkdirAll("A/B", 0o777))
+	require.NoError(t, touchFile(tempFS, "A/1"))
+	require.NoError(t, touchFile(tempFS, "A/B/2"))
+	require.NoError(t, touchFile(tempFS, "A/B/3"))
+
+	c, err := NewClient(context.Background(), nil, storage.NewMapConfigProvider(map[string]interface{}{
+		"type": "local",
+		"base": temppath,
+	}))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, c.DeleteObject(ctx, "local://A/1"))
+	_, err = c.HeadObject(ctx, "A/1")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	require.NoError(t, c.DeleteObject(ctx, "A/1"))
+	require.NoError(t, c.PutObject(ctx, "A/5", strings.NewReader("5"), nil, nil))
+	require.Error(t, c.DeleteObject(ctx, ".metadata/A/5.json"))
+	require.FileExists(t, filepath.Join(temppath, ".metadata", "A", "5.json"))
+	require.NoError(t, c.DeleteObject(ctx, "/A/../A/5"))
+	require.NoFileExists(t, filepath.Join(temppath, "A", "5"))
+	require.NoFileExists(t, filepath.Join(temppath, ".metadata", "A", "5.json"))
+
+	require.NoError(t, c.DeleteObjects(ctx, []string{"A/B/2", "A/B/3", "A/B/4"}))
+	entries, err := c.ReadDir("A/B")
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	_, err = c.ReadDir("A/missing")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = fs.ReadDir(c, "A/1")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	require.ErrorIs(t, c.CopyObject(ctx, "A/1", "A/2"), fs.ErrNotExist)
+}
+
+func TestClient_GetObjectRange(t *testing.T) {
+	temppath, err := os.MkdirTemp("", "test-fs")
+	require.NoError(t, err)
+	defer os.RemoveAll(temppath)
+	require.NoError(t, os.WriteFile(filepath.Join(temppath, "app.log"), []byte("0123456789abcdefghij"), 0o600))
+
+	c, err := NewClient(context.Background(), nil, storage.NewMapConfigProvider(map[string]interface{}{
+		"type": "local",
+		"base": temppath,
+	}))
+	require.NoError(t, err)
+
+	r, err := c.GetObjectRange(context.Background(), "app.log", 10, 5)
+	require.NoError(t, err)
+	buf, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "abcde", string(buf))
+	require.Equal(t, int64(20), r.Size)
+	_, err = r.Seek(-2, io.SeekEnd)
+	require.NoError(t, err)
+	buf, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "ij", string(buf))
+	require.NoError(t, r.Close())
+
+	r, err = c.GetObject(context.Background(), "app.log")
+	require.NoError(t, err)
+	defer r.Close()
+	req := httptest.NewRequest(http.MethodGet, "/app.log", nil)
+	req.Header.Set("Range", "bytes=2-4")
+	rec := httptest.NewRecorder()
+	http.ServeContent(rec, req, r.Name(), r.LastModified, r)
+	require.Equal(t, http.StatusPartialContent, rec.Code)
+	require.Equal(t, "234", rec.Body.String())
+}
+
+func TestClient_ListObjects(t *testing.T) {
+	temppath := t.TempDir()
+	tempFS := afero.NewBasePathFs(afero.NewOsFs(), temppath)
+	require.NoError(t, tempFS.MkdirAll("logs/2024/01", 0o777))
+	require.NoError(t, tempFS.MkdirAll("logs/empty", 0o777))
+	require.NoError(t, touchFile(tempFS, "logs/2024/01/a.log"))
+	require.NoError(t, touchFile(tempFS, "logs/2024/01/b.log"))
+	require.NoError(t, touchFile(tempFS, "logs/2024.txt"))
+	require.NoError(t, touchFile(tempFS, "logs/2024-12.txt"))
+	require.NoError(t, touchFile(tempFS, "logs/app.log"))
+	require.NoError(t, touchFile(tempFS, "other.log"))
+
+	c, err := NewClient(context.Background(), nil, storage.NewMapConfigProvider(map[string]interface{}{
+		"type": "local",
+		"base": temppath,
+	}))
+	require.NoError(t, err)
+
+	type page struct {
+		Keys           []string
+		CommonPrefixes []string
+		Token          string
+	}
+	list := func(o storage.ListOptions) (pages []page) {
+		require.NoError(t, c.ListObjects(context.Background(), o, func(p *storage.ListPage) error {
+			var keys []string
+			for _, obj := range p.Objects {
+				keys = append(keys, obj.Key)
+			}
+			pages = append(pages, page{Keys: keys, CommonPrefixes: p.CommonPrefixes, Token: p.NextContinuationToken})
+			return nil
+		}))
+		return pages
+	}
+
+	require.Equal(t, []page{
+		{Keys: []string{"logs/2024-12.txt", "logs/2024.txt"}, Token: "logs/2024.txt"},
+		{Keys: []string{"logs/app.log"}, CommonPrefixes: []string{"logs/2024/"}},
+	}, list(storage.ListOptions{Prefix: "logs/", Delimiter: "/", MaxKeys: 2}))
+	require.Equal(t, []page{
+		{Keys: []string{"logs/2024/01/a.log", "logs/2024/01/b.log", "logs/app.log"}},
+	}, list(storage.ListOptions{Prefix: "logs/", StartAfter: "logs/2024.txt"}))
+	require.Equal(t, []page{
+		{Keys: []string{"logs/2024/01/b.log", "logs/app.log"}},
+	}, list(storage.ListOptions{Prefix: "logs/", StartAfter: "logs/2024/01/a.log"}))
+	require.Equal(t, []page{
+		{Keys: []string{"logs/app.log"}},
+	}, list(storage.ListOptions{Prefix: "logs/", Delimiter: "/", ContinuationToken: "logs/2024/"}))
+	require.Equal(t, []page{
+		{Keys: []string{"logs/2024.txt", "logs/2024/01/a.log", "logs/2024/01/b.log", "logs/app.log"}, CommonPrefixes: []string{"logs/2024-"}},
+	}, list(storage.ListOptions{Prefix: "logs/", Delimiter: "-"}))
+	var pages int
+	require.NoError(t, c.ListObjects(context.Background(), storage.ListOptions{MaxKeys: 1}, func(p *storage.ListPage) error {
+		pages++
+		return fs.SkipAll
+	}))
+	require.Equal(t, 1, pages)
+
+	entries, err := c.ReadDir("logs")
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+		require.Equal(t, entry.Name() == "2024", entry.IsDir())
+	}
+	require.Equal(t, []string{"2024", "2024-12.txt", "2024.txt", "app.log"}, names)
+}
+
+func TestClient_PutObject(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewClient(ctx, nil, storage.NewMapConfigProvider(map[string]interface{}{
+		"type": "in-memory",
+	}))
+	require.NoError(t, err)
+
+	headers := http.Header{"Content-Type": {"text/plain"}, "Content-Length": {"5"}}
+	require.NoError(t, c.PutObject(ctx, "a/b/c.txt", strings.NewReader("hello"), headers, map[string]string{"owner": "test"}))
+	require.NoError(t, c.PutObject(ctx, "/d.txt", strings.NewReader("world"), nil, nil))
+	require.Error(t, c.PutObject(ctx, ".metadata/d.txt", strings.NewReader("world"), nil, nil))
+
+	r, err := c.GetObject(ctx, "a/b/c.txt")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, "hello", string(data))
+	require.Equal(t, "text/plain", r.Headers.Get("Content-Type"))
+	require.Equal(t, map[string]string{"owner": "test"}, r.Metadata)
+
+	var keys []string
+	require.NoError(t, c.ListObject(ctx, "", true, func(obj storage.Object) {
+		keys = append(keys, obj.Key)
+	}))
+	sort.Strings(keys)
+	require.Equal(t, []string{"a/b/c.txt", "d.txt"}, keys)
+
+	require.NoError(t, c.CopyObject(ctx, "a/b/c.txt", "e.txt"))
+	head, err := c.HeadObject(ctx, "e.txt")
+	require.NoError(t, err)
+	require.Equal(t, "text/plain", head.Headers.Get("Content-Type"))
+	require.Equal(t, map[string]string{"owner": "test"}, head.Metadata)
+
+	require.NoError(t, c.DeleteObject(ctx, "a/b/c.txt"))
+	_, err = c.HeadObject(ctx, "a/b/c.txt")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	require.NoError(t, c.PutObject(ctx, "a/b/c.txt", strings.NewReader("again"), nil, nil))
+	head, err = c.HeadObject(ctx, "a/b/c.txt")
+	require.NoError(t, err)
+	require.Empty(t, head.Metadata)
+}
+
+func TestClient_ZipWriter(t *testing.T) {
+	ctx := context.Background()
+	base := filepath.Join(t.TempDir(), "bundle.zip")
+	w, err := NewClient(ctx, nil, storage.NewMapConfigProvider(map[string]interface{}{
+		"type": "zip",
+		"base": base,
+		"mode": ZipModeWrite,
+	}))
+	require.NoError(t, err)
+	require.NoError(t, w.PutObject(ctx, "manifest.json", strings.NewReader("{}"), http.Header{"Content-Type": {"application/json"}}, nil))
+	require.NoError(t, w.PutObject(ctx, "assets/app.js", strings.NewReader("console.log(1)"), nil, nil))
+	_, err = os.Stat(base)
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	require.NoError(t, w.Close())
+	require.NoError(t, w.Close())
+
+	r, err := NewClient(ctx, nil, storage.NewMapConfigProvider(map[string]interface{}{
+		"type": "zip",
+		"base": base,
+	}))
+	require.NoError(t, err)
+	defer r.Close()
+	obj, err := r.GetObject(ctx, "assets/app.js")
+	require.NoError(t, err)
+	data, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	require.Equal(t, "console.log(1)", string(data))
+	zr, err := zip.OpenReader(base)
+	require.NoError(t, err)
+	defer zr.Close()
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	require.ElementsMatch(t, []string{"manifest.json", "assets/app.js"}, names)
+	entries, err := r.ReadDir(".")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Error(t, r.PutObject(ctx, "a.txt", strings.NewReader("a"), nil, nil))
+}
+
+func TestClient_ETag(t *testing.T) {
+	ctx := context.Background()
+	temppath := t.TempDir()
+	c, err := NewClient(ctx, nil, storage.NewMapConfigProvider(map[string]interface{}{
+		"type": "local",
+		"base": temppath,
+	}))
+	require.NoError(t, err)
+
+	require.NoError(t, c.PutObject(ctx, "a.txt", strings.NewReader("hello"), nil, nil))
+	head, err := c.HeadObject(ctx, "a.txt")
+	require.NoError(t, err)
+	require.Equal(t, "5d41402abc4b2a76b9719d911017c592", head.ETag)
+	require.Equal(t, `"5d41402abc4b2a76b9719d911017c592"`, head.Headers.Get("ETag"))
+
+	// the ETag of the files written outside of the storage is calculated and cached.
+	require.NoError(t, os.WriteFile(filepath.Join(temppath, "b.txt"), []byte("world"), 0o600))
+	var etags []string
+	require.NoError(t, c.ListObject(ctx, "", true, func(obj storage.Object) {
+		etags = append(etags, obj.Key+":"+obj.ETag)
+	}))
+	sort.Strings(etags)
+	require.Equal(t, []string{"a.txt:5d41402abc4b2a76b9719d911017c592", "b.txt:"}, etags)
+	head, err = c.HeadObject(ctx, "b.txt")
+	require.NoError(t, err)
+	require.Equal(t, "7d793037a0760186574b0282f2f435e7", head.ETag)
+	require.NoError(t, c.ListObjects(ctx, storage.ListOptions{Prefix: "b"}, func(page *storage.ListPage) error {
+		require.Len(t, page.Objects, 1)
+		require.Equal(t, "7d793037a0760186574b0282f2f435e7", page.Objects[0].ETag)
+		return nil
+	}))
+
+	require.NoError(t, os.WriteFile(filepath.Join(temppath, "b.txt"), []byte("hello world"), 0o600))
+	head, err = c.HeadObject(ctx, "b.txt")
+	require.NoError(t, err)
+	require.Equal(t, "5eb63bbbe01eeed093cb22bb8f5acdc3", head.ETag)
+
+	// the ETag calculated by a read before a concurrent write does not overwrite the sidecar of the write.
+	stale, err := os.Stat(filepath.Join(temppath, "b.txt"))
+	require.NoError(t, err)
+	require.NoError(t, c.PutObject(ctx, "b.txt", strings.NewReader("new"), http.Header{"Content-Type": {"text/plain"}}, map[string]string{"k": "v"}))
+	require.NoError(t, c.cacheETag("b.txt", "stale", stale))
+	head, err = c.HeadObject(ctx, "b.txt")
+	require.NoError(t, err)
+	require.Equal(t, "22af645d1859cb5ca6da0c484f1f37ea", head.ETag)
+	require.Equal(t, "text/plain", head.Headers.Get("Content-Type"))
+	require.Equal(t, map[string]string{"k": "v"}, head.Metadata)
+}
+
+// countingFS counts the reads of the content of the files.
+type countingFS struct {
+	fs.FS
+	reads int
+}
+
+type countingFile struct {
+	fs.File
+	fs *countingFS
+}
+
+func (c *countingFS) Open(name string) (fs.File, error) {
+	f, err := c.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return countingFile{File: f, fs: c}, nil
+}
+
+func (f countingFile) Read(p []byte) (int, error) {
+	f.fs.reads++
+	return f.File.Read(p)
+}
+
+func TestClient_ETag_ReadOnly(t *testing.T) {
+	ctx := context.Background()
+	modTime := stdtime.Now()
+	files := fstest.MapFS{"a.txt": {Data: []byte("hello"), ModTime: modTime}}
+	counting := &countingFS{FS: files}
+	c, err := NewClient(ctx, counting, nil)
+	require.NoError(t, err)
+
+	// the ETag of a read-only file system is calculated once and kept in memory.
+	for i := 0; i < 3; i++ {
+		head, err := c.HeadObject(ctx, "a.txt")
+		require.NoError(t, err)
+		require.Equal(t, "5d41402abc4b2a76b9719d911017c592", head.ETag)
+	}
+	require.Equal(t, 2, counting.reads)
+
+	files["a.txt"] = &fstest.MapFile{Data: []byte("world"), ModTime: modTime.Add(stdtime.Second)}
+	head, err := c.HeadObject(ctx, "a.txt")
+	require.NoError(t, err)
+	require.Equal(t, "7d793037a0760186574b0282f2f435e7", head.ETag)
+}
+
+func TestClient_Condition(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewClient(ctx, nil, storage.NewMapConfigProvider(map[string]interface{}{
+		"type": "local",
+		"base": t.TempDir(),
+	}))
+	require.NoError(t, err)
+
+	create := storage.WithCondition(ctx, storage.Condition{IfNoneMatch: "*"})
+	require.NoError(t, c.PutObject(create, "a.txt", strings.NewReader("hello"), nil, nil))
+	err = c.PutObject(create, "a.txt", strings.NewReader("world"), nil, nil)
+	require.True(t, errors.IsPreconditionFailed(err))
+
+	const etag = "5d41402abc4b2a76b9719d911017c592"
+	_, err = c.GetObject(storage.WithCondition(ctx, storage.Condition{IfNoneMatch: etag}), "a.txt")
+	require.True(t, errors.IsPreconditionFailed(err))
+	r, err := c.GetObject(storage.WithCondition(ctx, storage.Condition{IfMatch: `"` + etag + `"`}), "a.txt")
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	update := storage.WithCondition(ctx, storage.Condition{IfMatch: etag})
+	require.NoError(t, c.PutObject(update, "a.txt", strings.NewReader("world"), nil, nil))
+	err = c.PutObject(update, "a.txt", strings.NewReader("again"), nil, nil)
+	require.True(t, errors.IsPreconditionFailed(err))
+	err = c.PutObject(update, "b.txt", strings.NewReader("new"), nil, nil)
+	require.True(t, errors.IsPreconditionFailed(err))
+
+	r, err = c.GetObject(ctx, "a.txt")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, "world", string(data))
+}
+
+func TestClient_Watch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	temppath := t.TempDir()
+	c, err := NewClient(ctx, nil, storage.NewMapConfigProvider(map[string]interface{}{
+		"type": "local",
+		"base": temppath,
+	}))
+	require.NoError(t, err)
+	require.NoError(t, c.PutObject(ctx, "in/old.txt", strings.NewReader("old"), nil, nil))
+
+	events, err := storage.Watch(ctx, c, "in/", storage.WatchOptions{})
+	require.NoError(t, err)
+	receive := func() string {
+		select {
+		case event := <-events:
+			return string(event.Type) + ":" + event.Object.Key
+		case <-stdtime.After(5 * stdtime.Second):
+			require.FailNow(t, "timed out waiting for event")
+			return ""
+		}
+	}
+
+	require.NoError(t, c.PutObject(ctx, "in/a.txt", strings.NewReader("a"), nil, nil))
+	require.Equal(t, "created:in/a.txt", receive())
+	require.NoError(t, c.PutObject(ctx, "in/old.txt", strings.NewReader("new"), nil, nil))
+	require.Equal(t, "updated:in/old.txt", receive())
+	require.NoError(t, c.PutObject(ctx, "out/b.txt", strings.NewReader("b"), nil, nil))
+	require.NoError(t, os.MkdirAll(filepath.Join(temppath, "in", "sub"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(temppath, "in", "sub", "c.txt"), []byte("c"), 0o600))
+	require.Equal(t, "created:in/sub/c.txt", receive())
+	require.NoError(t, c.DeleteObject(ctx, "in/a.txt"))
+	require.Equal(t, "deleted:in/a.txt", receive())
+}
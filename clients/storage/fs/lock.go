@@ -0,0 +1,64 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// objectLocks serializes the writes of the same object in the process.
+type objectLocks struct {
+	mux   sync.Mutex
+	locks map[string]*objectLock
+}
+
+type objectLock struct {
+	sync.Mutex
+	refs int
+}
+
+func (l *objectLocks) lock(name string) (unlock func()) {
+	l.mux.Lock()
+	if l.locks == nil {
+		l.locks = map[string]*objectLock{}
+	}
+	ol, ok := l.locks[name]
+	if !ok {
+		ol = &objectLock{}
+		l.locks[name] = ol
+	}
+	ol.refs++
+	l.mux.Unlock()
+	ol.Lock()
+	return func() {
+		ol.Unlock()
+		l.mux.Lock()
+		defer l.mux.Unlock()
+		if ol.refs--; ol.refs == 0 {
+			delete(l.locks, name)
+		}
+	}
+}
+
+// lockObject locks the object for writing, so that a conditional write is atomic with the check of its condition.
+// The writers of the local storages in other processes are excluded by locking the lock file of the object.
+func (c Client) lockObject(name string) (unlock func(), err error) {
+	unlockProcess := c.locks.lock(name)
+	if len(c.localDir) == 0 {
+		return unlockProcess, nil
+	}
+	lockPath := filepath.Join(c.localDir, metadataDir, filepath.FromSlash(name)+".lock")
+	if err = os.MkdirAll(filepath.Dir(lockPath), 0o755); err != nil {
+		unlockProcess()
+		return nil, err
+	}
+	unlockFile, err := lockFile(lockPath)
+	if err != nil {
+		unlockProcess()
+		return nil, err
+	}
+	return func() {
+		unlockFile()
+		unlockProcess()
+	}, nil
+}
@@ -0,0 +1,253 @@
+package fs
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	stdtime "time"
+
+	"github.com/spf13/afero"
+)
+
+// metadataDir is the directory of the sidecar files, which keep the headers, metadata and ETag of the objects.
+// The sidecar of the object "a/b.txt" is ".metadata/a/b.txt.json". It is hidden from the listings and
+// cannot be written as an object.
+const metadataDir = ".metadata"
+
+// persistentHeaders are the headers of the objects saved by PutObject, as object storages do.
+var persistentHeaders = []string{"Content-Type", "Content-Encoding", "Content-Language", "Content-Disposition", "Cache-Control", "Expires"}
+
+type objectMetadata struct {
+	Headers  http.Header       `json:"headers,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// ETag is the hex encoded MD5 of the content, it is valid while the size and the modification time
+	// of the file are the same as Size and ModTime.
+	ETag    string       `json:"etag,omitempty"`
+	Size    int64        `json:"size,omitempty"`
+	ModTime stdtime.Time `json:"mod_time,omitempty"`
+}
+
+func (m *objectMetadata) validETag(stat fs.FileInfo) string {
+	if m.Size == stat.Size() && m.ModTime.Equal(stat.ModTime()) {
+		return m.ETag
+	}
+	return ""
+}
+
+func metadataPath(name string) string {
+	return path.Join(metadataDir, name+".json")
+}
+
+// cleanObjectPath returns the slash-separated relative path of the object.
+func cleanObjectPath(objectPath string) (string, error) {
+	name := strings.TrimPrefix(path.Clean("/"+resolveObjectPath(objectPath)), "/")
+	if len(name) == 0 {
+		return "", errors.New("invalid object path: path is empty")
+	}
+	if name == metadataDir || strings.HasPrefix(name, metadataDir+"/") {
+		return "", fmt.Errorf("invalid object path: %s is reserved", metadataDir)
+	}
+	return name, nil
+}
+
+// readMetadata reads the sidecar file of the object, the objects without sidecar file have empty metadata.
+func (c Client) readMetadata(name string) (*objectMetadata, error) {
+	var meta objectMetadata
+	data, err := fs.ReadFile(c.fs, metadataPath(name))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return &meta, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("invalid metadata of %s: %w", name, err)
+	}
+	return &meta, nil
+}
+
+func (c Client) putObject(name string, obj io.Reader, headers http.Header, metadata map[string]string) error {
+	if err := c.afs.MkdirAll(metadataDir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := afero.TempFile(c.afs, metadataDir, ".upload-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer c.afs.Remove(tmp.Name())
+	hash := md5.New()
+	if _, err = io.Copy(io.MultiWriter(tmp, hash), obj); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if dir := path.Dir(name); dir != "." {
+		if err = c.afs.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	if err = c.afs.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+	stat, err := c.afs.Stat(name)
+	if err != nil {
+		return err
+	}
+	meta := objectMetadata{
+		Metadata: metadata,
+		ETag:     hex.EncodeToString(hash.Sum(nil)),
+		Size:     stat.Size(),
+		ModTime:  stat.ModTime(),
+	}
+	for _, key := range persistentHeaders {
+		if value := headers.Get(key); len(value) != 0 {
+			if meta.Headers == nil {
+				meta.Headers = http.Header{}
+			}
+			meta.Headers.Set(key, value)
+		}
+	}
+	return c.writeMetadata(name, &meta)
+}
+
+func (c Client) writeMetadata(name string, meta *objectMetadata) error {
+	sidecar := metadataPath(name)
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err = c.afs.MkdirAll(path.Dir(sidecar), 0o755); err != nil {
+		return err
+	}
+	return afero.WriteFile(c.afs, sidecar, data, 0o644)
+}
+
+// maxCachedETags bounds the ETags kept in memory for a read-only storage.
+const maxCachedETags = 4096
+
+// etagCache keeps the ETags calculated for a read-only storage, which has no sidecar to cache them, by the size and
+// the modification time of the files.
+type etagCache struct {
+	mux     sync.Mutex
+	entries map[string]cachedETag
+}
+
+type cachedETag struct {
+	size    int64
+	modTime stdtime.Time
+	etag    string
+}
+
+func (c *etagCache) get(name string, stat fs.FileInfo) string {
+	if c == nil {
+		return ""
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if e, ok := c.entries[name]; ok && e.size == stat.Size() && e.modTime.Equal(stat.ModTime()) {
+		return e.etag
+	}
+	return ""
+}
+
+func (c *etagCache) set(name string, stat fs.FileInfo, etag string) {
+	if c == nil {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]cachedETag)
+	}
+	if _, ok := c.entries[name]; !ok && len(c.entries) >= maxCachedETags {
+		// evicts an arbitrary entry.
+		for key := range c.entries {
+			delete(c.entries, key)
+			break
+		}
+	}
+	c.entries[name] = cachedETag{size: stat.Size(), modTime: stat.ModTime(), etag: etag}
+}
+
+// etag returns the ETag of the object. The ETag of a file that has no sidecar or has been modified since
+// its sidecar was written is calculated from the content, and saved to the sidecar if cache is true and the storage
+// is writable, see Client.cacheETag. The ETags of a read-only storage are kept in memory instead.
+func (c Client) etag(name string, stat fs.FileInfo, meta *objectMetadata, cache bool) (string, error) {
+	if stat.IsDir() {
+		return "", nil
+	}
+	if etag := meta.validETag(stat); len(etag) != 0 {
+		return etag, nil
+	}
+	if etag := c.etags.get(name, stat); len(etag) != 0 {
+		return etag, nil
+	}
+	f, err := c.fs.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := md5.New()
+	if _, err = io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	etag := hex.EncodeToString(hash.Sum(nil))
+	if c.afs == nil {
+		c.etags.set(name, stat, etag)
+	} else if cache {
+		// the sidecar is a cache of the ETag here, failing to update it does not fail the request.
+		_ = c.cacheETag(name, etag, stat)
+	}
+	return etag, nil
+}
+
+// cacheETag saves the ETag of the content of stat to the sidecar of the object. The sidecar is read again and
+// written under the lock of the object, and is not written if the file has been modified since stat, so that the
+// headers and metadata written by a concurrent PutObject are not overwritten.
+func (c Client) cacheETag(objectPath, etag string, stat fs.FileInfo) error {
+	name, err := cleanObjectPath(objectPath)
+	if err != nil {
+		return err
+	}
+	unlock, err := c.lockObject(name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	current, err := c.afs.Stat(name)
+	if err != nil {
+		return err
+	}
+	if current.Size() != stat.Size() || !current.ModTime().Equal(stat.ModTime()) {
+		return nil
+	}
+	meta, err := c.readMetadata(name)
+	if err != nil {
+		return err
+	}
+	if len(meta.validETag(current)) != 0 {
+		return nil
+	}
+	meta.ETag, meta.Size, meta.ModTime = etag, stat.Size(), stat.ModTime()
+	return c.writeMetadata(name, meta)
+}
+
+// cachedETag returns the ETag of the object in its sidecar if it is valid, it is used by the listings,
+// which should not read the content of every file.
+func (c Client) cachedETag(name string, stat fs.FileInfo) string {
+	meta, err := c.readMetadata(name)
+	if err != nil {
+		return ""
+	}
+	return meta.validETag(stat)
+}
@@ -0,0 +1,186 @@
+package fs
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	stdtime "time"
+
+	"github.com/go-kit/log/level"
+
+	"github.com/MicroOps-cn/fuck/clients/storage"
+	"github.com/MicroOps-cn/fuck/log"
+)
+
+const (
+	presignExpiresParam       = "X-Expires"
+	presignSignedHeadersParam = "X-Signed-Headers"
+	presignSignatureParam     = "X-Signature"
+	presignResponseParam      = "response-"
+)
+
+var ErrPresignNotConfigured = errors.New("presign is not configured: presign_url and presign_secret are required")
+
+// PresignGet returns a URL served by PresignHandler, the URL is signed with HMAC-SHA256 of presign_secret.
+// The content type and headers of the options override the response headers.
+func (c Client) PresignGet(_ context.Context, objectPath string, o storage.PresignOptions) (*storage.PresignedRequest, error) {
+	query := url.Values{}
+	if len(o.ContentType) != 0 {
+		query.Set(presignResponseParam+"content-type", o.ContentType)
+	}
+	for name := range o.Headers {
+		query.Set(presignResponseParam+strings.ToLower(name), o.Headers.Get(name))
+	}
+	return c.presign(http.MethodGet, objectPath, o, query, nil)
+}
+
+// PresignPut returns a URL served by PresignHandler, the URL is signed with HMAC-SHA256 of presign_secret.
+// The content type and headers of the options must be sent with the request.
+func (c Client) PresignPut(_ context.Context, objectPath string, o storage.PresignOptions) (*storage.PresignedRequest, error) {
+	signedHeader := http.Header{}
+	for name := range o.Headers {
+		signedHeader.Set(name, o.Headers.Get(name))
+	}
+	if len(o.ContentType) != 0 {
+		signedHeader.Set("Content-Type", o.ContentType)
+	}
+	query := url.Values{}
+	if len(signedHeader) != 0 {
+		names := make([]string, 0, len(signedHeader))
+		for name := range signedHeader {
+			names = append(names, strings.ToLower(name))
+		}
+		sort.Strings(names)
+		query.Set(presignSignedHeadersParam, strings.Join(names, ";"))
+	}
+	return c.presign(http.MethodPut, objectPath, o, query, signedHeader)
+}
+
+func (c Client) presign(method, objectPath string, o storage.PresignOptions, query url.Values, signedHeader http.Header) (*storage.PresignedRequest, error) {
+	if c.presignURL == nil || len(c.presignSecret) == 0 {
+		return nil, ErrPresignNotConfigured
+	}
+	objectPath = path.Clean("/" + resolveObjectPath(objectPath))
+	expiration := stdtime.Now().Add(o.ExpiresIn())
+	query.Set(presignExpiresParam, strconv.FormatInt(expiration.Unix(), 10))
+	query.Set(presignSignatureParam, c.signature(method, objectPath, query, signedHeader))
+	u := *c.presignURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + objectPath
+	u.RawQuery = query.Encode()
+	return &storage.PresignedRequest{
+		Method:       method,
+		URL:          u.String(),
+		SignedHeader: signedHeader,
+		Expiration:   expiration,
+	}, nil
+}
+
+// signature calculates the HMAC-SHA256 of the method, the object path, the query parameters except the signature
+// and the headers listed in X-Signed-Headers.
+func (c Client) signature(method, objectPath string, query url.Values, header http.Header) string {
+	mac := hmac.New(sha256.New, c.presignSecret)
+	mac.Write([]byte(method + "\n" + objectPath + "\n"))
+	names := make([]string, 0, len(query))
+	for name := range query {
+		if name != presignSignatureParam {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		mac.Write([]byte(name + "=" + query.Get(name) + "\n"))
+	}
+	if signedHeaders := query.Get(presignSignedHeadersParam); len(signedHeaders) != 0 {
+		for _, name := range strings.Split(signedHeaders, ";") {
+			mac.Write([]byte(name + ":" + header.Get(name) + "\n"))
+		}
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (c Client) verify(r *http.Request, objectPath string) error {
+	query := r.URL.Query()
+	expires, err := strconv.ParseInt(query.Get(presignExpiresParam), 10, 64)
+	if err != nil {
+		return errors.New("invalid expires")
+	}
+	if stdtime.Now().Unix() > expires {
+		return errors.New("request has expired")
+	}
+	method := r.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	expected := c.signature(method, objectPath, query, r.Header)
+	if !hmac.Equal([]byte(expected), []byte(query.Get(presignSignatureParam))) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
+
+// PresignHandler returns a http.Handler that serves the URLs returned by PresignGet and PresignPut.
+// It should be mounted at the path of presign_url.
+func (c Client) PresignHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := log.GetContextLogger(r.Context())
+		if c.presignURL == nil || len(c.presignSecret) == 0 {
+			http.Error(w, ErrPresignNotConfigured.Error(), http.StatusNotImplemented)
+			return
+		}
+		objectPath := strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(c.presignURL.Path, "/"))
+		objectPath = path.Clean("/" + objectPath)
+		if err := c.verify(r, objectPath); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			obj, err := c.GetObject(r.Context(), strings.TrimPrefix(objectPath, "/"))
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					http.NotFound(w, r)
+				} else {
+					level.Error(logger).Log("msg", "failed to get object", "path", objectPath, "err", err)
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+				return
+			}
+			defer obj.Close()
+			for _, name := range append(persistentHeaders, "ETag") {
+				if value := obj.Headers.Get(name); len(value) != 0 {
+					w.Header().Set(name, value)
+				}
+			}
+			for name, values := range r.URL.Query() {
+				if strings.HasPrefix(name, presignResponseParam) && len(values) > 0 {
+					w.Header().Set(strings.TrimPrefix(name, presignResponseParam), values[0])
+				}
+			}
+			http.ServeContent(w, r, path.Base(objectPath), obj.LastModified, obj)
+		case http.MethodPut:
+			headers := http.Header{}
+			for _, name := range persistentHeaders {
+				if value := r.Header.Get(name); len(value) != 0 {
+					headers.Set(name, value)
+				}
+			}
+			if err := c.PutObject(r.Context(), strings.TrimPrefix(objectPath, "/"), r.Body, headers, nil); err != nil {
+				level.Error(logger).Log("msg", "failed to put object", "path", objectPath, "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+}
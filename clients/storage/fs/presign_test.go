@@ -0,0 +1,137 @@
+package fs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	stdtime "time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/MicroOps-cn/fuck/clients/storage"
+)
+
+func TestClient_PresignGet(t *testing.T) {
+	temppath := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(temppath, "assets"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(temppath, "assets", "app.js"), []byte("console.log(1)"), 0o600))
+
+	c, err := NewClient(context.Background(), nil, storage.NewMapConfigProvider(map[string]interface{}{
+		"type":           "local",
+		"base":           temppath,
+		"presign_url":    "http://127.0.0.1/files/",
+		"presign_secret": "0123456789abcdef",
+	}))
+	require.NoError(t, err)
+	mux := http.NewServeMux()
+	mux.Handle("/files/", c.PresignHandler())
+
+	req, err := c.PresignGet(context.Background(), "assets/app.js", storage.PresignOptions{ContentType: "text/javascript"})
+	require.NoError(t, err)
+	require.Equal(t, http.MethodGet, req.Method)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, req.URL, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "console.log(1)", rec.Body.String())
+	require.Equal(t, "text/javascript", rec.Header().Get("Content-Type"))
+
+	u, err := url.Parse(req.URL)
+	require.NoError(t, err)
+	query := u.Query()
+	query.Set("response-content-type", "text/html")
+	u.RawQuery = query.Encode()
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, u.String(), nil))
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	u, err = url.Parse(req.URL)
+	require.NoError(t, err)
+	u.Path = "/files/assets/other.js"
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, u.String(), nil))
+	require.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestClient_PresignPut(t *testing.T) {
+	temppath := t.TempDir()
+	c, err := NewClient(context.Background(), nil, storage.NewMapConfigProvider(map[string]interface{}{
+		"type":           "local",
+		"base":           temppath,
+		"presign_url":    "http://127.0.0.1/files/",
+		"presign_secret": "0123456789abcdef",
+	}))
+	require.NoError(t, err)
+	mux := http.NewServeMux()
+	mux.Handle("/files/", c.PresignHandler())
+	put := func(u string, header http.Header, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPut, u, strings.NewReader(body))
+		for name := range header {
+			r.Header.Set(name, header.Get(name))
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, r)
+		return rec
+	}
+
+	req, err := c.PresignPut(context.Background(), "uploads/app.js", storage.PresignOptions{
+		ContentType: "text/javascript",
+		Headers:     http.Header{"Cache-Control": []string{"no-cache"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, http.MethodPut, req.Method)
+	require.Equal(t, "text/javascript", req.SignedHeader.Get("Content-Type"))
+
+	// the signed headers must be sent as they were signed.
+	rec := put(req.URL, http.Header{"Content-Type": []string{"text/html"}, "Cache-Control": []string{"no-cache"}}, "alert(1)")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	rec = put(req.URL, http.Header{"Content-Type": []string{"text/javascript"}}, "alert(1)")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	u, err := url.Parse(req.URL)
+	require.NoError(t, err)
+	query := u.Query()
+	query.Set(presignSignatureParam, strings.Repeat("0", len(query.Get(presignSignatureParam))))
+	u.RawQuery = query.Encode()
+	rec = put(u.String(), req.SignedHeader, "alert(1)")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	u, err = url.Parse(req.URL)
+	require.NoError(t, err)
+	u.Path = "/files/uploads/other.js"
+	rec = put(u.String(), req.SignedHeader, "alert(1)")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	// a URL signed for GET cannot be used to upload.
+	get, err := c.PresignGet(context.Background(), "uploads/app.js", storage.PresignOptions{})
+	require.NoError(t, err)
+	rec = put(get.URL, nil, "alert(1)")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	// an expired URL is rejected even if its signature is valid.
+	query = url.Values{}
+	query.Set(presignExpiresParam, strconv.FormatInt(stdtime.Now().Add(-stdtime.Minute).Unix(), 10))
+	query.Set(presignSignatureParam, c.signature(http.MethodPut, "/uploads/app.js", query, nil))
+	rec = put("http://127.0.0.1/files/uploads/app.js?"+query.Encode(), nil, "alert(1)")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Contains(t, rec.Body.String(), "expired")
+
+	_, err = os.Stat(filepath.Join(temppath, "uploads", "app.js"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	rec = put(req.URL, req.SignedHeader, "console.log(1)")
+	require.Equal(t, http.StatusOK, rec.Code)
+	obj, err := c.HeadObject(context.Background(), "uploads/app.js")
+	require.NoError(t, err)
+	require.Equal(t, "text/javascript", obj.Headers.Get("Content-Type"))
+	require.Equal(t, "no-cache", obj.Headers.Get("Cache-Control"))
+	data, err := os.ReadFile(filepath.Join(temppath, "uploads", "app.js"))
+	require.NoError(t, err)
+	require.Equal(t, "console.log(1)", string(data))
+}
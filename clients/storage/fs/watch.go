@@ -0,0 +1,213 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	stdtime "time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-kit/log/level"
+
+	"github.com/MicroOps-cn/fuck/clients/storage"
+	"github.com/MicroOps-cn/fuck/log"
+)
+
+// watchDebounce is the quiet period of an object before its change is emitted, so that a file written by several
+// writes is emitted once.
+const watchDebounce = 100 * stdtime.Millisecond
+
+// Watch emits the changes of the objects under prefix with fsnotify. It is only supported by the local storage,
+// the other storages return storage.ErrWatchNotSupported so that storage.Watch polls them.
+func (c Client) Watch(ctx context.Context, prefix string) (<-chan storage.Event, error) {
+	if len(c.localDir) == 0 {
+		return nil, storage.ErrWatchNotSupported
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	fw := &fileWatcher{c: c, w: w, prefix: strings.TrimPrefix(resolveObjectPath(prefix), "/"), known: map[string]bool{}, dirty: map[string]stdtime.Time{}}
+	// the changes of a prefix that does not exist yet are watched from its nearest existing parent.
+	root := fw.prefix
+	if !strings.HasSuffix(root, "/") {
+		root = path.Dir(root)
+	}
+	root = strings.TrimSuffix(root, "/")
+	for len(root) != 0 && root != "." {
+		if stat, err := os.Stat(fw.osPath(root)); err == nil && stat.IsDir() {
+			break
+		}
+		root = path.Dir(root)
+	}
+	if root == "." {
+		root = ""
+	}
+	if err = fw.add(root, func(key string) { fw.known[key] = true }); err != nil {
+		w.Close()
+		return nil, err
+	}
+	events := make(chan storage.Event)
+	go fw.run(ctx, events)
+	return events, nil
+}
+
+type fileWatcher struct {
+	c      Client
+	w      *fsnotify.Watcher
+	prefix string
+	// known are the objects that exist, the changes of them are updates and deletions.
+	known map[string]bool
+	// dirty are the objects changed since the last emitted events, and the time of their last changes.
+	dirty map[string]stdtime.Time
+}
+
+func (fw *fileWatcher) osPath(key string) string {
+	return filepath.Join(fw.c.localDir, filepath.FromSlash(key))
+}
+
+func (fw *fileWatcher) key(name string) (string, bool) {
+	rel, err := filepath.Rel(fw.c.localDir, name)
+	if err != nil {
+		return "", false
+	}
+	key := filepath.ToSlash(rel)
+	if key == metadataDir || strings.HasPrefix(key, metadataDir+"/") || strings.HasPrefix(key, "../") {
+		return "", false
+	}
+	return key, true
+}
+
+// relevantDir reports whether the directory may contain the objects under the prefix.
+func (fw *fileWatcher) relevantDir(dir string) bool {
+	if len(dir) == 0 {
+		return true
+	}
+	return strings.HasPrefix(dir+"/", fw.prefix) || strings.HasPrefix(fw.prefix, dir+"/")
+}
+
+// add watches the directory and its relevant subdirectories, and calls found with the objects under the prefix in them.
+func (fw *fileWatcher) add(dir string, found func(key string)) error {
+	return filepath.WalkDir(fw.osPath(dir), func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		key, ok := fw.key(name)
+		if !ok {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if key == "." {
+			key = ""
+		}
+		if d.IsDir() {
+			if !fw.relevantDir(key) {
+				return filepath.SkipDir
+			}
+			return fw.w.Add(name)
+		}
+		if strings.HasPrefix(key, fw.prefix) {
+			found(key)
+		}
+		return nil
+	})
+}
+
+func (fw *fileWatcher) handle(ctx context.Context, event fsnotify.Event) {
+	key, ok := fw.key(event.Name)
+	if !ok {
+		return
+	}
+	now := stdtime.Now()
+	switch {
+	case event.Has(fsnotify.Create):
+		if stat, err := os.Stat(event.Name); err == nil && stat.IsDir() {
+			// the files moved in with the directory do not have their own events.
+			if err = fw.add(key, func(key string) { fw.dirty[key] = now }); err != nil {
+				level.Warn(log.GetContextLogger(ctx)).Log("msg", "failed to watch directory", "dir", key, "err", err)
+			}
+			return
+		}
+	case event.Has(fsnotify.Remove), event.Has(fsnotify.Rename):
+		// a removed directory only has its own event if it is moved out.
+		for known := range fw.known {
+			if strings.HasPrefix(known, key+"/") {
+				fw.dirty[known] = now
+			}
+		}
+	case !event.Has(fsnotify.Write):
+		return
+	}
+	if strings.HasPrefix(key, fw.prefix) {
+		fw.dirty[key] = now
+	}
+}
+
+// flush returns the events of the objects that have not changed for watchDebounce, the type of an event is decided
+// by whether the object was known and whether it exists now.
+func (fw *fileWatcher) flush(ctx context.Context) []storage.Event {
+	var events []storage.Event
+	now := stdtime.Now()
+	for key, changed := range fw.dirty {
+		if now.Sub(changed) < watchDebounce {
+			continue
+		}
+		delete(fw.dirty, key)
+		obj, err := fw.c.HeadObject(ctx, key)
+		if err == nil && obj.IsDir() {
+			continue
+		}
+		switch {
+		case err == nil && fw.known[key]:
+			events = append(events, storage.Event{Type: storage.EventUpdated, Object: *obj})
+		case err == nil:
+			fw.known[key] = true
+			events = append(events, storage.Event{Type: storage.EventCreated, Object: *obj})
+		case fw.known[key]:
+			delete(fw.known, key)
+			events = append(events, storage.Event{Type: storage.EventDeleted, Object: storage.Object{Key: key}})
+		}
+	}
+	return events
+}
+
+func (fw *fileWatcher) run(ctx context.Context, events chan<- storage.Event) {
+	defer close(events)
+	defer fw.w.Close()
+	logger := log.GetContextLogger(ctx)
+	ticker := stdtime.NewTicker(watchDebounce / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-fw.w.Events:
+			if !ok {
+				return
+			}
+			fw.handle(ctx, event)
+		case err, ok := <-fw.w.Errors:
+			if !ok {
+				return
+			}
+			level.Warn(logger).Log("msg", "failed to watch local storage", "dir", fw.c.localDir, "err", err)
+		case <-ticker.C:
+			for _, event := range fw.flush(ctx) {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package fs
+
+import (
+	"archive/zip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	// ZipModeRead opens the zip archive of base as a read-only storage.
+	ZipModeRead = "read"
+	// ZipModeWrite stages the objects in a temp directory, and writes them to the zip archive of base on Close.
+	ZipModeWrite = "write"
+)
+
+// zipWriter writes the files of a temp directory to a zip archive when it is closed, the headers and metadata of
+// the objects in their sidecar files are not kept in the archive.
+type zipWriter struct {
+	dir  string
+	base string
+	fs   afero.Fs
+	once sync.Once
+	err  error
+}
+
+func newZipWriter(base string) (*zipWriter, error) {
+	dir, err := os.MkdirTemp("", "storage-zip-*")
+	if err != nil {
+		return nil, err
+	}
+	return &zipWriter{dir: dir, base: base, fs: afero.NewBasePathFs(afero.NewOsFs(), dir)}, nil
+}
+
+func (z *zipWriter) Close() error {
+	z.once.Do(func() {
+		defer os.RemoveAll(z.dir)
+		z.err = z.write()
+	})
+	return z.err
+}
+
+// write writes the archive to a temp file next to base, and renames it to base when it is completed.
+func (z *zipWriter) write() error {
+	f, err := os.CreateTemp(filepath.Dir(z.base), filepath.Base(z.base)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	w := zip.NewWriter(f)
+	err = filepath.WalkDir(z.dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			// the sidecar files of the objects are not archived.
+			if name == filepath.Join(z.dir, metadataDir) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(z.dir, name)
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		header.Method = zip.Deflate
+		dst, err := w.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		src, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(dst, src)
+		return err
+	})
+	if err == nil {
+		err = w.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), z.base)
+}
@@ -0,0 +1,126 @@
+package gateway
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// isChunked reports whether the body of the request is in the aws-chunked encoding, which is used by
+// the SDKs to stream the payload with chunk signatures or trailing checksums.
+func isChunked(r *http.Request) bool {
+	if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return true
+	}
+	for _, encoding := range strings.Split(r.Header.Get("Content-Encoding"), ",") {
+		if strings.TrimSpace(encoding) == "aws-chunked" {
+			return true
+		}
+	}
+	return false
+}
+
+// requestBody returns the payload of the request and its size, the size is -1 if it is unknown.
+func requestBody(r *http.Request) (io.Reader, int64, error) {
+	if !isChunked(r) {
+		return r.Body, r.ContentLength, nil
+	}
+	size := int64(-1)
+	if value := r.Header.Get("X-Amz-Decoded-Content-Length"); len(value) != 0 {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid x-amz-decoded-content-length: %s", value)
+		}
+		size = n
+	}
+	return &chunkedReader{r: bufio.NewReader(r.Body)}, size, nil
+}
+
+// chunkedReader decodes the aws-chunked encoding. The chunk signatures and the trailing checksums are not verified.
+//
+//	<hex size>[;chunk-signature=<signature>]\r\n<data>\r\n ... 0[;chunk-signature=<signature>]\r\n[<trailer>\r\n]*\r\n
+type chunkedReader struct {
+	r         *bufio.Reader
+	remaining int64
+	eof       bool
+}
+
+var errMalformedChunk = errors.New("malformed aws-chunked body")
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.eof {
+			return 0, io.EOF
+		}
+		if err := c.next(); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if c.remaining == 0 && err == nil {
+		err = c.readCRLF()
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// next reads the header of the next chunk, the trailers are skipped after the last chunk.
+func (c *chunkedReader) next() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	sizeHex, _, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeHex), 16, 64)
+	if err != nil || size < 0 {
+		return errMalformedChunk
+	}
+	if size != 0 {
+		c.remaining = size
+		return nil
+	}
+	c.eof = true
+	for {
+		if line, err = c.readLine(); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+		}
+		if len(line) == 0 {
+			return nil
+		}
+	}
+}
+
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+func (c *chunkedReader) readCRLF() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	if len(line) != 0 {
+		return errMalformedChunk
+	}
+	return nil
+}
@@ -0,0 +1,670 @@
+// Package gateway serves a storage.Storage with the S3 REST API, so that the S3 SDKs and tools can access
+// the local storages, such as the fs storage in development and tests.
+//
+// It implements the object operations GetObject, HeadObject, PutObject, CopyObject, DeleteObject and
+// DeleteObjects, the bucket operations ListObjects, ListObjectsV2, HeadBucket and ListBuckets, and the
+// multipart uploads. The requests are not authenticated, the gateway must not be exposed to untrusted networks.
+package gateway
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	stdtime "time"
+
+	"github.com/go-kit/log/level"
+
+	"github.com/MicroOps-cn/fuck/clients/storage"
+	"github.com/MicroOps-cn/fuck/errors"
+	"github.com/MicroOps-cn/fuck/log"
+)
+
+type Options struct {
+	// Bucket is the name of the bucket served by the gateway. The requests of any bucket are served if it is empty.
+	// If it is set, the virtual-hosted-style requests of the bucket are served too.
+	Bucket string
+	// UploadDir is the directory that stages the parts of the multipart uploads when the storage does not
+	// implement storage.MultipartUploader. It is a directory in os.TempDir() by default.
+	UploadDir string
+}
+
+// metadataHeaderPrefix is the prefix of the headers of the user-defined metadata.
+const metadataHeaderPrefix = "X-Amz-Meta-"
+
+// objectHeaders are the standard headers that are saved with the objects.
+var objectHeaders = []string{"Content-Type", "Content-Encoding", "Content-Language", "Content-Disposition", "Cache-Control", "Expires"}
+
+var (
+	errNoSuchKey    = errors.NewError(http.StatusNotFound, "the specified key does not exist", "NoSuchKey")
+	errNoSuchBucket = errors.NewError(http.StatusNotFound, "the specified bucket does not exist", "NoSuchBucket")
+	errInvalidRange = errors.NewError(http.StatusRequestedRangeNotSatisfiable, "the requested range is not satisfiable", "InvalidRange")
+	errMalformedXML = errors.NewError(http.StatusBadRequest, "the XML you provided was not well-formed", "MalformedXML")
+	errNotModified  = errors.NewError(http.StatusNotModified, "not modified", "NotModified")
+)
+
+func notImplemented(operation string) error {
+	return errors.NewError(http.StatusNotImplemented, operation+" is not implemented", "NotImplemented")
+}
+
+// Handler is a http.Handler that serves a storage with the S3 REST API.
+type Handler struct {
+	s        storage.Storage
+	uploader storage.MultipartUploader
+	bucket   string
+	created  stdtime.Time
+}
+
+// New returns a Handler of s. The multipart uploads are delegated to s if it implements storage.MultipartUploader.
+func New(s storage.Storage, o Options) (*Handler, error) {
+	h := &Handler{s: s, bucket: o.Bucket, created: stdtime.Now()}
+	if u, ok := storage.As[storage.MultipartUploader](s); ok {
+		h.uploader = u
+	} else {
+		dir := o.UploadDir
+		if len(dir) == 0 {
+			dir = filepath.Join(os.TempDir(), "storage-gateway-uploads")
+		}
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create upload directory: %w", err)
+		}
+		h.uploader = &stagingUploader{s: s, dir: dir}
+	}
+	return h, nil
+}
+
+// route returns the bucket and the key of the request, in either the virtual-hosted style or the path style.
+func (h *Handler) route(r *http.Request) (bucket, key string) {
+	if len(h.bucket) != 0 {
+		host := r.Host
+		if hostname, _, found := strings.Cut(host, ":"); found {
+			host = hostname
+		}
+		if strings.HasPrefix(host, h.bucket+".") {
+			return h.bucket, strings.TrimPrefix(r.URL.Path, "/")
+		}
+	}
+	bucket, key, _ = strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	return bucket, key
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key := h.route(r)
+	var err error
+	switch {
+	case len(bucket) == 0:
+		if r.Method == http.MethodGet {
+			err = h.listBuckets(w)
+		} else {
+			err = notImplemented(r.Method + " /")
+		}
+	case len(h.bucket) != 0 && bucket != h.bucket:
+		err = errNoSuchBucket
+	case len(key) == 0:
+		err = h.serveBucket(w, r, bucket)
+	default:
+		err = h.serveObject(w, r, bucket, key)
+	}
+	if err != nil {
+		writeError(w, r, err)
+	}
+}
+
+func (h *Handler) serveBucket(w http.ResponseWriter, r *http.Request, bucket string) error {
+	query := r.URL.Query()
+	switch r.Method {
+	case http.MethodHead, http.MethodPut:
+		// the bucket always exists, creating it does nothing.
+		return nil
+	case http.MethodGet:
+		switch {
+		case query.Has("uploads"):
+			return h.listMultipartUploads(w, r, bucket)
+		case query.Has("location"):
+			return writeXML(w, http.StatusOK, struct {
+				XMLName xml.Name `xml:"LocationConstraint"`
+				Xmlns   string   `xml:"xmlns,attr"`
+			}{Xmlns: xmlns})
+		case query.Get("list-type") == "2":
+			return h.listObjects(w, r, bucket, true)
+		default:
+			return h.listObjects(w, r, bucket, false)
+		}
+	case http.MethodPost:
+		if query.Has("delete") {
+			return h.deleteObjects(w, r)
+		}
+	}
+	return notImplemented(r.Method + " bucket")
+}
+
+func (h *Handler) serveObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+	switch r.Method {
+	case http.MethodGet:
+		return h.getObject(w, r, key)
+	case http.MethodHead:
+		return h.headObject(w, r, key)
+	case http.MethodPut:
+		switch {
+		case len(uploadID) != 0:
+			return h.uploadPart(w, r, key, uploadID)
+		case len(r.Header.Get("X-Amz-Copy-Source")) != 0:
+			return h.copyObject(w, r, bucket, key)
+		default:
+			return h.putObject(w, r, key)
+		}
+	case http.MethodPost:
+		switch {
+		case query.Has("uploads"):
+			return h.initiateMultipartUpload(w, r, bucket, key)
+		case len(uploadID) != 0:
+			return h.completeMultipartUpload(w, r, bucket, key, uploadID)
+		}
+	case http.MethodDelete:
+		if len(uploadID) != 0 {
+			if err := h.uploader.AbortMultipartUpload(r.Context(), key, uploadID); err != nil {
+				return err
+			}
+		} else if err := h.s.DeleteObject(r.Context(), key); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return notImplemented(r.Method + " object")
+}
+
+func (h *Handler) listBuckets(w http.ResponseWriter) error {
+	result := listAllMyBucketsResult{Xmlns: xmlns}
+	if len(h.bucket) != 0 {
+		result.Buckets = append(result.Buckets, bucket{Name: h.bucket, CreationDate: formatTime(h.created)})
+	}
+	return writeXML(w, http.StatusOK, result)
+}
+
+// errStopListing stops the listing after the first page.
+var errStopListing = fmt.Errorf("stop listing")
+
+func (h *Handler) listObjects(w http.ResponseWriter, r *http.Request, bucket string, v2 bool) error {
+	query := r.URL.Query()
+	o := storage.ListOptions{
+		Prefix:    query.Get("prefix"),
+		Delimiter: query.Get("delimiter"),
+	}
+	if value := query.Get("max-keys"); len(value) != 0 {
+		maxKeys, err := strconv.Atoi(value)
+		if err != nil || maxKeys < 0 {
+			return errors.NewError(http.StatusBadRequest, "invalid max-keys: "+value, "InvalidArgument")
+		}
+		o.MaxKeys = maxKeys
+	}
+	result := listBucketResult{
+		Xmlns:     xmlns,
+		Name:      bucket,
+		Prefix:    o.Prefix,
+		Delimiter: o.Delimiter,
+		MaxKeys:   o.PageSize(),
+	}
+	if v2 {
+		o.StartAfter = query.Get("start-after")
+		o.ContinuationToken = query.Get("continuation-token")
+		result.StartAfter, result.ContinuationToken = o.StartAfter, o.ContinuationToken
+	} else {
+		marker := query.Get("marker")
+		o.StartAfter, result.Marker = marker, &marker
+	}
+	err := h.s.ListObjects(r.Context(), o, func(page *storage.ListPage) error {
+		for _, obj := range page.Objects {
+			if obj.IsDir() {
+				continue
+			}
+			result.Contents = append(result.Contents, content{
+				Key:          obj.Key,
+				LastModified: formatTime(obj.LastModified),
+				ETag:         storage.QuoteETag(obj.ETag),
+				Size:         obj.Size,
+				StorageClass: storageClass(obj.StorageClass),
+			})
+		}
+		for _, prefix := range page.CommonPrefixes {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: prefix})
+		}
+		result.IsTruncated = len(page.NextContinuationToken) != 0
+		result.NextContinuationToken = page.NextContinuationToken
+		return errStopListing
+	})
+	if err != nil && err != errStopListing {
+		return err
+	}
+	if v2 {
+		keyCount := len(result.Contents) + len(result.CommonPrefixes)
+		result.KeyCount = &keyCount
+	} else {
+		result.NextContinuationToken = ""
+		if result.IsTruncated {
+			// the next page of ListObjects starts after the last key or common prefix of this page.
+			if n := len(result.Contents); n != 0 {
+				result.NextMarker = result.Contents[n-1].Key
+			}
+			if n := len(result.CommonPrefixes); n != 0 && result.CommonPrefixes[n-1].Prefix > result.NextMarker {
+				result.NextMarker = result.CommonPrefixes[n-1].Prefix
+			}
+		}
+	}
+	return writeXML(w, http.StatusOK, result)
+}
+
+func storageClass(class string) string {
+	if len(class) == 0 {
+		return "STANDARD"
+	}
+	return class
+}
+
+func (h *Handler) deleteObjects(w http.ResponseWriter, r *http.Request) error {
+	var req deleteRequest
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		return errMalformedXML
+	}
+	result := deleteResult{Xmlns: xmlns}
+	for _, obj := range req.Objects {
+		if err := h.s.DeleteObject(r.Context(), obj.Key); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			code, _, message := errorCode(err)
+			result.Errors = append(result.Errors, deleteError{Key: obj.Key, Code: code, Message: message})
+		} else if !req.Quiet {
+			result.Deleted = append(result.Deleted, objectIdentifier{Key: obj.Key})
+		}
+	}
+	return writeXML(w, http.StatusOK, result)
+}
+
+// requestCondition returns the context with the condition of the conditional headers of the request.
+func requestCondition(r *http.Request) (context.Context, error) {
+	cond := storage.Condition{
+		IfMatch:     r.Header.Get("If-Match"),
+		IfNoneMatch: r.Header.Get("If-None-Match"),
+	}
+	for name, t := range map[string]*stdtime.Time{"If-Modified-Since": &cond.IfModifiedSince, "If-Unmodified-Since": &cond.IfUnmodifiedSince} {
+		if value := r.Header.Get(name); len(value) != 0 {
+			parsed, err := http.ParseTime(value)
+			if err != nil {
+				return nil, errors.NewError(http.StatusBadRequest, fmt.Sprintf("invalid %s: %s", name, value), "InvalidArgument")
+			}
+			*t = parsed
+		}
+	}
+	if cond.IsZero() {
+		return r.Context(), nil
+	}
+	return storage.WithCondition(r.Context(), cond), nil
+}
+
+func writeObjectHeaders(w http.ResponseWriter, obj *storage.Object) {
+	header := w.Header()
+	for _, name := range objectHeaders {
+		if value := obj.Headers.Get(name); len(value) != 0 {
+			header.Set(name, value)
+		}
+	}
+	if len(header.Get("Content-Type")) == 0 {
+		header.Set("Content-Type", "binary/octet-stream")
+	}
+	for name, value := range obj.Metadata {
+		header.Set(metadataHeaderPrefix+name, value)
+	}
+	if len(obj.ETag) != 0 {
+		header.Set("ETag", storage.QuoteETag(obj.ETag))
+	}
+	if !obj.LastModified.IsZero() {
+		header.Set("Last-Modified", obj.LastModified.UTC().Format(http.TimeFormat))
+	}
+	if obj.Size >= 0 {
+		header.Set("Accept-Ranges", "bytes")
+		header.Set("Content-Length", strconv.FormatInt(obj.Size, 10))
+	}
+}
+
+func (h *Handler) headObject(w http.ResponseWriter, r *http.Request, key string) error {
+	ctx, err := requestCondition(r)
+	if err != nil {
+		return err
+	}
+	obj, err := h.s.HeadObject(ctx, key)
+	if err != nil {
+		return err
+	}
+	if obj.IsDir() {
+		return errNoSuchKey
+	}
+	if cond, ok := storage.ConditionFromContext(ctx); ok {
+		if err = cond.CheckRead(obj); err != nil {
+			return err
+		}
+	}
+	writeObjectHeaders(w, obj)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (h *Handler) getObject(w http.ResponseWriter, r *http.Request, key string) error {
+	ctx, err := requestCondition(r)
+	if err != nil {
+		return err
+	}
+	var offset, length int64 = 0, -1
+	var partial bool
+	if spec := r.Header.Get("Range"); len(spec) != 0 {
+		head, err := h.s.HeadObject(ctx, key)
+		if err != nil {
+			return err
+		}
+		// the ranges of the objects of unknown size are ignored, the whole object is returned.
+		if head.Size >= 0 {
+			if offset, length, err = parseRange(spec, head.Size); err != nil {
+				return err
+			}
+			partial = true
+		}
+	}
+	var obj *storage.ObjectReader
+	if partial {
+		obj, err = h.s.GetObjectRange(ctx, key, offset, length)
+	} else {
+		obj, err = h.s.GetObject(ctx, key)
+	}
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	if obj.IsDir() {
+		return errNoSuchKey
+	}
+	writeObjectHeaders(w, &obj.Object)
+	status := http.StatusOK
+	if partial {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, obj.Size))
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+		status = http.StatusPartialContent
+	}
+	for name, value := range responseOverrides(r) {
+		w.Header().Set(name, value)
+	}
+	w.WriteHeader(status)
+	if _, err = io.Copy(w, obj); err != nil {
+		level.Warn(log.GetContextLogger(r.Context())).Log("msg", "failed to write object", "key", key, "err", err)
+	}
+	return nil
+}
+
+// responseOverrides returns the response headers overridden by the response-* query parameters of GetObject.
+func responseOverrides(r *http.Request) map[string]string {
+	overrides := map[string]string{}
+	for param, name := range map[string]string{
+		"response-content-type":        "Content-Type",
+		"response-content-language":    "Content-Language",
+		"response-expires":             "Expires",
+		"response-cache-control":       "Cache-Control",
+		"response-content-disposition": "Content-Disposition",
+		"response-content-encoding":    "Content-Encoding",
+	} {
+		if value := r.URL.Query().Get(param); len(value) != 0 {
+			overrides[name] = value
+		}
+	}
+	return overrides
+}
+
+// parseRange parses the Range header of a single range, such as "bytes=0-99", "bytes=100-" and "bytes=-100".
+func parseRange(spec string, size int64) (offset, length int64, err error) {
+	ranges, found := strings.CutPrefix(spec, "bytes=")
+	if !found || strings.Contains(ranges, ",") {
+		return 0, 0, errInvalidRange
+	}
+	start, end, found := strings.Cut(strings.TrimSpace(ranges), "-")
+	if !found {
+		return 0, 0, errInvalidRange
+	}
+	if len(start) == 0 {
+		n, err := strconv.ParseInt(end, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, errInvalidRange
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, nil
+	}
+	offset, err = strconv.ParseInt(start, 10, 64)
+	if err != nil || offset < 0 || offset >= size {
+		return 0, 0, errInvalidRange
+	}
+	last := size - 1
+	if len(end) != 0 {
+		if last, err = strconv.ParseInt(end, 10, 64); err != nil || last < offset {
+			return 0, 0, errInvalidRange
+		}
+		if last >= size {
+			last = size - 1
+		}
+	}
+	return offset, last - offset + 1, nil
+}
+
+// requestHeaders returns the standard headers and the user-defined metadata of the object in the request.
+func requestHeaders(r *http.Request) (http.Header, map[string]string) {
+	headers := http.Header{}
+	for _, name := range objectHeaders {
+		if value := r.Header.Get(name); len(value) != 0 {
+			headers.Set(name, value)
+		}
+	}
+	if isChunked(r) {
+		// aws-chunked is the encoding of the request body, not the encoding of the object.
+		var encodings []string
+		for _, encoding := range strings.Split(headers.Get("Content-Encoding"), ",") {
+			if encoding = strings.TrimSpace(encoding); len(encoding) != 0 && encoding != "aws-chunked" {
+				encodings = append(encodings, encoding)
+			}
+		}
+		headers.Del("Content-Encoding")
+		if len(encodings) != 0 {
+			headers.Set("Content-Encoding", strings.Join(encodings, ","))
+		}
+	}
+	var metadata map[string]string
+	for name, values := range r.Header {
+		if strings.HasPrefix(name, metadataHeaderPrefix) && len(values) != 0 {
+			if metadata == nil {
+				metadata = map[string]string{}
+			}
+			metadata[strings.ToLower(strings.TrimPrefix(name, metadataHeaderPrefix))] = values[0]
+		}
+	}
+	return headers, metadata
+}
+
+func (h *Handler) putObject(w http.ResponseWriter, r *http.Request, key string) error {
+	ctx, err := requestCondition(r)
+	if err != nil {
+		return err
+	}
+	body, _, err := requestBody(r)
+	if err != nil {
+		return errors.NewError(http.StatusBadRequest, err.Error(), "InvalidArgument")
+	}
+	headers, metadata := requestHeaders(r)
+	if err = h.s.PutObject(ctx, key, body, headers, metadata); err != nil {
+		return err
+	}
+	if obj, err := h.s.HeadObject(r.Context(), key); err == nil && len(obj.ETag) != 0 {
+		w.Header().Set("ETag", storage.QuoteETag(obj.ETag))
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// copySource returns the key of the x-amz-copy-source header, such as "/bucket/key" and "bucket/key?versionId=1".
+func (h *Handler) copySource(r *http.Request, bucket string) (string, error) {
+	source, _, _ := strings.Cut(r.Header.Get("X-Amz-Copy-Source"), "?")
+	source, err := url.PathUnescape(source)
+	if err != nil {
+		return "", errors.NewError(http.StatusBadRequest, "invalid x-amz-copy-source", "InvalidArgument")
+	}
+	srcBucket, srcKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	if len(srcKey) == 0 {
+		return "", errors.NewError(http.StatusBadRequest, "invalid x-amz-copy-source", "InvalidArgument")
+	}
+	if srcBucket != bucket {
+		return "", errNoSuchBucket
+	}
+	return srcKey, nil
+}
+
+func (h *Handler) copyObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	srcKey, err := h.copySource(r, bucket)
+	if err != nil {
+		return err
+	}
+	ctx := r.Context()
+	if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+		src, err := h.s.GetObject(ctx, srcKey)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		headers, metadata := requestHeaders(r)
+		err = h.s.PutObject(ctx, key, src, headers, metadata)
+		if err != nil {
+			return err
+		}
+	} else if err = h.s.CopyObject(ctx, srcKey, key); err != nil {
+		return err
+	}
+	obj, err := h.s.HeadObject(ctx, key)
+	if err != nil {
+		return err
+	}
+	return writeXML(w, http.StatusOK, copyObjectResult{
+		Xmlns:        xmlns,
+		ETag:         storage.QuoteETag(obj.ETag),
+		LastModified: formatTime(obj.LastModified),
+	})
+}
+
+func (h *Handler) initiateMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	headers, metadata := requestHeaders(r)
+	uploadID, err := h.uploader.InitiateMultipartUpload(r.Context(), key, headers, metadata)
+	if err != nil {
+		return err
+	}
+	return writeXML(w, http.StatusOK, initiateMultipartUploadResult{Xmlns: xmlns, Bucket: bucket, Key: key, UploadID: uploadID})
+}
+
+func (h *Handler) uploadPart(w http.ResponseWriter, r *http.Request, key, uploadID string) error {
+	partNumber, err := strconv.ParseInt(r.URL.Query().Get("partNumber"), 10, 32)
+	if err != nil {
+		return errors.NewError(http.StatusBadRequest, "invalid partNumber", "InvalidArgument")
+	}
+	body, size, err := requestBody(r)
+	if err != nil {
+		return errors.NewError(http.StatusBadRequest, err.Error(), "InvalidArgument")
+	}
+	part, err := h.uploader.UploadPart(r.Context(), key, uploadID, int32(partNumber), body, size)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", storage.QuoteETag(part.ETag))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (h *Handler) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) error {
+	var req completeMultipartUpload
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		return errMalformedXML
+	}
+	parts := make([]storage.Part, len(req.Parts))
+	for i, part := range req.Parts {
+		parts[i] = storage.Part{PartNumber: part.PartNumber, ETag: strings.Trim(part.ETag, `"`)}
+	}
+	if err := h.uploader.CompleteMultipartUpload(r.Context(), key, uploadID, parts); err != nil {
+		return err
+	}
+	result := completeMultipartUploadResult{Xmlns: xmlns, Location: "/" + bucket + "/" + key, Bucket: bucket, Key: key}
+	if obj, err := h.s.HeadObject(r.Context(), key); err == nil {
+		result.ETag = storage.QuoteETag(obj.ETag)
+	}
+	return writeXML(w, http.StatusOK, result)
+}
+
+func (h *Handler) listMultipartUploads(w http.ResponseWriter, r *http.Request, bucket string) error {
+	prefix := r.URL.Query().Get("prefix")
+	uploads, err := h.uploader.ListMultipartUploads(r.Context(), prefix)
+	if err != nil {
+		return err
+	}
+	result := listMultipartUploadsResult{Xmlns: xmlns, Bucket: bucket, Prefix: prefix}
+	for _, u := range uploads {
+		result.Uploads = append(result.Uploads, upload{Key: u.Key, UploadID: u.UploadID, Initiated: formatTime(u.Initiated)})
+	}
+	return writeXML(w, http.StatusOK, result)
+}
+
+func writeXML(w http.ResponseWriter, status int, v interface{}) error {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(data)
+	return nil
+}
+
+// errorCode returns the S3 error code, the status code and the message of err.
+func errorCode(err error) (code string, status int, message string) {
+	var pe *errors.PreconditionFailedError
+	if errors.As(err, &pe) {
+		if pe.NotModified {
+			return errNotModified.Code(), http.StatusNotModified, err.Error()
+		}
+		return "PreconditionFailed", http.StatusPreconditionFailed, err.Error()
+	}
+	if errors.Is(err, storage.ErrConditionNotSupported) {
+		return "NotImplemented", http.StatusNotImplemented, err.Error()
+	}
+	if errors.Is(err, storage.ErrNoSuchUpload) {
+		return errNoSuchUpload.Code(), http.StatusNotFound, errNoSuchUpload.Error()
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return errNoSuchKey.Code(), http.StatusNotFound, errNoSuchKey.Error()
+	}
+	var e errors.Error
+	if errors.As(err, &e) && e.StatusCode() >= 300 && e.StatusCode() < 600 {
+		return e.Code(), e.StatusCode(), e.Error()
+	}
+	return "InternalError", http.StatusInternalServerError, "we encountered an internal error, please try again"
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	code, status, message := errorCode(err)
+	if status == http.StatusInternalServerError {
+		level.Error(log.GetContextLogger(r.Context())).Log("msg", "failed to serve S3 request", "method", r.Method, "path", r.URL.Path, "err", err)
+	}
+	// the responses of HEAD requests and 304 have no body.
+	if r.Method == http.MethodHead || status == http.StatusNotModified {
+		w.WriteHeader(status)
+		return
+	}
+	_ = writeXML(w, status, errorResponse{Code: code, Message: message, Resource: r.URL.Path})
+}
@@ -0,0 +1,128 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/MicroOps-cn/fuck/clients/storage"
+	fsstorage "github.com/MicroOps-cn/fuck/clients/storage/fs"
+	"github.com/MicroOps-cn/fuck/clients/storage/s3"
+	"github.com/MicroOps-cn/fuck/errors"
+)
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+	backend, err := fsstorage.NewClient(ctx, nil, storage.NewMapConfigProvider(map[string]interface{}{"type": "in-memory"}))
+	require.NoError(t, err)
+	h, err := New(backend, Options{Bucket: "test", UploadDir: t.TempDir()})
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	c, err := s3.NewClient(ctx, storage.NewMapConfigProvider(map[string]interface{}{
+		"endpoint":          srv.URL,
+		"region":            "us-east-1",
+		"bucket":            "test",
+		"access_key_id":     "test",
+		"secret_access_key": "test",
+		"force_path_style":  true,
+	}))
+	require.NoError(t, err)
+	readObject := func(r *storage.ObjectReader, err error) string {
+		require.NoError(t, err)
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	headers := http.Header{"Content-Type": {"text/plain"}}
+	require.NoError(t, c.PutObject(ctx, "a/b.txt", strings.NewReader("hello world"), headers, map[string]string{"owner": "test"}))
+	require.Equal(t, "hello world", readObject(c.GetObject(ctx, "a/b.txt")))
+	require.Equal(t, "o w", readObject(c.GetObjectRange(ctx, "a/b.txt", 4, 3)))
+	head, err := c.HeadObject(ctx, "a/b.txt")
+	require.NoError(t, err)
+	require.Equal(t, int64(11), head.Size)
+	require.Equal(t, "5eb63bbbe01eeed093cb22bb8f5acdc3", head.ETag)
+	require.Equal(t, "text/plain", head.Headers.Get("Content-Type"))
+	require.Equal(t, map[string]string{"owner": "test"}, head.Metadata)
+
+	create := storage.WithCondition(ctx, storage.Condition{IfNoneMatch: "*"})
+	err = c.PutObject(create, "a/b.txt", strings.NewReader("again"), nil, nil)
+	require.True(t, errors.IsPreconditionFailed(err), err)
+	_, err = c.GetObject(storage.WithCondition(ctx, storage.Condition{IfNoneMatch: head.ETag}), "a/b.txt")
+	require.True(t, errors.IsPreconditionFailed(err), err)
+
+	require.NoError(t, c.CopyObject(ctx, "a/b.txt", "c.txt"))
+	require.Equal(t, "hello world", readObject(c.GetObject(ctx, "c.txt")))
+
+	// the parts of the multipart upload are staged by the gateway.
+	large := bytes.Repeat([]byte("0123456789"), 1200*1024)
+	require.NoError(t, c.PutObject(ctx, "large.bin", bytes.NewReader(large), nil, nil))
+	head, err = c.HeadObject(ctx, "large.bin")
+	require.NoError(t, err)
+	require.Equal(t, int64(len(large)), head.Size)
+	uploads, err := c.ListMultipartUploads(ctx, "")
+	require.NoError(t, err)
+	require.Empty(t, uploads)
+
+	var keys []string
+	require.NoError(t, c.ListObjects(ctx, storage.ListOptions{Delimiter: "/", MaxKeys: 1}, func(page *storage.ListPage) error {
+		for _, obj := range page.Objects {
+			keys = append(keys, obj.Key)
+		}
+		keys = append(keys, page.CommonPrefixes...)
+		return nil
+	}))
+	sort.Strings(keys)
+	require.Equal(t, []string{"a/", "c.txt", "large.bin"}, keys)
+
+	require.NoError(t, c.DeleteObjects(ctx, []string{"c.txt", "large.bin"}))
+	require.NoError(t, c.DeleteObject(ctx, "a/b.txt"))
+	_, err = c.HeadObject(ctx, "a/b.txt")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = c.GetObject(ctx, "a/b.txt")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestParseRange(t *testing.T) {
+	for spec, expected := range map[string][2]int64{
+		"bytes=0-9":   {0, 10},
+		"bytes=5-":    {5, 5},
+		"bytes=-3":    {7, 3},
+		"bytes=-20":   {0, 10},
+		"bytes=8-100": {8, 2},
+	} {
+		offset, length, err := parseRange(spec, 10)
+		require.NoError(t, err, spec)
+		require.Equal(t, expected, [2]int64{offset, length}, spec)
+	}
+	for _, spec := range []string{"bytes=10-", "bytes=5-4", "bytes=0-1,3-4", "items=0-1", "bytes=-0"} {
+		_, _, err := parseRange(spec, 10)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestChunkedReader(t *testing.T) {
+	body := "5;chunk-signature=abc\r\nhello\r\n6;chunk-signature=def\r\n world\r\n0;chunk-signature=ghi\r\nx-amz-checksum-crc32:AAAAAA==\r\n\r\n"
+	data, err := io.ReadAll(&chunkedReader{r: bufioReader(body)})
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(data))
+
+	_, err = io.ReadAll(&chunkedReader{r: bufioReader("5\r\nhel")})
+	require.Error(t, err)
+}
+
+func bufioReader(s string) *bufio.Reader {
+	return bufio.NewReader(strings.NewReader(s))
+}
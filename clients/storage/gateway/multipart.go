@@ -0,0 +1,243 @@
+package gateway
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	stdtime "time"
+
+	"github.com/MicroOps-cn/fuck/clients/storage"
+	"github.com/MicroOps-cn/fuck/errors"
+)
+
+var (
+	errNoSuchUpload = errors.NewError(http.StatusNotFound, "the specified multipart upload does not exist", "NoSuchUpload")
+	errInvalidPart  = errors.NewError(http.StatusBadRequest, "one or more of the specified parts could not be found", "InvalidPart")
+)
+
+// stagingUploader implements the multipart uploads for the storages that cannot upload an object in parts.
+// The parts are staged in a local directory, and the object is written by PutObject of the storage when the
+// upload is completed.
+type stagingUploader struct {
+	s   storage.Storage
+	dir string
+}
+
+type stagingUpload struct {
+	Key       string            `json:"key"`
+	Initiated stdtime.Time      `json:"initiated"`
+	Headers   http.Header       `json:"headers,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+const uploadFile = "upload.json"
+
+func (u *stagingUploader) uploadDir(uploadID string) (string, error) {
+	if len(uploadID) == 0 || strings.ContainsAny(uploadID, `/\.`) {
+		return "", errNoSuchUpload
+	}
+	return filepath.Join(u.dir, uploadID), nil
+}
+
+func (u *stagingUploader) load(objectPath, uploadID string) (string, *stagingUpload, error) {
+	dir, err := u.uploadDir(uploadID)
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, uploadFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil, errNoSuchUpload
+		}
+		return "", nil, err
+	}
+	var upload stagingUpload
+	if err = json.Unmarshal(data, &upload); err != nil {
+		return "", nil, err
+	}
+	if upload.Key != objectPath {
+		return "", nil, errNoSuchUpload
+	}
+	return dir, &upload, nil
+}
+
+func (u *stagingUploader) InitiateMultipartUpload(_ context.Context, objectPath string, headers http.Header, metadata map[string]string) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	uploadID := hex.EncodeToString(id)
+	data, err := json.Marshal(stagingUpload{Key: objectPath, Initiated: stdtime.Now(), Headers: headers, Metadata: metadata})
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(u.dir, uploadID)
+	if err = os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	if err = os.WriteFile(filepath.Join(dir, uploadFile), data, 0o600); err != nil {
+		return "", err
+	}
+	return uploadID, nil
+}
+
+func partFile(dir string, partNumber int32) string {
+	return filepath.Join(dir, fmt.Sprintf("%05d.part", partNumber))
+}
+
+func (u *stagingUploader) UploadPart(_ context.Context, objectPath, uploadID string, partNumber int32, body io.Reader, _ int64) (storage.Part, error) {
+	if partNumber < 1 || partNumber > storage.MaxParts {
+		return storage.Part{}, errors.NewError(http.StatusBadRequest, fmt.Sprintf("part number must be between 1 and %d", storage.MaxParts), "InvalidArgument")
+	}
+	dir, _, err := u.load(objectPath, uploadID)
+	if err != nil {
+		return storage.Part{}, err
+	}
+	tmp, err := os.CreateTemp(dir, ".part-*.tmp")
+	if err != nil {
+		return storage.Part{}, err
+	}
+	defer os.Remove(tmp.Name())
+	hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return storage.Part{}, err
+	}
+	if err = os.Rename(tmp.Name(), partFile(dir, partNumber)); err != nil {
+		return storage.Part{}, err
+	}
+	return storage.Part{PartNumber: partNumber, ETag: hex.EncodeToString(hash.Sum(nil)), Size: size}, nil
+}
+
+func (u *stagingUploader) CompleteMultipartUpload(ctx context.Context, objectPath, uploadID string, parts []storage.Part) error {
+	dir, upload, err := u.load(objectPath, uploadID)
+	if err != nil {
+		return err
+	}
+	files := make([]string, len(parts))
+	for i, part := range parts {
+		if i > 0 && part.PartNumber <= parts[i-1].PartNumber {
+			return errors.NewError(http.StatusBadRequest, "the list of parts was not in ascending order", "InvalidPartOrder")
+		}
+		files[i] = partFile(dir, part.PartNumber)
+		etag, err := fileMD5(files[i])
+		if err != nil {
+			if os.IsNotExist(err) {
+				return errInvalidPart
+			}
+			return err
+		}
+		if etag != strings.Trim(part.ETag, `"`) {
+			return errInvalidPart
+		}
+	}
+	r := &filesReader{files: files}
+	defer r.Close()
+	if err = u.s.PutObject(ctx, objectPath, r, upload.Headers, upload.Metadata); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+func (u *stagingUploader) AbortMultipartUpload(_ context.Context, objectPath, uploadID string) error {
+	dir, _, err := u.load(objectPath, uploadID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+func (u *stagingUploader) ListMultipartUploads(_ context.Context, objectPrefix string) ([]storage.MultipartUpload, error) {
+	entries, err := os.ReadDir(u.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var uploads []storage.MultipartUpload
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(u.dir, entry.Name(), uploadFile))
+		if err != nil {
+			continue
+		}
+		var upload stagingUpload
+		if err = json.Unmarshal(data, &upload); err != nil || !strings.HasPrefix(upload.Key, objectPrefix) {
+			continue
+		}
+		uploads = append(uploads, storage.MultipartUpload{Key: upload.Key, UploadID: entry.Name(), Initiated: upload.Initiated})
+	}
+	sort.Slice(uploads, func(i, j int) bool {
+		if uploads[i].Key != uploads[j].Key {
+			return uploads[i].Key < uploads[j].Key
+		}
+		return uploads[i].Initiated.Before(uploads[j].Initiated)
+	})
+	return uploads, nil
+}
+
+func fileMD5(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := md5.New()
+	if _, err = io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// filesReader reads the files one after another, only one of them is open at a time.
+type filesReader struct {
+	files   []string
+	current *os.File
+}
+
+func (r *filesReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.files) == 0 {
+				return 0, io.EOF
+			}
+			f, err := os.Open(r.files[0])
+			if err != nil {
+				return 0, err
+			}
+			r.current, r.files = f, r.files[1:]
+		}
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *filesReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
@@ -0,0 +1,334 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	stdtime "time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/MicroOps-cn/fuck/errors"
+	"github.com/MicroOps-cn/fuck/log"
+)
+
+const instrumentationName = "github.com/MicroOps-cn/fuck/clients/storage"
+
+var (
+	operationDurationHistogramVec = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "storage_operation_duration_seconds",
+		Help:    "The latency of the storage operations, partitioned by backend type and operation.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"type", "operation"})
+	operationBytesCounterVec = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_operation_bytes_total",
+		Help: "The total number of bytes read and written by the storage operations, partitioned by backend type and operation.",
+	}, []string{"type", "operation"})
+	operationErrorsCounterVec = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_operation_errors_total",
+		Help: "The total number of failed storage operations, partitioned by backend type, operation and error class.",
+	}, []string{"type", "operation", "class"})
+)
+
+func init() {
+	prometheus.MustRegister(operationDurationHistogramVec, operationBytesCounterVec, operationErrorsCounterVec)
+}
+
+// The classes of the errors returned by ErrorClass.
+const (
+	ErrorClassNotFound           = "not_found"
+	ErrorClassPermission         = "permission"
+	ErrorClassPreconditionFailed = "precondition_failed"
+	ErrorClassCanceled           = "canceled"
+	ErrorClassTimeout            = "timeout"
+	// ErrorClassUnavailable is the class of the errors of a backend that is temporarily unavailable, such as the
+	// 5xx and 429 responses, the failed connections and ErrCircuitOpen.
+	ErrorClassUnavailable = "unavailable"
+	ErrorClassOther       = "other"
+)
+
+// ErrorClass returns the class of an error returned by a Storage, it is the label of the error counter.
+func ErrorClass(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return ErrorClassNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return ErrorClassPermission
+	case errors.IsPreconditionFailed(err):
+		return ErrorClassPreconditionFailed
+	case errors.Is(err, context.Canceled):
+		return ErrorClassCanceled
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorClassTimeout
+	case isUnavailable(err):
+		return ErrorClassUnavailable
+	default:
+		return ErrorClassOther
+	}
+}
+
+func isUnavailable(err error) bool {
+	var status int
+	var awsErr interface{ HTTPStatusCode() int }
+	var ossErr interface{ HttpStatusCode() int }
+	switch {
+	case errors.Is(err, ErrCircuitOpen), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET):
+		return true
+	case errors.As(err, &awsErr):
+		status = awsErr.HTTPStatusCode()
+	case errors.As(err, &ossErr):
+		status = ossErr.HttpStatusCode()
+	}
+	return status >= http.StatusInternalServerError || status == http.StatusTooManyRequests
+}
+
+// instrumentedStorage records a span, the latency, the bytes and the errors of every operation of the backend.
+type instrumentedStorage struct {
+	Storage
+}
+
+// Instrument returns s with OpenTelemetry spans, Prometheus metrics and logs of its operations. NewClient instruments
+// the backends unless their config sets instrument to false, the storages assembled by hand, such as the decorators, can be
+// instrumented with it. Use As to get the optional interfaces of the instrumented storage.
+func Instrument(s Storage) Storage {
+	if _, ok := s.(*instrumentedStorage); ok {
+		return s
+	}
+	return &instrumentedStorage{Storage: s}
+}
+
+func (s *instrumentedStorage) Unwrap() Storage {
+	return s.Storage
+}
+
+// As returns s as T, such as MultipartUploader, Presigner or Watcher, looking through the layers added by Instrument
+// and WithResilience. The other decorators are not looked through, since their optional interfaces would bypass them.
+func As[T any](s Storage) (T, bool) {
+	for {
+		if t, ok := s.(T); ok {
+			return t, true
+		}
+		switch ws := s.(type) {
+		case *instrumentedStorage:
+			s = ws.Storage
+		case *resilientStorage:
+			s = ws.Storage
+		default:
+			var zero T
+			return zero, false
+		}
+	}
+}
+
+type operation struct {
+	s      *instrumentedStorage
+	name   string
+	key    string
+	begin  stdtime.Time
+	span   trace.Span
+	logger kitlog.Logger
+}
+
+func (s *instrumentedStorage) start(ctx context.Context, name, key string, attrs ...attribute.KeyValue) (context.Context, *operation) {
+	op := &operation{s: s, name: name, key: key, begin: stdtime.Now(), logger: log.GetContextLogger(ctx)}
+	ctx, op.span = otel.GetTracerProvider().Tracer(instrumentationName).Start(ctx, "Storage."+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append([]attribute.KeyValue{
+			attribute.String("storage.type", s.Type()),
+			attribute.String("storage.bucket", s.Name()),
+			attribute.String("storage.key", key),
+		}, attrs...)...),
+	)
+	return ctx, op
+}
+
+// end records the operation, bytes < 0 means the operation does not transfer the content of the objects.
+func (op *operation) end(bytes int64, err error) {
+	defer op.span.End()
+	elapsed := stdtime.Since(op.begin)
+	typ := op.s.Type()
+	operationDurationHistogramVec.WithLabelValues(typ, op.name).Observe(elapsed.Seconds())
+	if bytes >= 0 {
+		operationBytesCounterVec.WithLabelValues(typ, op.name).Add(float64(bytes))
+		op.span.SetAttributes(attribute.Int64("storage.bytes", bytes))
+	}
+	if err == nil {
+		op.span.SetStatus(codes.Ok, "")
+		level.Debug(op.logger).Log("msg", "storage operation", "storage", op.s.Name(), "operation", op.name, "key", op.key, "bytes", bytes, "duration", elapsed)
+		return
+	}
+	class := ErrorClass(err)
+	operationErrorsCounterVec.WithLabelValues(typ, op.name, class).Inc()
+	op.span.SetAttributes(attribute.String("storage.error_class", class))
+	op.span.SetStatus(codes.Error, err.Error())
+	logger := level.Error(op.logger)
+	switch class {
+	case ErrorClassNotFound, ErrorClassPreconditionFailed, ErrorClassCanceled:
+		// the expected failures, such as probing whether an object exists, are not worth an error log.
+		logger = level.Debug(op.logger)
+	}
+	logger.Log("msg", "failed to execute storage operation", "storage", op.s.Name(), "operation", op.name, "key", op.key, "class", class, "duration", elapsed, "err", err)
+}
+
+func (s *instrumentedStorage) ListObject(ctx context.Context, objectPrefix string, recursion bool, callback func(key Object)) (err error) {
+	ctx, op := s.start(ctx, "ListObject", objectPrefix, attribute.Bool("storage.recursion", recursion))
+	defer func() { op.end(-1, err) }()
+	return s.Storage.ListObject(ctx, objectPrefix, recursion, callback)
+}
+
+func (s *instrumentedStorage) ListObjects(ctx context.Context, o ListOptions, callback func(page *ListPage) error) (err error) {
+	ctx, op := s.start(ctx, "ListObjects", o.Prefix, attribute.String("storage.delimiter", o.Delimiter))
+	defer func() { op.end(-1, err) }()
+	return s.Storage.ListObjects(ctx, o, callback)
+}
+
+func (s *instrumentedStorage) HeadObject(ctx context.Context, objectPath string) (obj *Object, err error) {
+	ctx, op := s.start(ctx, "HeadObject", objectPath)
+	defer func() { op.end(-1, err) }()
+	return s.Storage.HeadObject(ctx, objectPath)
+}
+
+// GetObject records the bytes read from the object, the operation ends when the reader is closed.
+func (s *instrumentedStorage) GetObject(ctx context.Context, objectPath string) (*ObjectReader, error) {
+	ctx, op := s.start(ctx, "GetObject", objectPath)
+	r, err := s.Storage.GetObject(ctx, objectPath)
+	if err != nil {
+		op.end(0, err)
+		return nil, err
+	}
+	return countReads(r, op), nil
+}
+
+// GetObjectRange records the bytes read from the range, the operation ends when the reader is closed.
+func (s *instrumentedStorage) GetObjectRange(ctx context.Context, objectPath string, offset, length int64) (*ObjectReader, error) {
+	ctx, op := s.start(ctx, "GetObjectRange", objectPath, attribute.Int64("storage.offset", offset), attribute.Int64("storage.length", length))
+	r, err := s.Storage.GetObjectRange(ctx, objectPath, offset, length)
+	if err != nil {
+		op.end(0, err)
+		return nil, err
+	}
+	return countReads(r, op), nil
+}
+
+// countReads returns a reader of r that ends op with the bytes read from it when it is closed.
+func countReads(r *ObjectReader, op *operation) *ObjectReader {
+	return &ObjectReader{ReadCloser: &countingObjectReader{ObjectReader: r, op: op}, Object: r.Object, Offset: r.Offset}
+}
+
+// countingObjectReader counts the bytes read from an ObjectReader, whose Seek and ReadAt are kept so that the reader
+// returned by countReads does not reopen the object to seek.
+type countingObjectReader struct {
+	*ObjectReader
+	n    atomic.Int64
+	once sync.Once
+	op   *operation
+}
+
+func (r *countingObjectReader) Read(p []byte) (int, error) {
+	n, err := r.ObjectReader.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
+
+func (r *countingObjectReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.ObjectReader.ReadAt(p, off)
+	r.n.Add(int64(n))
+	return n, err
+}
+
+func (r *countingObjectReader) Close() error {
+	err := r.ObjectReader.Close()
+	r.once.Do(func() { r.op.end(r.n.Load(), err) })
+	return err
+}
+
+type countingReader struct {
+	io.Reader
+	n atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
+
+// PutObject counts the bytes read from obj. The readers that the backends may upload in parallel or rewind, which
+// implement io.Seeker and io.ReaderAt, are passed as is and their remaining length is recorded instead.
+func (s *instrumentedStorage) PutObject(ctx context.Context, objectPath string, obj io.Reader, headers http.Header, metadata map[string]string) (err error) {
+	ctx, op := s.start(ctx, "PutObject", objectPath)
+	var bytes func() int64
+	if rs, ok := obj.(interface {
+		io.Seeker
+		io.ReaderAt
+	}); ok {
+		size, sizeErr := remaining(rs)
+		bytes = func() int64 {
+			if sizeErr != nil {
+				return 0
+			}
+			return size
+		}
+	} else {
+		cr := &countingReader{Reader: obj}
+		obj, bytes = cr, cr.n.Load
+	}
+	defer func() {
+		if err != nil {
+			op.end(0, err)
+		} else {
+			op.end(bytes(), nil)
+		}
+	}()
+	return s.Storage.PutObject(ctx, objectPath, obj, headers, metadata)
+}
+
+func remaining(s io.Seeker) (int64, error) {
+	cur, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	end, err := s.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = s.Seek(cur, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return end - cur, nil
+}
+
+func (s *instrumentedStorage) DeleteObject(ctx context.Context, objectPath string) (err error) {
+	ctx, op := s.start(ctx, "DeleteObject", objectPath)
+	defer func() { op.end(-1, err) }()
+	return s.Storage.DeleteObject(ctx, objectPath)
+}
+
+func (s *instrumentedStorage) DeleteObjects(ctx context.Context, objectPaths []string) (err error) {
+	var key string
+	if len(objectPaths) != 0 {
+		key = objectPaths[0]
+	}
+	ctx, op := s.start(ctx, "DeleteObjects", key, attribute.Int("storage.objects", len(objectPaths)))
+	defer func() { op.end(-1, err) }()
+	return s.Storage.DeleteObjects(ctx, objectPaths)
+}
+
+func (s *instrumentedStorage) CopyObject(ctx context.Context, srcPath, dstPath string) (err error) {
+	ctx, op := s.start(ctx, "CopyObject", srcPath, attribute.String("storage.destination_key", dstPath))
+	defer func() { op.end(-1, err) }()
+	return s.Storage.CopyObject(ctx, srcPath, dstPath)
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/MicroOps-cn/fuck/errors"
+)
+
+type watchingStorage struct {
+	*memStorage
+}
+
+func (w watchingStorage) Watch(context.Context, string) (<-chan Event, error) {
+	return nil, ErrWatchNotSupported
+}
+
+// durationSampleCount returns the number of the samples of the operation duration histogram of the labels.
+func durationSampleCount(t *testing.T, typ, op string) uint64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != "storage_operation_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["type"] == typ && labels["operation"] == op {
+				return metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return 0
+}
+
+func TestInstrument(t *testing.T) {
+	ctx := context.Background()
+	s := Instrument(newMemStorage(map[string]string{"a.txt": "hello"}))
+	require.Equal(t, s, Instrument(s))
+
+	bytes := func(op string) float64 {
+		return testutil.ToFloat64(operationBytesCounterVec.WithLabelValues("memory", op))
+	}
+	before := bytes("PutObject")
+	require.NoError(t, s.PutObject(ctx, "b.txt", strings.NewReader("world"), nil, nil))
+	require.NoError(t, s.PutObject(ctx, "c.txt", io.MultiReader(strings.NewReader("hello "), strings.NewReader("world")), nil, nil))
+	require.Equal(t, before+16, bytes("PutObject"))
+
+	before = bytes("GetObject")
+	r, err := s.GetObject(ctx, "a.txt")
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, before, bytes("GetObject"))
+	// the operation ends when the reader is closed.
+	samples := durationSampleCount(t, "memory", "GetObject")
+	r, err = s.GetObject(ctx, "a.txt")
+	require.NoError(t, err)
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(r, buf)
+	require.NoError(t, err)
+	require.Equal(t, before, bytes("GetObject"))
+	require.Equal(t, samples, durationSampleCount(t, "memory", "GetObject"))
+	require.NoError(t, r.Close())
+	require.Equal(t, samples+1, durationSampleCount(t, "memory", "GetObject"))
+	require.NoError(t, r.Close())
+	require.Equal(t, before+2, bytes("GetObject"))
+
+	before = bytes("GetObjectRange")
+	r, err = s.GetObjectRange(ctx, "a.txt", 1, -1)
+	require.NoError(t, err)
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "ello", string(content))
+	require.NoError(t, r.Close())
+	require.Equal(t, before+4, bytes("GetObjectRange"))
+
+	errorsCounter := operationErrorsCounterVec.WithLabelValues("memory", "HeadObject", ErrorClassNotFound)
+	notFound := testutil.ToFloat64(errorsCounter)
+	_, err = s.HeadObject(ctx, "missing.txt")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	require.Equal(t, notFound+1, testutil.ToFloat64(errorsCounter))
+}
+
+func TestErrorClass(t *testing.T) {
+	require.Equal(t, ErrorClassNotFound, ErrorClass(&fs.PathError{Op: "open", Path: "a", Err: fs.ErrNotExist}))
+	require.Equal(t, ErrorClassPreconditionFailed, ErrorClass(&errors.PreconditionFailedError{}))
+	require.Equal(t, ErrorClassTimeout, ErrorClass(context.DeadlineExceeded))
+	require.Equal(t, ErrorClassUnavailable, ErrorClass(fmt.Errorf("get a.txt: %w", statusError(503))))
+	require.Equal(t, ErrorClassOther, ErrorClass(statusError(400)))
+}
+
+func TestAs(t *testing.T) {
+	_, ok := As[Watcher](Instrument(newMemStorage(nil)))
+	require.False(t, ok)
+	_, ok = As[Watcher](Instrument(watchingStorage{newMemStorage(nil)}))
+	require.True(t, ok)
+}
+
+func TestNewClient_Instrument(t *testing.T) {
+	RegisterStorage("instrument-test", func(context.Context, ConfigProvider) (Storage, error) {
+		return newMemStorage(nil), nil
+	})
+	s, err := NewClient(context.Background(), NewMapConfigProvider(map[string]interface{}{"type": "instrument-test"}))
+	require.NoError(t, err)
+	require.IsType(t, &instrumentedStorage{}, s)
+	_, ok := As[*memStorage](s)
+	require.True(t, ok)
+
+	s, err = NewClient(context.Background(), NewMapConfigProvider(map[string]interface{}{"type": "instrument-test", "instrument": false}))
+	require.NoError(t, err)
+	require.IsType(t, &memStorage{}, s)
+
+	_, err = NewClient(context.Background(), NewMapConfigProvider(map[string]interface{}{"type": "instrument-test", "instrument": "maybe"}))
+	require.Error(t, err)
+}
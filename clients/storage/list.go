@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// DefaultMaxKeys is the page size of ListObjects when ListOptions.MaxKeys is not set.
+const DefaultMaxKeys = 1000
+
+type ListOptions struct {
+	Prefix string
+	// Delimiter groups the keys that contain it after the prefix into CommonPrefixes, usually "/".
+	Delimiter string
+	// StartAfter lists the keys after it in lexicographical order.
+	StartAfter string
+	// MaxKeys is the maximum number of objects and common prefixes in a page.
+	MaxKeys int
+	// ContinuationToken resumes the listing from the NextContinuationToken of a previous page.
+	ContinuationToken string
+}
+
+func (o ListOptions) PageSize() int {
+	if o.MaxKeys <= 0 || o.MaxKeys > DefaultMaxKeys {
+		return DefaultMaxKeys
+	}
+	return o.MaxKeys
+}
+
+type ListPage struct {
+	Objects        []Object
+	CommonPrefixes []string
+	// NextContinuationToken is empty on the last page.
+	NextContinuationToken string
+}
+
+// ReadDir lists the direct children of name, the common prefixes are returned as directories.
+// The entries are named by the last element of their key and sorted by name, as fs.ReadDir does. A directory other
+// than the root without any key under it does not exist, as the prefixes of the object storages.
+func ReadDir(ctx context.Context, s Storage, name string) ([]fs.DirEntry, error) {
+	prefix := strings.Trim(name, "/")
+	if prefix == "." {
+		prefix = ""
+	} else if len(prefix) != 0 {
+		prefix += "/"
+	}
+	var entries []fs.DirEntry
+	err := s.ListObjects(ctx, ListOptions{Prefix: prefix, Delimiter: "/"}, func(page *ListPage) error {
+		for _, o := range page.Objects {
+			if o.Key == prefix {
+				continue
+			}
+			entries = append(entries, fs.FileInfoToDirEntry(&FileInfo{
+				name:    path.Base(o.Key),
+				size:    o.Size,
+				mode:    o.Mode &^ fs.ModeDir,
+				modTime: o.LastModified,
+			}))
+		}
+		for _, p := range page.CommonPrefixes {
+			entries = append(entries, fs.FileInfoToDirEntry(&FileInfo{
+				name: path.Base(p),
+				mode: fs.ModeDir | 0o755,
+			}))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 && len(prefix) != 0 {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	stdtime "time"
+)
+
+// memStorage is the in-memory Storage of the tests of the package, which cannot import the in-memory storage of
+// the fs package.
+type memStorage struct {
+	mux     sync.Mutex
+	objects map[string]Object
+	data    map[string][]byte
+}
+
+func newMemStorage(files map[string]string) *memStorage {
+	m := &memStorage{objects: map[string]Object{}, data: map[string][]byte{}}
+	for key, content := range files {
+		_ = m.PutObject(context.Background(), key, strings.NewReader(content), nil, nil)
+	}
+	return m
+}
+
+func (m *memStorage) Type() string {
+	return "memory"
+}
+
+func (m *memStorage) Name() string {
+	return "memory"
+}
+
+func (m *memStorage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{"type": m.Type()})
+}
+
+func (m *memStorage) Open(name string) (fs.File, error) {
+	return m.GetObject(context.Background(), name)
+}
+
+func (m *memStorage) ReadDir(name string) ([]fs.DirEntry, error) {
+	return ReadDir(context.Background(), m, name)
+}
+
+// keys returns the sorted keys of the objects under prefix.
+func (m *memStorage) keys(prefix string) []string {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	var keys []string
+	for key := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (m *memStorage) ListObject(_ context.Context, objectPrefix string, _ bool, callback func(key Object)) error {
+	for _, key := range m.keys(objectPrefix) {
+		m.mux.Lock()
+		obj, ok := m.objects[key]
+		m.mux.Unlock()
+		if ok {
+			callback(obj)
+		}
+	}
+	return nil
+}
+
+func (m *memStorage) ListObjects(ctx context.Context, o ListOptions, callback func(page *ListPage) error) error {
+	startAfter := o.StartAfter
+	if len(o.ContinuationToken) != 0 {
+		startAfter = o.ContinuationToken
+	}
+	var page ListPage
+	size, last := 0, ""
+	for _, key := range m.keys(o.Prefix) {
+		entry, obj := key, (*Object)(nil)
+		if i := strings.Index(key[len(o.Prefix):], o.Delimiter); len(o.Delimiter) != 0 && i >= 0 {
+			entry = key[:len(o.Prefix)+i+len(o.Delimiter)]
+		} else if head, err := m.HeadObject(ctx, key); err == nil {
+			obj = head
+		}
+		if entry <= startAfter || entry == last {
+			continue
+		}
+		if size == o.PageSize() {
+			page.NextContinuationToken = last
+			if err := callback(&page); err != nil {
+				if errors.Is(err, fs.SkipAll) {
+					return nil
+				}
+				return err
+			}
+			page, size = ListPage{}, 0
+		}
+		if obj != nil {
+			page.Objects = append(page.Objects, *obj)
+		} else {
+			page.CommonPrefixes = append(page.CommonPrefixes, entry)
+		}
+		size, last = size+1, entry
+	}
+	if err := callback(&page); err != nil && !errors.Is(err, fs.SkipAll) {
+		return err
+	}
+	return nil
+}
+
+func (m *memStorage) HeadObject(_ context.Context, objectPath string) (*Object, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	obj, ok := m.objects[objectPath]
+	if !ok {
+		return nil, &fs.PathError{Op: "head", Path: objectPath, Err: fs.ErrNotExist}
+	}
+	return &obj, nil
+}
+
+func (m *memStorage) GetObject(ctx context.Context, objectPath string) (*ObjectReader, error) {
+	return m.GetObjectRange(ctx, objectPath, 0, -1)
+}
+
+func (m *memStorage) GetObjectRange(ctx context.Context, objectPath string, offset, length int64) (*ObjectReader, error) {
+	obj, err := m.HeadObject(ctx, objectPath)
+	if err != nil {
+		return nil, err
+	}
+	m.mux.Lock()
+	data := m.data[objectPath]
+	m.mux.Unlock()
+	readRange := func(offset, length int64) (io.ReadCloser, error) {
+		if length < 0 {
+			length = int64(len(data)) - offset
+		}
+		return io.NopCloser(io.NewSectionReader(bytes.NewReader(data), offset, length)), nil
+	}
+	r, _ := readRange(offset, length)
+	return &ObjectReader{ReadCloser: r, Object: *obj, Offset: offset, ReadRange: readRange}, nil
+}
+
+func (m *memStorage) PutObject(_ context.Context, objectPath string, obj io.Reader, headers http.Header, metadata map[string]string) error {
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return err
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.data[objectPath] = data
+	m.objects[objectPath] = Object{
+		Key:          objectPath,
+		Size:         int64(len(data)),
+		LastModified: stdtime.Now(),
+		Headers:      headers,
+		Metadata:     metadata,
+	}
+	return nil
+}
+
+func (m *memStorage) DeleteObject(_ context.Context, objectPath string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	delete(m.objects, objectPath)
+	delete(m.data, objectPath)
+	return nil
+}
+
+func (m *memStorage) DeleteObjects(ctx context.Context, objectPaths []string) error {
+	for _, objectPath := range objectPaths {
+		if err := m.DeleteObject(ctx, objectPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memStorage) CopyObject(ctx context.Context, srcPath, dstPath string) error {
+	r, err := m.GetObject(ctx, srcPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return m.PutObject(ctx, dstPath, r, r.Headers, r.Metadata)
+}
+
+func (m *memStorage) files() map[string]string {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	files := map[string]string{}
+	for key, data := range m.data {
+		files[key] = string(data)
+	}
+	return files
+}
+
+var _ Storage = (*memStorage)(nil)
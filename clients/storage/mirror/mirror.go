@@ -0,0 +1,349 @@
+package mirror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"sync"
+	stdtime "time"
+
+	"github.com/go-kit/log/level"
+	"github.com/spf13/cast"
+
+	"github.com/MicroOps-cn/fuck/clients/storage"
+	"github.com/MicroOps-cn/fuck/log"
+)
+
+// DefaultFailoverCooldown is how long a failed backend is read after the healthy ones when Options.FailoverCooldown
+// is not set.
+const DefaultFailoverCooldown = 30 * stdtime.Second
+
+type Options struct {
+	// WriteQuorum is the number of backends that must accept a write, the default is all the backends.
+	// The failures of the other backends are logged.
+	WriteQuorum int `json:"write_quorum,omitempty" yaml:"write_quorum,omitempty" mapstructure:"write_quorum"`
+	// FailoverCooldown is how long a backend that failed a read is tried after the other backends.
+	FailoverCooldown stdtime.Duration `json:"failover_cooldown,omitempty" yaml:"failover_cooldown,omitempty" mapstructure:"failover_cooldown"`
+}
+
+// Storage writes every object to all the backends and reads it from the first healthy backend. A backend is unhealthy
+// for the FailoverCooldown after a read fails with an error other than not found, precondition failed and canceled.
+// With a WriteQuorum less than the number of backends, a backend may miss the objects written while it failed, so
+// that the objects not found are read from the other backends. The Condition of a write is checked by the first
+// backend only, the others are written unconditionally once the first backend has accepted the write.
+type Storage struct {
+	backends []storage.Storage
+	o        Options
+
+	mux            sync.Mutex
+	unhealthyUntil []stdtime.Time
+}
+
+func New(backends []storage.Storage, o Options) (*Storage, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("no backend")
+	}
+	if o.WriteQuorum <= 0 {
+		o.WriteQuorum = len(backends)
+	} else if o.WriteQuorum > len(backends) {
+		return nil, fmt.Errorf("write quorum %d is greater than the number of backends %d", o.WriteQuorum, len(backends))
+	}
+	if o.FailoverCooldown <= 0 {
+		o.FailoverCooldown = DefaultFailoverCooldown
+	}
+	return &Storage{backends: backends, o: o, unhealthyUntil: make([]stdtime.Time, len(backends))}, nil
+}
+
+// NewClient creates the mirror of the config, the backends are configured as:
+//
+//	write_quorum: 1
+//	failover_cooldown: 30s
+//	backends:
+//	  - {type: local, base: /data/storage}
+//	  - {type: s3, bucket: replica, ...}
+func NewClient(ctx context.Context, v storage.ConfigProvider) (*Storage, error) {
+	var backends []storage.Storage
+	for i := 0; ; i++ {
+		c := storage.SubConfig(v, fmt.Sprintf("backends.%d", i))
+		if len(c.GetString("type")) == 0 {
+			break
+		}
+		backend, err := storage.NewClient(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create backend %d: %w", i, err)
+		}
+		backends = append(backends, backend)
+	}
+	o := Options{WriteQuorum: v.GetInt("write_quorum")}
+	if cooldown := v.GetString("failover_cooldown"); len(cooldown) != 0 {
+		var err error
+		if o.FailoverCooldown, err = cast.ToDurationE(cooldown); err != nil {
+			return nil, fmt.Errorf("invalid failover_cooldown: %w", err)
+		}
+	}
+	return New(backends, o)
+}
+
+func (s *Storage) Type() string {
+	return "mirror"
+}
+
+func (s *Storage) Name() string {
+	return fmt.Sprintf("%s://%s", s.Type(), s.backends[0].Name())
+}
+
+func (s *Storage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"type":              s.Type(),
+		"write_quorum":      s.o.WriteQuorum,
+		"failover_cooldown": s.o.FailoverCooldown.String(),
+		"backends":          s.backends,
+	})
+}
+
+// Backends returns the backends in the order they are read.
+func (s *Storage) Backends() []storage.Storage {
+	return append([]storage.Storage(nil), s.backends...)
+}
+
+// order returns the indexes of the healthy backends followed by the unhealthy ones.
+func (s *Storage) order() []int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	now := stdtime.Now()
+	healthy := make([]int, 0, len(s.backends))
+	var unhealthy []int
+	for i, until := range s.unhealthyUntil {
+		if now.Before(until) {
+			unhealthy = append(unhealthy, i)
+		} else {
+			healthy = append(healthy, i)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+func (s *Storage) markUnhealthy(i int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.unhealthyUntil[i] = stdtime.Now().Add(s.o.FailoverCooldown)
+}
+
+func (s *Storage) markHealthy(i int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.unhealthyUntil[i] = stdtime.Time{}
+}
+
+// failover reports whether the read should be retried on the next backend.
+func failover(err error) bool {
+	switch storage.ErrorClass(err) {
+	case storage.ErrorClassNotFound, storage.ErrorClassPreconditionFailed, storage.ErrorClassCanceled:
+		return false
+	}
+	return true
+}
+
+// callbackError is an error returned by the callback of a listing, which is not a failure of the backend.
+type callbackError struct {
+	error
+}
+
+func (e callbackError) Unwrap() error {
+	return e.error
+}
+
+// read calls fn with the backends in order until it does not fail over, or retry returns false. The object not found
+// on a backend is read from the next one unless every write reaches all the backends.
+func (s *Storage) read(ctx context.Context, op string, retry func() bool, fn func(backend storage.Storage) error) error {
+	var errs []error
+	var notFound error
+	for _, i := range s.order() {
+		err := fn(s.backends[i])
+		var cbErr callbackError
+		if errors.As(err, &cbErr) {
+			return cbErr.error
+		}
+		if s.o.WriteQuorum < len(s.backends) && storage.ErrorClass(err) == storage.ErrorClassNotFound {
+			// the backend is healthy, but may have missed the write of the object.
+			s.markHealthy(i)
+			if notFound == nil {
+				notFound = err
+			}
+			if ctx.Err() != nil || (retry != nil && !retry()) {
+				break
+			}
+			continue
+		}
+		if err == nil || !failover(err) {
+			s.markHealthy(i)
+			return err
+		}
+		level.Warn(log.GetContextLogger(ctx)).Log("msg", "failed to read from mirror backend", "operation", op, "backend", s.backends[i].Name(), "err", err)
+		s.markUnhealthy(i)
+		errs = append(errs, err)
+		if ctx.Err() != nil || (retry != nil && !retry()) {
+			break
+		}
+	}
+	if len(errs) == 0 {
+		return notFound
+	}
+	// the object may exist on the backends that failed.
+	return errors.Join(errs...)
+}
+
+// write calls fn with all the backends, the first backend is called with ctx, the others without the Condition of ctx.
+// It fails if fewer than WriteQuorum backends succeed, or the first backend fails a conditional write, which is then
+// not applied to the other backends.
+func (s *Storage) write(ctx context.Context, op string, fn func(ctx context.Context, backend storage.Storage) error) error {
+	_, conditional := storage.ConditionFromContext(ctx)
+	var errs []error
+	succeeded := 0
+	for i, backend := range s.backends {
+		wctx := ctx
+		if i != 0 {
+			wctx = storage.WithoutCondition(ctx)
+		}
+		err := fn(wctx, backend)
+		if err == nil {
+			succeeded++
+			continue
+		}
+		if i == 0 && conditional {
+			// the other backends are only written once the first backend has checked the condition.
+			return err
+		}
+		level.Warn(log.GetContextLogger(ctx)).Log("msg", "failed to write to mirror backend", "operation", op, "backend", backend.Name(), "err", err)
+		errs = append(errs, fmt.Errorf("%s: %w", backend.Name(), err))
+	}
+	if succeeded < s.o.WriteQuorum {
+		return fmt.Errorf("%s succeeded on %d of %d backends, the write quorum is %d: %w",
+			op, succeeded, len(s.backends), s.o.WriteQuorum, errors.Join(errs...))
+	}
+	return nil
+}
+
+func (s *Storage) Open(name string) (fs.File, error) {
+	return s.GetObject(context.Background(), name)
+}
+
+func (s *Storage) ReadDir(name string) ([]fs.DirEntry, error) {
+	return storage.ReadDir(context.Background(), s, name)
+}
+
+// ListObject lists the objects of the first healthy backend, the listing does not fail over once the callback
+// has been called.
+func (s *Storage) ListObject(ctx context.Context, objectPrefix string, recursion bool, callback func(key storage.Object)) error {
+	var called bool
+	return s.read(ctx, "ListObject", func() bool { return !called }, func(backend storage.Storage) error {
+		return backend.ListObject(ctx, objectPrefix, recursion, func(obj storage.Object) {
+			called = true
+			callback(obj)
+		})
+	})
+}
+
+// ListObjects lists the objects of the first healthy backend, the continuation token of a page is only valid for
+// the backend that returned it.
+func (s *Storage) ListObjects(ctx context.Context, o storage.ListOptions, callback func(page *storage.ListPage) error) error {
+	var called bool
+	return s.read(ctx, "ListObjects", func() bool { return !called }, func(backend storage.Storage) error {
+		return backend.ListObjects(ctx, o, func(page *storage.ListPage) error {
+			called = true
+			if err := callback(page); err != nil {
+				return callbackError{err}
+			}
+			return nil
+		})
+	})
+}
+
+func (s *Storage) HeadObject(ctx context.Context, objectPath string) (obj *storage.Object, err error) {
+	err = s.read(ctx, "HeadObject", nil, func(backend storage.Storage) (err error) {
+		obj, err = backend.HeadObject(ctx, objectPath)
+		return err
+	})
+	return obj, err
+}
+
+func (s *Storage) GetObject(ctx context.Context, objectPath string) (r *storage.ObjectReader, err error) {
+	err = s.read(ctx, "GetObject", nil, func(backend storage.Storage) (err error) {
+		r, err = backend.GetObject(ctx, objectPath)
+		return err
+	})
+	return r, err
+}
+
+func (s *Storage) GetObjectRange(ctx context.Context, objectPath string, offset, length int64) (r *storage.ObjectReader, err error) {
+	err = s.read(ctx, "GetObjectRange", nil, func(backend storage.Storage) (err error) {
+		r, err = backend.GetObjectRange(ctx, objectPath, offset, length)
+		return err
+	})
+	return r, err
+}
+
+// PutObject writes obj to all the backends. The content is buffered in a temp file unless obj implements io.ReadSeeker.
+func (s *Storage) PutObject(ctx context.Context, objectPath string, obj io.Reader, headers http.Header, metadata map[string]string) error {
+	content, ok := obj.(io.ReadSeeker)
+	if len(s.backends) == 1 {
+		return s.write(ctx, "PutObject", func(ctx context.Context, backend storage.Storage) error {
+			return backend.PutObject(ctx, objectPath, obj, headers, metadata)
+		})
+	} else if !ok {
+		tmp, err := os.CreateTemp("", "storage-mirror-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if _, err = io.Copy(tmp, obj); err != nil {
+			return err
+		}
+		if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		content = tmp
+	}
+	start, err := content.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	return s.write(ctx, "PutObject", func(ctx context.Context, backend storage.Storage) error {
+		if _, err := content.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+		return backend.PutObject(ctx, objectPath, content, headers, metadata)
+	})
+}
+
+func (s *Storage) DeleteObject(ctx context.Context, objectPath string) error {
+	return s.write(ctx, "DeleteObject", func(ctx context.Context, backend storage.Storage) error {
+		return backend.DeleteObject(ctx, objectPath)
+	})
+}
+
+func (s *Storage) DeleteObjects(ctx context.Context, objectPaths []string) error {
+	return s.write(ctx, "DeleteObjects", func(ctx context.Context, backend storage.Storage) error {
+		return backend.DeleteObjects(ctx, objectPaths)
+	})
+}
+
+func (s *Storage) CopyObject(ctx context.Context, srcPath, dstPath string) error {
+	return s.write(ctx, "CopyObject", func(ctx context.Context, backend storage.Storage) error {
+		return backend.CopyObject(ctx, srcPath, dstPath)
+	})
+}
+
+func init() {
+	storage.RegisterStorage("mirror", func(ctx context.Context, v storage.ConfigProvider) (storage.Storage, error) {
+		return NewClient(ctx, v)
+	})
+}
+
+var _ storage.Storage = &Storage{}
@@ -0,0 +1,157 @@
+package mirror
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"testing"
+	stdtime "time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/MicroOps-cn/fuck/clients/storage"
+	_ "github.com/MicroOps-cn/fuck/clients/storage/fs"
+)
+
+type unavailableError struct{}
+
+func (unavailableError) Error() string {
+	return "service unavailable"
+}
+
+func (unavailableError) HTTPStatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+// downStorage fails the reads and writes of the objects as an unavailable backend while it is down.
+type downStorage struct {
+	storage.Storage
+	down bool
+}
+
+func (s *downStorage) GetObject(ctx context.Context, objectPath string) (*storage.ObjectReader, error) {
+	if s.down {
+		return nil, unavailableError{}
+	}
+	return s.Storage.GetObject(ctx, objectPath)
+}
+
+func (s *downStorage) PutObject(ctx context.Context, objectPath string, obj io.Reader, headers http.Header, metadata map[string]string) error {
+	if s.down {
+		return unavailableError{}
+	}
+	return s.Storage.PutObject(ctx, objectPath, obj, headers, metadata)
+}
+
+func newBackend(t *testing.T) storage.Storage {
+	s, err := storage.NewClient(context.Background(), storage.NewMapConfigProvider(map[string]interface{}{"type": "in-memory"}))
+	require.NoError(t, err)
+	return s
+}
+
+func readObject(t *testing.T, s storage.Storage, key string) string {
+	r, err := s.GetObject(context.Background(), key)
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestMirror(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewClient(ctx, storage.NewMapConfigProvider(map[string]interface{}{
+		"type":         "mirror",
+		"write_quorum": 1,
+		"backends": []interface{}{
+			map[string]interface{}{"type": "in-memory"},
+			map[string]interface{}{"type": "in-memory"},
+		},
+	}))
+	require.NoError(t, err)
+	m, ok := storage.As[*Storage](s)
+	require.True(t, ok)
+	backends := m.Backends()
+	require.Len(t, backends, 2)
+
+	// the content that cannot be rewound is buffered for every backend.
+	require.NoError(t, s.PutObject(ctx, "a.txt", io.MultiReader(strings.NewReader("hello")), nil, nil))
+	for _, backend := range backends {
+		require.Equal(t, "hello", readObject(t, backend, "a.txt"))
+	}
+	require.NoError(t, s.CopyObject(ctx, "a.txt", "b.txt"))
+	require.NoError(t, s.DeleteObject(ctx, "a.txt"))
+	for _, backend := range backends {
+		require.Equal(t, "hello", readObject(t, backend, "b.txt"))
+		_, err = backend.HeadObject(ctx, "a.txt")
+		require.Error(t, err)
+	}
+}
+
+func TestMirror_Failover(t *testing.T) {
+	ctx := context.Background()
+	primary, replica := newBackend(t), newBackend(t)
+	require.NoError(t, replica.PutObject(ctx, "a.txt", strings.NewReader("replica"), nil, nil))
+	s, err := New([]storage.Storage{&downStorage{Storage: primary, down: true}, replica}, Options{WriteQuorum: 1})
+	require.NoError(t, err)
+
+	require.Equal(t, "replica", readObject(t, s, "a.txt"))
+	require.Equal(t, []int{1, 0}, s.order())
+
+	// the write succeeds on the replica only, which meets the quorum.
+	require.NoError(t, s.PutObject(ctx, "b.txt", strings.NewReader("world"), nil, nil))
+	require.Equal(t, "world", readObject(t, replica, "b.txt"))
+
+	s, err = New([]storage.Storage{&downStorage{Storage: primary, down: true}, replica}, Options{})
+	require.NoError(t, err)
+	err = s.PutObject(ctx, "c.txt", strings.NewReader("world"), nil, nil)
+	require.ErrorContains(t, err, "succeeded on 1 of 2 backends")
+}
+
+func TestMirror_FailoverNotFound(t *testing.T) {
+	ctx := context.Background()
+	primary, replica := &downStorage{Storage: newBackend(t), down: true}, newBackend(t)
+	s, err := New([]storage.Storage{primary, replica}, Options{WriteQuorum: 1, FailoverCooldown: 10 * stdtime.Millisecond})
+	require.NoError(t, err)
+
+	// the write missed by the primary is read from the replica after the primary recovers.
+	require.NoError(t, s.PutObject(ctx, "a.txt", strings.NewReader("replica"), nil, nil))
+	require.Equal(t, "replica", readObject(t, s, "a.txt"))
+	require.Equal(t, []int{1, 0}, s.order())
+	primary.down = false
+	stdtime.Sleep(10 * stdtime.Millisecond)
+	require.Equal(t, []int{0, 1}, s.order())
+	require.Equal(t, "replica", readObject(t, s, "a.txt"))
+	require.Equal(t, []int{0, 1}, s.order())
+	_, err = s.HeadObject(ctx, "missing.txt")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	// every write reaches all the backends with the full quorum, so that the first not found is final.
+	s, err = New([]storage.Storage{primary, replica}, Options{})
+	require.NoError(t, err)
+	_, err = s.GetObject(ctx, "a.txt")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestMirror_ConditionalWrite(t *testing.T) {
+	ctx := context.Background()
+	primary, replica := &downStorage{Storage: newBackend(t), down: true}, newBackend(t)
+	s, err := New([]storage.Storage{primary, replica}, Options{WriteQuorum: 1})
+	require.NoError(t, err)
+
+	// the condition cannot be checked without the primary, so that the replica is not written.
+	cctx := storage.WithCondition(ctx, storage.Condition{IfNoneMatch: "*"})
+	err = s.PutObject(cctx, "a.txt", strings.NewReader("world"), nil, nil)
+	require.Equal(t, storage.ErrorClassUnavailable, storage.ErrorClass(err))
+	_, err = replica.HeadObject(ctx, "a.txt")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	primary.down = false
+	require.NoError(t, s.PutObject(cctx, "a.txt", strings.NewReader("world"), nil, nil))
+	require.Equal(t, "world", readObject(t, replica, "a.txt"))
+	err = s.PutObject(cctx, "a.txt", strings.NewReader("again"), nil, nil)
+	require.Equal(t, storage.ErrorClassPreconditionFailed, storage.ErrorClass(err))
+	require.Equal(t, "world", readObject(t, replica, "a.txt"))
+}
@@ -0,0 +1,246 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	stdtime "time"
+)
+
+const (
+	// DefaultPartSize is the part size used by UploadFile when UploadOptions.PartSize is not set.
+	DefaultPartSize int64 = 10 * 1024 * 1024
+	// MinPartSize is the minimum size of every part except the last one.
+	MinPartSize int64 = 5 * 1024 * 1024
+	// MaxParts is the maximum number of parts of a multipart upload.
+	MaxParts = 10000
+)
+
+// ErrNoSuchUpload is returned by the MultipartUploader when the upload does not exist, such as an upload that is
+// aborted or expired.
+var ErrNoSuchUpload = errors.New("no such multipart upload")
+
+// MultipartUploader is implemented by the backends that can upload an object in several parts.
+type MultipartUploader interface {
+	InitiateMultipartUpload(ctx context.Context, objectPath string, headers http.Header, metadata map[string]string) (uploadID string, err error)
+	UploadPart(ctx context.Context, objectPath, uploadID string, partNumber int32, body io.Reader, size int64) (Part, error)
+	CompleteMultipartUpload(ctx context.Context, objectPath, uploadID string, parts []Part) error
+	AbortMultipartUpload(ctx context.Context, objectPath, uploadID string) error
+	ListMultipartUploads(ctx context.Context, objectPrefix string) ([]MultipartUpload, error)
+}
+
+type Part struct {
+	PartNumber int32  `json:"part_number"`
+	ETag       string `json:"etag"`
+	Size       int64  `json:"size"`
+}
+
+type MultipartUpload struct {
+	Key       string
+	UploadID  string
+	Initiated stdtime.Time
+}
+
+type UploadOptions struct {
+	PartSize    int64
+	Concurrency int
+	// CheckpointFile records the completed parts, so that an interrupted upload can be resumed
+	// by calling UploadFile again with the same file. Resume is disabled if it is empty.
+	CheckpointFile string
+	Headers        http.Header
+	Metadata       map[string]string
+}
+
+type uploadCheckpoint struct {
+	ObjectPath string       `json:"object_path"`
+	UploadID   string       `json:"upload_id"`
+	FileSize   int64        `json:"file_size"`
+	ModTime    stdtime.Time `json:"mod_time"`
+	PartSize   int64        `json:"part_size"`
+	Parts      []Part       `json:"parts"`
+
+	path string
+	mux  sync.Mutex
+}
+
+func (c *uploadCheckpoint) matches(o *uploadCheckpoint) bool {
+	return c.ObjectPath == o.ObjectPath && c.FileSize == o.FileSize &&
+		c.ModTime.Equal(o.ModTime) && c.PartSize == o.PartSize && len(c.UploadID) != 0
+}
+
+func (c *uploadCheckpoint) load() error {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, c)
+}
+
+func (c *uploadCheckpoint) addPart(part Part) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.Parts = append(c.Parts, part)
+	return c.save()
+}
+
+func (c *uploadCheckpoint) save() error {
+	if len(c.path) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// UploadFile uploads the local file to objectPath part by part, using up to o.Concurrency parallel uploads.
+// If o.CheckpointFile is set, completed parts are recorded in it and skipped when the upload is retried,
+// the checkpoint is removed once the upload completes.
+func UploadFile(ctx context.Context, u MultipartUploader, objectPath, filePath string, o UploadOptions) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if o.PartSize <= 0 {
+		o.PartSize = DefaultPartSize
+	} else if o.PartSize < MinPartSize {
+		o.PartSize = MinPartSize
+	}
+	for stat.Size() > o.PartSize*MaxParts {
+		o.PartSize *= 2
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = 1
+	}
+
+	cp := &uploadCheckpoint{
+		ObjectPath: objectPath,
+		FileSize:   stat.Size(),
+		ModTime:    stat.ModTime(),
+		PartSize:   o.PartSize,
+		path:       o.CheckpointFile,
+	}
+	resumed := false
+	if len(cp.path) != 0 {
+		saved := &uploadCheckpoint{path: cp.path}
+		if err = saved.load(); err == nil && saved.matches(cp) {
+			cp.UploadID = saved.UploadID
+			cp.Parts = saved.Parts
+			resumed = true
+		} else if err == nil && len(saved.UploadID) != 0 {
+			_ = u.AbortMultipartUpload(ctx, saved.ObjectPath, saved.UploadID)
+		} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to load checkpoint %s: %w", cp.path, err)
+		}
+	}
+	err = uploadParts(ctx, u, f, cp, o)
+	if resumed && errors.Is(err, ErrNoSuchUpload) {
+		// the upload of the checkpoint is aborted or expired, so that the file is uploaded again by a new upload.
+		cp.UploadID, cp.Parts = "", nil
+		err = uploadParts(ctx, u, f, cp, o)
+	}
+	if err != nil {
+		return err
+	}
+	if len(cp.path) != 0 {
+		if err = os.Remove(cp.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// uploadParts uploads the parts of f that are not in cp and completes the upload, a new upload is initiated if cp
+// has none.
+func uploadParts(ctx context.Context, u MultipartUploader, f *os.File, cp *uploadCheckpoint, o UploadOptions) (err error) {
+	objectPath := cp.ObjectPath
+	if len(cp.UploadID) == 0 {
+		if cp.UploadID, err = u.InitiateMultipartUpload(ctx, objectPath, o.Headers, o.Metadata); err != nil {
+			return err
+		}
+		if err = cp.save(); err != nil {
+			return err
+		}
+	}
+
+	done := make(map[int32]bool, len(cp.Parts))
+	for _, part := range cp.Parts {
+		done[part.PartNumber] = true
+	}
+	partCount := int32((cp.FileSize + o.PartSize - 1) / o.PartSize)
+	if partCount == 0 {
+		partCount = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+		parts    = make(chan int32)
+	)
+	for i := 0; i < o.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for partNumber := range parts {
+				offset := int64(partNumber-1) * o.PartSize
+				size := min(o.PartSize, cp.FileSize-offset)
+				part, err := u.UploadPart(ctx, objectPath, cp.UploadID, partNumber, io.NewSectionReader(f, offset, size), size)
+				if err == nil {
+					err = cp.addPart(part)
+				}
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = fmt.Errorf("failed to upload part %d: %w", partNumber, err)
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+loop:
+	for partNumber := int32(1); partNumber <= partCount; partNumber++ {
+		if done[partNumber] {
+			continue
+		}
+		select {
+		case parts <- partNumber:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(parts)
+	wg.Wait()
+	if firstErr != nil {
+		if len(cp.path) == 0 {
+			_ = u.AbortMultipartUpload(context.Background(), objectPath, cp.UploadID)
+		}
+		return firstErr
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	sort.Slice(cp.Parts, func(i, j int) bool {
+		return cp.Parts[i].PartNumber < cp.Parts[j].PartNumber
+	})
+	return u.CompleteMultipartUpload(ctx, objectPath, cp.UploadID, cp.Parts)
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type memMultipartUploader struct {
+	mux      sync.Mutex
+	uploadID string
+	parts    map[int32][]byte
+	uploads  int
+	failPart int32
+	objects  map[string][]byte
+}
+
+func (m *memMultipartUploader) InitiateMultipartUpload(_ context.Context, _ string, _ http.Header, _ map[string]string) (string, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.parts = map[int32][]byte{}
+	m.uploadID = fmt.Sprintf("upload-id-%d", m.uploads)
+	return m.uploadID, nil
+}
+
+func (m *memMultipartUploader) UploadPart(_ context.Context, _, uploadID string, partNumber int32, body io.Reader, size int64) (Part, error) {
+	m.mux.Lock()
+	if uploadID != m.uploadID {
+		m.mux.Unlock()
+		return Part{}, fmt.Errorf("%w: %s", ErrNoSuchUpload, uploadID)
+	}
+	m.mux.Unlock()
+	if partNumber == m.failPart {
+		return Part{}, errors.New("connection reset")
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return Part{}, err
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.uploads++
+	m.parts[partNumber] = data
+	return Part{PartNumber: partNumber, ETag: fmt.Sprintf("etag-%d", partNumber), Size: size}, nil
+}
+
+func (m *memMultipartUploader) CompleteMultipartUpload(_ context.Context, objectPath, _ string, parts []Part) error {
+	var buf bytes.Buffer
+	for _, part := range parts {
+		buf.Write(m.parts[part.PartNumber])
+	}
+	m.objects[objectPath] = buf.Bytes()
+	return nil
+}
+
+func (m *memMultipartUploader) AbortMultipartUpload(_ context.Context, _, _ string) error {
+	return nil
+}
+
+func (m *memMultipartUploader) ListMultipartUploads(_ context.Context, _ string) ([]MultipartUpload, error) {
+	return nil, nil
+}
+
+func TestUploadFile(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "backup.tar")
+	data := make([]byte, 3*MinPartSize+123)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filePath, data, 0o600))
+
+	u := &memMultipartUploader{objects: map[string][]byte{}, failPart: 3}
+	o := UploadOptions{PartSize: MinPartSize, Concurrency: 2, CheckpointFile: filepath.Join(dir, "backup.tar.cp")}
+	require.Error(t, UploadFile(context.Background(), u, "backups/backup.tar", filePath, o))
+	require.FileExists(t, o.CheckpointFile)
+	uploaded := u.uploads
+
+	u.failPart = 0
+	require.NoError(t, UploadFile(context.Background(), u, "backups/backup.tar", filePath, o))
+	require.Equal(t, 4, u.uploads, "completed parts should not be uploaded again")
+	require.Less(t, uploaded, 4)
+	require.Equal(t, data, u.objects["backups/backup.tar"])
+	require.NoFileExists(t, o.CheckpointFile)
+}
+
+func TestUploadFile_UploadExpired(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "backup.tar")
+	data := make([]byte, 2*MinPartSize+123)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filePath, data, 0o600))
+
+	u := &memMultipartUploader{objects: map[string][]byte{}, failPart: 2}
+	o := UploadOptions{PartSize: MinPartSize, CheckpointFile: filepath.Join(dir, "backup.tar.cp")}
+	require.Error(t, UploadFile(context.Background(), u, "backups/backup.tar", filePath, o))
+	require.FileExists(t, o.CheckpointFile)
+
+	// the upload of the checkpoint is aborted by the server.
+	u.uploadID = ""
+	u.failPart = 0
+	require.NoError(t, UploadFile(context.Background(), u, "backups/backup.tar", filePath, o))
+	require.Equal(t, data, u.objects["backups/backup.tar"])
+	require.NoFileExists(t, o.CheckpointFile)
+}
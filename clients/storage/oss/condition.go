@@ -0,0 +1,49 @@
+package oss
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/aliyun/alibabacloud-oss-go-sdk-v2/oss"
+
+	"github.com/MicroOps-cn/fuck/clients/storage"
+)
+
+// applyReadCondition sets the conditional headers of the GetObject request.
+func applyReadCondition(ctx context.Context, req *oss.GetObjectRequest) {
+	cond, ok := storage.ConditionFromContext(ctx)
+	if !ok {
+		return
+	}
+	if len(cond.IfMatch) != 0 {
+		req.IfMatch = oss.Ptr(storage.QuoteETag(cond.IfMatch))
+	}
+	if len(cond.IfNoneMatch) != 0 {
+		req.IfNoneMatch = oss.Ptr(storage.QuoteETag(cond.IfNoneMatch))
+	}
+	if !cond.IfModifiedSince.IsZero() {
+		req.IfModifiedSince = oss.Ptr(cond.IfModifiedSince.UTC().Format(http.TimeFormat))
+	}
+	if !cond.IfUnmodifiedSince.IsZero() {
+		req.IfUnmodifiedSince = oss.Ptr(cond.IfUnmodifiedSince.UTC().Format(http.TimeFormat))
+	}
+}
+
+// applyWriteCondition makes the PutObject request conditional. "If-None-Match: *" is mapped to
+// x-oss-forbid-overwrite, which OSS evaluates atomically, also for multipart uploads. OSS has no conditional
+// headers for the other conditions of writes, which are rejected with storage.ErrConditionNotSupported.
+func applyWriteCondition(ctx context.Context, req *oss.PutObjectRequest) error {
+	cond, ok := storage.ConditionFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	if cond.IfNoneMatch == "*" {
+		req.ForbidOverwrite = oss.Ptr("true")
+		cond.IfNoneMatch = ""
+	}
+	if !cond.IsZero() {
+		return fmt.Errorf("%w: OSS only supports If-None-Match: * for writes of %s", storage.ErrConditionNotSupported, oss.ToString(req.Key))
+	}
+	return nil
+}
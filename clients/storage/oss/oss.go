@@ -15,6 +15,7 @@ import (
 	"github.com/aliyun/alibabacloud-oss-go-sdk-v2/oss/transport"
 
 	"github.com/MicroOps-cn/fuck/clients/storage"
+	ferrors "github.com/MicroOps-cn/fuck/errors"
 	"github.com/MicroOps-cn/fuck/safe"
 )
 
@@ -28,6 +29,10 @@ type Options struct {
 	Worker                 int         `json:"worker,omitempty" yaml:"worker,omitempty" mapstructure:"worker"`
 	OSSMaxIdleConns        int         `json:"oss_max_idle_conns,omitempty" yaml:"oss_max_idle_conns,omitempty" mapstructure:"oss_max_idle_conns"`
 	OSSMaxIdleConnsPerHost int         `json:"oss_max_idle_conns_per_host,omitempty" yaml:"oss_max_idle_conns_per_host,omitempty" mapstructure:"oss_max_idle_conns_per_host"`
+	PartSize               int64       `json:"part_size,omitempty" yaml:"part_size,omitempty" mapstructure:"part_size"`
+	// SDKRetryMaxAttempts is the maximum attempts of a request retried by the SDK, the default is 16, or 1 when
+	// storage.NewClient retries the operations by storage.ResilienceOptions.
+	SDKRetryMaxAttempts int `json:"sdk_retry_max_attempts,omitempty" yaml:"sdk_retry_max_attempts,omitempty" mapstructure:"sdk_retry_max_attempts"`
 }
 
 func (c Client) MarshalJSON() ([]byte, error) {
@@ -42,11 +47,7 @@ type Client struct {
 }
 
 func (c Client) ReadDir(name string) ([]fs.DirEntry, error) {
-	var entries []fs.DirEntry
-	err := c.ListObject(context.Background(), name, false, func(object storage.Object) {
-		entries = append(entries, object)
-	})
-	return entries, err
+	return storage.ReadDir(context.Background(), c, name)
 }
 
 func (c Client) Open(name string) (fs.File, error) {
@@ -58,36 +59,58 @@ func (c Client) Name() string {
 }
 
 func (c Client) GetObject(ctx context.Context, objectPath string) (*storage.ObjectReader, error) {
-	var key, bucket string
-	if !strings.HasPrefix(objectPath, "oss://") {
-		key = objectPath
-		bucket = c.bucket
-	} else {
-		var found bool
-		bucket, key, found = strings.Cut(strings.TrimPrefix(objectPath, "oss://"), "/")
-		if !found {
-			return nil, fmt.Errorf("invalid object path: path is empty: %s", objectPath)
-		}
-	}
-	if len(bucket) == 0 {
-		return nil, errors.New("invalid object path: bucket is empty")
+	return c.getObject(ctx, objectPath, 0, -1)
+}
+
+func (c Client) GetObjectRange(ctx context.Context, objectPath string, offset, length int64) (*storage.ObjectReader, error) {
+	return c.getObject(ctx, objectPath, offset, length)
+}
+
+func (c Client) getObject(ctx context.Context, objectPath string, offset, length int64) (*storage.ObjectReader, error) {
+	key, bucket, err := c.resolveObjectPath(objectPath)
+	if err != nil {
+		return nil, err
 	}
-	if len(objectPath) == 0 {
+	if len(key) == 0 {
 		return nil, errors.New("invalid object path: path is empty")
 	}
-	obj, err := c.clt.GetObject(ctx, &oss.GetObjectRequest{
+	req := oss.GetObjectRequest{
 		Bucket: &bucket,
 		Key:    &key,
-	})
+	}
+	if offset > 0 || length >= 0 {
+		req.Range = oss.Ptr(storage.HTTPRange(offset, length))
+		req.RangeBehavior = oss.Ptr("standard")
+	}
+	applyReadCondition(ctx, &req)
+	obj, err := c.clt.GetObject(ctx, &req)
 	if err != nil {
-		return nil, err
+		return nil, translateError("get", key, err)
+	}
+	size := obj.ContentLength
+	if totalSize, ok := storage.ContentRangeSize(oss.ToString(obj.ContentRange)); ok {
+		size = totalSize
 	}
 	return &storage.ObjectReader{
 		ReadCloser: obj.Body,
+		Offset:     offset,
+		ReadRange: func(offset, length int64) (io.ReadCloser, error) {
+			ret, err := c.clt.GetObject(ctx, &oss.GetObjectRequest{
+				Bucket:        &bucket,
+				Key:           &key,
+				IfMatch:       obj.ETag,
+				Range:         oss.Ptr(storage.HTTPRange(offset, length)),
+				RangeBehavior: oss.Ptr("standard"),
+			})
+			if err != nil {
+				return nil, translateError("get", key, err)
+			}
+			return ret.Body, nil
+		},
 		Object: storage.Object{
 			Key:          key,
 			LastModified: oss.ToTime(obj.LastModified),
-			Size:         obj.ContentLength,
+			Size:         size,
 			ETag:         strings.Trim(oss.ToString(obj.ETag), `"`),
 			StorageClass: obj.Headers.Get("x-oss-storage-class"),
 			Metadata:     obj.Metadata,
@@ -98,7 +121,147 @@ func (c Client) GetObject(ctx context.Context, objectPath string) (*storage.Obje
 }
 
 func (c Client) PutObject(ctx context.Context, objectPath string, obj io.Reader, headers http.Header, metadata map[string]string) error {
-	var key, bucket string
+	key, bucket, err := c.resolveObjectPath(objectPath)
+	if err != nil {
+		return err
+	}
+	if len(key) == 0 {
+		return errors.New("invalid object path: path is empty")
+	}
+	req := oss.PutObjectRequest{
+		Key:      &key,
+		Bucket:   &bucket,
+		Metadata: metadata,
+	}
+	for name := range headers {
+		switch name {
+		case "Content-Type":
+			req.ContentType = oss.Ptr(headers.Get(name))
+		case "Content-Encoding":
+			req.ContentEncoding = oss.Ptr(headers.Get(name))
+		case "Content-Disposition":
+			req.ContentDisposition = oss.Ptr(headers.Get(name))
+		case "Cache-Control":
+			req.CacheControl = oss.Ptr(headers.Get(name))
+		case "Expires":
+			req.Expires = oss.Ptr(headers.Get(name))
+		}
+	}
+	if err = applyWriteCondition(ctx, &req); err != nil {
+		return err
+	}
+	_, err = c.uploader.UploadFrom(ctx, &req, obj)
+	return translateError("put", key, err)
+}
+
+func (c Client) HeadObject(ctx context.Context, objectPath string) (obj *storage.Object, err error) {
+	key, bucket, err := c.resolveObjectPath(objectPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) == 0 {
+		return nil, errors.New("invalid object path: path is empty")
+	}
+	meta, err := c.clt.HeadObject(ctx, &oss.HeadObjectRequest{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return nil, translateError("head", key, err)
+	}
+	return &storage.Object{
+		Key:          key,
+		LastModified: oss.ToTime(meta.LastModified),
+		Size:         meta.ContentLength,
+		ETag:         strings.Trim(oss.ToString(meta.ETag), `"`),
+		StorageClass: oss.ToString(meta.StorageClass),
+		Metadata:     meta.Metadata,
+		Headers:      meta.Headers,
+		Mode:         storage.RawPermissionsToMode(meta.Metadata["x-oss-storage-perms"]),
+	}, nil
+}
+
+func (c Client) DeleteObject(ctx context.Context, objectPath string) error {
+	key, bucket, err := c.resolveObjectPath(objectPath)
+	if err != nil {
+		return err
+	}
+	if len(key) == 0 {
+		return errors.New("invalid object path: path is empty")
+	}
+	_, err = c.clt.DeleteObject(ctx, &oss.DeleteObjectRequest{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	return err
+}
+
+// maxDeleteObjects is the maximum number of keys accepted by a single DeleteMultipleObjects request.
+const maxDeleteObjects = 1000
+
+func (c Client) DeleteObjects(ctx context.Context, objectPaths []string) error {
+	var errs []error
+	keys := make(map[string][]oss.DeleteObject)
+	for _, objectPath := range objectPaths {
+		key, bucket, err := c.resolveObjectPath(objectPath)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if len(key) == 0 {
+			errs = append(errs, errors.New("invalid object path: path is empty"))
+			continue
+		}
+		keys[bucket] = append(keys[bucket], oss.DeleteObject{Key: oss.Ptr(key)})
+	}
+	for bucket, objects := range keys {
+		for len(objects) > 0 {
+			n := min(len(objects), maxDeleteObjects)
+			// OSS reports only the deleted keys, the quiet mode would hide the keys that failed.
+			ret, err := c.clt.DeleteMultipleObjects(ctx, &oss.DeleteMultipleObjectsRequest{
+				Bucket:  oss.Ptr(bucket),
+				Objects: objects[:n],
+			})
+			if err != nil {
+				return errors.Join(append(errs, err)...)
+			}
+			deleted := make(map[string]bool, len(ret.DeletedObjects))
+			for _, obj := range ret.DeletedObjects {
+				deleted[oss.ToString(obj.Key)] = true
+			}
+			for _, obj := range objects[:n] {
+				if !deleted[oss.ToString(obj.Key)] {
+					errs = append(errs, fmt.Errorf("failed to delete object %s", oss.ToString(obj.Key)))
+				}
+			}
+			objects = objects[n:]
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c Client) CopyObject(ctx context.Context, srcPath, dstPath string) error {
+	srcKey, srcBucket, err := c.resolveObjectPath(srcPath)
+	if err != nil {
+		return err
+	}
+	dstKey, dstBucket, err := c.resolveObjectPath(dstPath)
+	if err != nil {
+		return err
+	}
+	if len(srcKey) == 0 || len(dstKey) == 0 {
+		return errors.New("invalid object path: path is empty")
+	}
+	_, err = c.clt.CopyObject(ctx, &oss.CopyObjectRequest{
+		Bucket:       &dstBucket,
+		Key:          &dstKey,
+		SourceBucket: &srcBucket,
+		SourceKey:    &srcKey,
+	})
+	return translateError("copy", srcKey, err)
+}
+
+func (c Client) resolveObjectPath(objectPath string) (key, bucket string, err error) {
 	if !strings.HasPrefix(objectPath, "oss://") {
 		key = objectPath
 		bucket = c.bucket
@@ -106,18 +269,25 @@ func (c Client) PutObject(ctx context.Context, objectPath string, obj io.Reader,
 		var found bool
 		bucket, key, found = strings.Cut(strings.TrimPrefix(objectPath, "oss://"), "/")
 		if !found {
-			return fmt.Errorf("invalid object path: path is empty: %s", objectPath)
+			return "", "", fmt.Errorf("invalid object path: path is empty: %s", objectPath)
 		}
 	}
 	if len(bucket) == 0 {
-		return errors.New("invalid object path: bucket is empty")
+		return "", "", errors.New("invalid object path: bucket is empty")
 	}
-	if len(objectPath) == 0 {
-		return errors.New("invalid object path: path is empty")
+	return key, bucket, nil
+}
+
+func (c Client) InitiateMultipartUpload(ctx context.Context, objectPath string, headers http.Header, metadata map[string]string) (string, error) {
+	key, bucket, err := c.resolveObjectPath(objectPath)
+	if err != nil {
+		return "", err
 	}
-	req := oss.PutObjectRequest{
+	if len(key) == 0 {
+		return "", errors.New("invalid object path: path is empty")
+	}
+	req := oss.InitiateMultipartUploadRequest{
 		Key:      &key,
-		Body:     obj,
 		Bucket:   &bucket,
 		Metadata: metadata,
 	}
@@ -135,106 +305,284 @@ func (c Client) PutObject(ctx context.Context, objectPath string, obj io.Reader,
 			req.Expires = oss.Ptr(headers.Get(name))
 		}
 	}
-	_, err := c.uploader.UploadFrom(ctx, &oss.PutObjectRequest{
+	ret, err := c.clt.InitiateMultipartUpload(ctx, &req)
+	if err != nil {
+		return "", err
+	}
+	return oss.ToString(ret.UploadId), nil
+}
+
+func (c Client) UploadPart(ctx context.Context, objectPath, uploadID string, partNumber int32, body io.Reader, size int64) (storage.Part, error) {
+	key, bucket, err := c.resolveObjectPath(objectPath)
+	if err != nil {
+		return storage.Part{}, err
+	}
+	ret, err := c.clt.UploadPart(ctx, &oss.UploadPartRequest{
+		Bucket:        &bucket,
+		Key:           &key,
+		UploadId:      &uploadID,
+		PartNumber:    partNumber,
+		Body:          body,
+		ContentLength: oss.Ptr(size),
+	})
+	if err != nil {
+		return storage.Part{}, translateUploadError(uploadID, err)
+	}
+	return storage.Part{PartNumber: partNumber, ETag: oss.ToString(ret.ETag), Size: size}, nil
+}
+
+func (c Client) CompleteMultipartUpload(ctx context.Context, objectPath, uploadID string, parts []storage.Part) error {
+	key, bucket, err := c.resolveObjectPath(objectPath)
+	if err != nil {
+		return err
+	}
+	uploadParts := make([]oss.UploadPart, len(parts))
+	for i, part := range parts {
+		uploadParts[i] = oss.UploadPart{PartNumber: part.PartNumber, ETag: oss.Ptr(part.ETag)}
+	}
+	_, err = c.clt.CompleteMultipartUpload(ctx, &oss.CompleteMultipartUploadRequest{
+		Bucket:                  &bucket,
+		Key:                     &key,
+		UploadId:                &uploadID,
+		CompleteMultipartUpload: &oss.CompleteMultipartUpload{Parts: uploadParts},
+	})
+	return translateUploadError(uploadID, err)
+}
+
+func (c Client) AbortMultipartUpload(ctx context.Context, objectPath, uploadID string) error {
+	key, bucket, err := c.resolveObjectPath(objectPath)
+	if err != nil {
+		return err
+	}
+	_, err = c.clt.AbortMultipartUpload(ctx, &oss.AbortMultipartUploadRequest{
 		Bucket:   &bucket,
 		Key:      &key,
-		Metadata: metadata,
-		ProgressFn: func(increment, transferred, total int64) {
-
-		},
-	}, obj)
+		UploadId: &uploadID,
+	})
 	return err
 }
 
-func (c Client) HeadObject(ctx context.Context, objectPath string) (obj *storage.Object, err error) {
-	var key, bucket string
-	if !strings.HasPrefix(objectPath, "oss://") {
-		key = objectPath
-		bucket = c.bucket
-	} else {
-		var found bool
-		bucket, key, found = strings.Cut(strings.TrimPrefix(objectPath, "oss://"), "/")
-		if !found {
-			return nil, fmt.Errorf("invalid object path: path is empty: %s", objectPath)
+func (c Client) ListMultipartUploads(ctx context.Context, objectPrefix string) ([]storage.MultipartUpload, error) {
+	prefix, bucket, err := c.resolveObjectPath(objectPrefix)
+	if err != nil {
+		return nil, err
+	}
+	var uploads []storage.MultipartUpload
+	var keyMarker, uploadIDMarker *string
+	for {
+		ret, err := c.clt.ListMultipartUploads(ctx, &oss.ListMultipartUploadsRequest{
+			Bucket:         &bucket,
+			Prefix:         &prefix,
+			KeyMarker:      keyMarker,
+			UploadIdMarker: uploadIDMarker,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, upload := range ret.Uploads {
+			uploads = append(uploads, storage.MultipartUpload{
+				Key:       oss.ToString(upload.Key),
+				UploadID:  oss.ToString(upload.UploadId),
+				Initiated: oss.ToTime(upload.Initiated),
+			})
 		}
+		if !ret.IsTruncated {
+			return uploads, nil
+		}
+		keyMarker, uploadIDMarker = ret.NextKeyMarker, ret.NextUploadIdMarker
 	}
-	if len(bucket) == 0 {
-		return nil, errors.New("invalid object path: bucket is empty")
+}
+
+// UploadFile uploads a local file with multipart upload, part size and concurrency default to the client options.
+func (c Client) UploadFile(ctx context.Context, objectPath, filePath string, o storage.UploadOptions) error {
+	if o.PartSize <= 0 {
+		o.PartSize = c.o.PartSize
 	}
-	if len(objectPath) == 0 {
+	if o.Concurrency <= 0 {
+		o.Concurrency = c.o.Worker
+	}
+	return storage.UploadFile(ctx, c, objectPath, filePath, o)
+}
+
+func (c Client) PresignGet(ctx context.Context, objectPath string, o storage.PresignOptions) (*storage.PresignedRequest, error) {
+	key, bucket, err := c.resolveObjectPath(objectPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) == 0 {
 		return nil, errors.New("invalid object path: path is empty")
 	}
-	meta, err := c.clt.GetObjectMeta(ctx, &oss.GetObjectMetaRequest{
+	req := oss.GetObjectRequest{
 		Bucket: &bucket,
 		Key:    &key,
-	})
+	}
+	if len(o.ContentType) != 0 {
+		req.ResponseContentType = oss.Ptr(o.ContentType)
+	}
+	for name := range o.Headers {
+		switch name {
+		case "Content-Type":
+			req.ResponseContentType = oss.Ptr(o.Headers.Get(name))
+		case "Content-Encoding":
+			req.ResponseContentEncoding = oss.Ptr(o.Headers.Get(name))
+		case "Content-Language":
+			req.ResponseContentLanguage = oss.Ptr(o.Headers.Get(name))
+		case "Content-Disposition":
+			req.ResponseContentDisposition = oss.Ptr(o.Headers.Get(name))
+		case "Cache-Control":
+			req.ResponseCacheControl = oss.Ptr(o.Headers.Get(name))
+		}
+	}
+	return c.presign(ctx, &req, o)
+}
+
+func (c Client) PresignPut(ctx context.Context, objectPath string, o storage.PresignOptions) (*storage.PresignedRequest, error) {
+	key, bucket, err := c.resolveObjectPath(objectPath)
 	if err != nil {
 		return nil, err
 	}
-	return &storage.Object{
-		Key:          key,
-		LastModified: oss.ToTime(meta.LastModified),
-		Size:         meta.ContentLength,
-		ETag:         strings.Trim(oss.ToString(meta.ETag), `"`),
-		StorageClass: meta.Headers.Get("x-oss-storage-class"),
-		Metadata:     obj.Metadata,
-		Headers:      obj.Headers,
-		Mode:         storage.RawPermissionsToMode(obj.Metadata["x-oss-storage-perms"]),
-	}, nil
+	if len(key) == 0 {
+		return nil, errors.New("invalid object path: path is empty")
+	}
+	req := oss.PutObjectRequest{
+		Bucket: &bucket,
+		Key:    &key,
+	}
+	for name := range o.Headers {
+		switch name {
+		case "Content-Type":
+			req.ContentType = oss.Ptr(o.Headers.Get(name))
+		case "Content-Encoding":
+			req.ContentEncoding = oss.Ptr(o.Headers.Get(name))
+		case "Content-Disposition":
+			req.ContentDisposition = oss.Ptr(o.Headers.Get(name))
+		case "Cache-Control":
+			req.CacheControl = oss.Ptr(o.Headers.Get(name))
+		}
+	}
+	if len(o.ContentType) != 0 {
+		req.ContentType = oss.Ptr(o.ContentType)
+	}
+	return c.presign(ctx, &req, o)
+}
+
+func (c Client) presign(ctx context.Context, req any, o storage.PresignOptions) (*storage.PresignedRequest, error) {
+	ret, err := c.clt.Presign(ctx, req, oss.PresignExpires(o.ExpiresIn()))
+	if err != nil {
+		return nil, err
+	}
+	signedHeader := make(http.Header, len(ret.SignedHeaders))
+	for name, value := range ret.SignedHeaders {
+		signedHeader.Set(name, value)
+	}
+	return &storage.PresignedRequest{Method: ret.Method, URL: ret.URL, SignedHeader: signedHeader, Expiration: ret.Expiration}, nil
+}
+
+// translateError converts the "404 Not Found" response of the object operation to fs.ErrNotExist.
+func translateError(op, key string, err error) error {
+	var se *oss.ServiceError
+	if errors.As(err, &se) {
+		switch se.StatusCode {
+		case http.StatusNotFound:
+			return &fs.PathError{Op: op, Path: key, Err: fs.ErrNotExist}
+		case http.StatusPreconditionFailed:
+			return ferrors.NewPreconditionFailedError(key, op)
+		case http.StatusNotModified:
+			return ferrors.NewNotModifiedError(key, op)
+		case http.StatusConflict:
+			// FileAlreadyExists is returned when x-oss-forbid-overwrite is set and the object exists.
+			if se.Code == "FileAlreadyExists" {
+				return ferrors.NewPreconditionFailedError(key, "If-None-Match")
+			}
+		}
+	}
+	return err
+}
+
+// translateUploadError converts the NoSuchUpload error of the multipart upload operation to storage.ErrNoSuchUpload.
+func translateUploadError(uploadID string, err error) error {
+	var se *oss.ServiceError
+	if errors.As(err, &se) && se.Code == "NoSuchUpload" {
+		return fmt.Errorf("%w: %s: %s", storage.ErrNoSuchUpload, uploadID, err)
+	}
+	return err
 }
 
 func (c Client) ListObject(ctx context.Context, objectPrefix string, recursion bool, callback func(key storage.Object)) error {
+	o := storage.ListOptions{Prefix: objectPrefix}
+	if !recursion {
+		o.Delimiter = "/"
+	}
+	return c.ListObjects(ctx, o, func(page *storage.ListPage) error {
+		for _, obj := range page.Objects {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			callback(obj)
+		}
+		return nil
+	})
+}
+
+func (c Client) ListObjects(ctx context.Context, o storage.ListOptions, callback func(page *storage.ListPage) error) error {
 	var prefix, bucket string
-	if !strings.HasPrefix(objectPrefix, "oss://") {
-		prefix = objectPrefix
+	if !strings.HasPrefix(o.Prefix, "oss://") {
+		prefix = o.Prefix
 		bucket = c.bucket
 	} else {
-		bucket, prefix, _ = strings.Cut(strings.TrimPrefix(objectPrefix, "oss://"), "/")
+		bucket, prefix, _ = strings.Cut(strings.TrimPrefix(o.Prefix, "oss://"), "/")
 	}
 	if len(bucket) == 0 {
 		return errors.New("invalid object path: bucket is empty")
 	}
-	var delimiter *string
-
-	if !recursion {
-		delimiter = oss.Ptr("/")
+	req := oss.ListObjectsV2Request{
+		Bucket:  &bucket,
+		Prefix:  &prefix,
+		MaxKeys: int32(o.PageSize()),
+	}
+	if len(o.Delimiter) != 0 {
+		req.Delimiter = oss.Ptr(o.Delimiter)
+	}
+	if len(o.StartAfter) != 0 {
+		req.StartAfter = oss.Ptr(o.StartAfter)
+	}
+	if len(o.ContinuationToken) != 0 {
+		req.ContinuationToken = oss.Ptr(o.ContinuationToken)
 	}
-	var continueToken *string
 	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			result, err := c.clt.ListObjectsV2(ctx, &oss.ListObjectsV2Request{
-				Bucket:            &bucket,
-				Prefix:            &prefix,
-				MaxKeys:           1000,
-				ContinuationToken: continueToken,
-				Delimiter:         delimiter,
-			},
-			)
-			if err != nil {
-				return err
-			}
-			for _, obj := range result.Contents {
-				select {
-				case <-ctx.Done():
-					return ctx.Err()
-				default:
-					callback(storage.Object{
-						Key:          oss.ToString(obj.Key),
-						LastModified: oss.ToTime(obj.LastModified),
-						Size:         obj.Size,
-						ETag:         strings.Trim(oss.ToString(obj.ETag), `"`),
-						StorageClass: oss.ToString(obj.StorageClass),
-					})
-				}
-			}
-			if result.NextContinuationToken == nil || len(oss.ToString(result.NextContinuationToken)) == 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		result, err := c.clt.ListObjectsV2(ctx, &req)
+		if err != nil {
+			return err
+		}
+		page := storage.ListPage{
+			Objects:               make([]storage.Object, 0, len(result.Contents)),
+			NextContinuationToken: oss.ToString(result.NextContinuationToken),
+		}
+		for _, obj := range result.Contents {
+			page.Objects = append(page.Objects, storage.Object{
+				Key:          oss.ToString(obj.Key),
+				LastModified: oss.ToTime(obj.LastModified),
+				Size:         obj.Size,
+				ETag:         strings.Trim(oss.ToString(obj.ETag), `"`),
+				StorageClass: oss.ToString(obj.StorageClass),
+			})
+		}
+		for _, commonPrefix := range result.CommonPrefixes {
+			page.CommonPrefixes = append(page.CommonPrefixes, oss.ToString(commonPrefix.Prefix))
+		}
+		if err = callback(&page); err != nil {
+			if errors.Is(err, fs.SkipAll) {
 				return nil
 			}
-			continueToken = result.NextContinuationToken
+			return err
+		}
+		if !result.IsTruncated || len(page.NextContinuationToken) == 0 {
+			return nil
 		}
+		req.ContinuationToken = result.NextContinuationToken
 	}
 }
 
@@ -272,12 +620,16 @@ func NewClient(ctx context.Context, v storage.ConfigProvider) (*Client, error) {
 			maxIdleConnsPerHost = 100
 		}
 	}
+	retryMaxAttempts := v.GetInt("sdk_retry_max_attempts")
+	if retryMaxAttempts <= 0 {
+		retryMaxAttempts = 16
+	}
 	clt := oss.NewClient(&oss.Config{
 		LogLevel:            oss.Ptr(oss.LogWarn),
 		Endpoint:            &endpoint,
 		Region:              &region,
 		CredentialsProvider: credentials.NewStaticCredentialsProvider(accessKeyId, accessKeySecret, ""),
-		RetryMaxAttempts:    oss.Ptr(16),
+		RetryMaxAttempts:    oss.Ptr(retryMaxAttempts),
 		HttpClient: transport.NewHttpClient(&transport.Config{}, func(t *http.Transport) {
 			t.MaxIdleConns = maxIdleConns
 			t.MaxIdleConnsPerHost = maxIdleConnsPerHost
@@ -285,10 +637,23 @@ func NewClient(ctx context.Context, v storage.ConfigProvider) (*Client, error) {
 		}),
 	})
 
+	if workerNum <= 0 {
+		workerNum = oss.DefaultUploadParallel
+	}
+	partSize := int64(v.GetInt("part_size"))
+	if partSize <= 0 {
+		partSize = storage.DefaultPartSize
+	} else if partSize < storage.MinPartSize {
+		partSize = storage.MinPartSize
+	}
+
 	return &Client{
-		clt:      clt,
-		uploader: clt.NewUploader(),
-		bucket:   v.GetString("bucket"),
+		clt: clt,
+		uploader: clt.NewUploader(func(uo *oss.UploaderOptions) {
+			uo.PartSize = partSize
+			uo.ParallelNum = workerNum
+		}),
+		bucket: v.GetString("bucket"),
 		o: Options{
 			Worker:                 workerNum,
 			SecretAccessKey:        safeSecretKey,
@@ -298,6 +663,8 @@ func NewClient(ctx context.Context, v storage.ConfigProvider) (*Client, error) {
 			Bucket:                 v.GetString("bucket"),
 			OSSMaxIdleConns:        maxIdleConns,
 			OSSMaxIdleConnsPerHost: maxIdleConnsPerHost,
+			PartSize:               partSize,
+			SDKRetryMaxAttempts:    retryMaxAttempts,
 			Type:                   Client{}.Type(),
 		},
 	}, nil
@@ -0,0 +1,16 @@
+package storage
+
+import (
+	"path"
+	"strings"
+)
+
+// MatchKey reports whether key matches pattern. A pattern that contains any of the glob characters "*?["
+// is matched by path.Match, in which "*" does not match "/", otherwise it is matched as a key prefix.
+func MatchKey(pattern, key string) bool {
+	if strings.ContainsAny(pattern, "*?[") {
+		matched, _ := path.Match(pattern, key)
+		return matched
+	}
+	return strings.HasPrefix(key, pattern)
+}
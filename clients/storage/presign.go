@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	stdtime "time"
+)
+
+// DefaultPresignExpires is the validity period of presigned URLs when PresignOptions.Expires is not set.
+const DefaultPresignExpires = 15 * stdtime.Minute
+
+type PresignOptions struct {
+	Expires stdtime.Duration
+	// ContentType is the content type that the PUT request must carry,
+	// for a GET request it overrides the Content-Type of the response.
+	ContentType string
+	// Headers are the headers that the PUT request must carry, such as Content-Disposition and Cache-Control,
+	// for a GET request they override the response headers.
+	Headers http.Header
+}
+
+func (o PresignOptions) ExpiresIn() stdtime.Duration {
+	if o.Expires <= 0 {
+		return DefaultPresignExpires
+	}
+	return o.Expires
+}
+
+type PresignedRequest struct {
+	Method string
+	URL    string
+	// SignedHeader is the headers that must be sent with the request.
+	SignedHeader http.Header
+	Expiration   stdtime.Time
+}
+
+// Presigner is implemented by the backends that can mint URLs for accessing an object without credentials.
+type Presigner interface {
+	PresignGet(ctx context.Context, objectPath string, o PresignOptions) (*PresignedRequest, error)
+	PresignPut(ctx context.Context, objectPath string, o PresignOptions) (*PresignedRequest, error)
+}
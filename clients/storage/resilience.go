@@ -0,0 +1,465 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	stdtime "time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/cast"
+)
+
+var (
+	retriesCounterVec = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_operation_retries_total",
+		Help: "The total number of retried storage operations, partitioned by backend type and operation.",
+	}, []string{"type", "operation"})
+	breakerStateGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "storage_circuit_breaker_state",
+		Help: "The state of the circuit breaker of the storage: 0 is closed, 1 is half-open and 2 is open.",
+	}, []string{"type", "name"})
+	breakerRejectionsCounterVec = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_circuit_breaker_rejections_total",
+		Help: "The total number of storage operations rejected by the open circuit breaker.",
+	}, []string{"type", "name"})
+)
+
+func init() {
+	prometheus.MustRegister(retriesCounterVec, breakerStateGaugeVec, breakerRejectionsCounterVec)
+}
+
+// ErrCircuitOpen is returned without calling the backend while its circuit breaker is open.
+var ErrCircuitOpen = errors.New("storage: circuit breaker is open")
+
+const (
+	DefaultRetryInitialBackoff = 100 * stdtime.Millisecond
+	DefaultRetryMaxBackoff     = 10 * stdtime.Second
+	DefaultBreakerCooldown     = 30 * stdtime.Second
+)
+
+// ResilienceOptions configures the retries, the deadlines and the circuit breaker of the operations of a backend.
+// Only the errors of the ErrorClassTimeout and ErrorClassUnavailable classes are retried and counted by the breaker.
+type ResilienceOptions struct {
+	// MaxAttempts is the maximum attempts of an idempotent operation, 0 and 1 disable the retries.
+	// PutObject is only retried if the reader implements io.Seeker and the context has no Condition, whose retry
+	// would fail if the first attempt was applied, and the listings are only retried if the callback has not been
+	// called. NewClient disables the retries of the SDKs of the backends that retry by MaxAttempts, unless the
+	// config sets sdk_retry_max_attempts, which multiplies the attempts.
+	MaxAttempts int `json:"retry_max_attempts,omitempty" yaml:"retry_max_attempts,omitempty" mapstructure:"retry_max_attempts"`
+	// InitialBackoff and MaxBackoff bound the random delay before a retry, the upper bound of the delay doubles
+	// after every attempt.
+	InitialBackoff stdtime.Duration `json:"retry_initial_backoff,omitempty" yaml:"retry_initial_backoff,omitempty" mapstructure:"retry_initial_backoff"`
+	MaxBackoff     stdtime.Duration `json:"retry_max_backoff,omitempty" yaml:"retry_max_backoff,omitempty" mapstructure:"retry_max_backoff"`
+	// Timeout is the deadline of every attempt. The deadline of GetObject and GetObjectRange ends when the reader is
+	// returned, the reads of the content are not bounded by it.
+	Timeout stdtime.Duration `json:"operation_timeout,omitempty" yaml:"operation_timeout,omitempty" mapstructure:"operation_timeout"`
+	// OperationTimeouts overrides Timeout for the operations of its keys, which are the names of the methods of
+	// Storage, such as PutObject, 0 disables the deadline of the operation.
+	OperationTimeouts map[string]stdtime.Duration `json:"operation_timeouts,omitempty" yaml:"operation_timeouts,omitempty" mapstructure:"operation_timeouts"`
+	// BreakerThreshold is the number of consecutive failures that open the circuit breaker, 0 disables it.
+	BreakerThreshold int `json:"circuit_breaker_threshold,omitempty" yaml:"circuit_breaker_threshold,omitempty" mapstructure:"circuit_breaker_threshold"`
+	// BreakerCooldown is how long the breaker stays open before an attempt is let through to probe the backend.
+	BreakerCooldown stdtime.Duration `json:"circuit_breaker_cooldown,omitempty" yaml:"circuit_breaker_cooldown,omitempty" mapstructure:"circuit_breaker_cooldown"`
+}
+
+// operations are the operations of Storage that the resilience applies to.
+var operations = []string{
+	"ListObject", "ListObjects", "HeadObject", "GetObject", "GetObjectRange",
+	"PutObject", "DeleteObject", "DeleteObjects", "CopyObject",
+}
+
+// ResilienceOptionsFromConfig reads the options from the keys in the tags of ResilienceOptions, the durations are
+// strings such as "500ms", and the timeout of an operation is read from a key such as operation_timeouts.PutObject.
+func ResilienceOptionsFromConfig(v ConfigProvider) (o ResilienceOptions, err error) {
+	o.MaxAttempts = v.GetInt("retry_max_attempts")
+	o.BreakerThreshold = v.GetInt("circuit_breaker_threshold")
+	for key, d := range map[string]*stdtime.Duration{
+		"retry_initial_backoff":    &o.InitialBackoff,
+		"retry_max_backoff":        &o.MaxBackoff,
+		"operation_timeout":        &o.Timeout,
+		"circuit_breaker_cooldown": &o.BreakerCooldown,
+	} {
+		if val := v.GetString(key); len(val) != 0 {
+			if *d, err = cast.ToDurationE(val); err != nil {
+				return o, fmt.Errorf("invalid %s: %w", key, err)
+			}
+		}
+	}
+	for _, op := range operations {
+		key := "operation_timeouts." + op
+		if val := v.GetString(key); len(val) != 0 {
+			d, err := cast.ToDurationE(val)
+			if err != nil {
+				return o, fmt.Errorf("invalid %s: %w", key, err)
+			}
+			if o.OperationTimeouts == nil {
+				o.OperationTimeouts = make(map[string]stdtime.Duration)
+			}
+			o.OperationTimeouts[op] = d
+		}
+	}
+	return o, nil
+}
+
+func (o ResilienceOptions) enabled() bool {
+	if o.MaxAttempts > 1 || o.Timeout > 0 || o.BreakerThreshold > 0 {
+		return true
+	}
+	for _, d := range o.OperationTimeouts {
+		if d > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// timeout returns the deadline of an attempt of op.
+func (o ResilienceOptions) timeout(op string) stdtime.Duration {
+	if d, ok := o.OperationTimeouts[op]; ok {
+		return d
+	}
+	return o.Timeout
+}
+
+type resilientStorage struct {
+	Storage
+	o       ResilienceOptions
+	breaker *circuitBreaker
+}
+
+// WithResilience returns s with the retries, the deadlines and the circuit breaker of o. NewClient applies it to the
+// backends that configure any of them.
+func WithResilience(s Storage, o ResilienceOptions) Storage {
+	if o.InitialBackoff <= 0 {
+		o.InitialBackoff = DefaultRetryInitialBackoff
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = DefaultRetryMaxBackoff
+	}
+	if o.BreakerCooldown <= 0 {
+		o.BreakerCooldown = DefaultBreakerCooldown
+	}
+	rs := &resilientStorage{Storage: s, o: o}
+	if o.BreakerThreshold > 0 {
+		rs.breaker = &circuitBreaker{
+			threshold: o.BreakerThreshold,
+			cooldown:  o.BreakerCooldown,
+			state:     breakerStateGaugeVec.WithLabelValues(s.Type(), s.Name()),
+			rejected:  breakerRejectionsCounterVec.WithLabelValues(s.Type(), s.Name()),
+		}
+		rs.breaker.state.Set(float64(breakerClosed))
+	}
+	return rs
+}
+
+func (s *resilientStorage) Unwrap() Storage {
+	return s.Storage
+}
+
+// transient reports whether err may not occur again, it is retried and counted by the circuit breaker.
+func transient(err error) bool {
+	switch ErrorClass(err) {
+	case ErrorClassTimeout, ErrorClassUnavailable:
+		return !errors.Is(err, ErrCircuitOpen)
+	}
+	return false
+}
+
+func (s *resilientStorage) backoff(attempt int) stdtime.Duration {
+	d := s.o.InitialBackoff
+	for i := 1; i < attempt && d < s.o.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > s.o.MaxBackoff {
+		d = s.o.MaxBackoff
+	}
+	return stdtime.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// attempt calls call with the deadline of the options. If it succeeds, the returned function releases the context
+// passed to call, the context of a failed attempt is released before returning.
+func (s *resilientStorage) attempt(ctx context.Context, op string, call func(ctx context.Context) error) (context.CancelFunc, error) {
+	timeout := s.o.timeout(op)
+	if timeout <= 0 {
+		return func() {}, call(ctx)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	timer := stdtime.AfterFunc(timeout, cancel)
+	err := call(ctx)
+	if !timer.Stop() {
+		cancel()
+		return func() {}, fmt.Errorf("%s timed out after %s: %w", op, timeout, context.DeadlineExceeded)
+	}
+	if err != nil {
+		cancel()
+		return func() {}, err
+	}
+	return cancel, nil
+}
+
+// open calls call until it succeeds, the error is not transient, retry returns false or the attempts run out.
+// The returned function releases the context of the successful attempt.
+func (s *resilientStorage) open(ctx context.Context, op string, retry func() bool, call func(ctx context.Context) error) (context.CancelFunc, error) {
+	for attempt := 1; ; attempt++ {
+		if err := s.breaker.allow(); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", op, s.Name(), err)
+		}
+		release, err := s.attempt(ctx, op, call)
+		s.breaker.record(err)
+		if err == nil {
+			return release, nil
+		}
+		if attempt >= s.o.MaxAttempts || !transient(err) || ctx.Err() != nil || (retry != nil && !retry()) {
+			return nil, err
+		}
+		retriesCounterVec.WithLabelValues(s.Type(), op).Inc()
+		timer := stdtime.NewTimer(s.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *resilientStorage) do(ctx context.Context, op string, retry func() bool, call func(ctx context.Context) error) error {
+	release, err := s.open(ctx, op, retry, call)
+	if err != nil {
+		return err
+	}
+	release()
+	return nil
+}
+
+func (s *resilientStorage) ListObject(ctx context.Context, objectPrefix string, recursion bool, callback func(key Object)) error {
+	var called bool
+	return s.do(ctx, "ListObject", func() bool { return !called }, func(ctx context.Context) error {
+		return s.Storage.ListObject(ctx, objectPrefix, recursion, func(obj Object) {
+			called = true
+			callback(obj)
+		})
+	})
+}
+
+func (s *resilientStorage) ListObjects(ctx context.Context, o ListOptions, callback func(page *ListPage) error) error {
+	var called bool
+	return s.do(ctx, "ListObjects", func() bool { return !called }, func(ctx context.Context) error {
+		return s.Storage.ListObjects(ctx, o, func(page *ListPage) error {
+			called = true
+			return callback(page)
+		})
+	})
+}
+
+func (s *resilientStorage) HeadObject(ctx context.Context, objectPath string) (obj *Object, err error) {
+	err = s.do(ctx, "HeadObject", nil, func(ctx context.Context) (err error) {
+		obj, err = s.Storage.HeadObject(ctx, objectPath)
+		return err
+	})
+	return obj, err
+}
+
+// getObject keeps the context of the reader until it is closed.
+func (s *resilientStorage) getObject(ctx context.Context, op string, get func(ctx context.Context) (*ObjectReader, error)) (*ObjectReader, error) {
+	var r *ObjectReader
+	release, err := s.open(ctx, op, nil, func(ctx context.Context) (err error) {
+		if r != nil {
+			// the reader of an attempt that returned after its deadline.
+			_ = r.Close()
+		}
+		r, err = get(ctx)
+		return err
+	})
+	if err != nil {
+		if r != nil {
+			_ = r.Close()
+		}
+		return nil, err
+	}
+	if s.o.timeout(op) > 0 {
+		r.ReadCloser = withCloseHook(r.ReadCloser, release)
+	}
+	return r, nil
+}
+
+func (s *resilientStorage) GetObject(ctx context.Context, objectPath string) (*ObjectReader, error) {
+	return s.getObject(ctx, "GetObject", func(ctx context.Context) (*ObjectReader, error) {
+		return s.Storage.GetObject(ctx, objectPath)
+	})
+}
+
+func (s *resilientStorage) GetObjectRange(ctx context.Context, objectPath string, offset, length int64) (*ObjectReader, error) {
+	return s.getObject(ctx, "GetObjectRange", func(ctx context.Context) (*ObjectReader, error) {
+		return s.Storage.GetObjectRange(ctx, objectPath, offset, length)
+	})
+}
+
+func (s *resilientStorage) PutObject(ctx context.Context, objectPath string, obj io.Reader, headers http.Header, metadata map[string]string) error {
+	seeker, ok := obj.(io.Seeker)
+	if _, conditional := ConditionFromContext(ctx); conditional {
+		ok = false
+	}
+	var start int64
+	if ok {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			ok = false
+		}
+	}
+	var attempted bool
+	return s.do(ctx, "PutObject", func() bool { return ok }, func(ctx context.Context) error {
+		if attempted {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		attempted = true
+		return s.Storage.PutObject(ctx, objectPath, obj, headers, metadata)
+	})
+}
+
+func (s *resilientStorage) DeleteObject(ctx context.Context, objectPath string) error {
+	return s.do(ctx, "DeleteObject", nil, func(ctx context.Context) error {
+		return s.Storage.DeleteObject(ctx, objectPath)
+	})
+}
+
+func (s *resilientStorage) DeleteObjects(ctx context.Context, objectPaths []string) error {
+	return s.do(ctx, "DeleteObjects", nil, func(ctx context.Context) error {
+		return s.Storage.DeleteObjects(ctx, objectPaths)
+	})
+}
+
+func (s *resilientStorage) CopyObject(ctx context.Context, srcPath, dstPath string) error {
+	return s.do(ctx, "CopyObject", nil, func(ctx context.Context) error {
+		return s.Storage.CopyObject(ctx, srcPath, dstPath)
+	})
+}
+
+// withCloseHook calls hook when rc is closed, the returned reader keeps the io.Seeker and io.ReaderAt of rc,
+// which are used by ObjectReader.
+func withCloseHook(rc io.ReadCloser, hook func()) io.ReadCloser {
+	h := &closeHook{ReadCloser: rc, hook: hook}
+	seeker, isSeeker := rc.(io.Seeker)
+	readerAt, isReaderAt := rc.(io.ReaderAt)
+	switch {
+	case isSeeker && isReaderAt:
+		return struct {
+			*closeHook
+			io.Seeker
+			io.ReaderAt
+		}{h, seeker, readerAt}
+	case isSeeker:
+		return struct {
+			*closeHook
+			io.Seeker
+		}{h, seeker}
+	case isReaderAt:
+		return struct {
+			*closeHook
+			io.ReaderAt
+		}{h, readerAt}
+	}
+	return h
+}
+
+type closeHook struct {
+	io.ReadCloser
+	hook func()
+	once sync.Once
+}
+
+func (c *closeHook) Close() error {
+	defer c.once.Do(c.hook)
+	return c.ReadCloser.Close()
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+// circuitBreaker opens after threshold consecutive transient failures and rejects the operations until the cooldown
+// has passed, then a single probe is let through: the breaker closes if it succeeds, or opens again if it fails.
+type circuitBreaker struct {
+	threshold int
+	cooldown  stdtime.Duration
+	state     prometheus.Gauge
+	rejected  prometheus.Counter
+
+	mux      sync.Mutex
+	current  breakerState
+	failures int
+	openedAt stdtime.Time
+	probing  bool
+}
+
+func (b *circuitBreaker) set(state breakerState) {
+	b.current = state
+	b.state.Set(float64(state))
+}
+
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	switch b.current {
+	case breakerOpen:
+		if stdtime.Since(b.openedAt) < b.cooldown {
+			break
+		}
+		b.set(breakerHalfOpen)
+		fallthrough
+	case breakerHalfOpen:
+		if b.probing {
+			break
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+	b.rejected.Inc()
+	return ErrCircuitOpen
+}
+
+func (b *circuitBreaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	failed := transient(err)
+	switch b.current {
+	case breakerClosed:
+		if !failed {
+			b.failures = 0
+		} else if b.failures++; b.failures >= b.threshold {
+			b.set(breakerOpen)
+			b.openedAt = stdtime.Now()
+		}
+	case breakerHalfOpen:
+		b.probing = false
+		switch {
+		case failed:
+			b.set(breakerOpen)
+			b.openedAt = stdtime.Now()
+		case errors.Is(err, context.Canceled):
+			// the canceled probe tells nothing about the backend, the next operation probes it again.
+		default:
+			b.set(breakerClosed)
+			b.failures = 0
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	stdtime "time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+type statusError int
+
+func (e statusError) Error() string {
+	return "unexpected status code"
+}
+
+func (e statusError) HTTPStatusCode() int {
+	return int(e)
+}
+
+// flakyStorage fails the operations with 503 while failures is positive.
+type flakyStorage struct {
+	*memStorage
+	failures int
+	calls    int
+	block    bool
+}
+
+func (f *flakyStorage) Name() string {
+	return "flaky"
+}
+
+func (f *flakyStorage) fail(ctx context.Context) error {
+	f.calls++
+	if f.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if f.failures > 0 {
+		f.failures--
+		return statusError(503)
+	}
+	return nil
+}
+
+func (f *flakyStorage) HeadObject(ctx context.Context, objectPath string) (*Object, error) {
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	return f.memStorage.HeadObject(ctx, objectPath)
+}
+
+func (f *flakyStorage) PutObject(ctx context.Context, objectPath string, obj io.Reader, headers http.Header, metadata map[string]string) error {
+	if err := f.fail(ctx); err != nil {
+		_, _ = io.Copy(io.Discard, obj)
+		return err
+	}
+	return f.memStorage.PutObject(ctx, objectPath, obj, headers, metadata)
+}
+
+func TestWithResilience_Retry(t *testing.T) {
+	ctx := context.Background()
+	f := &flakyStorage{memStorage: newMemStorage(map[string]string{"a.txt": "hello"}), failures: 2}
+	s := WithResilience(f, ResilienceOptions{MaxAttempts: 3, InitialBackoff: stdtime.Millisecond})
+	retries := testutil.ToFloat64(retriesCounterVec.WithLabelValues("memory", "HeadObject"))
+	_, err := s.HeadObject(ctx, "a.txt")
+	require.NoError(t, err)
+	require.Equal(t, 3, f.calls)
+	require.Equal(t, retries+2, testutil.ToFloat64(retriesCounterVec.WithLabelValues("memory", "HeadObject")))
+
+	// the rewound content is written again.
+	f.calls, f.failures = 0, 1
+	require.NoError(t, s.PutObject(ctx, "b.txt", strings.NewReader("world"), nil, nil))
+	require.Equal(t, 2, f.calls)
+	require.Equal(t, "world", f.files()["b.txt"])
+
+	// the content that cannot be rewound is not retried.
+	f.calls, f.failures = 0, 1
+	err = s.PutObject(ctx, "c.txt", io.MultiReader(strings.NewReader("world")), nil, nil)
+	require.Equal(t, ErrorClassUnavailable, ErrorClass(err))
+	require.Equal(t, 1, f.calls)
+
+	// the conditional write is not retried, since its first attempt may have been applied.
+	f.calls, f.failures = 0, 1
+	err = s.PutObject(WithCondition(ctx, Condition{IfNoneMatch: "*"}), "d.txt", strings.NewReader("world"), nil, nil)
+	require.Equal(t, ErrorClassUnavailable, ErrorClass(err))
+	require.Equal(t, 1, f.calls)
+}
+
+func TestWithResilience_Timeout(t *testing.T) {
+	f := &flakyStorage{memStorage: newMemStorage(nil), block: true}
+	s := WithResilience(f, ResilienceOptions{MaxAttempts: 2, InitialBackoff: stdtime.Millisecond, Timeout: 10 * stdtime.Millisecond})
+	_, err := s.HeadObject(context.Background(), "a.txt")
+	require.Equal(t, ErrorClassTimeout, ErrorClass(err))
+	require.Equal(t, 2, f.calls)
+
+	f.calls, f.block = 0, false
+	s = WithResilience(f, ResilienceOptions{Timeout: stdtime.Hour, OperationTimeouts: map[string]stdtime.Duration{"HeadObject": 10 * stdtime.Millisecond}})
+	require.Equal(t, 10*stdtime.Millisecond, s.(*resilientStorage).o.timeout("HeadObject"))
+	require.Equal(t, stdtime.Hour, s.(*resilientStorage).o.timeout("PutObject"))
+	f.block = true
+	_, err = s.HeadObject(context.Background(), "a.txt")
+	require.Equal(t, ErrorClassTimeout, ErrorClass(err))
+}
+
+func TestWithResilience_CircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	f := &flakyStorage{memStorage: newMemStorage(map[string]string{"a.txt": "hello"}), failures: 2}
+	s := WithResilience(f, ResilienceOptions{BreakerThreshold: 2, BreakerCooldown: 20 * stdtime.Millisecond})
+	state := breakerStateGaugeVec.WithLabelValues("memory", "flaky")
+	for i := 0; i < 2; i++ {
+		_, err := s.HeadObject(ctx, "a.txt")
+		require.Equal(t, ErrorClassUnavailable, ErrorClass(err))
+	}
+	require.Equal(t, float64(breakerOpen), testutil.ToFloat64(state))
+	_, err := s.HeadObject(ctx, "a.txt")
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.Equal(t, 2, f.calls)
+
+	stdtime.Sleep(20 * stdtime.Millisecond)
+	_, err = s.HeadObject(ctx, "a.txt")
+	require.NoError(t, err)
+	require.Equal(t, float64(breakerClosed), testutil.ToFloat64(state))
+}
+
+func TestResilienceOptionsFromConfig(t *testing.T) {
+	o, err := ResilienceOptionsFromConfig(NewMapConfigProvider(map[string]interface{}{
+		"retry_max_attempts": 5, "operation_timeout": "2s", "circuit_breaker_threshold": "3",
+	}))
+	require.NoError(t, err)
+	require.Equal(t, ResilienceOptions{MaxAttempts: 5, Timeout: 2 * stdtime.Second, BreakerThreshold: 3}, o)
+	_, err = ResilienceOptionsFromConfig(NewMapConfigProvider(map[string]interface{}{"retry_max_backoff": "soon"}))
+	require.Error(t, err)
+
+	o, err = ResilienceOptionsFromConfig(NewMapConfigProvider(map[string]interface{}{
+		"operation_timeouts": map[string]interface{}{"PutObject": "1m", "HeadObject": "1s"},
+	}))
+	require.NoError(t, err)
+	require.Equal(t, map[string]stdtime.Duration{"PutObject": stdtime.Minute, "HeadObject": stdtime.Second}, o.OperationTimeouts)
+	require.True(t, o.enabled())
+	_, err = ResilienceOptionsFromConfig(NewMapConfigProvider(map[string]interface{}{"operation_timeouts.GetObject": "soon"}))
+	require.Error(t, err)
+}
+
+func TestNewClient_SDKRetries(t *testing.T) {
+	var sdkAttempts int
+	RegisterStorage("sdk-retries-test", func(_ context.Context, v ConfigProvider) (Storage, error) {
+		sdkAttempts = v.GetInt("sdk_retry_max_attempts")
+		return newMemStorage(nil), nil
+	})
+	for _, tt := range []struct {
+		config   map[string]interface{}
+		attempts int
+	}{
+		{map[string]interface{}{}, 0},
+		{map[string]interface{}{"retry_max_attempts": 3}, 1},
+		{map[string]interface{}{"retry_max_attempts": 3, "sdk_retry_max_attempts": 2}, 2},
+		{map[string]interface{}{"operation_timeout": "1s"}, 0},
+	} {
+		tt.config["type"] = "sdk-retries-test"
+		_, err := NewClient(context.Background(), NewMapConfigProvider(tt.config))
+		require.NoError(t, err)
+		require.Equal(t, tt.attempts, sdkAttempts, tt.config)
+	}
+}
@@ -0,0 +1,283 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	stdtime "time"
+
+	"github.com/go-kit/log/level"
+
+	"github.com/MicroOps-cn/fuck/log"
+	"github.com/MicroOps-cn/fuck/signals"
+)
+
+type RetentionAction string
+
+const (
+	RetentionActionDelete RetentionAction = "delete"
+	// RetentionActionArchive copies the expired objects to RetentionOptions.Archive before deleting them.
+	RetentionActionArchive RetentionAction = "archive"
+)
+
+// DefaultRetentionInterval is the interval of the retention job when RetentionOptions.Interval is not set.
+const DefaultRetentionInterval = stdtime.Hour
+
+// RetentionRule expires the objects under Prefix. The objects are ordered from the newest to the oldest by
+// LastModified, an object expires if it is older than MaxAge, or it is not one of the newest MaxCount objects,
+// or the total size of it and the newer objects exceeds MaxBytes. The zero limits are not applied.
+type RetentionRule struct {
+	Name   string `json:"name,omitempty" yaml:"name,omitempty" mapstructure:"name"`
+	Prefix string `json:"prefix" yaml:"prefix" mapstructure:"prefix"`
+	// Pattern filters the keys relative to Prefix, see MatchKey. All the keys under Prefix match if it is empty.
+	Pattern  string           `json:"pattern,omitempty" yaml:"pattern,omitempty" mapstructure:"pattern"`
+	MaxAge   stdtime.Duration `json:"max_age,omitempty" yaml:"max_age,omitempty" mapstructure:"max_age"`
+	MaxCount int              `json:"max_count,omitempty" yaml:"max_count,omitempty" mapstructure:"max_count"`
+	MaxBytes int64            `json:"max_bytes,omitempty" yaml:"max_bytes,omitempty" mapstructure:"max_bytes"`
+	// PerDirectory applies MaxCount and MaxBytes to the objects of every directory under Prefix separately,
+	// such as the rotated logs of every application, instead of all the objects under Prefix.
+	PerDirectory bool            `json:"per_directory,omitempty" yaml:"per_directory,omitempty" mapstructure:"per_directory"`
+	Action       RetentionAction `json:"action,omitempty" yaml:"action,omitempty" mapstructure:"action"`
+	// ArchivePrefix is prepended to the keys of the archived objects in RetentionOptions.Archive.
+	ArchivePrefix string `json:"archive_prefix,omitempty" yaml:"archive_prefix,omitempty" mapstructure:"archive_prefix"`
+}
+
+func (r RetentionRule) name() string {
+	if len(r.Name) != 0 {
+		return r.Name
+	}
+	return r.Prefix
+}
+
+func (r RetentionRule) action() RetentionAction {
+	if len(r.Action) == 0 {
+		return RetentionActionDelete
+	}
+	return r.Action
+}
+
+type RetentionOptions struct {
+	// Rules are applied in order, an object is only expired by the first rule that matches it.
+	Rules []RetentionRule
+	// Archive is the backend of the archived objects, it is required by the rules of RetentionActionArchive.
+	Archive Storage
+	// DryRun reports the expired objects without deleting or archiving them.
+	DryRun bool
+	// Interval is the interval between two runs of the retention job, the default is DefaultRetentionInterval.
+	Interval stdtime.Duration
+	// StopLevel is the level of signals.Handler that stops the retention job, the default is signals.LevelRequest.
+	StopLevel uint8
+}
+
+func (o RetentionOptions) validate() error {
+	for _, rule := range o.Rules {
+		if rule.MaxAge <= 0 && rule.MaxCount <= 0 && rule.MaxBytes <= 0 {
+			return fmt.Errorf("retention rule %s has no limit", rule.name())
+		}
+		switch rule.action() {
+		case RetentionActionDelete:
+		case RetentionActionArchive:
+			if o.Archive == nil {
+				return fmt.Errorf("retention rule %s archives the objects, but the archive storage is not set", rule.name())
+			}
+		default:
+			return fmt.Errorf("unsupported action %s of retention rule %s", rule.Action, rule.name())
+		}
+	}
+	return nil
+}
+
+// RetentionEvent is an object expired by a rule, Reason is the limit that expires it.
+type RetentionEvent struct {
+	Rule         string
+	Action       RetentionAction
+	Key          string
+	Size         int64
+	LastModified stdtime.Time
+	Reason       string
+	DryRun       bool
+	Err          error
+}
+
+type RetentionReport struct {
+	Events   []RetentionEvent
+	Deleted  int
+	Archived int
+	Failed   int
+	// Bytes is the total size of the deleted and archived objects.
+	Bytes int64
+}
+
+// expire returns the objects expired by the rule, the keys in handled are skipped and the matched keys are added to it.
+func (r RetentionRule) expire(ctx context.Context, s Storage, now stdtime.Time, handled map[string]bool) ([]RetentionEvent, error) {
+	groups := map[string][]Object{}
+	prefix := strings.TrimPrefix(r.Prefix, "/")
+	err := s.ListObject(ctx, r.Prefix, true, func(obj Object) {
+		key := strings.TrimPrefix(obj.Key, "/")
+		if obj.IsDir() || handled[key] || (len(r.Pattern) != 0 && !MatchKey(r.Pattern, strings.TrimPrefix(key, prefix))) {
+			return
+		}
+		handled[key] = true
+		obj.Key = key
+		var group string
+		if r.PerDirectory {
+			group = path.Dir(key)
+		}
+		groups[group] = append(groups[group], obj)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var events []RetentionEvent
+	for _, objects := range groups {
+		sort.SliceStable(objects, func(i, j int) bool {
+			return objects[i].LastModified.After(objects[j].LastModified)
+		})
+		var total int64
+		for i, obj := range objects {
+			if obj.Size > 0 {
+				total += obj.Size
+			}
+			var reason string
+			switch {
+			case r.MaxAge > 0 && now.Sub(obj.LastModified) > r.MaxAge:
+				reason = fmt.Sprintf("older than %s", r.MaxAge)
+			case r.MaxCount > 0 && i >= r.MaxCount:
+				reason = fmt.Sprintf("more than %d objects", r.MaxCount)
+			case r.MaxBytes > 0 && total > r.MaxBytes:
+				reason = fmt.Sprintf("more than %d bytes", r.MaxBytes)
+			default:
+				continue
+			}
+			events = append(events, RetentionEvent{
+				Rule:         r.name(),
+				Action:       r.action(),
+				Key:          obj.Key,
+				Size:         obj.Size,
+				LastModified: obj.LastModified,
+				Reason:       reason,
+			})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Key < events[j].Key
+	})
+	return events, nil
+}
+
+// ApplyRetention evaluates the rules against the objects listed by ListObject, and deletes or archives the expired
+// objects. The failures of single objects are recorded in the report instead of stopping the run.
+func ApplyRetention(ctx context.Context, s Storage, o RetentionOptions) (*RetentionReport, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	report := &RetentionReport{}
+	now := stdtime.Now()
+	handled := map[string]bool{}
+	for _, rule := range o.Rules {
+		events, err := rule.expire(ctx, s, now, handled)
+		if err != nil {
+			return report, fmt.Errorf("failed to evaluate retention rule %s: %w", rule.name(), err)
+		}
+		for _, event := range events {
+			if err = ctx.Err(); err != nil {
+				return report, err
+			}
+			event.DryRun = o.DryRun
+			if !o.DryRun {
+				event.Err = applyRetention(ctx, s, o.Archive, rule, event.Key)
+			}
+			switch {
+			case event.Err != nil:
+				report.Failed++
+			case event.Action == RetentionActionArchive:
+				report.Archived++
+				report.Bytes += event.Size
+			default:
+				report.Deleted++
+				report.Bytes += event.Size
+			}
+			report.Events = append(report.Events, event)
+		}
+	}
+	return report, nil
+}
+
+func applyRetention(ctx context.Context, s, archive Storage, rule RetentionRule, key string) error {
+	if rule.action() == RetentionActionArchive {
+		if err := Copy(ctx, archive, rule.ArchivePrefix+key, s, key); err != nil {
+			return fmt.Errorf("failed to archive %s: %w", key, err)
+		}
+	}
+	return s.DeleteObject(ctx, key)
+}
+
+// RetentionJob applies the retention rules periodically in the background.
+type RetentionJob struct {
+	s      Storage
+	o      RetentionOptions
+	cancel context.CancelFunc
+	done   chan struct{}
+	once   sync.Once
+}
+
+// StartRetention starts a RetentionJob that applies the rules immediately and then every Interval. The job is stopped
+// at the StopLevel of signals.Handler, or by Stop.
+func StartRetention(ctx context.Context, s Storage, o RetentionOptions) (*RetentionJob, error) {
+	if len(o.Rules) == 0 {
+		return nil, errors.New("no retention rule")
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	if o.Interval <= 0 {
+		o.Interval = DefaultRetentionInterval
+	}
+	if o.StopLevel == signals.LevelRoot {
+		o.StopLevel = signals.LevelRequest
+	}
+	logger := log.GetContextLogger(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	job := &RetentionJob{s: s, o: o, cancel: cancel, done: make(chan struct{})}
+	stopCh := signals.SetupSignalHandler(logger)
+	stopCh.PreStop(o.StopLevel, job.Stop)
+	go job.run(ctx)
+	return job, nil
+}
+
+func (j *RetentionJob) run(ctx context.Context) {
+	defer close(j.done)
+	logger := log.GetContextLogger(ctx)
+	ticker := stdtime.NewTicker(j.o.Interval)
+	defer ticker.Stop()
+	for {
+		report, err := ApplyRetention(ctx, j.s, j.o)
+		if err != nil && ctx.Err() == nil {
+			level.Error(logger).Log("msg", "failed to apply retention rules", "storage", j.s.Name(), "err", err)
+		}
+		if report != nil {
+			for _, event := range report.Events {
+				if event.Err != nil {
+					level.Warn(logger).Log("msg", "failed to expire object", "rule", event.Rule, "key", event.Key, "err", event.Err)
+				}
+			}
+			level.Info(logger).Log("msg", "applied retention rules", "storage", j.s.Name(), "dry_run", j.o.DryRun,
+				"deleted", report.Deleted, "archived", report.Archived, "failed", report.Failed, "bytes", report.Bytes)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop stops the job and waits for the running evaluation to return.
+func (j *RetentionJob) Stop() {
+	j.once.Do(j.cancel)
+	<-j.done
+}
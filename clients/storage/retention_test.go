@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	stdtime "time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyRetention(t *testing.T) {
+	ctx := context.Background()
+	s := newMemStorage(map[string]string{
+		"exports/1.csv":       "1",
+		"exports/2.csv":       "22",
+		"exports/3.csv":       "333",
+		"exports/keep.txt":    "keep",
+		"logs/a/1.log":        "aaaa",
+		"logs/a/2.log":        "aaaa",
+		"logs/a/3.log":        "aaaa",
+		"logs/b/1.log":        "bbbb",
+		"logs/b/2.log.tmp":    "bbbb",
+		"tmp/old.bin":         "old",
+		"tmp/new.bin":         "new",
+		"other/untouched.bin": "x",
+	})
+	now := stdtime.Now()
+	ages := map[string]stdtime.Duration{
+		"exports/1.csv": 3, "exports/2.csv": 2, "exports/3.csv": 1,
+		"logs/a/1.log": 3, "logs/a/2.log": 2, "logs/a/3.log": 1, "logs/b/1.log": 1,
+		"tmp/old.bin": 48, "tmp/new.bin": 1,
+	}
+	for key, age := range ages {
+		obj := s.objects[key]
+		obj.LastModified = now.Add(-age * stdtime.Hour)
+		s.objects[key] = obj
+	}
+	archive := newMemStorage(nil)
+	o := RetentionOptions{
+		Rules: []RetentionRule{
+			{Name: "tmp", Prefix: "tmp/", MaxAge: 24 * stdtime.Hour},
+			{Prefix: "exports/", Pattern: "*.csv", MaxCount: 1, Action: RetentionActionArchive, ArchivePrefix: "archive/"},
+			{Prefix: "logs/", Pattern: "*/*.log", MaxBytes: 8, PerDirectory: true},
+		},
+		Archive: archive,
+		DryRun:  true,
+	}
+	report, err := ApplyRetention(ctx, s, o)
+	require.NoError(t, err)
+	require.Equal(t, 4, report.Deleted+report.Archived)
+	require.Len(t, s.files(), 12)
+	var keys []string
+	for _, event := range report.Events {
+		require.True(t, event.DryRun)
+		keys = append(keys, event.Key)
+	}
+	require.Equal(t, []string{"tmp/old.bin", "exports/1.csv", "exports/2.csv", "logs/a/1.log"}, keys)
+
+	o.DryRun = false
+	report, err = ApplyRetention(ctx, s, o)
+	require.NoError(t, err)
+	require.Equal(t, RetentionReport{Events: report.Events, Deleted: 2, Archived: 2, Bytes: 10}, *report)
+	require.Equal(t, map[string]string{"archive/exports/1.csv": "1", "archive/exports/2.csv": "22"}, archive.files())
+	require.Len(t, s.files(), 8)
+
+	_, err = ApplyRetention(ctx, s, RetentionOptions{Rules: []RetentionRule{{Prefix: "logs/", Action: RetentionActionArchive, MaxCount: 1}}})
+	require.Error(t, err)
+}
+
+func TestStartRetention(t *testing.T) {
+	s := newMemStorage(map[string]string{"a": "a", "b": "b"})
+	job, err := StartRetention(context.Background(), s, RetentionOptions{
+		Rules:    []RetentionRule{{MaxCount: 1}},
+		Interval: stdtime.Millisecond,
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		s.mux.Lock()
+		defer s.mux.Unlock()
+		return len(s.objects) == 1
+	}, stdtime.Second, stdtime.Millisecond)
+	job.Stop()
+	job.Stop()
+}
@@ -0,0 +1,425 @@
+package router
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/MicroOps-cn/fuck/clients/storage"
+)
+
+// Route sends the keys that start with Prefix to Backend. The keys are passed to the backend unchanged.
+type Route struct {
+	Prefix  string
+	Backend storage.Storage
+}
+
+func (r Route) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{"prefix": r.Prefix, "backend": r.Backend})
+}
+
+// Storage routes every key to the backend of the longest matching route prefix. The listings that span several
+// routes are merged in lexicographical order, and the keys that a backend holds but are routed to another backend
+// are hidden.
+type Storage struct {
+	// routes are sorted by the length of the prefixes in descending order.
+	routes []Route
+	// backends are the distinct backends of the routes, ids are the indexes of the backends of the routes.
+	backends []storage.Storage
+	ids      []int
+}
+
+// New returns a router of the routes, a route with an empty prefix is the default backend. A key that matches no
+// route is rejected.
+func New(routes []Route) (*Storage, error) {
+	if len(routes) == 0 {
+		return nil, errors.New("no route")
+	}
+	s := &Storage{routes: make([]Route, len(routes))}
+	copy(s.routes, routes)
+	sort.SliceStable(s.routes, func(i, j int) bool {
+		return len(s.routes[i].Prefix) > len(s.routes[j].Prefix)
+	})
+	s.ids = make([]int, len(s.routes))
+	for i, r := range s.routes {
+		if r.Backend == nil {
+			return nil, fmt.Errorf("the backend of route %q is nil", r.Prefix)
+		}
+		if i != 0 && s.routes[i-1].Prefix == r.Prefix {
+			return nil, fmt.Errorf("duplicate route %q", r.Prefix)
+		}
+		s.ids[i] = len(s.backends)
+		for id, backend := range s.backends {
+			if sameBackend(backend, r.Backend) {
+				s.ids[i] = id
+				break
+			}
+		}
+		if s.ids[i] == len(s.backends) {
+			s.backends = append(s.backends, r.Backend)
+		}
+	}
+	return s, nil
+}
+
+// NewClient creates the router of the config, the routes are configured as:
+//
+//	routes:
+//	  - prefix: hot/
+//	    backend: {type: local, base: /data/hot}
+//	  - prefix: archive/
+//	    backend: {type: oss, bucket: archive, ...}
+//	  - backend: {type: local, base: /data/default}
+func NewClient(ctx context.Context, v storage.ConfigProvider) (*Storage, error) {
+	var routes []Route
+	for i := 0; ; i++ {
+		route := storage.SubConfig(v, fmt.Sprintf("routes.%d", i))
+		if len(route.GetString("backend.type")) == 0 {
+			break
+		}
+		backend, err := storage.NewClient(ctx, storage.SubConfig(route, "backend"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the backend of route %d: %w", i, err)
+		}
+		routes = append(routes, Route{Prefix: strings.TrimPrefix(route.GetString("prefix"), "/"), Backend: backend})
+	}
+	return New(routes)
+}
+
+func (s *Storage) Type() string {
+	return "router"
+}
+
+func (s *Storage) Name() string {
+	names := make([]string, len(s.backends))
+	for i, backend := range s.backends {
+		names[i] = backend.Name()
+	}
+	return fmt.Sprintf("%s://%s", s.Type(), strings.Join(names, ","))
+}
+
+func (s *Storage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{"type": s.Type(), "routes": s.routes})
+}
+
+// Routes returns the routes in the order they are matched.
+func (s *Storage) Routes() []Route {
+	return append([]Route(nil), s.routes...)
+}
+
+// sameBackend reports whether a and b are the same instance, the backends of value types are never the same.
+func sameBackend(a, b storage.Storage) bool {
+	return reflect.ValueOf(a).Kind() == reflect.Pointer && a == b
+}
+
+// route returns the id of the backend of the key.
+func (s *Storage) route(key string) (int, error) {
+	key = strings.TrimPrefix(key, "/")
+	for i, r := range s.routes {
+		if strings.HasPrefix(key, r.Prefix) {
+			return s.ids[i], nil
+		}
+	}
+	return -1, &fs.PathError{Op: "route", Path: key, Err: fs.ErrNotExist}
+}
+
+// owns reports whether the key listed from the backend is routed to it. A common prefix is owned by every backend
+// that may hold keys under it.
+func (s *Storage) owns(id int, key string, isPrefix bool) bool {
+	if routed, err := s.route(key); err == nil && routed == id {
+		return true
+	}
+	if isPrefix {
+		for i, r := range s.routes {
+			if s.ids[i] == id && strings.HasPrefix(r.Prefix, key) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lookup returns the ids of the backends that may hold the keys under prefix.
+func (s *Storage) lookup(prefix string) []int {
+	prefix = strings.TrimPrefix(prefix, "/")
+	var ids []int
+	seen := make([]bool, len(s.backends))
+	for i, r := range s.routes {
+		contained := strings.HasPrefix(prefix, r.Prefix)
+		if !contained && !strings.HasPrefix(r.Prefix, prefix) {
+			continue
+		}
+		if !seen[s.ids[i]] {
+			seen[s.ids[i]] = true
+			ids = append(ids, s.ids[i])
+		}
+		if contained {
+			// the longest route that contains the prefix shadows the shorter ones.
+			break
+		}
+	}
+	return ids
+}
+
+func (s *Storage) Open(name string) (fs.File, error) {
+	return s.GetObject(context.Background(), name)
+}
+
+func (s *Storage) ReadDir(name string) ([]fs.DirEntry, error) {
+	return storage.ReadDir(context.Background(), s, name)
+}
+
+func (s *Storage) ListObject(ctx context.Context, objectPrefix string, recursion bool, callback func(key storage.Object)) error {
+	dirs := map[string]bool{}
+	for _, id := range s.lookup(objectPrefix) {
+		err := s.backends[id].ListObject(ctx, objectPrefix, recursion, func(obj storage.Object) {
+			key := strings.TrimPrefix(obj.Key, "/")
+			if !s.owns(id, key, obj.IsDir()) {
+				return
+			}
+			if obj.IsDir() {
+				if dirs[key] {
+					return
+				}
+				dirs[key] = true
+			}
+			callback(obj)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type entry struct {
+	key    string
+	object *storage.Object
+}
+
+// cursor reads the pages of a backend, the objects and the common prefixes of a page are merged into the entries.
+type cursor struct {
+	id int
+	o  storage.ListOptions
+	// skip is the common prefix that ended the previous page, whose keys are listed again by the backends such as
+	// S3 since they are after StartAfter.
+	skip    string
+	entries []entry
+	done    bool
+}
+
+func (c *cursor) skipped(key string) bool {
+	return len(c.skip) != 0 && strings.HasPrefix(key, c.skip)
+}
+
+func (c *cursor) fill(ctx context.Context, s *Storage) error {
+	for len(c.entries) == 0 && !c.done {
+		var page *storage.ListPage
+		err := s.backends[c.id].ListObjects(ctx, c.o, func(p *storage.ListPage) error {
+			page = p
+			return fs.SkipAll
+		})
+		if err != nil {
+			return err
+		}
+		if page == nil || len(page.NextContinuationToken) == 0 {
+			c.done = true
+		} else {
+			c.o.ContinuationToken = page.NextContinuationToken
+		}
+		if page == nil {
+			break
+		}
+		for i := range page.Objects {
+			if key := strings.TrimPrefix(page.Objects[i].Key, "/"); s.owns(c.id, key, false) && !c.skipped(page.Objects[i].Key) {
+				c.entries = append(c.entries, entry{key: page.Objects[i].Key, object: &page.Objects[i]})
+			}
+		}
+		for _, p := range page.CommonPrefixes {
+			if s.owns(c.id, strings.TrimPrefix(p, "/"), true) && !c.skipped(p) {
+				c.entries = append(c.entries, entry{key: p})
+			}
+		}
+		sort.SliceStable(c.entries, func(i, j int) bool {
+			return c.entries[i].key < c.entries[j].key
+		})
+	}
+	return nil
+}
+
+// ListObjects merges the listings of the backends that may hold keys under the prefix. The continuation token is
+// the last key of the page, so that every backend resumes from it. When the last key is a common prefix, the keys
+// under it are skipped.
+func (s *Storage) ListObjects(ctx context.Context, o storage.ListOptions, callback func(page *storage.ListPage) error) error {
+	var skip string
+	if len(o.ContinuationToken) != 0 {
+		last, err := base64.RawURLEncoding.DecodeString(o.ContinuationToken)
+		if err != nil {
+			return fmt.Errorf("invalid continuation token: %w", err)
+		}
+		if string(last) > o.StartAfter {
+			o.StartAfter = string(last)
+			if isCommonPrefix(o, o.StartAfter) {
+				skip = o.StartAfter
+			}
+		}
+	}
+	ids := s.lookup(o.Prefix)
+	cursors := make([]*cursor, len(ids))
+	for i, id := range ids {
+		cursors[i] = &cursor{id: id, skip: skip, o: storage.ListOptions{
+			Prefix: o.Prefix, Delimiter: o.Delimiter, StartAfter: o.StartAfter, MaxKeys: o.MaxKeys,
+		}}
+	}
+	pageSize := o.PageSize()
+	var last string
+	for {
+		page := &storage.ListPage{}
+		for len(page.Objects)+len(page.CommonPrefixes) < pageSize {
+			var next *cursor
+			for _, c := range cursors {
+				if err := c.fill(ctx, s); err != nil {
+					return err
+				}
+				if len(c.entries) != 0 && (next == nil || c.entries[0].key < next.entries[0].key) {
+					next = c
+				}
+			}
+			if next == nil {
+				break
+			}
+			e := next.entries[0]
+			next.entries = next.entries[1:]
+			if e.key == last && len(last) != 0 {
+				// a common prefix returned by several backends.
+				continue
+			}
+			last = e.key
+			if e.object != nil {
+				page.Objects = append(page.Objects, *e.object)
+			} else {
+				page.CommonPrefixes = append(page.CommonPrefixes, e.key)
+			}
+		}
+		more := false
+		for _, c := range cursors {
+			if err := c.fill(ctx, s); err != nil {
+				return err
+			}
+			more = more || len(c.entries) != 0
+		}
+		if more {
+			page.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+		}
+		if err := callback(page); err != nil {
+			if errors.Is(err, fs.SkipAll) {
+				return nil
+			}
+			return err
+		}
+		if !more {
+			return nil
+		}
+	}
+}
+
+// isCommonPrefix reports whether key is a common prefix of the listing, a key that ends with the delimiter after
+// the prefix is always rolled up into a common prefix.
+func isCommonPrefix(o storage.ListOptions, key string) bool {
+	return len(o.Delimiter) != 0 && len(key) > len(o.Prefix) && strings.HasPrefix(key, o.Prefix) &&
+		strings.HasSuffix(key[len(o.Prefix):], o.Delimiter)
+}
+
+func (s *Storage) HeadObject(ctx context.Context, objectPath string) (*storage.Object, error) {
+	id, err := s.route(objectPath)
+	if err != nil {
+		return nil, err
+	}
+	return s.backends[id].HeadObject(ctx, objectPath)
+}
+
+func (s *Storage) GetObject(ctx context.Context, objectPath string) (*storage.ObjectReader, error) {
+	id, err := s.route(objectPath)
+	if err != nil {
+		return nil, err
+	}
+	return s.backends[id].GetObject(ctx, objectPath)
+}
+
+func (s *Storage) GetObjectRange(ctx context.Context, objectPath string, offset, length int64) (*storage.ObjectReader, error) {
+	id, err := s.route(objectPath)
+	if err != nil {
+		return nil, err
+	}
+	return s.backends[id].GetObjectRange(ctx, objectPath, offset, length)
+}
+
+func (s *Storage) PutObject(ctx context.Context, objectPath string, obj io.Reader, headers http.Header, metadata map[string]string) error {
+	id, err := s.route(objectPath)
+	if err != nil {
+		return err
+	}
+	return s.backends[id].PutObject(ctx, objectPath, obj, headers, metadata)
+}
+
+func (s *Storage) DeleteObject(ctx context.Context, objectPath string) error {
+	id, err := s.route(objectPath)
+	if err != nil {
+		// the objects that match no route do not exist.
+		return nil
+	}
+	return s.backends[id].DeleteObject(ctx, objectPath)
+}
+
+// DeleteObjects deletes the objects of every backend in a batch.
+func (s *Storage) DeleteObjects(ctx context.Context, objectPaths []string) error {
+	groups := make([][]string, len(s.backends))
+	for _, objectPath := range objectPaths {
+		if id, err := s.route(objectPath); err == nil {
+			groups[id] = append(groups[id], objectPath)
+		}
+	}
+	var errs []error
+	for id, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		if err := s.backends[id].DeleteObjects(ctx, group); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CopyObject copies within the backend if both paths are routed to it, otherwise the object is streamed between
+// the backends.
+func (s *Storage) CopyObject(ctx context.Context, srcPath, dstPath string) error {
+	src, err := s.route(srcPath)
+	if err != nil {
+		return err
+	}
+	dst, err := s.route(dstPath)
+	if err != nil {
+		return err
+	}
+	if src == dst {
+		return s.backends[src].CopyObject(ctx, srcPath, dstPath)
+	}
+	return storage.Copy(ctx, s.backends[dst], dstPath, s.backends[src], srcPath)
+}
+
+func init() {
+	storage.RegisterStorage("router", func(ctx context.Context, v storage.ConfigProvider) (storage.Storage, error) {
+		return NewClient(ctx, v)
+	})
+}
+
+var _ storage.Storage = &Storage{}
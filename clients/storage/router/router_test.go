@@ -0,0 +1,149 @@
+package router
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/MicroOps-cn/fuck/clients/storage"
+	_ "github.com/MicroOps-cn/fuck/clients/storage/fs"
+)
+
+// s3Storage lists the common prefix that StartAfter ends with again, as S3 does since the keys under it are after
+// StartAfter.
+type s3Storage struct {
+	storage.Storage
+}
+
+func (s s3Storage) ListObjects(ctx context.Context, o storage.ListOptions, callback func(page *storage.ListPage) error) error {
+	startAfter := o.StartAfter
+	if len(o.Delimiter) == 0 || !strings.HasSuffix(startAfter, o.Delimiter) {
+		return s.Storage.ListObjects(ctx, o, callback)
+	}
+	o.StartAfter = strings.TrimSuffix(startAfter, o.Delimiter)
+	return s.Storage.ListObjects(ctx, o, func(page *storage.ListPage) error {
+		objects := page.Objects[:0]
+		for _, obj := range page.Objects {
+			if obj.Key > startAfter {
+				objects = append(objects, obj)
+			}
+		}
+		page.Objects = objects
+		prefixes := page.CommonPrefixes[:0]
+		for _, p := range page.CommonPrefixes {
+			if p >= startAfter {
+				prefixes = append(prefixes, p)
+			}
+		}
+		page.CommonPrefixes = prefixes
+		return callback(page)
+	})
+}
+
+// resumedListing lists a page per call of ListObjects, resuming by the continuation token of the previous page.
+func resumedListing(t *testing.T, s storage.Storage, o storage.ListOptions) []string {
+	var entries []string
+	for calls := 0; calls < 10; calls++ {
+		var next string
+		require.NoError(t, s.ListObjects(context.Background(), o, func(page *storage.ListPage) error {
+			entries = append(entries, page.CommonPrefixes...)
+			for _, obj := range page.Objects {
+				entries = append(entries, strings.TrimPrefix(obj.Key, "/"))
+			}
+			next = page.NextContinuationToken
+			return fs.SkipAll
+		}))
+		if len(next) == 0 {
+			return entries
+		}
+		o.ContinuationToken = next
+	}
+	t.Fatalf("the listing does not end: %v", entries)
+	return nil
+}
+
+func TestRouter(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewClient(ctx, storage.NewMapConfigProvider(map[string]interface{}{
+		"type": "router",
+		"routes": []interface{}{
+			map[string]interface{}{"prefix": "hot/", "backend": map[string]interface{}{"type": "in-memory"}},
+			map[string]interface{}{"backend": map[string]interface{}{"type": "in-memory"}},
+		},
+	}))
+	require.NoError(t, err)
+	router, ok := storage.As[*Storage](s)
+	require.True(t, ok)
+	routes := router.Routes()
+	require.Len(t, routes, 2)
+	hot, cold := routes[0].Backend, routes[1].Backend
+
+	for _, key := range []string{"hot/a.txt", "hot/b/c.txt", "cold/d.txt", "e.txt"} {
+		require.NoError(t, s.PutObject(ctx, key, strings.NewReader(key), nil, nil))
+	}
+	_, err = hot.HeadObject(ctx, "hot/a.txt")
+	require.NoError(t, err)
+	_, err = cold.HeadObject(ctx, "hot/a.txt")
+	require.Error(t, err)
+	// the keys routed to another backend are hidden.
+	require.NoError(t, cold.PutObject(ctx, "hot/stale.txt", strings.NewReader("stale"), nil, nil))
+
+	var keys []string
+	var pages int
+	require.NoError(t, s.ListObjects(ctx, storage.ListOptions{MaxKeys: 2}, func(page *storage.ListPage) error {
+		pages++
+		for _, obj := range page.Objects {
+			keys = append(keys, strings.TrimPrefix(obj.Key, "/"))
+		}
+		return nil
+	}))
+	require.Equal(t, []string{"cold/d.txt", "e.txt", "hot/a.txt", "hot/b/c.txt"}, keys)
+	require.Equal(t, 2, pages)
+
+	var prefixes []string
+	keys = nil
+	require.NoError(t, s.ListObjects(ctx, storage.ListOptions{Delimiter: "/"}, func(page *storage.ListPage) error {
+		prefixes = append(prefixes, page.CommonPrefixes...)
+		for _, obj := range page.Objects {
+			keys = append(keys, strings.TrimPrefix(obj.Key, "/"))
+		}
+		return nil
+	}))
+	require.Equal(t, []string{"cold/", "hot/"}, prefixes)
+	require.Equal(t, []string{"e.txt"}, keys)
+
+	// a listing resumed by the continuation token skips the keys of the common prefix that ended the previous page,
+	// which the backends such as S3 list again.
+	require.Equal(t, []string{"cold/", "e.txt", "hot/"}, resumedListing(t, s, storage.ListOptions{Delimiter: "/", MaxKeys: 1}))
+	s3Router, err := New([]Route{{Prefix: "hot/", Backend: s3Storage{hot}}, {Backend: s3Storage{cold}}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"cold/", "e.txt", "hot/"}, resumedListing(t, s3Router, storage.ListOptions{Delimiter: "/", MaxKeys: 1}))
+	require.Equal(t, []string{"hot/a.txt", "hot/b/"}, resumedListing(t, s3Router, storage.ListOptions{Prefix: "hot/", Delimiter: "/", MaxKeys: 1}))
+
+	keys = nil
+	require.NoError(t, s.ListObject(ctx, "hot/", true, func(obj storage.Object) {
+		if !obj.IsDir() {
+			keys = append(keys, strings.TrimPrefix(obj.Key, "/"))
+		}
+	}))
+	require.ElementsMatch(t, []string{"hot/a.txt", "hot/b/c.txt"}, keys)
+
+	// copying across the routes streams the object.
+	require.NoError(t, s.CopyObject(ctx, "hot/a.txt", "archive/a.txt"))
+	r, err := cold.GetObject(ctx, "archive/a.txt")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, "hot/a.txt", string(data))
+
+	require.NoError(t, s.DeleteObjects(ctx, []string{"hot/a.txt", "archive/a.txt"}))
+	_, err = hot.HeadObject(ctx, "hot/a.txt")
+	require.Error(t, err)
+	_, err = cold.HeadObject(ctx, "archive/a.txt")
+	require.Error(t, err)
+}
@@ -0,0 +1,75 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+
+	"github.com/MicroOps-cn/fuck/clients/storage"
+)
+
+// applyReadCondition sets the conditional headers of the GetObject request.
+func applyReadCondition(ctx context.Context, input *s3.GetObjectInput) {
+	cond, ok := storage.ConditionFromContext(ctx)
+	if !ok {
+		return
+	}
+	if len(cond.IfMatch) != 0 {
+		input.IfMatch = aws.String(storage.QuoteETag(cond.IfMatch))
+	}
+	if len(cond.IfNoneMatch) != 0 {
+		input.IfNoneMatch = aws.String(storage.QuoteETag(cond.IfNoneMatch))
+	}
+	if !cond.IfModifiedSince.IsZero() {
+		input.IfModifiedSince = aws.Time(cond.IfModifiedSince)
+	}
+	if !cond.IfUnmodifiedSince.IsZero() {
+		input.IfUnmodifiedSince = aws.Time(cond.IfUnmodifiedSince)
+	}
+}
+
+// conditionalWrite returns the options of the uploader that make the write conditional. If-Match and If-None-Match
+// are sent with PutObject and CompleteMultipartUpload, which S3 evaluates atomically. The uploader does not pass
+// the conditions of PutObjectInput to CompleteMultipartUpload, so they are added by a middleware.
+// If-Unmodified-Since is not supported by S3 for writes, it is rejected with storage.ErrConditionNotSupported.
+func conditionalWrite(ctx context.Context, key string) ([]func(*s3.Options), error) {
+	cond, ok := storage.ConditionFromContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+	if !cond.IfUnmodifiedSince.IsZero() {
+		return nil, fmt.Errorf("%w: S3 does not support If-Unmodified-Since for writes of %s", storage.ErrConditionNotSupported, key)
+	}
+	headers := map[string]string{}
+	if len(cond.IfMatch) != 0 {
+		headers["If-Match"] = storage.QuoteETag(cond.IfMatch)
+	}
+	if len(cond.IfNoneMatch) != 0 {
+		headers["If-None-Match"] = storage.QuoteETag(cond.IfNoneMatch)
+	}
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	return []func(*s3.Options){func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			return stack.Build.Add(middleware.BuildMiddlewareFunc("ConditionalWrite", func(
+				ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler,
+			) (middleware.BuildOutput, middleware.Metadata, error) {
+				switch awsmiddleware.GetOperationName(ctx) {
+				case "PutObject", "CompleteMultipartUpload":
+					if req, ok := in.Request.(*smithyhttp.Request); ok {
+						for name, value := range headers {
+							req.Header.Set(name, value)
+						}
+					}
+				}
+				return next.HandleBuild(ctx, in)
+			}), middleware.After)
+		})
+	}}, nil
+}
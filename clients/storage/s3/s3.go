@@ -8,20 +8,23 @@ import (
 	"io"
 	"io/fs"
 	http2 "net/http"
-	"os"
+	"net/url"
 	"strconv"
 	"strings"
+	stdtime "time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/smithy-go"
 	"github.com/aws/smithy-go/time"
-	"github.com/spf13/afero"
+	"github.com/spf13/cast"
 
 	"github.com/MicroOps-cn/fuck/clients/storage"
+	ferrors "github.com/MicroOps-cn/fuck/errors"
 	"github.com/MicroOps-cn/fuck/safe"
 )
 
@@ -33,6 +36,13 @@ type Options struct {
 	Bucket          string      `json:"bucket,omitempty" yaml:"bucket,omitempty" mapstructure:"bucket"`
 	Region          string      `json:"region,omitempty" yaml:"region,omitempty" mapstructure:"region"`
 	Worker          int         `json:"worker,omitempty" yaml:"worker,omitempty" mapstructure:"worker"`
+	PartSize        int64       `json:"part_size,omitempty" yaml:"part_size,omitempty" mapstructure:"part_size"`
+	// ForcePathStyle addresses the buckets in the path instead of the host name, which is required by
+	// most S3-compatible servers that are not behind a wildcard DNS name.
+	ForcePathStyle bool `json:"force_path_style,omitempty" yaml:"force_path_style,omitempty" mapstructure:"force_path_style"`
+	// SDKRetryMaxAttempts is the maximum attempts of a request retried by the SDK, the default is 16, or 1 when
+	// storage.NewClient retries the operations by storage.ResilienceOptions.
+	SDKRetryMaxAttempts int `json:"sdk_retry_max_attempts,omitempty" yaml:"sdk_retry_max_attempts,omitempty" mapstructure:"sdk_retry_max_attempts"`
 }
 
 func (c Client) MarshalJSON() ([]byte, error) {
@@ -44,15 +54,10 @@ type Client struct {
 	uploader *manager.Uploader
 	bucket   string
 	o        Options
-	cacheFS  afero.Fs
 }
 
 func (c Client) ReadDir(name string) ([]fs.DirEntry, error) {
-	var entries []fs.DirEntry
-	err := c.ListObject(context.Background(), name, false, func(object storage.Object) {
-		entries = append(entries, object)
-	})
-	return entries, err
+	return storage.ReadDir(context.Background(), c, name)
 }
 
 func (c Client) Open(name string) (fs.File, error) {
@@ -80,6 +85,14 @@ func (c Client) resolveObjectPath(objectPath string) (key, bucket string, err er
 }
 
 func (c Client) GetObject(ctx context.Context, objectPath string) (*storage.ObjectReader, error) {
+	return c.getObject(ctx, objectPath, 0, -1)
+}
+
+func (c Client) GetObjectRange(ctx context.Context, objectPath string, offset, length int64) (*storage.ObjectReader, error) {
+	return c.getObject(ctx, objectPath, offset, length)
+}
+
+func (c Client) getObject(ctx context.Context, objectPath string, offset, length int64) (*storage.ObjectReader, error) {
 	key, bucket, err := c.resolveObjectPath(objectPath)
 	if err != nil {
 		return nil, err
@@ -87,18 +100,42 @@ func (c Client) GetObject(ctx context.Context, objectPath string) (*storage.Obje
 	if len(key) == 0 {
 		return nil, errors.New("invalid object path: path is empty")
 	}
-	ret, err := c.clt.GetObject(ctx, &s3.GetObjectInput{
+	input := s3.GetObjectInput{
 		Bucket: &bucket,
 		Key:    &key,
-	})
+	}
+	if offset > 0 || length >= 0 {
+		input.Range = aws.String(storage.HTTPRange(offset, length))
+	}
+	applyReadCondition(ctx, &input)
+	ret, err := c.clt.GetObject(ctx, &input)
 	if err != nil {
-		return nil, err
+		return nil, translateError("get", key, err)
+	}
+	size := aws.ToInt64(ret.ContentLength)
+	if totalSize, ok := storage.ContentRangeSize(aws.ToString(ret.ContentRange)); ok {
+		size = totalSize
 	}
 	return &storage.ObjectReader{
 		ReadCloser: ret.Body,
+		Offset:     offset,
+		ReadRange: func(offset, length int64) (io.ReadCloser, error) {
+			ret, err := c.clt.GetObject(ctx, &s3.GetObjectInput{
+				Bucket:  &bucket,
+				Key:     &key,
+				IfMatch: ret.ETag,
+				Range:   aws.String(storage.HTTPRange(offset, length)),
+			})
+			if err != nil {
+				return nil, translateError("get", key, err)
+			}
+			return ret.Body, nil
+		},
 		Object: storage.Object{
+			Key:          key,
 			LastModified: aws.ToTime(ret.LastModified),
-			Size:         aws.ToInt64(ret.ContentLength),
+			Size:         size,
+			ETag:         strings.Trim(aws.ToString(ret.ETag), `"`),
 			Headers: map[string][]string{
 				"Content-Type":        {aws.ToString(ret.ContentType)},
 				"Content-Encoding":    {aws.ToString(ret.ContentEncoding)},
@@ -106,7 +143,7 @@ func (c Client) GetObject(ctx context.Context, objectPath string) (*storage.Obje
 				"Content-Disposition": {aws.ToString(ret.ContentDisposition)},
 				"Cache-Control":       {aws.ToString(ret.CacheControl)},
 				"Expires":             {aws.ToString(ret.ExpiresString)},
-				"Content-Length":      {strconv.FormatInt(aws.ToInt64(ret.ContentLength), 64)},
+				"Content-Length":      {strconv.FormatInt(size, 10)},
 				"Last-Modified":       {time.FormatHTTPDate(aws.ToTime(ret.LastModified))},
 				"ETag":                {aws.ToString(ret.ETag)},
 			},
@@ -149,7 +186,17 @@ func (c Client) PutObject(ctx context.Context, objectPath string, obj io.Reader,
 			}
 		}
 	}
-	_, err = c.uploader.Upload(ctx, &s3PutParams)
+	conditionOptions, err := conditionalWrite(ctx, key)
+	if err != nil {
+		return err
+	}
+	_, err = c.uploader.Upload(ctx, &s3PutParams, func(u *manager.Uploader) {
+		u.ClientOptions = append(u.ClientOptions, conditionOptions...)
+	})
+	if err = translateError("put", key, err); len(conditionOptions) != 0 && errors.Is(err, fs.ErrNotExist) {
+		// S3 responds to If-Match of a missing object with 404.
+		return ferrors.NewPreconditionFailedError(key, "If-Match")
+	}
 	return err
 }
 
@@ -180,69 +227,393 @@ func (c Client) HeadObject(ctx context.Context, objectPath string) (obj *storage
 				"Content-Disposition": {aws.ToString(s3ObjectInfo.ContentDisposition)},
 				"Cache-Control":       {aws.ToString(s3ObjectInfo.CacheControl)},
 				"Expires":             {aws.ToString(s3ObjectInfo.ExpiresString)},
-				"Content-Length":      {strconv.FormatInt(aws.ToInt64(s3ObjectInfo.ContentLength), 64)},
+				"Content-Length":      {strconv.FormatInt(aws.ToInt64(s3ObjectInfo.ContentLength), 10)},
 				"Last-Modified":       {time.FormatHTTPDate(aws.ToTime(s3ObjectInfo.LastModified))},
 				"ETag":                {aws.ToString(s3ObjectInfo.ETag)},
 			},
 			Mode:     storage.RawPermissionsToMode(s3ObjectInfo.Metadata["x-amz-meta-file-permissions"]),
 			Metadata: s3ObjectInfo.Metadata,
 		}, nil
-	} else {
-		var oe *smithy.OperationError
-		var re *http.ResponseError
-		if errors.As(err, &oe) && errors.As(oe, &re) && re.HTTPStatusCode() == 404 {
-			return nil, os.ErrNotExist
-		} else {
-			return nil, err
+	}
+	return nil, translateError("head", key, err)
+}
+
+func (c Client) DeleteObject(ctx context.Context, objectPath string) error {
+	key, bucket, err := c.resolveObjectPath(objectPath)
+	if err != nil {
+		return err
+	}
+	if len(key) == 0 {
+		return errors.New("invalid object path: path is empty")
+	}
+	_, err = c.clt.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	return err
+}
+
+// maxDeleteObjects is the maximum number of keys accepted by a single DeleteObjects request.
+const maxDeleteObjects = 1000
+
+func (c Client) DeleteObjects(ctx context.Context, objectPaths []string) error {
+	var errs []error
+	keys := make(map[string][]types.ObjectIdentifier)
+	for _, objectPath := range objectPaths {
+		key, bucket, err := c.resolveObjectPath(objectPath)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if len(key) == 0 {
+			errs = append(errs, errors.New("invalid object path: path is empty"))
+			continue
+		}
+		keys[bucket] = append(keys[bucket], types.ObjectIdentifier{Key: aws.String(key)})
+	}
+	for bucket, objects := range keys {
+		for len(objects) > 0 {
+			n := min(len(objects), maxDeleteObjects)
+			ret, err := c.clt.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+				Bucket: aws.String(bucket),
+				Delete: &types.Delete{Objects: objects[:n], Quiet: aws.Bool(true)},
+			})
+			if err != nil {
+				return errors.Join(append(errs, err)...)
+			}
+			for _, e := range ret.Errors {
+				errs = append(errs, fmt.Errorf("failed to delete object %s: %s: %s", aws.ToString(e.Key), aws.ToString(e.Code), aws.ToString(e.Message)))
+			}
+			objects = objects[n:]
 		}
 	}
+	return errors.Join(errs...)
 }
 
-func (c Client) ListObject(ctx context.Context, objectPrefix string, recursion bool, callback func(key storage.Object)) error {
-	prefix, bucket, err := c.resolveObjectPath(objectPrefix)
+func (c Client) CopyObject(ctx context.Context, srcPath, dstPath string) error {
+	srcKey, srcBucket, err := c.resolveObjectPath(srcPath)
+	if err != nil {
+		return err
+	}
+	dstKey, dstBucket, err := c.resolveObjectPath(dstPath)
+	if err != nil {
+		return err
+	}
+	if len(srcKey) == 0 || len(dstKey) == 0 {
+		return errors.New("invalid object path: path is empty")
+	}
+	_, err = c.clt.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     &dstBucket,
+		Key:        &dstKey,
+		CopySource: aws.String(srcBucket + "/" + url.PathEscape(srcKey)),
+	})
+	return translateError("copy", srcKey, err)
+}
+
+func (c Client) InitiateMultipartUpload(ctx context.Context, objectPath string, headers http2.Header, metadata map[string]string) (string, error) {
+	key, bucket, err := c.resolveObjectPath(objectPath)
+	if err != nil {
+		return "", err
+	}
+	if len(key) == 0 {
+		return "", errors.New("invalid object path: path is empty")
+	}
+	input := s3.CreateMultipartUploadInput{
+		Key:      &key,
+		Bucket:   &bucket,
+		Metadata: metadata,
+	}
+	for name := range headers {
+		switch name {
+		case "Content-Type":
+			input.ContentType = aws.String(headers.Get(name))
+		case "Content-Encoding":
+			input.ContentEncoding = aws.String(headers.Get(name))
+		case "Content-Language":
+			input.ContentLanguage = aws.String(headers.Get(name))
+		case "Content-Disposition":
+			input.ContentDisposition = aws.String(headers.Get(name))
+		case "Cache-Control":
+			input.CacheControl = aws.String(headers.Get(name))
+		case "Expires":
+			date, err := time.ParseHTTPDate(headers.Get(name))
+			if err == nil {
+				input.Expires = aws.Time(date)
+			}
+		}
+	}
+	ret, err := c.clt.CreateMultipartUpload(ctx, &input)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(ret.UploadId), nil
+}
+
+func (c Client) UploadPart(ctx context.Context, objectPath, uploadID string, partNumber int32, body io.Reader, size int64) (storage.Part, error) {
+	key, bucket, err := c.resolveObjectPath(objectPath)
+	if err != nil {
+		return storage.Part{}, err
+	}
+	ret, err := c.clt.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        &bucket,
+		Key:           &key,
+		UploadId:      &uploadID,
+		PartNumber:    aws.Int32(partNumber),
+		Body:          body,
+		ContentLength: aws.Int64(size),
+	})
+	if err != nil {
+		return storage.Part{}, translateUploadError(uploadID, err)
+	}
+	return storage.Part{PartNumber: partNumber, ETag: aws.ToString(ret.ETag), Size: size}, nil
+}
+
+func (c Client) CompleteMultipartUpload(ctx context.Context, objectPath, uploadID string, parts []storage.Part) error {
+	key, bucket, err := c.resolveObjectPath(objectPath)
 	if err != nil {
 		return err
 	}
-	var delimiter *string
+	completedParts := make([]types.CompletedPart, len(parts))
+	for i, part := range parts {
+		completedParts[i] = types.CompletedPart{
+			ETag:       aws.String(part.ETag),
+			PartNumber: aws.Int32(part.PartNumber),
+		}
+	}
+	_, err = c.clt.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          &bucket,
+		Key:             &key,
+		UploadId:        &uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completedParts},
+	})
+	return translateUploadError(uploadID, err)
+}
 
-	if !recursion {
-		delimiter = aws.String("/")
+func (c Client) AbortMultipartUpload(ctx context.Context, objectPath, uploadID string) error {
+	key, bucket, err := c.resolveObjectPath(objectPath)
+	if err != nil {
+		return err
 	}
-	var continueToken *string
+	_, err = c.clt.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   &bucket,
+		Key:      &key,
+		UploadId: &uploadID,
+	})
+	return err
+}
+
+func (c Client) ListMultipartUploads(ctx context.Context, objectPrefix string) ([]storage.MultipartUpload, error) {
+	prefix, bucket, err := c.resolveObjectPath(objectPrefix)
+	if err != nil {
+		return nil, err
+	}
+	var uploads []storage.MultipartUpload
+	var keyMarker, uploadIDMarker *string
 	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			result, err := c.clt.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
-				Bucket:            &bucket,
-				Prefix:            &prefix,
-				MaxKeys:           aws.Int32(1000),
-				ContinuationToken: continueToken,
-				Delimiter:         delimiter,
+		ret, err := c.clt.ListMultipartUploads(ctx, &s3.ListMultipartUploadsInput{
+			Bucket:         &bucket,
+			Prefix:         &prefix,
+			KeyMarker:      keyMarker,
+			UploadIdMarker: uploadIDMarker,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, upload := range ret.Uploads {
+			uploads = append(uploads, storage.MultipartUpload{
+				Key:       aws.ToString(upload.Key),
+				UploadID:  aws.ToString(upload.UploadId),
+				Initiated: aws.ToTime(upload.Initiated),
 			})
-			if err != nil {
+		}
+		if !aws.ToBool(ret.IsTruncated) {
+			return uploads, nil
+		}
+		keyMarker, uploadIDMarker = ret.NextKeyMarker, ret.NextUploadIdMarker
+	}
+}
+
+// UploadFile uploads a local file with multipart upload, part size and concurrency default to the client options.
+func (c Client) UploadFile(ctx context.Context, objectPath, filePath string, o storage.UploadOptions) error {
+	if o.PartSize <= 0 {
+		o.PartSize = c.o.PartSize
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = c.o.Worker
+	}
+	return storage.UploadFile(ctx, c, objectPath, filePath, o)
+}
+
+func (c Client) PresignGet(ctx context.Context, objectPath string, o storage.PresignOptions) (*storage.PresignedRequest, error) {
+	key, bucket, err := c.resolveObjectPath(objectPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) == 0 {
+		return nil, errors.New("invalid object path: path is empty")
+	}
+	input := s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	}
+	if len(o.ContentType) != 0 {
+		input.ResponseContentType = aws.String(o.ContentType)
+	}
+	for name := range o.Headers {
+		switch name {
+		case "Content-Type":
+			input.ResponseContentType = aws.String(o.Headers.Get(name))
+		case "Content-Encoding":
+			input.ResponseContentEncoding = aws.String(o.Headers.Get(name))
+		case "Content-Language":
+			input.ResponseContentLanguage = aws.String(o.Headers.Get(name))
+		case "Content-Disposition":
+			input.ResponseContentDisposition = aws.String(o.Headers.Get(name))
+		case "Cache-Control":
+			input.ResponseCacheControl = aws.String(o.Headers.Get(name))
+		}
+	}
+	expiration := stdtime.Now().Add(o.ExpiresIn())
+	req, err := s3.NewPresignClient(c.clt).PresignGetObject(ctx, &input, s3.WithPresignExpires(o.ExpiresIn()))
+	if err != nil {
+		return nil, err
+	}
+	return &storage.PresignedRequest{Method: req.Method, URL: req.URL, SignedHeader: req.SignedHeader, Expiration: expiration}, nil
+}
+
+func (c Client) PresignPut(ctx context.Context, objectPath string, o storage.PresignOptions) (*storage.PresignedRequest, error) {
+	key, bucket, err := c.resolveObjectPath(objectPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) == 0 {
+		return nil, errors.New("invalid object path: path is empty")
+	}
+	input := s3.PutObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	}
+	for name := range o.Headers {
+		switch name {
+		case "Content-Type":
+			input.ContentType = aws.String(o.Headers.Get(name))
+		case "Content-Encoding":
+			input.ContentEncoding = aws.String(o.Headers.Get(name))
+		case "Content-Language":
+			input.ContentLanguage = aws.String(o.Headers.Get(name))
+		case "Content-Disposition":
+			input.ContentDisposition = aws.String(o.Headers.Get(name))
+		case "Cache-Control":
+			input.CacheControl = aws.String(o.Headers.Get(name))
+		}
+	}
+	if len(o.ContentType) != 0 {
+		input.ContentType = aws.String(o.ContentType)
+	}
+	expiration := stdtime.Now().Add(o.ExpiresIn())
+	req, err := s3.NewPresignClient(c.clt).PresignPutObject(ctx, &input, s3.WithPresignExpires(o.ExpiresIn()))
+	if err != nil {
+		return nil, err
+	}
+	return &storage.PresignedRequest{Method: req.Method, URL: req.URL, SignedHeader: req.SignedHeader, Expiration: expiration}, nil
+}
+
+// translateError converts the "404 Not Found" response of the object operation to fs.ErrNotExist.
+func translateError(op, key string, err error) error {
+	var oe *smithy.OperationError
+	var re *http.ResponseError
+	if errors.As(err, &oe) && errors.As(oe, &re) {
+		switch re.HTTPStatusCode() {
+		case http2.StatusNotFound:
+			return &fs.PathError{Op: op, Path: key, Err: fs.ErrNotExist}
+		case http2.StatusPreconditionFailed, http2.StatusConflict:
+			// 409 is returned when a conditional write conflicts with a concurrent write.
+			return ferrors.NewPreconditionFailedError(key, op)
+		case http2.StatusNotModified:
+			return ferrors.NewNotModifiedError(key, op)
+		}
+	}
+	return err
+}
+
+// translateUploadError converts the NoSuchUpload error of the multipart upload operation to storage.ErrNoSuchUpload.
+func translateUploadError(uploadID string, err error) error {
+	var ae smithy.APIError
+	if errors.As(err, &ae) && ae.ErrorCode() == "NoSuchUpload" {
+		return fmt.Errorf("%w: %s: %s", storage.ErrNoSuchUpload, uploadID, err)
+	}
+	return err
+}
+
+func (c Client) ListObject(ctx context.Context, objectPrefix string, recursion bool, callback func(key storage.Object)) error {
+	o := storage.ListOptions{Prefix: objectPrefix}
+	if !recursion {
+		o.Delimiter = "/"
+	}
+	return c.ListObjects(ctx, o, func(page *storage.ListPage) error {
+		for _, obj := range page.Objects {
+			if err := ctx.Err(); err != nil {
 				return err
 			}
-			for _, obj := range result.Contents {
-				select {
-				case <-ctx.Done():
-					return ctx.Err()
-				default:
-					callback(storage.Object{
-						Key:          aws.ToString(obj.Key),
-						LastModified: aws.ToTime(obj.LastModified),
-						Size:         aws.ToInt64(obj.Size),
-						ETag:         strings.Trim(aws.ToString(obj.ETag), `"`),
-						StorageClass: string(obj.StorageClass),
-					})
-				}
-			}
-			if result.NextContinuationToken == nil || len(aws.ToString(result.NextContinuationToken)) == 0 {
+			callback(obj)
+		}
+		return nil
+	})
+}
+
+func (c Client) ListObjects(ctx context.Context, o storage.ListOptions, callback func(page *storage.ListPage) error) error {
+	prefix, bucket, err := c.resolveObjectPath(o.Prefix)
+	if err != nil {
+		return err
+	}
+	input := s3.ListObjectsV2Input{
+		Bucket:  &bucket,
+		Prefix:  &prefix,
+		MaxKeys: aws.Int32(int32(o.PageSize())),
+	}
+	if len(o.Delimiter) != 0 {
+		input.Delimiter = aws.String(o.Delimiter)
+	}
+	if len(o.StartAfter) != 0 {
+		input.StartAfter = aws.String(o.StartAfter)
+	}
+	if len(o.ContinuationToken) != 0 {
+		input.ContinuationToken = aws.String(o.ContinuationToken)
+	}
+	for {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		result, err := c.clt.ListObjectsV2(ctx, &input)
+		if err != nil {
+			return err
+		}
+		page := storage.ListPage{
+			Objects:               make([]storage.Object, 0, len(result.Contents)),
+			NextContinuationToken: aws.ToString(result.NextContinuationToken),
+		}
+		for _, obj := range result.Contents {
+			page.Objects = append(page.Objects, storage.Object{
+				Key:          aws.ToString(obj.Key),
+				LastModified: aws.ToTime(obj.LastModified),
+				Size:         aws.ToInt64(obj.Size),
+				ETag:         strings.Trim(aws.ToString(obj.ETag), `"`),
+				StorageClass: string(obj.StorageClass),
+			})
+		}
+		for _, commonPrefix := range result.CommonPrefixes {
+			page.CommonPrefixes = append(page.CommonPrefixes, aws.ToString(commonPrefix.Prefix))
+		}
+		if err = callback(&page); err != nil {
+			if errors.Is(err, fs.SkipAll) {
 				return nil
 			}
-			continueToken = result.NextContinuationToken
+			return err
+		}
+		if len(page.NextContinuationToken) == 0 {
+			return nil
 		}
+		input.ContinuationToken = result.NextContinuationToken
 	}
 }
 
@@ -268,6 +639,11 @@ func NewClient(ctx context.Context, v storage.ConfigProvider) (*Client, error) {
 	if len(endpoint) > 0 && !(strings.HasPrefix(endpoint, "http://") || strings.HasPrefix(endpoint, "https://")) {
 		endpoint = "http://" + endpoint
 	}
+	forcePathStyle := cast.ToBool(v.GetString("force_path_style"))
+	retryMaxAttempts := v.GetInt("sdk_retry_max_attempts")
+	if retryMaxAttempts <= 0 {
+		retryMaxAttempts = 16
+	}
 	clt := s3.NewFromConfig(aws.Config{
 		Region: region,
 		Credentials: credentials.StaticCredentialsProvider{
@@ -278,22 +654,39 @@ func NewClient(ctx context.Context, v storage.ConfigProvider) (*Client, error) {
 		}},
 		func(o *s3.Options) {
 			o.BaseEndpoint = &endpoint
-			o.RetryMaxAttempts = 16
+			o.RetryMaxAttempts = retryMaxAttempts
+			o.UsePathStyle = forcePathStyle
 		},
 	)
+	workerNum := v.GetInt("worker")
+	if workerNum <= 0 {
+		workerNum = manager.DefaultUploadConcurrency
+	}
+	partSize := int64(v.GetInt("part_size"))
+	if partSize <= 0 {
+		partSize = storage.DefaultPartSize
+	} else if partSize < manager.MinUploadPartSize {
+		partSize = manager.MinUploadPartSize
+	}
 	return &Client{
 		clt: clt,
 		uploader: manager.NewUploader(clt, func(uploader *manager.Uploader) {
-			uploader.BufferProvider = manager.NewBufferedReadSeekerWriteToPool(10 * 1024 * 1024)
+			uploader.BufferProvider = manager.NewBufferedReadSeekerWriteToPool(int(partSize))
+			uploader.PartSize = partSize
+			uploader.Concurrency = workerNum
 		}),
 		bucket: v.GetString("bucket"),
 		o: Options{
-			AccessKeyId:     accessKeyId,
-			SecretAccessKey: safeSecretKey,
-			Region:          region,
-			Endpoint:        endpoint,
-			Bucket:          v.GetString("bucket"),
-			Type:            Client{}.Type(),
+			AccessKeyId:         accessKeyId,
+			SecretAccessKey:     safeSecretKey,
+			Region:              region,
+			Endpoint:            endpoint,
+			Bucket:              v.GetString("bucket"),
+			Worker:              workerNum,
+			PartSize:            partSize,
+			ForcePathStyle:      forcePathStyle,
+			SDKRetryMaxAttempts: retryMaxAttempts,
+			Type:                Client{}.Type(),
 		},
 	}, nil
 }
@@ -3,10 +3,14 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
 	stdtime "time"
 
 	"github.com/spf13/cast"
@@ -18,14 +22,53 @@ type Storage interface {
 	Type() string
 	Name() string
 	ListObject(ctx context.Context, objectPrefix string, recursion bool, callback func(key Object)) error
+	// ListObjects lists the objects page by page in lexicographical order of the keys, until the last page
+	// or the callback returns an error. Returning fs.SkipAll from the callback stops the listing without error.
+	ListObjects(ctx context.Context, o ListOptions, callback func(page *ListPage) error) error
 	HeadObject(ctx context.Context, objectPath string) (obj *Object, err error)
+	// GetObject returns a reader of the object, the reads and writes are conditional if ctx has a Condition,
+	// see WithCondition.
 	GetObject(ctx context.Context, objectPath string) (*ObjectReader, error)
+	// GetObjectRange returns a reader of length bytes starting at offset, length < 0 means to the end of the object.
+	// Seek and ReadAt of the returned reader address the whole object.
+	GetObjectRange(ctx context.Context, objectPath string, offset, length int64) (*ObjectReader, error)
 	PutObject(ctx context.Context, objectPath string, obj io.Reader, headers http.Header, metadata map[string]string) error
+	// DeleteObject removes the object. Deleting an object that does not exist is not an error.
+	DeleteObject(ctx context.Context, objectPath string) error
+	// DeleteObjects removes the objects in as few requests as the backend allows.
+	// Objects that do not exist are ignored, the failures of the others are joined into the returned error.
+	DeleteObjects(ctx context.Context, objectPaths []string) error
+	// CopyObject copies srcPath to dstPath within the backend. If srcPath does not exist, an error
+	// satisfying errors.Is(err, fs.ErrNotExist) is returned.
+	CopyObject(ctx context.Context, srcPath, dstPath string) error
+}
+
+// Copy copies srcPath in src to dstPath in dst. When src and dst are the same backend, the backend's
+// own CopyObject is used, otherwise the object is streamed from src to dst. The Condition of ctx only applies to
+// the write of dstPath.
+func Copy(ctx context.Context, dst Storage, dstPath string, src Storage, srcPath string) error {
+	if isSameStorage(dst, src) {
+		return src.CopyObject(ctx, srcPath, dstPath)
+	}
+	r, err := src.GetObject(WithoutCondition(ctx), srcPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return dst.PutObject(ctx, dstPath, r, r.Headers, r.Metadata)
+}
+
+func isSameStorage(a, b Storage) bool {
+	if reflect.ValueOf(a).Kind() != reflect.Pointer {
+		return false
+	}
+	return a == b
 }
 
 type Object struct {
 	Key          string
 	LastModified stdtime.Time
+	// Size is -1 if it is unknown, such as the size of an object that is decompressed while being read.
 	Size         int64
 	ETag         string
 	StorageClass string
@@ -58,6 +101,126 @@ func (o Object) Info() (fs.FileInfo, error) {
 type ObjectReader struct {
 	io.ReadCloser
 	Object
+	// ReadRange opens length bytes of the object starting at offset, length < 0 means to the end of the object.
+	// It is used by Seek and ReadAt when the underlying reader does not support them.
+	ReadRange func(offset, length int64) (io.ReadCloser, error)
+	// Offset is the position of ReadCloser in the object.
+	Offset int64
+
+	seekTo *int64
+}
+
+func (o *ObjectReader) Read(p []byte) (n int, err error) {
+	if o.seekTo != nil {
+		if err = o.reopen(*o.seekTo); err != nil {
+			return 0, err
+		}
+		o.seekTo = nil
+	}
+	n, err = o.ReadCloser.Read(p)
+	o.Offset += int64(n)
+	return n, err
+}
+
+func (o *ObjectReader) reopen(offset int64) error {
+	if offset == o.Offset {
+		return nil
+	}
+	if seeker, ok := o.ReadCloser.(io.Seeker); ok {
+		if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		o.Offset = offset
+		return nil
+	}
+	if o.Size >= 0 && offset >= o.Size {
+		_ = o.ReadCloser.Close()
+		o.ReadCloser = http.NoBody
+		o.Offset = offset
+		return nil
+	}
+	if o.ReadRange == nil {
+		return errors.New("storage: seek is not supported")
+	}
+	r, err := o.ReadRange(offset, -1)
+	if err != nil {
+		return err
+	}
+	_ = o.ReadCloser.Close()
+	o.ReadCloser = r
+	o.Offset = offset
+	return nil
+}
+
+// Seek sets the position for the next Read, the underlying reader is repositioned lazily by the next Read.
+func (o *ObjectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		if o.seekTo != nil {
+			offset += *o.seekTo
+		} else {
+			offset += o.Offset
+		}
+	case io.SeekEnd:
+		if o.Size < 0 {
+			return 0, errors.New("storage: size of the object is unknown")
+		}
+		offset += o.Size
+	default:
+		return 0, errors.New("storage: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("storage: negative position")
+	}
+	o.seekTo = &offset
+	return offset, nil
+}
+
+func (o *ObjectReader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("storage: negative offset")
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if ra, ok := o.ReadCloser.(io.ReaderAt); ok {
+		return ra.ReadAt(p, off)
+	}
+	if o.Size >= 0 && off >= o.Size {
+		return 0, io.EOF
+	}
+	if o.ReadRange == nil {
+		return 0, errors.New("storage: read at is not supported")
+	}
+	r, err := o.ReadRange(off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	n, err = io.ReadFull(r, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// HTTPRange formats the value of the Range header, length < 0 means to the end of the object.
+func HTTPRange(offset, length int64) string {
+	if length < 0 {
+		return fmt.Sprintf("bytes=%d-", offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+}
+
+// ContentRangeSize returns the complete length of the object in the Content-Range header, such as "bytes 0-99/1234".
+func ContentRangeSize(contentRange string) (int64, bool) {
+	_, size, found := strings.Cut(contentRange, "/")
+	if !found || size == "*" {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	return n, err == nil
 }
 
 type FileInfo struct {
@@ -133,8 +296,26 @@ func (v mapConfigProvider) GetString(key string) string {
 	return cast.ToString(v.Get(key))
 }
 
+// Get returns the value of key, the keys of the nested maps and the indexes of the nested slices are joined by ".",
+// such as "backends.0.type".
 func (v mapConfigProvider) Get(key string) interface{} {
-	val, _ := v[key]
+	if val, ok := v[key]; ok {
+		return val
+	}
+	var val interface{} = map[string]interface{}(v)
+	for _, name := range strings.Split(key, ".") {
+		if m, err := cast.ToStringMapE(val); err == nil {
+			val = m[name]
+		} else if s, err := cast.ToSliceE(val); err == nil {
+			i, err := strconv.Atoi(name)
+			if err != nil || i < 0 || i >= len(s) {
+				return nil
+			}
+			val = s[i]
+		} else {
+			return nil
+		}
+	}
 	return val
 }
 
@@ -146,6 +327,45 @@ func NewMapConfigProvider(m map[string]interface{}) ConfigProvider {
 	return mapConfigProvider(m)
 }
 
+type subConfigProvider struct {
+	v      ConfigProvider
+	prefix string
+}
+
+func (v subConfigProvider) GetString(key string) string {
+	return v.v.GetString(v.prefix + "." + key)
+}
+
+func (v subConfigProvider) GetInt(key string) int {
+	return v.v.GetInt(v.prefix + "." + key)
+}
+
+// SubConfig returns the config under prefix, such as the config of a nested backend. The keys are joined by ".",
+// which is how viper and NewMapConfigProvider address the nested maps and slices.
+func SubConfig(v ConfigProvider, prefix string) ConfigProvider {
+	return subConfigProvider{v: v, prefix: prefix}
+}
+
+// defaultConfigProvider returns the values of defaults for the keys that are not set in the config.
+type defaultConfigProvider struct {
+	ConfigProvider
+	defaults mapConfigProvider
+}
+
+func (v defaultConfigProvider) GetString(key string) string {
+	if val := v.ConfigProvider.GetString(key); len(val) != 0 {
+		return val
+	}
+	return v.defaults.GetString(key)
+}
+
+func (v defaultConfigProvider) GetInt(key string) int {
+	if len(v.ConfigProvider.GetString(key)) != 0 {
+		return v.ConfigProvider.GetInt(key)
+	}
+	return v.defaults.GetInt(key)
+}
+
 var registeredStorage = make(map[string]func(ctx context.Context, v ConfigProvider) (Storage, error))
 
 func RegisterStorage(storageType string, newFunc func(ctx context.Context, v ConfigProvider) (Storage, error)) {
@@ -155,13 +375,41 @@ func RegisterStorage(storageType string, newFunc func(ctx context.Context, v Con
 	registeredStorage[storageType] = newFunc
 }
 
+// NewClient creates the backend of the registered type. The backend is wrapped by WithResilience if the config has
+// any of the keys of ResilienceOptions, and is instrumented by Instrument unless the config sets instrument to false.
+// The wrapped backends do not implement the optional interfaces, such as MultipartUploader, use As to get them.
+// When ResilienceOptions retries the operations, sdk_retry_max_attempts defaults to 1, so that the retries of the
+// SDKs of the backends do not multiply the attempts.
 func NewClient(ctx context.Context, v ConfigProvider) (Storage, error) {
 	storageType := v.GetString("type")
 	if storageType == "" {
 		return nil, fmt.Errorf("storage type is empty")
 	}
 	if newFunc, ok := registeredStorage[storageType]; ok {
-		return newFunc(ctx, v)
+		o, err := ResilienceOptionsFromConfig(v)
+		if err != nil {
+			return nil, err
+		}
+		instrument := true
+		if val := v.GetString("instrument"); len(val) != 0 {
+			if instrument, err = cast.ToBoolE(val); err != nil {
+				return nil, fmt.Errorf("invalid instrument: %w", err)
+			}
+		}
+		if o.MaxAttempts > 1 && len(v.GetString("sdk_retry_max_attempts")) == 0 {
+			v = defaultConfigProvider{ConfigProvider: v, defaults: mapConfigProvider{"sdk_retry_max_attempts": 1}}
+		}
+		s, err := newFunc(ctx, v)
+		if err != nil {
+			return nil, err
+		}
+		if o.enabled() {
+			s = WithResilience(s, o)
+		}
+		if instrument {
+			s = Instrument(s)
+		}
+		return s, nil
 	}
 	return nil, fmt.Errorf("unknown backend type %s", storageType)
 }
@@ -1,8 +1,13 @@
 package storage
 
 import (
+	"bytes"
+	"context"
+	"io"
 	"io/fs"
 	"testing"
+
+	"github.com/stretchr/testify/require"
 )
 
 func TestRawPermissionsToMode(t *testing.T) {
@@ -28,3 +33,82 @@ func TestRawPermissionsToMode(t *testing.T) {
 		})
 	}
 }
+
+func TestObjectReader(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+	var opened int
+	readRange := func(offset, length int64) (io.ReadCloser, error) {
+		opened++
+		end := int64(len(content))
+		if length >= 0 {
+			end = min(offset+length, end)
+		}
+		return io.NopCloser(bytes.NewReader(content[offset:end])), nil
+	}
+	r := &ObjectReader{
+		ReadCloser: io.NopCloser(bytes.NewReader(content[5:10])),
+		Object:     Object{Size: int64(len(content))},
+		ReadRange:  readRange,
+		Offset:     5,
+	}
+	buf, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "56789", string(buf))
+
+	pos, err := r.Seek(-3, io.SeekEnd)
+	require.NoError(t, err)
+	require.Equal(t, int64(17), pos)
+	buf, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "hij", string(buf))
+
+	buf = make([]byte, 4)
+	n, err := r.ReadAt(buf, 10)
+	require.NoError(t, err)
+	require.Equal(t, "abcd", string(buf[:n]))
+	n, err = r.ReadAt(buf, 18)
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, "ij", string(buf[:n]))
+
+	_, err = r.Seek(0, io.SeekEnd)
+	require.NoError(t, err)
+	buf, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.Empty(t, buf)
+	require.Equal(t, 3, opened)
+}
+
+func TestSubConfig(t *testing.T) {
+	v := NewMapConfigProvider(map[string]interface{}{
+		"backends": []interface{}{
+			map[string]interface{}{"type": "local", "worker": 4},
+			map[interface{}]interface{}{"type": "s3"},
+		},
+		"routes.0.prefix": "hot/",
+	})
+	require.Equal(t, "local", SubConfig(v, "backends.0").GetString("type"))
+	require.Equal(t, 4, SubConfig(v, "backends.0").GetInt("worker"))
+	require.Equal(t, "s3", SubConfig(v, "backends.1").GetString("type"))
+	require.Equal(t, "", SubConfig(v, "backends.2").GetString("type"))
+	require.Equal(t, "hot/", SubConfig(v, "routes.0").GetString("prefix"))
+}
+
+func TestReadDir(t *testing.T) {
+	m := newMemStorage(map[string]string{"a/b.txt": "b", "a/c/d.txt": "d", "e.txt": "e"})
+	entries, err := fs.ReadDir(m, "a")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "b.txt", entries[0].Name())
+	require.Equal(t, "c", entries[1].Name())
+	require.True(t, entries[1].IsDir())
+
+	_, err = fs.ReadDir(m, "missing")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	var pathErr *fs.PathError
+	require.ErrorAs(t, err, &pathErr)
+	require.Equal(t, "missing", pathErr.Path)
+
+	entries, err = ReadDir(context.Background(), newMemStorage(nil), ".")
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
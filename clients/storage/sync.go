@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type SyncCompare string
+
+const (
+	// SyncCompareModTime copies the objects whose sizes differ or that are modified after the destination.
+	SyncCompareModTime SyncCompare = "mtime"
+	// SyncCompareSize copies the objects whose sizes differ.
+	SyncCompareSize SyncCompare = "size"
+	// SyncCompareETag copies the objects whose ETags differ, the sizes are compared if either ETag is unknown.
+	// ETags are only comparable between backends of the same type.
+	SyncCompareETag SyncCompare = "etag"
+)
+
+type SyncAction string
+
+const (
+	SyncActionCopy   SyncAction = "copy"
+	SyncActionDelete SyncAction = "delete"
+	SyncActionSkip   SyncAction = "skip"
+)
+
+// SyncEvent is reported to SyncOptions.Progress for every object, Key is relative to the prefix.
+type SyncEvent struct {
+	Action SyncAction
+	Key    string
+	Size   int64
+	DryRun bool
+	Err    error
+}
+
+type SyncOptions struct {
+	// Compare is how the changed objects are detected, the default is SyncCompareModTime.
+	Compare SyncCompare
+	// Delete removes the objects in the destination that do not exist in the source.
+	Delete bool
+	// DryRun reports the actions without copying or deleting anything.
+	DryRun bool
+	// Include and Exclude are patterns of the keys relative to the prefixes, see MatchKey.
+	// If Include is not empty, only the keys matching any of it are synchronized.
+	Include []string
+	Exclude []string
+	// Workers is the number of objects copied in parallel, the default is 4.
+	Workers int
+	// Progress is called after each object is processed. The calls are serialized.
+	Progress func(event SyncEvent)
+}
+
+type SyncResult struct {
+	Copied  int
+	Deleted int
+	Skipped int
+	Failed  int
+	// Bytes is the total size of the copied objects.
+	Bytes int64
+}
+
+func (o SyncOptions) match(key string) bool {
+	for _, pattern := range o.Exclude {
+		if MatchKey(pattern, key) {
+			return false
+		}
+	}
+	if len(o.Include) == 0 {
+		return true
+	}
+	for _, pattern := range o.Include {
+		if MatchKey(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncPrefix returns the prefix as a directory, such as "logs/", or an empty string for the root.
+func syncPrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if len(prefix) == 0 {
+		return ""
+	}
+	return prefix + "/"
+}
+
+// listRelative lists the objects under prefix by their keys relative to prefix.
+func listRelative(ctx context.Context, s Storage, prefix string) (map[string]Object, error) {
+	objects := map[string]Object{}
+	err := s.ListObject(ctx, prefix, true, func(obj Object) {
+		if obj.IsDir() || !strings.HasPrefix(obj.Key, prefix) || len(obj.Key) == len(prefix) {
+			return
+		}
+		objects[strings.TrimPrefix(obj.Key, prefix)] = obj
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+func (o SyncOptions) changed(ctx context.Context, src, dst Storage, srcObj, dstObj Object) (bool, error) {
+	switch o.Compare {
+	case SyncCompareSize:
+		return srcObj.Size != dstObj.Size, nil
+	case SyncCompareETag:
+		if len(srcObj.ETag) == 0 {
+			head, err := src.HeadObject(ctx, srcObj.Key)
+			if err != nil {
+				return false, err
+			}
+			srcObj.ETag = head.ETag
+		}
+		if len(dstObj.ETag) == 0 {
+			head, err := dst.HeadObject(ctx, dstObj.Key)
+			if err != nil {
+				return false, err
+			}
+			dstObj.ETag = head.ETag
+		}
+		if len(srcObj.ETag) == 0 || len(dstObj.ETag) == 0 {
+			return srcObj.Size != dstObj.Size, nil
+		}
+		return srcObj.ETag != dstObj.ETag, nil
+	default:
+		return srcObj.Size != dstObj.Size || srcObj.LastModified.After(dstObj.LastModified), nil
+	}
+}
+
+// Sync makes the objects under dstPrefix of dst the same as the objects under srcPrefix of src. The prefixes are
+// directories of keys without the scheme, such as "logs/2024". Only the changed objects are copied, see
+// SyncOptions.Compare. The failures of individual objects do not stop the synchronization, they are joined into
+// the returned error with the error of ctx if it is canceled.
+//
+// Sync only uses ListObject, HeadObject, GetObject and PutObject of the backends, so that it works with any backend,
+// except that SyncOptions.Delete removes the extraneous objects by DeleteObject of dst.
+func Sync(ctx context.Context, src, dst Storage, srcPrefix, dstPrefix string, o SyncOptions) (*SyncResult, error) {
+	if o.Workers <= 0 {
+		o.Workers = 4
+	}
+	srcPrefix, dstPrefix = syncPrefix(srcPrefix), syncPrefix(dstPrefix)
+	srcObjects, err := listRelative(ctx, src, srcPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list source objects: %w", err)
+	}
+	dstObjects, err := listRelative(ctx, dst, dstPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list destination objects: %w", err)
+	}
+	keys := make([]string, 0, len(srcObjects))
+	for key := range srcObjects {
+		if o.match(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var (
+		result SyncResult
+		errs   []error
+		mux    sync.Mutex
+		wg     sync.WaitGroup
+		tasks  = make(chan string)
+	)
+	report := func(event SyncEvent) {
+		mux.Lock()
+		defer mux.Unlock()
+		switch {
+		case event.Err != nil:
+			result.Failed++
+			errs = append(errs, fmt.Errorf("failed to %s %s: %w", event.Action, event.Key, event.Err))
+		case event.Action == SyncActionCopy:
+			result.Copied++
+			result.Bytes += event.Size
+		case event.Action == SyncActionDelete:
+			result.Deleted++
+		default:
+			result.Skipped++
+		}
+		if o.Progress != nil {
+			o.Progress(event)
+		}
+	}
+	for i := 0; i < o.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range tasks {
+				srcObj := srcObjects[key]
+				event := SyncEvent{Action: SyncActionCopy, Key: key, Size: srcObj.Size, DryRun: o.DryRun}
+				if dstObj, ok := dstObjects[key]; ok {
+					changed, err := o.changed(ctx, src, dst, srcObj, dstObj)
+					if err != nil {
+						event.Err = err
+					} else if !changed {
+						event.Action = SyncActionSkip
+					}
+				}
+				if event.Action == SyncActionCopy && event.Err == nil && !o.DryRun {
+					event.Err = syncObject(ctx, src, dst, srcObj.Key, dstPrefix+key)
+				}
+				report(event)
+			}
+		}()
+	}
+loop:
+	for _, key := range keys {
+		select {
+		case tasks <- key:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(tasks)
+	wg.Wait()
+	if err = ctx.Err(); err != nil {
+		return &result, errors.Join(append(errs, err)...)
+	}
+
+	if o.Delete {
+		var extraneous []string
+		for key := range dstObjects {
+			if _, ok := srcObjects[key]; !ok && o.match(key) {
+				extraneous = append(extraneous, key)
+			}
+		}
+		sort.Strings(extraneous)
+		for _, key := range extraneous {
+			if err = ctx.Err(); err != nil {
+				return &result, errors.Join(append(errs, err)...)
+			}
+			event := SyncEvent{Action: SyncActionDelete, Key: key, Size: dstObjects[key].Size, DryRun: o.DryRun}
+			if !o.DryRun {
+				event.Err = dst.DeleteObject(ctx, dstObjects[key].Key)
+			}
+			report(event)
+		}
+	}
+	return &result, errors.Join(errs...)
+}
+
+// syncObject streams the object from src to dst by GetObject and PutObject.
+func syncObject(ctx context.Context, src, dst Storage, srcPath, dstPath string) error {
+	r, err := src.GetObject(ctx, srcPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return dst.PutObject(ctx, dstPath, r, r.Headers, r.Metadata)
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	src := newMemStorage(map[string]string{
+		"logs/a.log":        "a",
+		"logs/b.log":        "bb",
+		"logs/tmp/c.tmp":    "c",
+		"logs/2024/d.log":   "d",
+		"logs-other/e.log":  "e",
+		"logs/unchanged.gz": "same",
+	})
+	dst := newMemStorage(map[string]string{
+		"backup/b.log":        "b",
+		"backup/unchanged.gz": "same",
+		"backup/old.log":      "old",
+		"backup/tmp/keep.tmp": "keep",
+	})
+
+	var events []SyncEvent
+	o := SyncOptions{
+		Delete:   true,
+		DryRun:   true,
+		Exclude:  []string{"tmp/"},
+		Progress: func(event SyncEvent) { events = append(events, event) },
+	}
+	result, err := Sync(ctx, src, dst, "logs", "/backup/", o)
+	require.NoError(t, err)
+	require.Equal(t, SyncResult{Copied: 3, Deleted: 1, Skipped: 1, Bytes: 4}, *result)
+	require.Len(t, events, 5)
+	require.Len(t, dst.files(), 4)
+
+	o.DryRun = false
+	result, err = Sync(ctx, src, dst, "logs", "/backup/", o)
+	require.NoError(t, err)
+	require.Equal(t, SyncResult{Copied: 3, Deleted: 1, Skipped: 1, Bytes: 4}, *result)
+	require.Equal(t, map[string]string{
+		"backup/a.log":        "a",
+		"backup/b.log":        "bb",
+		"backup/2024/d.log":   "d",
+		"backup/unchanged.gz": "same",
+		"backup/tmp/keep.tmp": "keep",
+	}, dst.files())
+
+	result, err = Sync(ctx, src, dst, "logs", "backup", SyncOptions{Compare: SyncCompareSize, Include: []string{"*.log"}})
+	require.NoError(t, err)
+	require.Equal(t, SyncResult{Skipped: 2}, *result)
+}
+
+func TestSync_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := newMemStorage(map[string]string{"a.log": "a", "b.log": "b", "c.log": "c"})
+	dst := &flakyStorage{memStorage: newMemStorage(nil), failures: 1}
+
+	result, err := Sync(ctx, src, dst, "", "", SyncOptions{
+		Workers:  1,
+		Progress: func(event SyncEvent) { cancel() },
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.ErrorContains(t, err, "failed to copy a.log")
+	require.Equal(t, 1, result.Failed)
+}
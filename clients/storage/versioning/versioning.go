@@ -0,0 +1,370 @@
+package versioning
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	stdtime "time"
+
+	"github.com/MicroOps-cn/fuck/clients/storage"
+)
+
+// DefaultPrefix is the prefix of the keys of the versions when Options.Prefix is not set.
+const DefaultPrefix = ".versions/"
+
+// Latest is the ID of the current version of an object.
+const Latest = "latest"
+
+// deleteMarkerSuffix is the suffix of the version IDs of the delete markers.
+const deleteMarkerSuffix = ".deleted"
+
+type Options struct {
+	// Prefix is the prefix of the keys of the versions. The version of "a/b.txt" is kept in "<Prefix>a/b.txt/<id>".
+	// The keys under it are hidden from the listings and cannot be written.
+	Prefix string `json:"prefix,omitempty" yaml:"prefix,omitempty" mapstructure:"prefix"`
+	// MaxVersions is the number of versions kept for every object, the older versions are purged when an object is
+	// written or deleted. Zero means unlimited.
+	MaxVersions int `json:"max_versions,omitempty" yaml:"max_versions,omitempty" mapstructure:"max_versions"`
+}
+
+// Storage keeps the previous content of the objects. When an object is overwritten by PutObject or CopyObject,
+// its previous content is copied to a version key first. When an object is deleted, its content is kept as a version
+// and a delete marker is recorded. It only relies on CopyObject of the backend, so it works the same on every backend.
+// Archiving the previous content and writing the object are not atomic, concurrent writes to the same object may
+// lose a version.
+type Storage struct {
+	storage.Storage
+	prefix      string
+	maxVersions int
+}
+
+func New(backend storage.Storage, o Options) (*Storage, error) {
+	prefix := strings.Trim(o.Prefix, "/")
+	if len(prefix) == 0 {
+		prefix = DefaultPrefix
+	} else {
+		prefix += "/"
+	}
+	if o.MaxVersions < 0 {
+		return nil, fmt.Errorf("invalid max_versions: %d", o.MaxVersions)
+	}
+	return &Storage{Storage: backend, prefix: prefix, maxVersions: o.MaxVersions}, nil
+}
+
+func (s *Storage) Unwrap() storage.Storage {
+	return s.Storage
+}
+
+type Version struct {
+	storage.Object
+	// ID is Latest for the current version.
+	ID string
+	// Archived is the time when the version was replaced or deleted, it is zero for the current version.
+	Archived     stdtime.Time
+	IsLatest     bool
+	DeleteMarker bool
+}
+
+func cleanKey(objectPath string) string {
+	return strings.TrimPrefix(objectPath, "/")
+}
+
+func (s *Storage) isReserved(key string) bool {
+	return strings.HasPrefix(key+"/", s.prefix)
+}
+
+func (s *Storage) versionsPrefix(key string) string {
+	return s.prefix + key + "/"
+}
+
+// newVersionID returns an ID that sorts by the time it is created.
+func newVersionID(now stdtime.Time) (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%016x%s", now.UnixNano(), hex.EncodeToString(suffix)), nil
+}
+
+// parseVersionID returns the time when the version was archived, and whether it is a delete marker.
+func parseVersionID(id string) (archived stdtime.Time, deleteMarker bool, ok bool) {
+	id, deleteMarker = strings.CutSuffix(id, deleteMarkerSuffix)
+	if len(id) != 24 {
+		return stdtime.Time{}, false, false
+	}
+	if _, err := hex.DecodeString(id); err != nil {
+		return stdtime.Time{}, false, false
+	}
+	nanos, err := strconv.ParseInt(id[:16], 16, 64)
+	if err != nil {
+		return stdtime.Time{}, false, false
+	}
+	return stdtime.Unix(0, nanos), deleteMarker, true
+}
+
+// archive copies the current content of key to a new version, it does nothing if key does not exist.
+func (s *Storage) archive(ctx context.Context, key string) (bool, error) {
+	ctx = storage.WithoutCondition(ctx)
+	if _, err := s.Storage.HeadObject(ctx, key); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	id, err := newVersionID(stdtime.Now())
+	if err != nil {
+		return false, err
+	}
+	if err = s.Storage.CopyObject(ctx, key, s.versionsPrefix(key)+id); err != nil {
+		return false, fmt.Errorf("failed to archive %s: %w", key, err)
+	}
+	return true, nil
+}
+
+// prune purges the versions of key over MaxVersions.
+func (s *Storage) prune(ctx context.Context, key string) error {
+	if s.maxVersions == 0 {
+		return nil
+	}
+	_, err := s.purge(ctx, key+"/", PurgeOptions{Keep: s.maxVersions})
+	return err
+}
+
+func (s *Storage) PutObject(ctx context.Context, objectPath string, obj io.Reader, headers http.Header, metadata map[string]string) error {
+	key := cleanKey(objectPath)
+	if s.isReserved(key) {
+		return fmt.Errorf("invalid object path: %s is reserved for versions", s.prefix)
+	}
+	if _, err := s.archive(ctx, key); err != nil {
+		return err
+	}
+	if err := s.Storage.PutObject(ctx, objectPath, obj, headers, metadata); err != nil {
+		return err
+	}
+	return s.prune(ctx, key)
+}
+
+func (s *Storage) CopyObject(ctx context.Context, srcPath, dstPath string) error {
+	key := cleanKey(dstPath)
+	if s.isReserved(key) {
+		return fmt.Errorf("invalid object path: %s is reserved for versions", s.prefix)
+	}
+	if _, err := s.archive(ctx, key); err != nil {
+		return err
+	}
+	if err := s.Storage.CopyObject(ctx, srcPath, dstPath); err != nil {
+		return err
+	}
+	return s.prune(ctx, key)
+}
+
+// DeleteObject keeps the content of the object as a version and records a delete marker before deleting it.
+func (s *Storage) DeleteObject(ctx context.Context, objectPath string) error {
+	key := cleanKey(objectPath)
+	if s.isReserved(key) {
+		return fmt.Errorf("invalid object path: %s is reserved for versions", s.prefix)
+	}
+	archived, err := s.archive(ctx, key)
+	if err != nil {
+		return err
+	}
+	if archived {
+		id, err := newVersionID(stdtime.Now())
+		if err != nil {
+			return err
+		}
+		if err = s.Storage.PutObject(ctx, s.versionsPrefix(key)+id+deleteMarkerSuffix, strings.NewReader(""), nil, nil); err != nil {
+			return fmt.Errorf("failed to write the delete marker of %s: %w", key, err)
+		}
+	}
+	if err = s.Storage.DeleteObject(ctx, objectPath); err != nil {
+		return err
+	}
+	return s.prune(ctx, key)
+}
+
+func (s *Storage) DeleteObjects(ctx context.Context, objectPaths []string) error {
+	var errs []error
+	for _, objectPath := range objectPaths {
+		if err := s.DeleteObject(ctx, objectPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ListVersions returns the versions of the object from the newest to the oldest, the current version is the first
+// one if the object exists.
+func (s *Storage) ListVersions(ctx context.Context, objectPath string) ([]Version, error) {
+	key := cleanKey(objectPath)
+	var versions []Version
+	err := s.Storage.ListObjects(ctx, storage.ListOptions{Prefix: s.versionsPrefix(key), Delimiter: "/"}, func(page *storage.ListPage) error {
+		for _, obj := range page.Objects {
+			id := path.Base(obj.Key)
+			archived, deleteMarker, ok := parseVersionID(id)
+			if !ok || obj.IsDir() {
+				continue
+			}
+			obj.Key = key
+			if deleteMarker {
+				obj.Size, obj.ETag = 0, ""
+			}
+			versions = append(versions, Version{Object: obj, ID: id, Archived: archived, DeleteMarker: deleteMarker})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].ID > versions[j].ID
+	})
+	current, err := s.Storage.HeadObject(ctx, objectPath)
+	if err == nil {
+		current.Key = key
+		versions = append([]Version{{Object: *current, ID: Latest, IsLatest: true}}, versions...)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return versions, nil
+}
+
+func (s *Storage) versionPath(key, versionID string) (string, error) {
+	if _, deleteMarker, ok := parseVersionID(versionID); !ok {
+		return "", fmt.Errorf("invalid version id: %s", versionID)
+	} else if deleteMarker {
+		return "", &fs.PathError{Op: "get", Path: key, Err: fmt.Errorf("version %s is a delete marker: %w", versionID, fs.ErrNotExist)}
+	}
+	return s.versionsPrefix(key) + versionID, nil
+}
+
+// GetVersion returns the content of a version of the object, the delete markers do not exist.
+func (s *Storage) GetVersion(ctx context.Context, objectPath, versionID string) (*storage.ObjectReader, error) {
+	if versionID == Latest {
+		return s.GetObject(ctx, objectPath)
+	}
+	key := cleanKey(objectPath)
+	versionPath, err := s.versionPath(key, versionID)
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.Storage.GetObject(ctx, versionPath)
+	if err != nil {
+		return nil, err
+	}
+	r.Key = key
+	return r, nil
+}
+
+// Restore makes a version the current version of the object, the current content is kept as a new version.
+func (s *Storage) Restore(ctx context.Context, objectPath, versionID string) error {
+	if versionID == Latest {
+		return nil
+	}
+	key := cleanKey(objectPath)
+	versionPath, err := s.versionPath(key, versionID)
+	if err != nil {
+		return err
+	}
+	if _, err = s.Storage.HeadObject(ctx, versionPath); err != nil {
+		return err
+	}
+	if _, err = s.archive(ctx, key); err != nil {
+		return err
+	}
+	if err = s.Storage.CopyObject(ctx, versionPath, objectPath); err != nil {
+		return err
+	}
+	return s.prune(ctx, key)
+}
+
+type PurgeOptions struct {
+	// Keep is the number of the newest versions kept for every object, zero means the versions are not purged by count.
+	Keep int
+	// OlderThan purges the versions archived more than it ago, zero means the versions are not purged by age.
+	OlderThan stdtime.Duration
+	DryRun    bool
+}
+
+// Purge deletes the versions of the objects under prefix that exceed Keep or are older than OlderThan, and returns
+// the number of the purged versions. The current versions are never purged.
+func (s *Storage) Purge(ctx context.Context, prefix string, o PurgeOptions) (int, error) {
+	return s.purge(ctx, cleanKey(prefix), o)
+}
+
+func (s *Storage) purge(ctx context.Context, prefix string, o PurgeOptions) (int, error) {
+	if o.Keep <= 0 && o.OlderThan <= 0 {
+		return 0, nil
+	}
+	// the versions of an object are grouped by the key of the object.
+	versions := map[string][]string{}
+	err := s.Storage.ListObjects(ctx, storage.ListOptions{Prefix: s.prefix + prefix}, func(page *storage.ListPage) error {
+		for _, obj := range page.Objects {
+			key, id := path.Split(strings.TrimPrefix(cleanKey(obj.Key), s.prefix))
+			if _, _, ok := parseVersionID(id); ok && !obj.IsDir() {
+				key = strings.TrimSuffix(key, "/")
+				versions[key] = append(versions[key], id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	var purged []string
+	deadline := stdtime.Now().Add(-o.OlderThan)
+	for key, ids := range versions {
+		sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+		for i, id := range ids {
+			archived, _, _ := parseVersionID(id)
+			if (o.Keep > 0 && i >= o.Keep) || (o.OlderThan > 0 && archived.Before(deadline)) {
+				purged = append(purged, s.versionsPrefix(key)+id)
+			}
+		}
+	}
+	if o.DryRun || len(purged) == 0 {
+		return len(purged), nil
+	}
+	if err = s.Storage.DeleteObjects(ctx, purged); err != nil {
+		return 0, err
+	}
+	return len(purged), nil
+}
+
+func (s *Storage) ListObject(ctx context.Context, objectPrefix string, recursion bool, callback func(key storage.Object)) error {
+	return s.Storage.ListObject(ctx, objectPrefix, recursion, func(obj storage.Object) {
+		if !s.isReserved(cleanKey(obj.Key)) {
+			callback(obj)
+		}
+	})
+}
+
+func (s *Storage) ListObjects(ctx context.Context, o storage.ListOptions, callback func(page *storage.ListPage) error) error {
+	return s.Storage.ListObjects(ctx, o, func(page *storage.ListPage) error {
+		filtered := &storage.ListPage{NextContinuationToken: page.NextContinuationToken}
+		for _, obj := range page.Objects {
+			if !s.isReserved(cleanKey(obj.Key)) {
+				filtered.Objects = append(filtered.Objects, obj)
+			}
+		}
+		for _, prefix := range page.CommonPrefixes {
+			if !s.isReserved(cleanKey(prefix)) {
+				filtered.CommonPrefixes = append(filtered.CommonPrefixes, prefix)
+			}
+		}
+		return callback(filtered)
+	})
+}
+
+func (s *Storage) ReadDir(name string) ([]fs.DirEntry, error) {
+	return storage.ReadDir(context.Background(), s, name)
+}
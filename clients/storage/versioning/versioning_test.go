@@ -0,0 +1,114 @@
+package versioning
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	stdtime "time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/MicroOps-cn/fuck/clients/storage"
+	fsstorage "github.com/MicroOps-cn/fuck/clients/storage/fs"
+)
+
+func newStorage(t *testing.T, o Options) *Storage {
+	backend, err := fsstorage.NewClient(context.Background(), nil, storage.NewMapConfigProvider(map[string]interface{}{"type": "in-memory"}))
+	require.NoError(t, err)
+	s, err := New(backend, o)
+	require.NoError(t, err)
+	return s
+}
+
+func readAll(t *testing.T, r *storage.ObjectReader, err error) string {
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+	s := newStorage(t, Options{})
+
+	require.NoError(t, s.PutObject(ctx, "conf/app.yaml", strings.NewReader("v1"), nil, map[string]string{"rev": "1"}))
+	require.NoError(t, s.PutObject(ctx, "conf/app.yaml", strings.NewReader("v2"), nil, nil))
+	require.NoError(t, s.PutObject(ctx, "conf/app.yaml", strings.NewReader("v3"), nil, nil))
+
+	versions, err := s.ListVersions(ctx, "conf/app.yaml")
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	require.True(t, versions[0].IsLatest)
+	require.Equal(t, Latest, versions[0].ID)
+	require.Equal(t, "conf/app.yaml", versions[1].Key)
+	r, err := s.GetVersion(ctx, "conf/app.yaml", versions[1].ID)
+	require.Equal(t, "v2", readAll(t, r, err))
+	r, err = s.GetVersion(ctx, "conf/app.yaml", versions[2].ID)
+	require.Equal(t, "v1", readAll(t, r, err))
+	require.Equal(t, map[string]string{"rev": "1"}, r.Metadata)
+
+	// the versions are hidden and cannot be written.
+	var keys []string
+	require.NoError(t, s.ListObject(ctx, "", true, func(obj storage.Object) {
+		if !obj.IsDir() {
+			keys = append(keys, obj.Key)
+		}
+	}))
+	require.Equal(t, []string{"conf/app.yaml"}, keys)
+	require.Error(t, s.PutObject(ctx, DefaultPrefix+"x", strings.NewReader("x"), nil, nil))
+
+	require.NoError(t, s.Restore(ctx, "conf/app.yaml", versions[2].ID))
+	r, err = s.GetObject(ctx, "conf/app.yaml")
+	require.Equal(t, "v1", readAll(t, r, err))
+
+	require.NoError(t, s.DeleteObject(ctx, "conf/app.yaml"))
+	_, err = s.HeadObject(ctx, "conf/app.yaml")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	versions, err = s.ListVersions(ctx, "conf/app.yaml")
+	require.NoError(t, err)
+	require.Len(t, versions, 5)
+	require.True(t, versions[0].DeleteMarker)
+	_, err = s.GetVersion(ctx, "conf/app.yaml", versions[0].ID)
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	require.Error(t, s.Restore(ctx, "conf/app.yaml", versions[0].ID))
+	require.NoError(t, s.Restore(ctx, "conf/app.yaml", versions[1].ID))
+	r, err = s.GetObject(ctx, "conf/app.yaml")
+	require.Equal(t, "v1", readAll(t, r, err))
+
+	n, err := s.Purge(ctx, "conf/", PurgeOptions{Keep: 2, DryRun: true})
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	n, err = s.Purge(ctx, "conf/", PurgeOptions{Keep: 2})
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	versions, err = s.ListVersions(ctx, "conf/app.yaml")
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+
+	n, err = s.Purge(ctx, "", PurgeOptions{OlderThan: stdtime.Nanosecond})
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	versions, err = s.ListVersions(ctx, "conf/app.yaml")
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	require.True(t, versions[0].IsLatest)
+}
+
+func TestStorage_MaxVersions(t *testing.T) {
+	ctx := context.Background()
+	s := newStorage(t, Options{MaxVersions: 2})
+	for _, content := range []string{"1", "2", "3", "4"} {
+		require.NoError(t, s.PutObject(ctx, "a.txt", strings.NewReader(content), nil, nil))
+		require.NoError(t, s.PutObject(ctx, "a.txt.bak", strings.NewReader(content), nil, nil))
+	}
+	for _, key := range []string{"a.txt", "a.txt.bak"} {
+		versions, err := s.ListVersions(ctx, key)
+		require.NoError(t, err)
+		require.Len(t, versions, 3)
+		r, err := s.GetVersion(ctx, key, versions[2].ID)
+		require.Equal(t, "2", readAll(t, r, err))
+	}
+}
@@ -0,0 +1,239 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	stdtime "time"
+
+	"github.com/go-kit/log/level"
+
+	"github.com/MicroOps-cn/fuck/log"
+)
+
+type EventType string
+
+const (
+	EventCreated EventType = "created"
+	EventUpdated EventType = "updated"
+	EventDeleted EventType = "deleted"
+)
+
+// Event is a change of an object, the Object of a deleted object only has the Key.
+type Event struct {
+	Type   EventType
+	Object Object
+}
+
+// DefaultWatchInterval is the polling interval of Watch when WatchOptions.Interval is not set.
+const DefaultWatchInterval = 30 * stdtime.Second
+
+// ErrWatchNotSupported is returned by the Watcher of a backend that cannot watch the changes natively,
+// Watch falls back to polling for it.
+var ErrWatchNotSupported = errors.New("watch is not supported")
+
+// Watcher is implemented by the backends that are notified of the changes of the objects, such as the local
+// file system. The channel is closed when ctx is done.
+type Watcher interface {
+	Watch(ctx context.Context, prefix string) (<-chan Event, error)
+}
+
+type WatchOptions struct {
+	// Interval is the polling interval, the default is DefaultWatchInterval.
+	Interval stdtime.Duration
+	// Checkpoint is the file that keeps the last snapshot of the polling. If it is set, the changes made while the
+	// watcher was not running are emitted when it starts. Otherwise, the objects that exist when the watcher starts
+	// are not emitted.
+	Checkpoint string
+	// Poll forces polling even if the backend implements Watcher.
+	Poll bool
+}
+
+// Watch emits the changes of the objects under prefix until ctx is done. It uses the Watcher of s if s implements it
+// and no checkpoint is set, otherwise it compares the snapshots of ListObject every interval. The objects are compared
+// by ETag, or by size and LastModified if the ETag is unknown.
+func Watch(ctx context.Context, s Storage, prefix string, o WatchOptions) (<-chan Event, error) {
+	if w, ok := As[Watcher](s); ok && !o.Poll && len(o.Checkpoint) == 0 {
+		events, err := w.Watch(ctx, prefix)
+		if !errors.Is(err, ErrWatchNotSupported) {
+			return events, err
+		}
+	}
+	p := &poller{s: s, prefix: prefix, o: o}
+	if p.o.Interval <= 0 {
+		p.o.Interval = DefaultWatchInterval
+	}
+	snapshot, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil {
+		if snapshot, err = p.snapshot(ctx); err != nil {
+			return nil, err
+		}
+		if err = p.save(snapshot); err != nil {
+			return nil, err
+		}
+	}
+	events := make(chan Event)
+	go p.run(ctx, snapshot, events)
+	return events, nil
+}
+
+// snapshotEntry is the state of an object in a snapshot.
+type snapshotEntry struct {
+	ETag         string       `json:"etag,omitempty"`
+	Size         int64        `json:"size"`
+	LastModified stdtime.Time `json:"last_modified"`
+}
+
+func (e snapshotEntry) changed(o snapshotEntry) bool {
+	if len(e.ETag) != 0 && len(o.ETag) != 0 {
+		return e.ETag != o.ETag
+	}
+	return e.Size != o.Size || !e.LastModified.Equal(o.LastModified)
+}
+
+type pollCheckpoint struct {
+	Prefix  string                   `json:"prefix"`
+	Objects map[string]snapshotEntry `json:"objects"`
+}
+
+type poller struct {
+	s      Storage
+	prefix string
+	o      WatchOptions
+}
+
+type snapshot struct {
+	entries map[string]snapshotEntry
+	objects map[string]Object
+}
+
+func (p *poller) snapshot(ctx context.Context) (*snapshot, error) {
+	snap := &snapshot{entries: map[string]snapshotEntry{}, objects: map[string]Object{}}
+	err := p.s.ListObject(ctx, p.prefix, true, func(obj Object) {
+		if obj.IsDir() {
+			return
+		}
+		key := strings.TrimPrefix(obj.Key, "/")
+		snap.entries[key] = snapshotEntry{ETag: obj.ETag, Size: obj.Size, LastModified: obj.LastModified}
+		snap.objects[key] = obj
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// load returns the snapshot of the checkpoint, it returns nil if there is no checkpoint of the prefix.
+func (p *poller) load() (*snapshot, error) {
+	if len(p.o.Checkpoint) == 0 {
+		return nil, nil
+	}
+	data, err := os.ReadFile(p.o.Checkpoint)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var cp pollCheckpoint
+	if err = json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("invalid watch checkpoint %s: %w", p.o.Checkpoint, err)
+	}
+	if cp.Prefix != p.prefix {
+		return nil, nil
+	}
+	if cp.Objects == nil {
+		cp.Objects = map[string]snapshotEntry{}
+	}
+	return &snapshot{entries: cp.Objects}, nil
+}
+
+// save writes the checkpoint to a temp file and renames it, so that an interrupted write keeps the last checkpoint.
+func (p *poller) save(snap *snapshot) error {
+	if len(p.o.Checkpoint) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(pollCheckpoint{Prefix: p.prefix, Objects: snap.entries})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p.o.Checkpoint), filepath.Base(p.o.Checkpoint)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p.o.Checkpoint)
+}
+
+func diffSnapshots(prev, curr *snapshot) []Event {
+	var events []Event
+	for key, entry := range curr.entries {
+		if old, ok := prev.entries[key]; !ok {
+			events = append(events, Event{Type: EventCreated, Object: curr.objects[key]})
+		} else if old.changed(entry) {
+			events = append(events, Event{Type: EventUpdated, Object: curr.objects[key]})
+		}
+	}
+	for key := range prev.entries {
+		if _, ok := curr.entries[key]; !ok {
+			events = append(events, Event{Type: EventDeleted, Object: Object{Key: key}})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Object.Key < events[j].Object.Key
+	})
+	return events
+}
+
+func (p *poller) run(ctx context.Context, prev *snapshot, events chan<- Event) {
+	defer close(events)
+	logger := log.GetContextLogger(ctx)
+	// the changes since the checkpoint are emitted immediately.
+	delay := stdtime.Duration(0)
+	if prev.objects != nil {
+		delay = p.o.Interval
+	}
+	timer := stdtime.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		curr, err := p.snapshot(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				level.Warn(logger).Log("msg", "failed to list objects for watching", "prefix", p.prefix, "err", err)
+			}
+		} else {
+			for _, event := range diffSnapshots(prev, curr) {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+			prev = curr
+			if err = p.save(curr); err != nil {
+				level.Warn(logger).Log("msg", "failed to save watch checkpoint", "checkpoint", p.o.Checkpoint, "err", err)
+			}
+		}
+		timer.Reset(p.o.Interval)
+	}
+}
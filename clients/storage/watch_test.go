@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	stdtime "time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func receiveEvents(t *testing.T, events <-chan Event, n int) []string {
+	var received []string
+	for len(received) < n {
+		select {
+		case event := <-events:
+			received = append(received, string(event.Type)+":"+event.Object.Key)
+		case <-stdtime.After(stdtime.Second):
+			require.FailNow(t, "timed out waiting for events", "received: %v", received)
+		}
+	}
+	sort.Strings(received)
+	return received
+}
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := newMemStorage(map[string]string{"in/a.txt": "a", "in/b.txt": "b", "out/c.txt": "c"})
+	checkpoint := filepath.Join(t.TempDir(), "watch.json")
+	o := WatchOptions{Interval: 10 * stdtime.Millisecond, Checkpoint: checkpoint}
+	events, err := Watch(ctx, s, "in/", o)
+	require.NoError(t, err)
+
+	require.NoError(t, s.PutObject(ctx, "in/a.txt", strings.NewReader("changed"), nil, nil))
+	require.NoError(t, s.DeleteObject(ctx, "in/b.txt"))
+	require.NoError(t, s.PutObject(ctx, "in/d.txt", strings.NewReader("d"), nil, nil))
+	require.NoError(t, s.PutObject(ctx, "out/e.txt", strings.NewReader("e"), nil, nil))
+	require.Equal(t, []string{"created:in/d.txt", "deleted:in/b.txt", "updated:in/a.txt"}, receiveEvents(t, events, 3))
+	cancel()
+	for range events {
+	}
+
+	// the changes made while the watcher is stopped are emitted from the checkpoint when it restarts.
+	require.NoError(t, s.DeleteObject(ctx, "in/d.txt"))
+	require.NoError(t, s.PutObject(ctx, "in/f.txt", strings.NewReader("f"), nil, nil))
+	ctx, cancel = context.WithCancel(context.Background())
+	events, err = Watch(ctx, s, "in/", o)
+	require.NoError(t, err)
+	require.Equal(t, []string{"created:in/f.txt", "deleted:in/d.txt"}, receiveEvents(t, events, 2))
+	cancel()
+	for range events {
+	}
+}
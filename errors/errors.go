@@ -193,6 +193,45 @@ func IsNotFount(err error) bool {
 	return false
 }
 
+// PreconditionFailedError is returned when the precondition of a conditional request, such as If-Match, is not met.
+type PreconditionFailedError struct {
+	Name      string
+	Condition string
+	// NotModified is true if a read is rejected by If-None-Match or If-Modified-Since,
+	// which is reported as 304 Not Modified over HTTP.
+	NotModified bool
+}
+
+func (e *PreconditionFailedError) Error() string {
+	return fmt.Sprintf("precondition failed: %s: %s", e.Condition, e.Name)
+}
+
+func (e *PreconditionFailedError) Code() string {
+	return strconv.Itoa(e.StatusCode())
+}
+
+func (e *PreconditionFailedError) StatusCode() int {
+	if e.NotModified {
+		return http.StatusNotModified
+	}
+	return http.StatusPreconditionFailed
+}
+
+func NewPreconditionFailedError(name, condition string) error {
+	return &PreconditionFailedError{Name: name, Condition: condition}
+}
+
+func NewNotModifiedError(name, condition string) error {
+	return &PreconditionFailedError{Name: name, Condition: condition, NotModified: true}
+}
+
+func IsPreconditionFailed(err error) bool {
+	var pe *PreconditionFailedError
+	return errors.As(err, &pe)
+}
+
+var _ Error = (*PreconditionFailedError)(nil)
+
 func NewErrors(status int, prefix string, code ...string) *Errors {
 	var c string
 	if len(code) <= 0 {